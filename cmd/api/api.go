@@ -1,59 +1,241 @@
 package main
 
 import (
-	// "github.com/colbynh/alfred/internal/device/light"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/auth"
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/device/light"
 	"github.com/colbynh/alfred/internal/device/outlet"
+	"github.com/colbynh/alfred/internal/energy"
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/colbynh/alfred/internal/registry"
 	"github.com/gin-contrib/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	// "net/http"
 )
 
 type application struct {
-	config config
-	logger *logrus.Logger
+	config   config
+	logger   *logrus.Logger
+	registry *registry.Registry
+	outlets  outlet.Config
+	lights   light.Config
+	energy   *energy.Store
+	meters   energy.Source
+	tariff   *energy.TariffFile
+	events   *event.Bus
+	devices  *device.Manager
+	ops      *operations
+	auth     auth.Config
+	acl      *acl.List
+	audit    *acl.Audit
 }
 
 type config struct {
-	addr     string
-	env      string
-	apiURL   string
-	logLevel string
+	addr      string
+	env       string
+	apiURL    string
+	logLevel  string
+	logFormat string
+	kasa      outlet.Credentials
+	subnets   []string
+	scanJobs  int
+	registry  string
+
+	shutdownTimeout time.Duration
+
+	energyDir       string
+	energyInterval  time.Duration
+	energyRetention time.Duration
+	tariff          string
+	hueKeys         string
+	hueCA           string
+	huePins         string
+	apiKeys         string
+	users           string
+	sessionTTL      time.Duration
+	acl             string
+	auditLog        string
+
+	features features
 }
 
 func (app *application) mount() *gin.Engine {
 	svr := gin.New()
-	svr.Use(logger.SetLogger())
-
-	// Middleware to require a header
-	// authHeader := func(c *gin.Context) {
-	// 	if c.GetHeader("hue-application-key") == "" {
-	// 		app.logger.Warn("Missing hue-application-key header")
-	// 		c.JSON(http.StatusBadRequest, gin.H{"error": "hue-application-key is required"})
-	// 		c.Abort()
-	// 		return
-	// 	}
-	// 	c.Next()
-	// }
-
-	// Apply the middleware to specific routes
-	svr.POST("/api/v1/device/outlet/:brand/:id/:action", outlet.OutletActionHandler(svr, app.logger))
-	svr.GET("/api/v1/device/outlet/:brand/:id/:action", outlet.OutletActionHandler(svr, app.logger))
+	svr.Use(logger.SetLogger(), app.ops.middleware())
+
+	outletCfg := app.outlets
+
+	// Every route requires an API key with the scope it needs or a user
+	// whose role allows it
+	read := app.require(auth.ScopeRead)
+	control := app.require(auth.ScopeControl)
+	discovery := app.require(auth.ScopeDiscovery)
+	admin := app.require(auth.ScopeAdmin)
+	outletAction := app.requireFor(app.outletAccess)
+	lightAction := app.requireFor(app.lightAccess)
+	deviceAction := app.requireFor(app.deviceAccess)
+
+	svr.POST("/api/v1/device/outlet/:brand/:id/:action", outletAction, outlet.OutletActionHandler(svr, app.logger, outletCfg))
+	svr.GET("/api/v1/device/outlet/:brand/:id/:action", outletAction, outlet.OutletActionHandler(svr, app.logger, outletCfg))
+	svr.POST("/api/v1/device/outlet/:brand/:id/children/:childId/:action", outletAction, outlet.OutletActionHandler(svr, app.logger, outletCfg))
+	svr.GET("/api/v1/device/outlet/:brand/:id/children/:childId/:action", outletAction, outlet.OutletActionHandler(svr, app.logger, outletCfg))
 	// svr.GET("/api/v1/device/outlet/:brand/:action", outlet.OutletActionHandler(svr))
 
-	// svr.PUT("/api/v1/device/light/:brand/:ip/:id/:action", authHeader, light.LightActionHandler(svr, app.logger))
-	// svr.GET("/api/v1/device/light/:brand/:ip/:action", authHeader, light.LightActionHandler(svr, logger))
-	// TODO: add delete route and test
+	svr.GET("/api/v1/devices", read, device.ListHandler(svr, app.logger, app.devices))
+	svr.GET("/api/v1/devices/:id", deviceAction, device.GetHandler(svr, app.logger, app.devices))
+	svr.POST("/api/v1/devices/:id/:command", deviceAction, device.CommandHandler(svr, app.logger, app.devices, app.acl))
+
+	svr.GET("/api/v1/registry/devices", read, registry.ListHandler(svr, app.logger, app.registry))
+	svr.PATCH("/api/v1/registry/devices/:id", admin, registry.UpdateHandler(svr, app.logger, app.registry))
+	svr.DELETE("/api/v1/registry/devices/:id", admin, registry.RemoveHandler(svr, app.logger, app.registry))
+
+	svr.GET("/api/v1/events", read, event.StreamHandler(svr, app.logger, app.events))
+
+	if app.config.features.auth {
+		svr.POST("/api/v1/auth/login", auth.LoginHandler(svr, app.logger, app.auth))
+		svr.POST("/api/v1/auth/logout", auth.LogoutHandler(svr, app.logger, app.auth))
+		svr.GET("/api/v1/auth/me", app.require(""), auth.MeHandler(svr, app.logger))
+		svr.GET("/api/v1/auth/keys", admin, auth.ListKeysHandler(svr, app.logger, app.auth.Keys))
+		svr.POST("/api/v1/auth/keys", admin, auth.CreateKeyHandler(svr, app.logger, app.auth.Keys))
+		svr.DELETE("/api/v1/auth/keys/:id", admin, auth.RevokeKeyHandler(svr, app.logger, app.auth.Keys))
+		svr.GET("/api/v1/auth/users", admin, auth.ListUsersHandler(svr, app.logger, app.auth))
+		svr.POST("/api/v1/auth/users", admin, auth.CreateUserHandler(svr, app.logger, app.auth))
+		svr.PATCH("/api/v1/auth/users/:username", admin, auth.UpdateUserHandler(svr, app.logger, app.auth))
+		svr.DELETE("/api/v1/auth/users/:username", admin, auth.RemoveUserHandler(svr, app.logger, app.auth))
+	}
+
+	svr.GET("/api/v1/acl", admin, acl.ListHandler(svr, app.logger, app.acl))
+	svr.GET("/api/v1/acl/audit", admin, acl.AuditHandler(svr, app.logger, app.audit))
+	svr.PUT("/api/v1/acl/:device", admin, acl.UpdateHandler(svr, app.logger, app.acl))
+	svr.DELETE("/api/v1/acl/:device", admin, acl.RemoveHandler(svr, app.logger, app.acl))
+
+	if app.config.features.energy {
+		svr.GET("/api/v1/energy/meters", read, energy.MetersHandler(svr, app.logger, app.energy, app.meters))
+		svr.GET("/api/v1/energy/meters/:id/history", read, energy.HistoryHandler(svr, app.logger, app.energy))
+		svr.GET("/api/v1/energy/tariff", read, energy.GetTariffHandler(svr, app.logger, app.tariff))
+		svr.PUT("/api/v1/energy/tariff", admin, energy.SetTariffHandler(svr, app.logger, app.tariff))
+		svr.GET("/api/v1/energy/cost", read, energy.CostHandler(svr, app.logger, app.energy, app.meters, app.tariff))
+		svr.GET("/api/v1/energy/rooms/:room/cost", read, energy.CostHandler(svr, app.logger, app.energy, app.meters, app.tariff))
+		svr.GET("/api/v1/energy/meters/:id/cost", read, energy.CostHandler(svr, app.logger, app.energy, app.meters, app.tariff))
+	}
+
+	if app.config.features.hue {
+		svr.POST("/api/v1/device/light/:brand/discover", discovery, light.DiscoverHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/bridges", read, light.BridgesHandler(svr, app.logger, app.lights))
+		svr.POST("/api/v1/device/light/:brand/:bridge/pair", admin, light.PairHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/pair", read, light.PairHandler(svr, app.logger, app.lights))
+		svr.DELETE("/api/v1/device/light/:brand/:bridge/pair", admin, light.PairHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/events", read, light.EventsHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/sensors", read, light.SensorHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/sensors/:id", read, light.SensorHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/certificate", read, light.CertificateHandler(svr, app.logger, app.lights))
+		svr.DELETE("/api/v1/device/light/:brand/:bridge/certificate", admin, light.CertificateHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/lights", read, light.LightActionHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/lights/:id", lightAction, light.LightActionHandler(svr, app.logger, app.lights))
+		svr.PUT("/api/v1/device/light/:brand/:bridge/lights/:id/:action", lightAction, light.LightActionHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/rooms", read, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupRoom))
+		svr.GET("/api/v1/device/light/:brand/:bridge/rooms/:id", read, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupRoom))
+		svr.PUT("/api/v1/device/light/:brand/:bridge/rooms/:id/:action", control, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupRoom))
+		svr.GET("/api/v1/device/light/:brand/:bridge/zones", read, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupZone))
+		svr.GET("/api/v1/device/light/:brand/:bridge/zones/:id", read, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupZone))
+		svr.PUT("/api/v1/device/light/:brand/:bridge/zones/:id/:action", control, light.GroupActionHandler(svr, app.logger, app.lights, light.GroupZone))
+		svr.GET("/api/v1/device/light/:brand/:bridge/scenes", read, light.SceneHandler(svr, app.logger, app.lights))
+		svr.POST("/api/v1/device/light/:brand/:bridge/scenes", control, light.SceneHandler(svr, app.logger, app.lights))
+		svr.GET("/api/v1/device/light/:brand/:bridge/scenes/:id", read, light.SceneHandler(svr, app.logger, app.lights))
+		svr.PUT("/api/v1/device/light/:brand/:bridge/scenes/:id/:action", control, light.SceneHandler(svr, app.logger, app.lights))
+	}
 
 	return svr
 }
 
-func (app *application) run(svr *gin.Engine) error {
+// require returns the middleware admitting clients with the given scope, or
+// one admitting every request when authentication is off.
+func (app *application) require(scope auth.Scope) gin.HandlerFunc {
+	return app.requireFor(func(*gin.Context) auth.Access { return auth.Access{Scope: scope} })
+}
+
+// requireFor is like require with the access decided per request.
+func (app *application) requireFor(accessOf func(c *gin.Context) auth.Access) gin.HandlerFunc {
+	if !app.config.features.auth {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return auth.RequireFor(app.auth, app.logger, accessOf)
+}
+
+// outletAccess describes an outlet action: reading state and meters needs
+// read, discovery needs discovery and everything else, such as switching or
+// renaming, needs control. Only "on" and "off" are switches guests may use.
+func (app *application) outletAccess(c *gin.Context) auth.Access {
+	a := auth.Access{Scope: auth.ScopeControl, Device: "kasa:" + app.registryID(c.Param("id"))}
+	switch action := c.Param("action"); action {
+	case "state", "sysinfo", "children", "emeter", "emeterDaily", "emeterMonthly":
+		a.Scope = auth.ScopeRead
+	case "discover", "discoverByKasa", "discoverByPorts":
+		a = auth.Access{Scope: auth.ScopeDiscovery}
+	case "on", "off":
+		a.Switch = true
+	}
+	return a
+}
+
+// lightAccess describes a request for a single light: reading it needs read
+// and changing it control, of which "on" and "off" are switches.
+func (app *application) lightAccess(c *gin.Context) auth.Access {
+	a := auth.Access{Scope: auth.ScopeRead, Device: "hue:" + app.registryID(c.Param("bridge")) + ":" + c.Param("id")}
+	if c.Request.Method != http.MethodGet {
+		action := c.Param("action")
+		a.Scope, a.Switch = auth.ScopeControl, action == "on" || action == "off"
+	}
+	return a
+}
+
+// deviceAccess describes a request of the device API: reading a device
+// needs read and commands control, of which on, off and toggle are switches.
+func (app *application) deviceAccess(c *gin.Context) auth.Access {
+	id := c.Param("id")
+	if ref, ok := strings.CutPrefix(id, "kasa:"); ok {
+		id = "kasa:" + app.registryID(ref)
+	}
+	a := auth.Access{Scope: auth.ScopeRead, Device: id}
+	if c.Request.Method != http.MethodGet {
+		switch c.Param("command") {
+		case device.CommandOn, device.CommandOff, device.CommandToggle:
+			a.Switch = true
+		}
+		a.Scope = auth.ScopeControl
+	}
+	return a
+}
+
+// registryID returns the registry ID of a device named by ID, MAC or IP, so
+// devices are shared by their stable ID however a request names them.
+func (app *application) registryID(ref string) string {
+	if app.registry != nil {
+		if d, ok := app.registry.Resolve(ref); ok {
+			return d.ID
+		}
+	}
+	return ref
+}
+
+// run serves the API until ctx is cancelled and the in-flight requests are
+// drained.
+func (app *application) run(ctx context.Context, svr *gin.Engine) error {
 	app.logger.Info("Starting server on", app.config.addr)
-	if err := svr.Run(app.config.addr); err != nil {
+	if app.config.apiURL != "" {
+		app.logger.Info("API available at ", app.config.apiURL)
+	}
+	ln, err := net.Listen("tcp", app.config.addr)
+	if err != nil {
 		app.logger.Error("Error starting server:", err)
 		return err
 	}
-	app.logger.Info("Server stopped")
-	return nil
+	return app.serve(ctx, ln, svr)
 }
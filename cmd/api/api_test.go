@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/colbynh/alfred/internal/auth"
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMountRequiresScopes verifies that the routes are guarded by the scope
+// of what they do, including outlet actions sent with GET.
+func TestMountRequiresScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys, _ := auth.OpenKeyStore("")
+	_, reader, _ := keys.Create("dashboard", []auth.Scope{auth.ScopeRead})
+	app := &application{
+		config: config{features: features{auth: true}},
+		logger: logrus.New(),
+		ops:    newOperations(),
+		auth:   auth.Config{Keys: keys},
+	}
+	svr := app.mount()
+
+	for _, tc := range []struct {
+		method, target, key string
+		status              int
+	}{
+		{http.MethodGet, "/api/v1/devices", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/device/outlet/kasa/plug/on", reader, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/device/outlet/kasa/plug/discover", reader, http.StatusForbidden},
+		{http.MethodPost, "/api/v1/devices/kasa:plug/off", reader, http.StatusForbidden},
+		{http.MethodPatch, "/api/v1/registry/devices/plug", reader, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/auth/keys", reader, http.StatusForbidden},
+		{http.MethodPut, "/api/v1/acl/kasa:plug", reader, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/acl/audit", reader, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+		}
+		w := httptest.NewRecorder()
+		svr.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, tc.method+" "+tc.target)
+	}
+}
+
+// TestDeviceAccess verifies that requests name devices by their registry ID
+// however they address them, so guests can switch exactly the devices shared
+// with them.
+func TestDeviceAccess(t *testing.T) {
+	reg, _ := registry.Open("")
+	_, _, err := reg.Record(registry.Device{ID: "50c7bf010203", Kind: "outlet", Brand: "kasa", IP: "192.168.1.20", LastSeen: time.Now()})
+	assert.NoError(t, err)
+	app := &application{registry: reg}
+	guest := auth.Principal{User: &auth.User{Username: "sitter", Role: auth.RoleGuest, Devices: []string{"kasa:50c7bf010203", "hue:bridge:light-1"}}}
+
+	// access describes a request with the given method and URL parameters.
+	access := func(accessOf func(*gin.Context) auth.Access, method string, params ...string) auth.Access {
+		c := &gin.Context{Request: httptest.NewRequest(method, "/", nil)}
+		for i := 0; i < len(params); i += 2 {
+			c.Params = append(c.Params, gin.Param{Key: params[i], Value: params[i+1]})
+		}
+		return accessOf(c)
+	}
+	outlet, light, device := app.outletAccess, app.lightAccess, app.deviceAccess
+
+	for _, tc := range []struct {
+		access  auth.Access
+		allowed bool
+	}{
+		{access(outlet, http.MethodGet, "id", "192.168.1.20", "action", "off"), true},
+		{access(outlet, http.MethodGet, "id", "192.168.1.20", "action", "state"), true},
+		{access(outlet, http.MethodGet, "id", "192.168.1.20", "action", "rename"), false},
+		{access(outlet, http.MethodGet, "id", "192.168.1.20", "action", "discover"), false},
+		{access(outlet, http.MethodGet, "id", "other", "action", "on"), false},
+		{access(device, http.MethodPost, "id", "kasa:192.168.1.20", "command", "toggle"), true},
+		{access(device, http.MethodPost, "id", "kasa:50c7bf010203", "command", "energy"), false},
+		{access(light, http.MethodPut, "bridge", "bridge", "id", "light-1", "action", "on"), true},
+		{access(light, http.MethodPut, "bridge", "bridge", "id", "light-1", "action", "color"), false},
+	} {
+		assert.Equal(t, tc.allowed, guest.Allows(tc.access), "%+v", tc.access)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colbynh/alfred/internal/device/outlet"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Environments accepted by the "env" setting. Production runs gin in release mode.
+const (
+	envDevelopment = "development"
+	envProduction  = "production"
+)
+
+// Log formats accepted by the "log.format" setting.
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// features switches optional parts of the server on and off.
+type features struct {
+	hue          bool // Hue lights, sensors and scenes
+	eventStreams bool // Live caches of the Hue bridges' event streams, needs hue
+	energy       bool // Energy history, tariff and cost routes
+	auth         bool // API key authentication of every route
+}
+
+// defaultConfig returns the settings used when nothing else is configured.
+func defaultConfig() config {
+	return config{
+		addr:      "0.0.0.0:8080",
+		env:       envDevelopment,
+		logLevel:  "debug",
+		logFormat: logFormatJSON,
+		registry:  "data/devices.json",
+
+		shutdownTimeout: 15 * time.Second,
+
+		energyDir:      "data/energy",
+		energyInterval: time.Minute,
+		tariff:         "data/tariff.json",
+		hueKeys:        "data/hue-keys.json",
+		huePins:        "data/hue-pins.json",
+		apiKeys:        "data/api-keys.json",
+		users:          "data/users.json",
+		sessionTTL:     12 * time.Hour,
+		acl:            "data/acl.json",
+		auditLog:       "data/audit.log",
+
+		features: features{hue: true, eventStreams: true, energy: true, auth: true},
+	}
+}
+
+// fileConfig is the layout of the configuration file, e.g. in YAML:
+//
+//	server:
+//	  addr: 0.0.0.0:8080
+//	  env: production
+//	log:
+//	  level: info
+//	  format: text
+//	discovery:
+//	  subnets: [192.168.0.0/23]
+//	kasa:
+//	  username: me@example.com
+//	  password: secret
+//	energy:
+//	  interval: 30s
+//	features:
+//	  hue: false
+//
+// Durations are written as Go durations such as "30s" or "720h".
+type fileConfig struct {
+	Server struct {
+		Addr            string `yaml:"addr" toml:"addr"`
+		Env             string `yaml:"env" toml:"env"`
+		APIURL          string `yaml:"apiURL" toml:"apiURL"`
+		ShutdownTimeout string `yaml:"shutdownTimeout" toml:"shutdownTimeout"`
+	} `yaml:"server" toml:"server"`
+	Log struct {
+		Level  string `yaml:"level" toml:"level"`
+		Format string `yaml:"format" toml:"format"`
+	} `yaml:"log" toml:"log"`
+	Discovery struct {
+		Subnets     []string `yaml:"subnets" toml:"subnets"`
+		Concurrency int      `yaml:"concurrency" toml:"concurrency"`
+	} `yaml:"discovery" toml:"discovery"`
+	Registry string `yaml:"registry" toml:"registry"`
+	Kasa     struct {
+		Username string `yaml:"username" toml:"username"`
+		Password string `yaml:"password" toml:"password"`
+	} `yaml:"kasa" toml:"kasa"`
+	Hue struct {
+		Keys string `yaml:"keys" toml:"keys"`
+		CA   string `yaml:"ca" toml:"ca"`
+		Pins string `yaml:"pins" toml:"pins"`
+	} `yaml:"hue" toml:"hue"`
+	Auth struct {
+		Keys       string `yaml:"keys" toml:"keys"`
+		Users      string `yaml:"users" toml:"users"`
+		SessionTTL string `yaml:"sessionTTL" toml:"sessionTTL"`
+		ACL        string `yaml:"acl" toml:"acl"`
+		AuditLog   string `yaml:"auditLog" toml:"auditLog"`
+	} `yaml:"auth" toml:"auth"`
+	Energy struct {
+		Dir       string `yaml:"dir" toml:"dir"`
+		Interval  string `yaml:"interval" toml:"interval"`
+		Retention string `yaml:"retention" toml:"retention"`
+		Tariff    string `yaml:"tariff" toml:"tariff"`
+	} `yaml:"energy" toml:"energy"`
+	Features struct {
+		Hue          bool `yaml:"hue" toml:"hue"`
+		EventStreams bool `yaml:"eventStreams" toml:"eventStreams"`
+		Energy       bool `yaml:"energy" toml:"energy"`
+		Auth         bool `yaml:"auth" toml:"auth"`
+	} `yaml:"features" toml:"features"`
+}
+
+// setting is a value that can be overridden by an environment variable and
+// a command-line flag.
+type setting struct {
+	env     string                            // Environment variable, e.g. "ALFRED_ADDR"
+	flag    string                            // Flag name without the dash, "" for none
+	usage   string                            // Flag help text
+	boolean bool                              // Whether the flag may be given without a value
+	apply   func(cfg *config, v string) error // Parses v into cfg
+}
+
+// settings lists every setting that can be overridden, in the order they are
+// applied. Passwords have no flag so they do not show up in process lists.
+var settings = []setting{
+	{env: "ALFRED_ADDR", flag: "addr", usage: "listen address, host:port", apply: func(cfg *config, v string) error {
+		cfg.addr = v
+		return nil
+	}},
+	{env: "ALFRED_ENV", flag: "env", usage: "environment, development or production", apply: func(cfg *config, v string) error {
+		cfg.env = v
+		return nil
+	}},
+	{env: "ALFRED_API_URL", flag: "api-url", usage: "public URL of the API", apply: func(cfg *config, v string) error {
+		cfg.apiURL = v
+		return nil
+	}},
+	{env: "ALFRED_SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "time given to in-flight requests and workers on shutdown, e.g. 15s", apply: func(cfg *config, v string) error {
+		return parseDuration(v, &cfg.shutdownTimeout)
+	}},
+	{env: "ALFRED_LOG_LEVEL", flag: "log-level", usage: "log level: trace, debug, info, warn or error", apply: func(cfg *config, v string) error {
+		cfg.logLevel = v
+		return nil
+	}},
+	{env: "ALFRED_LOG_FORMAT", flag: "log-format", usage: "log format, json or text", apply: func(cfg *config, v string) error {
+		cfg.logFormat = v
+		return nil
+	}},
+	{env: "ALFRED_SUBNETS", flag: "subnets", usage: "comma separated CIDR ranges scanned by discovery", apply: func(cfg *config, v string) error {
+		cfg.subnets = splitList(v)
+		return nil
+	}},
+	{env: "ALFRED_SCAN_CONCURRENCY", flag: "scan-concurrency", usage: "hosts probed at once during discovery", apply: func(cfg *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", v)
+		}
+		cfg.scanJobs = n
+		return nil
+	}},
+	{env: "ALFRED_REGISTRY", flag: "registry", usage: "device registry file", apply: func(cfg *config, v string) error {
+		cfg.registry = v
+		return nil
+	}},
+	{env: "KASA_USERNAME", flag: "kasa-username", usage: "TP-Link account for KLAP devices", apply: func(cfg *config, v string) error {
+		cfg.kasa.Username = v
+		return nil
+	}},
+	{env: "KASA_PASSWORD", apply: func(cfg *config, v string) error {
+		cfg.kasa.Password = v
+		return nil
+	}},
+	{env: "ALFRED_ENERGY_DIR", flag: "energy-dir", usage: "directory of the energy history", apply: func(cfg *config, v string) error {
+		cfg.energyDir = v
+		return nil
+	}},
+	{env: "ALFRED_ENERGY_INTERVAL", flag: "energy-interval", usage: "energy sampling interval, 0 disables collection", apply: func(cfg *config, v string) error {
+		return parseDuration(v, &cfg.energyInterval)
+	}},
+	{env: "ALFRED_ENERGY_RETENTION", flag: "energy-retention", usage: "how long energy history is kept, 0 keeps it forever", apply: func(cfg *config, v string) error {
+		return parseDuration(v, &cfg.energyRetention)
+	}},
+	{env: "ALFRED_TARIFF", flag: "tariff", usage: "energy tariff file", apply: func(cfg *config, v string) error {
+		cfg.tariff = v
+		return nil
+	}},
+	{env: "ALFRED_HUE_KEYS", flag: "hue-keys", usage: "Hue application key file", apply: func(cfg *config, v string) error {
+		cfg.hueKeys = v
+		return nil
+	}},
+	{env: "ALFRED_HUE_CA", flag: "hue-ca", usage: "PEM file with the Hue bridge root CA", apply: func(cfg *config, v string) error {
+		cfg.hueCA = v
+		return nil
+	}},
+	{env: "ALFRED_HUE_PINS", flag: "hue-pins", usage: "Hue certificate pin file", apply: func(cfg *config, v string) error {
+		cfg.huePins = v
+		return nil
+	}},
+	{env: "ALFRED_API_KEYS", flag: "api-keys", usage: "API key file", apply: func(cfg *config, v string) error {
+		cfg.apiKeys = v
+		return nil
+	}},
+	{env: "ALFRED_USERS", flag: "users", usage: "user account file", apply: func(cfg *config, v string) error {
+		cfg.users = v
+		return nil
+	}},
+	{env: "ALFRED_SESSION_TTL", flag: "session-ttl", usage: "idle time after which users are signed out, e.g. 12h", apply: func(cfg *config, v string) error {
+		return parseDuration(v, &cfg.sessionTTL)
+	}},
+	{env: "ALFRED_ACL", flag: "acl", usage: "device access control list file", apply: func(cfg *config, v string) error {
+		cfg.acl = v
+		return nil
+	}},
+	{env: "ALFRED_AUDIT_LOG", flag: "audit-log", usage: "audit log of denied device actions", apply: func(cfg *config, v string) error {
+		cfg.auditLog = v
+		return nil
+	}},
+	{env: "ALFRED_FEATURE_HUE", flag: "hue", boolean: true, usage: "enable Hue lights", apply: func(cfg *config, v string) error {
+		return parseBool(v, &cfg.features.hue)
+	}},
+	{env: "ALFRED_FEATURE_EVENT_STREAMS", flag: "event-streams", boolean: true, usage: "follow Hue bridge event streams", apply: func(cfg *config, v string) error {
+		return parseBool(v, &cfg.features.eventStreams)
+	}},
+	{env: "ALFRED_FEATURE_ENERGY", flag: "energy", boolean: true, usage: "enable energy history and costs", apply: func(cfg *config, v string) error {
+		return parseBool(v, &cfg.features.energy)
+	}},
+	{env: "ALFRED_FEATURE_AUTH", flag: "auth", boolean: true, usage: "require API keys", apply: func(cfg *config, v string) error {
+		return parseBool(v, &cfg.features.auth)
+	}},
+}
+
+// flagValue collects a command-line flag so it can be applied after the
+// configuration file and the environment.
+type flagValue struct {
+	value   string
+	boolean bool
+	set     bool
+}
+
+func (f *flagValue) String() string { return f.value }
+
+func (f *flagValue) Set(v string) error {
+	f.value, f.set = v, true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.boolean }
+
+// loadConfig builds the server configuration from, in increasing priority,
+// the defaults, the configuration file named by -config or ALFRED_CONFIG,
+// environment variables and command-line flags. Every setting is validated
+// and all problems are reported together.
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	fs := flag.NewFlagSet("alfred", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	path := fs.String("config", getenv("ALFRED_CONFIG"), "configuration file (.yaml, .yml or .toml)")
+	flags := make([]*flagValue, len(settings))
+	for i, s := range settings {
+		flags[i] = &flagValue{boolean: s.boolean}
+		if s.flag != "" {
+			fs.Var(flags[i], s.flag, s.usage+" ($"+s.env+")")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	if fs.NArg() > 0 {
+		return config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := defaultConfig()
+	if *path != "" {
+		if err := readConfigFile(*path, &cfg); err != nil {
+			return config{}, fmt.Errorf("config file %s: %w", *path, err)
+		}
+	}
+
+	var errs []error
+	for i, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.apply(&cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+		if flags[i].set {
+			if err := s.apply(&cfg, flags[i].value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		errs = cfg.validate()
+	}
+	if len(errs) > 0 {
+		return config{}, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// readConfigFile overlays the settings of a YAML or TOML file onto cfg.
+// Settings missing from the file keep their current values; unknown settings
+// are rejected so typos do not go unnoticed.
+func readConfigFile(path string, cfg *config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	file := cfg.toFile()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			var strict *toml.StrictMissingError
+			if errors.As(err, &strict) {
+				var keys []string
+				for _, e := range strict.Errors {
+					keys = append(keys, strings.Join(e.Key(), "."))
+				}
+				return fmt.Errorf("unknown settings: %s", strings.Join(keys, ", "))
+			}
+			return err
+		}
+	default:
+		return errors.New("unsupported format, expected .yaml, .yml or .toml")
+	}
+	return cfg.fromFile(file)
+}
+
+// toFile returns the settings of cfg in the layout of the configuration file.
+func (cfg config) toFile() fileConfig {
+	var f fileConfig
+	f.Server.Addr, f.Server.Env, f.Server.APIURL = cfg.addr, cfg.env, cfg.apiURL
+	f.Server.ShutdownTimeout = cfg.shutdownTimeout.String()
+	f.Log.Level, f.Log.Format = cfg.logLevel, cfg.logFormat
+	f.Discovery.Subnets, f.Discovery.Concurrency = cfg.subnets, cfg.scanJobs
+	f.Registry = cfg.registry
+	f.Kasa.Username, f.Kasa.Password = cfg.kasa.Username, cfg.kasa.Password
+	f.Hue.Keys, f.Hue.CA, f.Hue.Pins = cfg.hueKeys, cfg.hueCA, cfg.huePins
+	f.Auth.Keys, f.Auth.Users, f.Auth.SessionTTL = cfg.apiKeys, cfg.users, cfg.sessionTTL.String()
+	f.Auth.ACL, f.Auth.AuditLog = cfg.acl, cfg.auditLog
+	f.Energy.Dir, f.Energy.Tariff = cfg.energyDir, cfg.tariff
+	f.Energy.Interval, f.Energy.Retention = cfg.energyInterval.String(), cfg.energyRetention.String()
+	f.Features.Hue, f.Features.EventStreams, f.Features.Energy = cfg.features.hue, cfg.features.eventStreams, cfg.features.energy
+	f.Features.Auth = cfg.features.auth
+	return f
+}
+
+// fromFile replaces the settings of cfg with those of a configuration file.
+func (cfg *config) fromFile(f fileConfig) error {
+	cfg.addr, cfg.env, cfg.apiURL = f.Server.Addr, f.Server.Env, f.Server.APIURL
+	cfg.logLevel, cfg.logFormat = f.Log.Level, f.Log.Format
+	cfg.subnets, cfg.scanJobs = f.Discovery.Subnets, f.Discovery.Concurrency
+	cfg.registry = f.Registry
+	cfg.kasa = outlet.Credentials{Username: f.Kasa.Username, Password: f.Kasa.Password}
+	cfg.hueKeys, cfg.hueCA, cfg.huePins = f.Hue.Keys, f.Hue.CA, f.Hue.Pins
+	cfg.apiKeys, cfg.users = f.Auth.Keys, f.Auth.Users
+	cfg.acl, cfg.auditLog = f.Auth.ACL, f.Auth.AuditLog
+	cfg.energyDir, cfg.tariff = f.Energy.Dir, f.Energy.Tariff
+	cfg.features = features{hue: f.Features.Hue, eventStreams: f.Features.EventStreams, energy: f.Features.Energy, auth: f.Features.Auth}
+	if err := parseDuration(f.Server.ShutdownTimeout, &cfg.shutdownTimeout); err != nil {
+		return fmt.Errorf("server.shutdownTimeout: %w", err)
+	}
+	if err := parseDuration(f.Auth.SessionTTL, &cfg.sessionTTL); err != nil {
+		return fmt.Errorf("auth.sessionTTL: %w", err)
+	}
+	if err := parseDuration(f.Energy.Interval, &cfg.energyInterval); err != nil {
+		return fmt.Errorf("energy.interval: %w", err)
+	}
+	if err := parseDuration(f.Energy.Retention, &cfg.energyRetention); err != nil {
+		return fmt.Errorf("energy.retention: %w", err)
+	}
+	return nil
+}
+
+// validate checks every setting and returns one error per problem.
+func (cfg config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(cfg.addr); err != nil {
+		fail("addr %q: expected host:port", cfg.addr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		fail("addr %q: port must be between 1 and 65535", cfg.addr)
+	}
+	if cfg.env != envDevelopment && cfg.env != envProduction {
+		fail("env %q: expected %s or %s", cfg.env, envDevelopment, envProduction)
+	}
+	if cfg.shutdownTimeout <= 0 {
+		fail("shutdown timeout %s: must be positive", cfg.shutdownTimeout)
+	}
+	if cfg.apiURL != "" {
+		if u, err := url.Parse(cfg.apiURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("apiURL %q: expected an absolute http or https URL", cfg.apiURL)
+		}
+	}
+	if _, err := logrus.ParseLevel(cfg.logLevel); err != nil {
+		fail("log level %q: expected trace, debug, info, warn, error, fatal or panic", cfg.logLevel)
+	}
+	if cfg.logFormat != logFormatJSON && cfg.logFormat != logFormatText {
+		fail("log format %q: expected %s or %s", cfg.logFormat, logFormatJSON, logFormatText)
+	}
+
+	if _, err := outlet.ParseSubnets(cfg.subnets); err != nil {
+		fail("subnets: %v", err)
+	}
+	if cfg.scanJobs < 0 {
+		fail("scan concurrency %d: must not be negative", cfg.scanJobs)
+	}
+	if (cfg.kasa.Username == "") != (cfg.kasa.Password == "") {
+		fail("kasa credentials: username and password must be set together")
+	}
+
+	if cfg.features.energy {
+		if cfg.energyDir == "" {
+			fail("energy dir: must be set when the energy feature is enabled")
+		}
+		if cfg.energyInterval < 0 || cfg.energyRetention < 0 {
+			fail("energy interval and retention must not be negative")
+		}
+		if cfg.energyRetention > 0 && cfg.energyInterval > cfg.energyRetention {
+			fail("energy retention %s: must be longer than the interval %s", cfg.energyRetention, cfg.energyInterval)
+		}
+	}
+	if cfg.features.auth && cfg.sessionTTL <= 0 {
+		fail("session TTL %s: must be positive", cfg.sessionTTL)
+	}
+	if cfg.features.hue && cfg.hueCA != "" {
+		if _, err := os.Stat(cfg.hueCA); err != nil {
+			fail("hue CA: %v", err)
+		}
+	}
+	return errs
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDuration parses a Go duration such as "30s" into d. An empty string
+// leaves d unchanged.
+func parseDuration(v string, d *time.Duration) error {
+	if v == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("expected a duration such as 30s or 1h, got %q", v)
+	}
+	*d = parsed
+	return nil
+}
+
+// parseBool parses a boolean setting into b.
+func parseBool(v string, b *bool) error {
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("expected true or false, got %q", v)
+	}
+	*b = parsed
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// envMap returns a getenv function backed by vars.
+func envMap(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// writeConfig writes a configuration file with the given name and content
+// to a temporary directory and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigDefaults verifies that the server starts with the defaults
+// when nothing is configured.
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, envMap(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+}
+
+// TestLoadConfigPrecedence verifies that the environment overrides the file
+// and flags override both, for YAML and TOML files.
+func TestLoadConfigPrecedence(t *testing.T) {
+	yamlFile := writeConfig(t, "alfred.yaml", `
+server:
+  addr: 127.0.0.1:9000
+  env: production
+  shutdownTimeout: 30s
+log:
+  level: info
+  format: text
+discovery:
+  subnets: [192.168.0.0/23]
+kasa:
+  username: me@example.com
+  password: secret
+energy:
+  interval: 30s
+features:
+  hue: false
+`)
+	tomlFile := writeConfig(t, "alfred.toml", `
+[server]
+addr = "127.0.0.1:9000"
+env = "production"
+shutdownTimeout = "30s"
+
+[log]
+level = "info"
+format = "text"
+
+[discovery]
+subnets = ["192.168.0.0/23"]
+
+[kasa]
+username = "me@example.com"
+password = "secret"
+
+[energy]
+interval = "30s"
+
+[features]
+hue = false
+`)
+
+	for _, path := range []string{yamlFile, tomlFile} {
+		cfg, err := loadConfig([]string{"-config", path}, envMap(nil))
+		assert.NoError(t, err, path)
+		assert.Equal(t, "127.0.0.1:9000", cfg.addr)
+		assert.Equal(t, envProduction, cfg.env)
+		assert.Equal(t, 30*time.Second, cfg.shutdownTimeout)
+		assert.Equal(t, "info", cfg.logLevel)
+		assert.Equal(t, logFormatText, cfg.logFormat)
+		assert.Equal(t, []string{"192.168.0.0/23"}, cfg.subnets)
+		assert.Equal(t, "secret", cfg.kasa.Password)
+		assert.Equal(t, 30*time.Second, cfg.energyInterval)
+		assert.False(t, cfg.features.hue)
+		assert.True(t, cfg.features.energy, "settings missing from the file keep their defaults")
+		assert.Equal(t, "data/devices.json", cfg.registry)
+	}
+
+	cfg, err := loadConfig([]string{"-addr", ":7000", "-hue"}, envMap(map[string]string{
+		"ALFRED_CONFIG":       yamlFile,
+		"ALFRED_ADDR":         ":8000",
+		"ALFRED_LOG_LEVEL":    "warn",
+		"ALFRED_SUBNETS":      "10.0.0.0/24, 10.0.1.0/24",
+		"ALFRED_FEATURE_AUTH": "false",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, ":7000", cfg.addr)
+	assert.Equal(t, "warn", cfg.logLevel)
+	assert.Equal(t, []string{"10.0.0.0/24", "10.0.1.0/24"}, cfg.subnets)
+	assert.True(t, cfg.features.hue)
+	assert.False(t, cfg.features.auth)
+}
+
+// TestLoadConfigInvalid verifies that every problem is reported and that
+// unknown file settings are rejected.
+func TestLoadConfigInvalid(t *testing.T) {
+	_, err := loadConfig([]string{"-addr", "localhost", "-log-level", "loud"}, envMap(map[string]string{
+		"ALFRED_SUBNETS":         "not-a-cidr",
+		"KASA_USERNAME":          "me@example.com",
+		"ALFRED_ENERGY_INTERVAL": "soon",
+	}))
+	assert.ErrorContains(t, err, "ALFRED_ENERGY_INTERVAL")
+
+	_, err = loadConfig([]string{"-addr", "localhost", "-log-level", "loud", "-shutdown-timeout", "0s"}, envMap(map[string]string{
+		"ALFRED_SUBNETS": "not-a-cidr",
+		"KASA_USERNAME":  "me@example.com",
+	}))
+	assert.ErrorContains(t, err, `addr "localhost"`)
+	assert.ErrorContains(t, err, `log level "loud"`)
+	assert.ErrorContains(t, err, "subnets")
+	assert.ErrorContains(t, err, "kasa credentials")
+	assert.ErrorContains(t, err, "shutdown timeout")
+
+	_, err = loadConfig([]string{"-config", writeConfig(t, "typo.yaml", "servr:\n  addr: :80\n")}, envMap(nil))
+	assert.ErrorContains(t, err, "servr")
+
+	_, err = loadConfig([]string{"-config", writeConfig(t, "typo.toml", "[servr]\naddr = \":80\"\n")}, envMap(nil))
+	assert.ErrorContains(t, err, "servr")
+
+	_, err = loadConfig([]string{"-config", writeConfig(t, "alfred.json", "{}")}, envMap(nil))
+	assert.ErrorContains(t, err, "unsupported format")
+}
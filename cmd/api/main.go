@@ -1,6 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/auth"
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/device/light"
+	"github.com/colbynh/alfred/internal/device/outlet"
+	"github.com/colbynh/alfred/internal/energy"
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
@@ -8,19 +26,156 @@ func main() {
 	// Initialize logrus logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.DebugLevel)
 
-	cfg := config{
-		addr:     "0.0.0.0:8080",
-		logLevel: "debug",
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	level, _ := logrus.ParseLevel(cfg.logLevel)
+	logger.SetLevel(level)
+	if cfg.logFormat == logFormatText {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+	if cfg.env == envProduction {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	reg, err := registry.Open(cfg.registry)
+	if err != nil {
+		logger.Fatal("Error opening device registry: ", err)
+	}
+
+	audit, err := acl.OpenAudit(cfg.auditLog, logger)
+	if err != nil {
+		logger.Fatal("Error opening audit log: ", err)
+	}
+	defer audit.Close()
+	rules, err := acl.Open(cfg.acl, audit)
+	if err != nil {
+		logger.Fatal("Error opening device access rules: ", err)
+	}
+
+	credentials := outlet.NewCredentialStore()
+	if cfg.kasa.Username != "" {
+		credentials.SetDefault(cfg.kasa)
+	}
+	outletCfg := outlet.Config{
+		Credentials: credentials,
+		Subnets:     cfg.subnets,
+		Concurrency: cfg.scanJobs,
+		Registry:    reg,
+		ACL:         rules,
+	}
+
+	hueKeys, err := light.OpenKeyStore(cfg.hueKeys)
+	if err != nil {
+		logger.Fatal("Error opening Hue key store: ", err)
+	}
+	huePins, err := light.OpenPinStore(cfg.huePins)
+	if err != nil {
+		logger.Fatal("Error opening Hue certificate pins: ", err)
+	}
+	hueTLS, err := light.NewBridgeTLS(cfg.hueCA, huePins, logger)
+	if err != nil {
+		logger.Fatal("Error loading Hue root CA: ", err)
+	}
+	if cfg.features.hue && cfg.hueCA == "" {
+		logger.Warn("ALFRED_HUE_CA not set, Hue bridge certificates are pinned on first use")
+	}
+
+	store, err := energy.OpenStore(cfg.energyDir)
+	if err != nil {
+		logger.Fatal("Error opening energy store: ", err)
+	}
+	meters := outlet.NewEnergySource(outletCfg)
+	tariff, err := energy.OpenTariffFile(cfg.tariff)
+	if err != nil {
+		logger.Fatal("Error loading tariff: ", err)
+	}
+
+	apiKeys, err := auth.OpenKeyStore(cfg.apiKeys)
+	if err != nil {
+		logger.Fatal("Error opening API key store: ", err)
+	}
+	if cfg.features.auth && len(apiKeys.List()) == 0 {
+		// Without a key nobody could create one; issue the first admin key
+		// and show it once on the terminal, never in the logs.
+		key, secret, err := apiKeys.Create("initial admin", []auth.Scope{auth.ScopeAdmin})
+		if err != nil {
+			logger.Fatal("Error creating the initial API key: ", err)
+		}
+		fmt.Fprintf(os.Stderr, "Created admin API key %s, it will not be shown again:\n\n    %s\n\n", key.ID, secret)
+	}
+	users, err := auth.OpenUserStore(cfg.users)
+	if err != nil {
+		logger.Fatal("Error opening user accounts: ", err)
+	}
+	// Session cookies are only sent over HTTPS when the API is served that way.
+	authCfg := auth.Config{
+		Keys:     apiKeys,
+		Users:    users,
+		Sessions: auth.NewSessions(cfg.sessionTTL, strings.HasPrefix(cfg.apiURL, "https://")),
+	}
+	if !cfg.features.auth {
+		logger.Warn("API authentication is disabled, anyone on the network can control the devices")
+	}
+
+	// Cancelled on SIGINT or SIGTERM; background workers stop with it and
+	// the server starts draining.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ops := newOperations()
+
+	bus := event.NewBus(logger)
+	lights := light.Config{Keys: hueKeys, Registry: reg, TLS: hueTLS, Bus: bus, ACL: rules}
+	providers := []device.Provider{outlet.NewDeviceProvider(outletCfg, logger)}
+	if cfg.features.hue {
+		if cfg.features.eventStreams {
+			lights.Streams = light.NewEventStreams(ctx, lights, logger)
+			lights.Streams.WatchPaired()
+			streams := lights.Streams
+			ops.goWorker("hue event streams", func() {
+				<-ctx.Done()
+				streams.Close()
+			})
+		}
+		ops.goWorker("hue pairing", func() {
+			<-ctx.Done()
+			light.StopPairing()
+		})
+		providers = append(providers, light.NewDeviceProvider(lights, logger))
 	}
+	devices := device.NewManager(logger, providers...)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
+		config:   cfg,
+		logger:   logger,
+		registry: reg,
+		outlets:  outletCfg,
+		lights:   lights,
+		events:   bus,
+		devices:  devices,
+		energy:   store,
+		meters:   meters,
+		tariff:   tariff,
+		ops:      ops,
+		auth:     authCfg,
+		acl:      rules,
+		audit:    audit,
+	}
+
+	if cfg.features.energy && cfg.energyInterval > 0 {
+		collector := energy.NewCollector(meters, store, cfg.energyInterval, cfg.energyRetention, logger)
+		ops.goWorker("energy collector", func() { collector.Run(ctx) })
 	}
 
 	svr := app.mount()
 
-	logger.Fatal(app.run(svr))
+	if err := app.run(ctx, svr); err != nil {
+		logger.Fatal(err)
+	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// operations tracks the requests being served and the background workers
+// running, so shutdown can wait for them and report the ones it abandons.
+type operations struct {
+	mu      sync.Mutex
+	next    int
+	running map[int]operation
+	done    chan struct{} // Signalled whenever an operation ends
+}
+
+// operation is a request or worker in progress.
+type operation struct {
+	name    string
+	started time.Time
+}
+
+// newOperations returns an empty tracker.
+func newOperations() *operations {
+	return &operations{running: map[int]operation{}, done: make(chan struct{}, 1)}
+}
+
+// start records an operation and returns the function marking it done.
+func (o *operations) start(name string) func() {
+	o.mu.Lock()
+	id := o.next
+	o.next++
+	o.running[id] = operation{name: name, started: time.Now()}
+	o.mu.Unlock()
+
+	return func() {
+		o.mu.Lock()
+		delete(o.running, id)
+		o.mu.Unlock()
+		select {
+		case o.done <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pending describes the operations still running, longest running first,
+// e.g. "request POST /api/v1/device/outlet/kasa/plug/on (running 3s)".
+func (o *operations) pending() []string {
+	o.mu.Lock()
+	ops := make([]operation, 0, len(o.running))
+	for _, op := range o.running {
+		ops = append(ops, op)
+	}
+	o.mu.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].started.Before(ops[j].started) })
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = fmt.Sprintf("%s (running %s)", op.name, time.Since(op.started).Round(time.Millisecond))
+	}
+	return names
+}
+
+// wait blocks until no operation is running or ctx ends. It reports whether
+// every operation finished.
+func (o *operations) wait(ctx context.Context) bool {
+	for {
+		o.mu.Lock()
+		idle := len(o.running) == 0
+		o.mu.Unlock()
+		if idle {
+			return true
+		}
+		select {
+		case <-o.done:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// middleware tracks every request served by the engine.
+func (o *operations) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer o.start("request " + c.Request.Method + " " + c.Request.URL.Path)()
+		c.Next()
+	}
+}
+
+// goWorker runs a background worker, tracked under name until fn returns.
+// Workers are expected to return once the server's context is cancelled.
+func (o *operations) goWorker(name string, fn func()) {
+	done := o.start("worker " + name)
+	go func() {
+		defer done()
+		fn()
+	}()
+}
+
+// serve answers requests on ln until ctx is cancelled, then drains: the
+// event bus and streams are closed so long-lived clients disconnect, new
+// connections are refused and in-flight requests and workers get the
+// shutdown timeout to finish. Requests still running after that have their
+// context cancelled, so device retries stop, and are reported as abandoned.
+func (app *application) serve(ctx context.Context, ln net.Listener, svr *gin.Engine) error {
+	// Request contexts outlive ctx so in-flight commands can complete; they
+	// are cancelled only when draining gives up.
+	requests, abandon := context.WithCancel(context.Background())
+	defer abandon()
+	srv := &http.Server{
+		Handler:     svr,
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case err := <-errc:
+		app.logger.Error("Error starting server:", err)
+		return err
+	case <-ctx.Done():
+	}
+
+	app.logger.Infof("Shutting down, waiting up to %s for %d operations", app.config.shutdownTimeout, len(app.ops.pending()))
+	drain, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	if app.events != nil {
+		app.events.Close()
+	}
+	err := srv.Shutdown(drain)
+	app.ops.wait(drain)
+
+	abandoned := app.ops.pending()
+	if len(abandoned) == 0 && err == nil {
+		app.logger.Info("Server stopped")
+		return nil
+	}
+	for _, op := range abandoned {
+		app.logger.Warnf("Abandoned %s", op)
+	}
+	abandon()
+	srv.Close()
+	app.logger.Warnf("Server stopped, %d operations abandoned after %s", len(abandoned), app.config.shutdownTimeout)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
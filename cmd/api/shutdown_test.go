@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// startServer serves svr through app on a local port and returns its URL,
+// the function triggering shutdown and a channel receiving serve's result.
+func startServer(t *testing.T, app *application, svr *gin.Engine) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	result := make(chan error, 1)
+	go func() { result <- app.serve(ctx, ln, svr) }()
+	return "http://" + ln.Addr().String(), cancel, result
+}
+
+// testApplication returns an application with the given shutdown timeout
+// logging to out, and an engine tracking its requests.
+func testApplication(timeout time.Duration, out io.Writer) (*application, *gin.Engine) {
+	logger := logrus.New()
+	logger.SetOutput(out)
+	app := &application{
+		config: config{shutdownTimeout: timeout},
+		logger: logger,
+		events: event.NewBus(logger),
+		ops:    newOperations(),
+	}
+	gin.SetMode(gin.TestMode)
+	svr := gin.New()
+	svr.Use(app.ops.middleware())
+	return app, svr
+}
+
+// TestServeDrainsRequests verifies that a request in flight when the
+// shutdown starts completes, that workers are waited for and that event
+// clients are disconnected.
+func TestServeDrainsRequests(t *testing.T) {
+	var logs bytes.Buffer
+	app, svr := testApplication(5*time.Second, &logs)
+	started := make(chan struct{})
+	svr.POST("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	})
+	svr.GET("/events", event.StreamHandler(svr, app.logger, app.events))
+
+	url, shutdown, result := startServer(t, app, svr)
+	stopWorker := make(chan struct{})
+	workerDone := false
+	app.ops.goWorker("test", func() {
+		<-stopWorker
+		time.Sleep(50 * time.Millisecond)
+		workerDone = true
+	})
+
+	events, err := http.Get(url + "/events")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer events.Body.Close()
+
+	response := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(url+"/slow", "application/json", nil)
+		if err != nil {
+			response <- 0
+			return
+		}
+		resp.Body.Close()
+		response <- resp.StatusCode
+	}()
+	<-started
+	shutdown()
+	close(stopWorker)
+
+	assert.Equal(t, http.StatusOK, <-response)
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	assert.True(t, workerDone)
+	assert.Contains(t, logs.String(), "Server stopped")
+	assert.NotContains(t, logs.String(), "Abandoned")
+}
+
+// TestServeAbandonsRequests verifies that requests still running after the
+// shutdown timeout are cancelled and reported.
+func TestServeAbandonsRequests(t *testing.T) {
+	var logs bytes.Buffer
+	app, svr := testApplication(50*time.Millisecond, &logs)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	svr.POST("/device/on", func(c *gin.Context) {
+		close(started)
+		<-c.Request.Context().Done()
+		close(cancelled)
+	})
+
+	url, shutdown, result := startServer(t, app, svr)
+	go http.Post(url+"/device/on", "application/json", nil)
+	<-started
+	shutdown()
+
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("abandoned request was not cancelled")
+	}
+	assert.Contains(t, logs.String(), "Abandoned request POST /device/on")
+	assert.Contains(t, logs.String(), "1 operations abandoned")
+}
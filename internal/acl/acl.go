@@ -0,0 +1,293 @@
+// Package acl restricts who may act on individual devices.
+// This file stores the per-device rules and checks actions against them.
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/auth"
+	"github.com/colbynh/alfred/internal/jsonfile"
+	"github.com/gin-gonic/gin"
+)
+
+// ConfirmHeader is the request header confirming that a protected device may
+// be switched off, as an alternative to the "confirm=true" query parameter.
+const ConfirmHeader = "X-Alfred-Confirm"
+
+var (
+	// ErrNotFound is returned for devices without a rule.
+	ErrNotFound = errors.New("no access rule for device")
+
+	// ErrInvalidRule is wrapped by errors about malformed rules.
+	ErrInvalidRule = errors.New("invalid access rule")
+
+	// ErrDenied is returned when a device's access list does not name the
+	// principal.
+	ErrDenied = errors.New("access to device denied")
+
+	// ErrConfirmationRequired is returned when a protected device would be
+	// switched off without confirmation by someone other than an admin.
+	ErrConfirmationRequired = errors.New("device is protected, confirm to switch it off")
+)
+
+// offActions lists the actions that may switch a device off.
+var offActions = map[string]bool{"off": true, "toggle": true}
+
+// SwitchesOff reports whether an action may switch a device off, so callers
+// know when the rules of what it contains, such as the sockets of a power
+// strip, apply as well.
+func SwitchesOff(action string) bool {
+	return offActions[action]
+}
+
+// Rule restricts the use of one device, or one channel of a device such as
+// a socket of a power strip.
+type Rule struct {
+	// Device is the device API ID, e.g. "kasa:50c7bf010203", or that of a
+	// channel, e.g. "kasa:50c7bf010204:8006AB0102".
+	Device string `json:"device"`
+
+	// Protected devices may only be switched off by admins or with an
+	// explicit confirmation.
+	Protected bool `json:"protected,omitempty"`
+
+	// Allow lists who may act on the device: "user:<username>",
+	// "role:<role>" or "key:<API key ID>". Admins are always allowed; an
+	// empty list allows everyone.
+	Allow []string `json:"allow,omitempty"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// allows reports whether the rule's access list admits a principal.
+func (r Rule) allows(p auth.Principal) bool {
+	if len(r.Allow) == 0 || p.IsAdmin() {
+		return true
+	}
+	for _, entry := range r.Allow {
+		kind, name, _ := strings.Cut(entry, ":")
+		switch {
+		case kind == "user" && p.User != nil && strings.EqualFold(name, p.User.Username):
+			return true
+		case kind == "role" && p.User != nil && auth.Role(name) == p.User.Role:
+			return true
+		case kind == "key" && p.Key != nil && name == p.Key.ID:
+			return true
+		}
+	}
+	return false
+}
+
+// Request is an action on a device to check against the rules.
+type Request struct {
+	Device    string         // Device API ID, e.g. "kasa:50c7bf010203"
+	Channel   string         // Channel ID for multi-channel devices, if one is addressed
+	Action    string         // Action or command, e.g. "off"
+	Principal auth.Principal // Who sends the request; zero when authentication is off
+	Confirmed bool           // Whether switching off a protected device was confirmed
+	Remote    string         // Client address, for the audit log
+}
+
+// FromRequest describes an action on a device sent in an HTTP request. The
+// principal is the one stored by the auth middleware.
+func FromRequest(c *gin.Context, device, action string) Request {
+	p, _ := auth.FromContext(c)
+	if c.Request == nil {
+		return Request{Device: device, Action: action, Principal: p}
+	}
+	confirmed, _ := strconv.ParseBool(c.Query("confirm"))
+	if header, err := strconv.ParseBool(c.GetHeader(ConfirmHeader)); err == nil {
+		confirmed = confirmed || header
+	}
+	return Request{Device: device, Action: action, Principal: p, Confirmed: confirmed, Remote: c.ClientIP()}
+}
+
+// List holds the access rules of the devices in a JSON file. It is safe for
+// concurrent use; a nil List allows everything.
+type List struct {
+	audit *Audit
+
+	mu    sync.RWMutex
+	path  string
+	rules map[string]Rule // Keyed by the lower-case device ID
+}
+
+// Open loads the rules stored at path, starting empty if the file does not
+// exist. An empty path keeps the rules in memory only. Denied actions are
+// recorded in audit, which may be nil.
+func Open(path string, audit *Audit) (*List, error) {
+	l := &List{audit: audit, path: path, rules: map[string]Rule{}}
+
+	var rules []Rule
+	if err := jsonfile.Load(path, &rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		l.rules[strings.ToLower(r.Device)] = r
+	}
+	return l, nil
+}
+
+// Get returns the rule of a device or channel.
+func (l *List) Get(device string) (Rule, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	r, ok := l.rules[strings.ToLower(device)]
+	return r, ok
+}
+
+// List returns every rule sorted by device ID.
+func (l *List) List() []Rule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rules := make([]Rule, 0, len(l.rules))
+	for _, r := range l.rules {
+		rules = append(rules, r)
+	}
+	sortRules(rules)
+	return rules
+}
+
+// Restricts reports whether any rule applies to a device whose ID starts
+// with prefix, such as the lights of one bridge, so callers can skip looking
+// up the members of a group when nothing restricts them.
+func (l *List) Restricts(prefix string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	prefix = strings.ToLower(prefix)
+	for key := range l.rules {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set adds or replaces the rule of a device.
+func (l *List) Set(r Rule) (Rule, error) {
+	r.Device = strings.TrimSpace(r.Device)
+	if kind, id, _ := strings.Cut(r.Device, ":"); kind == "" || id == "" {
+		return Rule{}, fmt.Errorf("%w: device %q, expected a device ID such as \"kasa:50c7bf010203\"", ErrInvalidRule, r.Device)
+	}
+	allow := make([]string, 0, len(r.Allow))
+	for _, entry := range r.Allow {
+		entry, err := parseEntry(entry)
+		if err != nil {
+			return Rule{}, err
+		}
+		allow = append(allow, entry)
+	}
+	r.Allow = allow
+	r.UpdatedAt = time.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := strings.ToLower(r.Device)
+	previous, existed := l.rules[key]
+	l.rules[key] = r
+	if err := l.save(); err != nil {
+		if existed {
+			l.rules[key] = previous
+		} else {
+			delete(l.rules, key)
+		}
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// Remove deletes the rule of a device, lifting its restrictions.
+func (l *List) Remove(device string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := strings.ToLower(device)
+	if _, ok := l.rules[key]; !ok {
+		return ErrNotFound
+	}
+	delete(l.rules, key)
+	return l.save()
+}
+
+// Check decides whether a request may act on a device. The rules of the
+// device and of the addressed channel both apply. Denials, and protected
+// devices switched off on confirmation, are recorded in the audit log.
+func (l *List) Check(req Request) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.RLock()
+	var rules []Rule
+	if r, ok := l.rules[strings.ToLower(req.Device)]; ok {
+		rules = append(rules, r)
+	}
+	if req.Channel != "" {
+		if r, ok := l.rules[strings.ToLower(req.Device+":"+req.Channel)]; ok {
+			rules = append(rules, r)
+		}
+	}
+	l.mu.RUnlock()
+
+	protected := false
+	for _, r := range rules {
+		if !r.allows(req.Principal) {
+			l.audit.Record(entryFor(req, DecisionDenied, "not on the access list of "+r.Device))
+			return fmt.Errorf("%w: %s may not use %s", ErrDenied, req.Principal.Name(), r.Device)
+		}
+		protected = protected || r.Protected
+	}
+	if !protected || !offActions[req.Action] || req.Principal.IsAdmin() {
+		return nil
+	}
+	if !req.Confirmed {
+		l.audit.Record(entryFor(req, DecisionDenied, "protected device, not confirmed"))
+		return ErrConfirmationRequired
+	}
+	l.audit.Record(entryFor(req, DecisionConfirmed, "protected device, confirmed"))
+	return nil
+}
+
+// parseEntry validates an access list entry and normalizes its case.
+func parseEntry(entry string) (string, error) {
+	kind, name, _ := strings.Cut(strings.TrimSpace(entry), ":")
+	kind, name = strings.ToLower(kind), strings.TrimSpace(name)
+	if name != "" {
+		switch kind {
+		case "user":
+			return kind + ":" + strings.ToLower(name), nil
+		case "role":
+			role, err := auth.ParseRole(name)
+			if err != nil {
+				return "", fmt.Errorf("%w: %v", ErrInvalidRule, err)
+			}
+			return kind + ":" + string(role), nil
+		case "key":
+			return kind + ":" + name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: allow entry %q, expected user:<username>, role:<role> or key:<id>", ErrInvalidRule, entry)
+}
+
+// sortRules orders rules by device ID.
+func sortRules(rules []Rule) {
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Device < rules[j].Device })
+}
+
+// save writes the rules to disk. l.mu must be held.
+func (l *List) save() error {
+	rules := make([]Rule, 0, len(l.rules))
+	for _, r := range l.rules {
+		rules = append(rules, r)
+	}
+	sortRules(rules)
+	return jsonfile.Save(l.path, rules)
+}
@@ -0,0 +1,154 @@
+// Package acl restricts who may act on individual devices.
+// This test file contains unit tests for the access rules and the audit log.
+package acl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// users returns principals signed in with each role.
+func users() (admin, member, guest auth.Principal) {
+	return auth.Principal{User: &auth.User{Username: "root", Role: auth.RoleAdmin}},
+		auth.Principal{User: &auth.User{Username: "alice", Role: auth.RoleMember}},
+		auth.Principal{User: &auth.User{Username: "sitter", Role: auth.RoleGuest}}
+}
+
+// TestCheck verifies access lists, protected devices and the audit entries
+// of denied and confirmed actions.
+func TestCheck(t *testing.T) {
+	audit, err := OpenAudit("", logrus.New())
+	assert.NoError(t, err)
+	rules, err := Open("", audit)
+	assert.NoError(t, err)
+	admin, member, guest := users()
+	key := auth.Principal{Key: &auth.Key{ID: "3f9a0c12d4e5", Scopes: []auth.Scope{auth.ScopeControl}}}
+
+	_, err = rules.Set(Rule{Device: "kasa:rack", Protected: true})
+	assert.NoError(t, err)
+	_, err = rules.Set(Rule{Device: "kasa:aquarium", Allow: []string{"User:Alice", "key:3f9a0c12d4e5"}})
+	assert.NoError(t, err)
+	_, err = rules.Set(Rule{Device: "kasa:strip:8006AB0101", Protected: true, Allow: []string{"role:member"}})
+	assert.NoError(t, err)
+
+	// Unrestricted devices and a nil list allow everything.
+	assert.NoError(t, rules.Check(Request{Device: "kasa:lamp", Action: "off", Principal: guest}))
+	assert.NoError(t, (*List)(nil).Check(Request{Device: "kasa:rack", Action: "off"}))
+
+	// Protected devices are switched off by admins or on confirmation only.
+	assert.NoError(t, rules.Check(Request{Device: "kasa:rack", Action: "on", Principal: member}))
+	assert.NoError(t, rules.Check(Request{Device: "KASA:Rack", Action: "off", Principal: admin}))
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:rack", Action: "off", Principal: member}), ErrConfirmationRequired)
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:rack", Action: "toggle", Principal: key}), ErrConfirmationRequired)
+	assert.NoError(t, rules.Check(Request{Device: "kasa:rack", Action: "off", Principal: member, Confirmed: true, Remote: "192.168.1.20"}))
+
+	// Access lists name users, roles and keys; admins are always allowed.
+	assert.NoError(t, rules.Check(Request{Device: "kasa:aquarium", Action: "off", Principal: member}))
+	assert.NoError(t, rules.Check(Request{Device: "kasa:aquarium", Action: "off", Principal: key}))
+	assert.NoError(t, rules.Check(Request{Device: "kasa:aquarium", Action: "state", Principal: admin}))
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:aquarium", Action: "state", Principal: guest}), ErrDenied)
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:aquarium", Action: "on"}), ErrDenied)
+
+	// Rules of a channel apply to it only.
+	assert.NoError(t, rules.Check(Request{Device: "kasa:strip", Channel: "8006AB0102", Action: "off", Principal: guest}))
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:strip", Channel: "8006AB0101", Action: "on", Principal: guest}), ErrDenied)
+	assert.ErrorIs(t, rules.Check(Request{Device: "kasa:strip", Channel: "8006AB0101", Action: "off", Principal: member}), ErrConfirmationRequired)
+
+	entries := audit.Recent(0)
+	assert.Len(t, entries, 7)
+	assert.Equal(t, "user alice", entries[0].Principal)
+	assert.Equal(t, "8006AB0101", entries[0].Channel)
+	assert.Equal(t, DecisionDenied, entries[0].Decision)
+	assert.Equal(t, "anonymous", entries[2].Principal)
+	confirmed := entries[len(entries)-3]
+	assert.Equal(t, DecisionConfirmed, confirmed.Decision)
+	assert.Equal(t, "192.168.1.20", confirmed.Remote)
+	assert.Len(t, audit.Recent(2), 2)
+
+	assert.True(t, rules.Restricts("kasa:"))
+	assert.False(t, rules.Restricts("hue:"))
+}
+
+// TestListStore verifies that rules are validated and survive a reload.
+func TestListStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	rules, err := Open(path, nil)
+	assert.NoError(t, err)
+
+	_, err = rules.Set(Rule{Device: "rack"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	_, err = rules.Set(Rule{Device: "kasa:rack", Allow: []string{"alice"}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	_, err = rules.Set(Rule{Device: "kasa:rack", Allow: []string{"role:owner"}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	r, err := rules.Set(Rule{Device: " kasa:rack ", Protected: true, Allow: []string{"ROLE:Member"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "kasa:rack", r.Device)
+	assert.Equal(t, []string{"role:member"}, r.Allow)
+
+	reloaded, err := Open(path, nil)
+	assert.NoError(t, err)
+	r, ok := reloaded.Get("KASA:RACK")
+	assert.True(t, ok)
+	assert.True(t, r.Protected)
+	assert.Len(t, reloaded.List(), 1)
+
+	assert.NoError(t, reloaded.Remove("kasa:rack"))
+	assert.ErrorIs(t, reloaded.Remove("kasa:rack"), ErrNotFound)
+	reloaded, err = Open(path, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, reloaded.List())
+}
+
+// TestAuditLog verifies that entries are appended to the log file and
+// loaded again when it is reopened.
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAudit(path, logrus.New())
+	assert.NoError(t, err)
+	audit.Record(Entry{Principal: "user alice", Device: "kasa:rack", Action: "off", Decision: DecisionDenied, Reason: "test"})
+	audit.Record(Entry{Principal: "user bob", Device: "kasa:rack", Action: "off", Decision: DecisionDenied, Reason: "test"})
+	assert.NoError(t, audit.Close())
+
+	reopened, err := OpenAudit(path, logrus.New())
+	assert.NoError(t, err)
+	defer reopened.Close()
+	entries := reopened.Recent(10)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "user bob", entries[0].Principal)
+	assert.False(t, entries[0].Time.IsZero())
+}
+
+// TestFromRequest verifies that the principal, confirmation and client
+// address are taken from the request.
+func TestFromRequest(t *testing.T) {
+	_, member, _ := users()
+	for _, tc := range []struct {
+		url, header string
+		confirmed   bool
+	}{
+		{url: "/off"},
+		{url: "/off?confirm=true", confirmed: true},
+		{url: "/off", header: "1", confirmed: true},
+		{url: "/off?confirm=no"},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, tc.url, nil)
+		if tc.header != "" {
+			c.Request.Header.Set(ConfirmHeader, tc.header)
+		}
+		c.Set("auth.principal", member)
+
+		req := FromRequest(c, "kasa:rack", "off")
+		assert.Equal(t, tc.confirmed, req.Confirmed, tc.url)
+		assert.Equal(t, "user alice", req.Principal.Name())
+		assert.Equal(t, "192.0.2.1", req.Remote)
+	}
+}
@@ -0,0 +1,169 @@
+// Package acl restricts who may act on individual devices.
+// This file keeps the audit log of denied and confirmed device actions.
+package acl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Decisions recorded in the audit log.
+const (
+	DecisionDenied    = "denied"    // The action was refused
+	DecisionConfirmed = "confirmed" // A protected device was switched off on confirmation
+)
+
+// maxRecent bounds the audit entries kept in memory for the API.
+const maxRecent = 500
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"` // e.g. "user alice" or "key 3f9a0c12d4e5"
+	Device    string    `json:"device"`
+	Channel   string    `json:"channel,omitempty"`
+	Action    string    `json:"action"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason"`
+	Remote    string    `json:"remote,omitempty"`
+}
+
+// entryFor returns the audit entry of a decision on a request.
+func entryFor(req Request, decision, reason string) Entry {
+	return Entry{
+		Principal: req.Principal.Name(),
+		Device:    req.Device,
+		Channel:   req.Channel,
+		Action:    req.Action,
+		Decision:  decision,
+		Reason:    reason,
+		Remote:    req.Remote,
+	}
+}
+
+// Audit appends entries to a JSON lines file and the server log, and keeps
+// the most recent ones in memory. It is safe for concurrent use; recording
+// to a nil Audit does nothing.
+type Audit struct {
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	file   *os.File // nil when the log is kept in memory only
+	recent []Entry  // Oldest first
+}
+
+// OpenAudit opens the audit log at path for appending, creating it if needed,
+// and loads its most recent entries. An empty path keeps entries in memory
+// only.
+func OpenAudit(path string, logger *logrus.Logger) (*Audit, error) {
+	a := &Audit{logger: logger}
+	if path == "" {
+		return a, nil
+	}
+
+	if err := a.load(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+// Record adds an entry, stamped with the current time.
+func (a *Audit) Record(e Entry) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	a.logger.WithFields(logrus.Fields{
+		"principal": e.Principal,
+		"device":    e.Device,
+		"channel":   e.Channel,
+		"action":    e.Action,
+		"remote":    e.Remote,
+	}).Warnf("Audit: %s %s on %s: %s", e.Decision, e.Action, e.Device, e.Reason)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.remember(e)
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		a.logger.Errorf("Error writing audit log: %v", err)
+	}
+}
+
+// Recent returns up to limit entries, newest first.
+func (a *Audit) Recent(limit int) []Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if limit <= 0 || limit > len(a.recent) {
+		limit = len(a.recent)
+	}
+	entries := make([]Entry, 0, limit)
+	for i := len(a.recent) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, a.recent[i])
+	}
+	return entries
+}
+
+// Close closes the log file.
+func (a *Audit) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// load reads the most recent entries of an existing log. Lines that cannot
+// be parsed are skipped.
+func (a *Audit) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			a.remember(e)
+		}
+	}
+	return scanner.Err()
+}
+
+// remember keeps an entry in memory, dropping the oldest beyond maxRecent.
+// a.mu must be held.
+func (a *Audit) remember(e Entry) {
+	a.recent = append(a.recent, e)
+	if len(a.recent) > maxRecent {
+		a.recent = append(a.recent[:0], a.recent[len(a.recent)-maxRecent:]...)
+	}
+}
@@ -0,0 +1,109 @@
+// Package acl restricts who may act on individual devices.
+// This file provides the HTTP handlers for managing the rules and reading
+// the audit log.
+package acl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAuditLimit is the number of audit entries returned when the request
+// does not ask for a number.
+const defaultAuditLimit = 100
+
+// ListHandler returns every access rule.
+//
+// Example URL: GET /api/v1/acl
+func ListHandler(svr *gin.Engine, logger *logrus.Logger, rules *List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rules": rules.List()})
+	}
+}
+
+// UpdateHandler sets the rule of the device named by the ":device" URL
+// parameter, replacing any rule it had.
+//
+// Example URL: PUT /api/v1/acl/kasa:50c7bf010203 {"protected": true, "allow": ["role:member", "user:alice"]}
+func UpdateHandler(svr *gin.Engine, logger *logrus.Logger, rules *List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Protected bool     `json:"protected"`
+			Allow     []string `json:"allow"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with \"protected\" and \"allow\""})
+			return
+		}
+
+		r, err := rules.Set(Rule{Device: c.Param("device"), Protected: body.Protected, Allow: body.Allow})
+		if err != nil {
+			if errors.Is(err, ErrInvalidRule) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			logger.Errorf("Error saving access rule: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Infof("Set access rule of %s (protected %t, allow %v)", r.Device, r.Protected, r.Allow)
+		c.JSON(http.StatusOK, gin.H{"rule": r})
+	}
+}
+
+// RemoveHandler deletes the rule of the device named by the ":device" URL
+// parameter.
+//
+// Example URL: DELETE /api/v1/acl/kasa:50c7bf010203
+func RemoveHandler(svr *gin.Engine, logger *logrus.Logger, rules *List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		device := c.Param("device")
+		if err := rules.Remove(device); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			logger.Errorf("Error removing access rule of %s: %v", device, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Infof("Removed access rule of %s", device)
+		c.JSON(http.StatusOK, gin.H{"device": device, "status": "success"})
+	}
+}
+
+// AuditHandler returns the most recent audit log entries, newest first. The
+// "limit" query parameter sets how many, 100 by default.
+//
+// Example URL: GET /api/v1/acl/audit?limit=20
+func AuditHandler(svr *gin.Engine, logger *logrus.Logger, audit *Audit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultAuditLimit
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+				return
+			}
+			limit = n
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": audit.Recent(limit)})
+	}
+}
+
+// StatusForError returns the HTTP status for an error of Check: 403 when
+// denied, 428 when a confirmation is required. It returns false for other
+// errors.
+func StatusForError(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrDenied):
+		return http.StatusForbidden, true
+	case errors.Is(err, ErrConfirmationRequired):
+		return http.StatusPreconditionRequired, true
+	}
+	return 0, false
+}
@@ -0,0 +1,220 @@
+// Package auth authenticates API clients and checks what they may do.
+// This file stores the API keys and the scopes granted to each.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+)
+
+// Scope is a set of operations an API key is allowed to perform.
+type Scope string
+
+// Scopes that can be granted to an API key. Admin implies every other scope.
+const (
+	ScopeRead      Scope = "read"      // Read device state, energy data and events
+	ScopeControl   Scope = "control"   // Switch, dim and color devices, recall scenes
+	ScopeDiscovery Scope = "discovery" // Scan the network for devices and bridges
+	ScopeAdmin     Scope = "admin"     // Manage keys, pairing, the registry and the tariff
+)
+
+// Scopes lists every scope in increasing order of privilege.
+var Scopes = []Scope{ScopeRead, ScopeControl, ScopeDiscovery, ScopeAdmin}
+
+// keyPrefix starts every API key so leaked keys are easy to recognise.
+const keyPrefix = "alf_"
+
+var (
+	// ErrNotFound is returned for key IDs that are not stored.
+	ErrNotFound = errors.New("API key not found")
+
+	// ErrInvalidKey is returned for malformed, unknown or revoked keys.
+	ErrInvalidKey = errors.New("invalid API key")
+
+	// ErrInvalidScope is wrapped by errors about unknown scope names.
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrLastAdmin is returned when revoking a key would leave no admin key.
+	ErrLastAdmin = errors.New("cannot revoke the last admin key")
+)
+
+// Key is an API key. Only a hash of its secret is kept; the secret itself is
+// returned once, when the key is created.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []Scope   `json:"scopes"`
+	Hash      string    `json:"hash,omitempty"` // SHA-256 of the secret, hex encoded
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Allows reports whether the key grants scope.
+func (k Key) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes validates a list of scope names and removes duplicates.
+func ParseScopes(names []string) ([]Scope, error) {
+	seen := map[Scope]bool{}
+	var scopes []Scope
+	for _, name := range names {
+		s := Scope(strings.ToLower(strings.TrimSpace(name)))
+		valid := false
+		for _, known := range Scopes {
+			valid = valid || s == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w %q, expected one of read, control, discovery or admin", ErrInvalidScope, name)
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	return scopes, nil
+}
+
+// KeyStore keeps API keys in a JSON file. It is safe for concurrent use.
+type KeyStore struct {
+	mu   sync.RWMutex
+	path string
+	keys map[string]Key
+}
+
+// OpenKeyStore loads the keys stored at path, starting empty if the file does
+// not exist. An empty path keeps the keys in memory only.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path, keys: map[string]Key{}}
+
+	var keys []Key
+	if err := jsonfile.Load(path, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return s, nil
+}
+
+// Create generates a new key with the given name and scopes. It returns the
+// stored key and the secret to hand to the client, "alf_<id>_<secret>".
+func (s *KeyStore) Create(name string, scopes []Scope) (Key, string, error) {
+	random := make([]byte, 6+32)
+	if _, err := rand.Read(random); err != nil {
+		return Key{}, "", err
+	}
+	id, secret := hex.EncodeToString(random[:6]), hex.EncodeToString(random[6:])
+	k := Key{ID: id, Name: name, Scopes: scopes, Hash: hashSecret(secret), CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return Key{}, "", err
+	}
+	return k.public(), keyPrefix + id + "_" + secret, nil
+}
+
+// Authenticate returns the key matching a secret presented by a client.
+func (s *KeyStore) Authenticate(token string) (Key, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, keyPrefix), "_")
+	if !ok || !strings.HasPrefix(token, keyPrefix) {
+		return Key{}, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	k, found := s.keys[id]
+	s.mu.RUnlock()
+	if !found || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashSecret(secret))) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return k.public(), nil
+}
+
+// List returns the stored keys, without their hashes, oldest first.
+func (s *KeyStore) List() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.public())
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// Remove revokes a key. The last key with the admin scope cannot be revoked,
+// so keys can always be managed.
+func (s *KeyStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if k.Allows(ScopeAdmin) {
+		admins := 0
+		for _, other := range s.keys {
+			if other.Allows(ScopeAdmin) {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return ErrLastAdmin
+		}
+	}
+	delete(s.keys, id)
+	return s.save()
+}
+
+// public returns a copy of the key without its hash.
+func (k Key) public() Key {
+	k.Hash = ""
+	return k
+}
+
+// hashSecret returns the hex encoded SHA-256 of a key secret. Secrets are
+// random, so a fast hash is enough to make a stolen key file useless.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// sortKeys orders keys by creation time, then ID.
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+// save writes the keys to disk. s.mu must be held.
+func (s *KeyStore) save() error {
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	return jsonfile.Save(s.path, keys)
+}
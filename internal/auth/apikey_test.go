@@ -0,0 +1,69 @@
+// Package auth authenticates API clients and checks what they may do.
+// This test file contains unit tests for the API key store.
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeyStore verifies that keys authenticate by their secret only, that
+// the secret is not stored and that revoked keys stop working.
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	keys, err := OpenKeyStore(path)
+	assert.NoError(t, err)
+
+	admin, adminSecret, err := keys.Create("initial admin", []Scope{ScopeAdmin})
+	assert.NoError(t, err)
+	reader, secret, err := keys.Create("Grafana", []Scope{ScopeRead})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "alf_"+reader.ID+"_"))
+	assert.Empty(t, reader.Hash)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), strings.TrimPrefix(secret, "alf_"+reader.ID+"_"))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	reloaded, err := OpenKeyStore(path)
+	assert.NoError(t, err)
+	k, err := reloaded.Authenticate(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "Grafana", k.Name)
+	assert.True(t, k.Allows(ScopeRead))
+	assert.False(t, k.Allows(ScopeControl))
+
+	for _, bad := range []string{"", "alf_", secret + "x", "alf_" + reader.ID + "_" + strings.Repeat("0", 64), strings.TrimPrefix(secret, "alf_")} {
+		_, err := reloaded.Authenticate(bad)
+		assert.ErrorIs(t, err, ErrInvalidKey, bad)
+	}
+
+	assert.ErrorIs(t, reloaded.Remove(admin.ID), ErrLastAdmin)
+	assert.NoError(t, reloaded.Remove(reader.ID))
+	_, err = reloaded.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorIs(t, reloaded.Remove(reader.ID), ErrNotFound)
+
+	k, err = reloaded.Authenticate(adminSecret)
+	assert.NoError(t, err)
+	assert.True(t, k.Allows(ScopeDiscovery), "admin implies every scope")
+}
+
+// TestParseScopes verifies that scope names are validated.
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"read", " Control", "read"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeRead, ScopeControl}, scopes)
+
+	_, err = ParseScopes([]string{"root"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, err = ParseScopes(nil)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
@@ -0,0 +1,236 @@
+// Package auth authenticates API clients and checks what they may do.
+// This file provides the HTTP handlers for signing in and for managing API
+// keys and user accounts.
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ListKeysHandler returns every API key with its scopes. Secrets and their
+// hashes are never returned.
+//
+// Example URL: GET /api/v1/auth/keys
+func ListKeysHandler(svr *gin.Engine, logger *logrus.Logger, keys *KeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": keys.List()})
+	}
+}
+
+// CreateKeyHandler creates an API key with a name and scopes. The response
+// holds the secret, which cannot be retrieved again.
+//
+// Example URL: POST /api/v1/auth/keys {"name": "Grafana", "scopes": ["read"]}
+func CreateKeyHandler(svr *gin.Engine, logger *logrus.Logger, keys *KeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with a \"name\" and \"scopes\""})
+			return
+		}
+		scopes, err := ParseScopes(body.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		key, secret, err := keys.Create(strings.TrimSpace(body.Name), scopes)
+		if err != nil {
+			logger.Errorf("Error creating API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		creator, _ := FromContext(c)
+		logger.Infof("Created API key %s (%s) with scopes %v, by %s", key.ID, key.Name, key.Scopes, creator.Name())
+		c.JSON(http.StatusCreated, gin.H{"key": key, "secret": secret})
+	}
+}
+
+// RevokeKeyHandler revokes the API key named by the ":id" URL parameter.
+//
+// Example URL: DELETE /api/v1/auth/keys/3f9a0c12d4e5
+func RevokeKeyHandler(svr *gin.Engine, logger *logrus.Logger, keys *KeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := keys.Remove(id); err != nil {
+			switch {
+			case errors.Is(err, ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			case errors.Is(err, ErrLastAdmin):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				logger.Errorf("Error revoking API key %s: %v", id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		logger.Infof("Revoked API key %s", id)
+		c.JSON(http.StatusOK, gin.H{"id": id, "status": "success"})
+	}
+}
+
+// LoginHandler signs a user in and sets the session cookie.
+//
+// Example URL: POST /api/v1/auth/login {"username": "alice", "password": "correct horse"}
+func LoginHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil || body.Username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with a \"username\" and \"password\""})
+			return
+		}
+
+		user, err := cfg.Users.Authenticate(body.Username, body.Password)
+		if err != nil {
+			logger.Warnf("Failed sign-in as %q from %s", body.Username, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		token, err := cfg.Sessions.Create(user.Username)
+		if err != nil {
+			logger.Errorf("Error creating session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		http.SetCookie(c.Writer, cfg.Sessions.Cookie(token))
+		logger.Infof("User %s signed in from %s", user.Username, c.ClientIP())
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// LogoutHandler ends the session of the request and clears its cookie.
+//
+// Example URL: POST /api/v1/auth/logout
+func LogoutHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, err := c.Cookie(SessionCookie); err == nil {
+			cfg.Sessions.Remove(token)
+		}
+		http.SetCookie(c.Writer, cfg.Sessions.Cookie(""))
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	}
+}
+
+// MeHandler returns the API key or user the request is authenticated as,
+// including the devices shared with a guest.
+//
+// Example URL: GET /api/v1/auth/me
+func MeHandler(svr *gin.Engine, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, _ := FromContext(c)
+		if p.Key != nil {
+			c.JSON(http.StatusOK, gin.H{"key": p.Key})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user": p.User})
+	}
+}
+
+// ListUsersHandler returns every user account. Password hashes are never
+// returned.
+//
+// Example URL: GET /api/v1/auth/users
+func ListUsersHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"users": cfg.Users.List()})
+	}
+}
+
+// CreateUserHandler creates a user account.
+//
+// Example URL: POST /api/v1/auth/users {"username": "alice", "password": "correct horse", "role": "member"}
+func CreateUserHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with a \"username\", \"password\" and \"role\""})
+			return
+		}
+		role, err := ParseRole(body.Role)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := cfg.Users.Create(body.Username, body.Password, role)
+		if err != nil {
+			c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		creator, _ := FromContext(c)
+		logger.Infof("Created %s user %s, by %s", user.Role, user.Username, creator.Name())
+		c.JSON(http.StatusCreated, gin.H{"user": user})
+	}
+}
+
+// UpdateUserHandler changes the role, password or shared devices of the
+// account named by the ":username" URL parameter. Changing the password or
+// role signs the user out everywhere.
+//
+// Example URL: PATCH /api/v1/auth/users/guest {"devices": ["kasa:50c7bf010203"]}
+func UpdateUserHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var upd UserUpdate
+		if err := c.ShouldBindJSON(&upd); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with a \"role\", \"password\" or \"devices\""})
+			return
+		}
+
+		user, err := cfg.Users.Update(c.Param("username"), upd)
+		if err != nil {
+			c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if upd.Password != nil || upd.Role != nil {
+			cfg.Sessions.RemoveUser(user.Username)
+		}
+		editor, _ := FromContext(c)
+		logger.Infof("Updated user %s (role %s, %d shared devices), by %s", user.Username, user.Role, len(user.Devices), editor.Name())
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// RemoveUserHandler deletes the account named by the ":username" URL
+// parameter and ends its sessions.
+//
+// Example URL: DELETE /api/v1/auth/users/alice
+func RemoveUserHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if err := cfg.Users.Remove(username); err != nil {
+			c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		cfg.Sessions.RemoveUser(username)
+		logger.Infof("Removed user %s", username)
+		c.JSON(http.StatusOK, gin.H{"username": username, "status": "success"})
+	}
+}
+
+// userErrorStatus returns the HTTP status for an error of the user store.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidUser):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUserExists), errors.Is(err, ErrLastAdminUser):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,183 @@
+// Package auth authenticates API clients and checks what they may do.
+// This file provides the middleware admitting requests by API key scope or
+// user role.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// principalContext is the gin context key under which the authenticated
+// principal is stored for the handlers.
+const principalContext = "auth.principal"
+
+// errNoCredentials is returned for requests carrying neither an API key nor
+// a session cookie.
+var errNoCredentials = errors.New("authentication required")
+
+// Config holds the stores requests are authenticated against.
+type Config struct {
+	// Keys holds the API keys of scripts and integrations.
+	Keys *KeyStore
+
+	// Users holds the accounts of the web interface. It is optional;
+	// without it only API keys are accepted.
+	Users *UserStore
+
+	// Sessions holds the login sessions of the accounts.
+	Sessions *Sessions
+}
+
+// Access describes what a request does, to decide who may send it.
+type Access struct {
+	Scope  Scope  // Scope an API key needs; empty for requests any client may send
+	Device string // Device read or switched, as a device API ID such as "kasa:50c7bf010203"
+	Switch bool   // Whether the request only switches the device on or off
+}
+
+// Principal is who a request was authenticated as: an API key or a
+// signed-in user.
+type Principal struct {
+	Key  *Key
+	User *User
+}
+
+// Name identifies the principal in logs, e.g. "key 3f9a0c12d4e5" or
+// "user alice".
+func (p Principal) Name() string {
+	switch {
+	case p.Key != nil:
+		return "key " + p.Key.ID
+	case p.User != nil:
+		return "user " + p.User.Username
+	}
+	return "anonymous"
+}
+
+// Allows reports whether the principal may perform an access. Admins may do
+// everything and members everything but administration. Guests may only
+// read and switch the devices shared with them.
+func (p Principal) Allows(a Access) bool {
+	if p.Key == nil && p.User == nil {
+		return false
+	}
+	if a.Scope == "" {
+		return true
+	}
+	if p.Key != nil {
+		return p.Key.Allows(a.Scope)
+	}
+	switch p.User.Role {
+	case RoleAdmin:
+		return true
+	case RoleMember:
+		return a.Scope != ScopeAdmin
+	case RoleGuest:
+		return a.Device != "" && p.User.Shares(a.Device) &&
+			(a.Scope == ScopeRead || (a.Scope == ScopeControl && a.Switch))
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal has full access.
+func (p Principal) IsAdmin() bool {
+	return p.Allows(Access{Scope: ScopeAdmin})
+}
+
+// Require returns middleware admitting requests from clients with the given
+// scope. Requests without valid credentials are answered with 401, those of
+// clients lacking the scope with 403.
+func Require(cfg Config, logger *logrus.Logger, scope Scope) gin.HandlerFunc {
+	return RequireFor(cfg, logger, func(*gin.Context) Access { return Access{Scope: scope} })
+}
+
+// RequireFor is like Require with the access decided per request, for
+// routes whose parameters decide what they do, such as an ":action".
+func RequireFor(cfg Config, logger *logrus.Logger, accessOf func(c *gin.Context) Access) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := cfg.authenticate(c)
+		if err != nil {
+			if !errors.Is(err, errNoCredentials) {
+				logger.Warnf("Rejected credentials for %s %s from %s: %v", c.Request.Method, c.Request.URL.Path, c.ClientIP(), err)
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="alfred"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		access := accessOf(c)
+		if !p.Allows(access) {
+			logger.Warnf("Denied %s %s to %s", c.Request.Method, c.Request.URL.Path, p.Name())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": denial(p, access)})
+			return
+		}
+		c.Set(principalContext, p)
+		c.Next()
+	}
+}
+
+// FromContext returns the principal that authenticated the request, if any.
+func FromContext(c *gin.Context) (Principal, bool) {
+	v, ok := c.Get(principalContext)
+	if !ok {
+		return Principal{}, false
+	}
+	p, ok := v.(Principal)
+	return p, ok
+}
+
+// authenticate identifies the client by its API key or, for the web
+// interface, its session cookie.
+func (cfg Config) authenticate(c *gin.Context) (Principal, error) {
+	if token := requestKey(c.Request); token != "" {
+		if cfg.Keys == nil {
+			return Principal{}, ErrInvalidKey
+		}
+		key, err := cfg.Keys.Authenticate(token)
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{Key: &key}, nil
+	}
+
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil || cookie == "" || cfg.Sessions == nil || cfg.Users == nil {
+		return Principal{}, errNoCredentials
+	}
+	username, ok := cfg.Sessions.Lookup(cookie)
+	if !ok {
+		return Principal{}, errors.New("session expired, sign in again")
+	}
+	user, ok := cfg.Users.Get(username)
+	if !ok {
+		cfg.Sessions.Remove(cookie)
+		return Principal{}, errors.New("session expired, sign in again")
+	}
+	return Principal{User: &user}, nil
+}
+
+// denial explains why a principal may not perform an access.
+func denial(p Principal, a Access) string {
+	switch {
+	case p.Key != nil:
+		return fmt.Sprintf("API key lacks the %q scope", a.Scope)
+	case p.User.Role == RoleGuest:
+		return "guests may only switch the devices shared with them"
+	}
+	return fmt.Sprintf("the %s role may not do this", p.User.Role)
+}
+
+// requestKey returns the API key sent as "Authorization: Bearer <key>" or in
+// the X-API-Key header.
+func requestKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
@@ -0,0 +1,205 @@
+// Package auth authenticates API clients and checks what they may do.
+// This test file contains unit tests for the authentication middleware and
+// the sign-in and management handlers.
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig returns in-memory key, user and session stores.
+func testConfig() Config {
+	keys, _ := OpenKeyStore("")
+	users, _ := OpenUserStore("")
+	return Config{Keys: keys, Users: users, Sessions: NewSessions(time.Hour, false)}
+}
+
+// testRouter returns a router with a read and a control route, a route
+// switching the ":device" named in its URL and the sign-in and management
+// routes, all behind the middleware.
+func testRouter(cfg Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	logger := logrus.New()
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "success"}) }
+	admin := Require(cfg, logger, ScopeAdmin)
+	router.GET("/state", Require(cfg, logger, ScopeRead), ok)
+	router.POST("/on", Require(cfg, logger, ScopeControl), ok)
+	router.POST("/devices/:device/:action", RequireFor(cfg, logger, func(c *gin.Context) Access {
+		return Access{Scope: ScopeControl, Device: c.Param("device"), Switch: c.Param("action") == "on"}
+	}), ok)
+	router.POST("/api/v1/auth/login", LoginHandler(router, logger, cfg))
+	router.POST("/api/v1/auth/logout", LogoutHandler(router, logger, cfg))
+	router.GET("/api/v1/auth/me", Require(cfg, logger, ""), MeHandler(router, logger))
+	router.GET("/api/v1/auth/keys", admin, ListKeysHandler(router, logger, cfg.Keys))
+	router.POST("/api/v1/auth/keys", admin, CreateKeyHandler(router, logger, cfg.Keys))
+	router.DELETE("/api/v1/auth/keys/:id", admin, RevokeKeyHandler(router, logger, cfg.Keys))
+	router.POST("/api/v1/auth/users", admin, CreateUserHandler(router, logger, cfg))
+	router.PATCH("/api/v1/auth/users/:username", admin, UpdateUserHandler(router, logger, cfg))
+	return router
+}
+
+// serve sends a request with the given key and decodes the JSON response.
+func serve(t *testing.T, router *gin.Engine, method, target, key, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+// TestRequire verifies that requests without a valid key get 401 and those
+// whose key lacks the scope 403.
+func TestRequire(t *testing.T) {
+	cfg := testConfig()
+	_, reader, _ := cfg.Keys.Create("dashboard", []Scope{ScopeRead})
+	router := testRouter(cfg)
+
+	code, _ := serve(t, router, http.MethodGet, "/state", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = serve(t, router, http.MethodGet, "/state", "alf_nope_nope", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = serve(t, router, http.MethodGet, "/state", reader, "")
+	assert.Equal(t, http.StatusOK, code)
+	code, response := serve(t, router, http.MethodPost, "/on", reader, "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, response["error"], "control")
+
+	req := httptest.NewRequest(http.MethodGet, "/state", nil)
+	req.Header.Set("X-API-Key", reader)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestKeyHandlers verifies that admins can create, list and revoke keys and
+// that secrets are only returned on creation.
+func TestKeyHandlers(t *testing.T) {
+	cfg := testConfig()
+	admin, adminSecret, _ := cfg.Keys.Create("initial admin", []Scope{ScopeAdmin})
+	router := testRouter(cfg)
+
+	code, response := serve(t, router, http.MethodPost, "/api/v1/auth/keys", adminSecret, `{"name": "Remote", "scopes": ["control"]}`)
+	assert.Equal(t, http.StatusCreated, code)
+	secret := response["secret"].(string)
+	id := response["key"].(map[string]interface{})["id"].(string)
+
+	code, _ = serve(t, router, http.MethodPost, "/on", secret, "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(t, router, http.MethodGet, "/api/v1/auth/keys", secret, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, response = serve(t, router, http.MethodPost, "/api/v1/auth/keys", adminSecret, `{"name": "Bad", "scopes": ["root"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response["error"], "root")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecret)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.Contains(t, w.Body.String(), "Remote")
+
+	code, _ = serve(t, router, http.MethodDelete, "/api/v1/auth/keys/"+id, adminSecret, "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(t, router, http.MethodPost, "/on", secret, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = serve(t, router, http.MethodDelete, "/api/v1/auth/keys/"+admin.ID, adminSecret, "")
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+// signIn logs a user in and returns the session cookie.
+func signIn(t *testing.T, router *gin.Engine, username, password string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/login",
+		strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`)))
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookie && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("sign-in as %s failed: %d %s", username, w.Code, w.Body.String())
+	return nil
+}
+
+// serveAs sends a request with a session cookie and returns the status.
+func serveAs(router *gin.Engine, cookie *http.Cookie, method, target string) int {
+	req := httptest.NewRequest(method, target, nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+// TestSessionRoles verifies that signed-in users are admitted by role and
+// that guests may only switch the devices shared with them.
+func TestSessionRoles(t *testing.T) {
+	cfg := testConfig()
+	_, adminSecret, _ := cfg.Keys.Create("initial admin", []Scope{ScopeAdmin})
+	router := testRouter(cfg)
+
+	for _, body := range []string{
+		`{"username": "Alice", "password": "correct horse", "role": "member"}`,
+		`{"username": "sitter", "password": "battery staple", "role": "guest"}`,
+	} {
+		code, _ := serve(t, router, http.MethodPost, "/api/v1/auth/users", adminSecret, body)
+		assert.Equal(t, http.StatusCreated, code)
+	}
+	code, _ := serve(t, router, http.MethodPost, "/api/v1/auth/users", adminSecret, `{"username": "bob", "password": "short", "role": "member"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(t, router, http.MethodPatch, "/api/v1/auth/users/sitter", adminSecret, `{"devices": ["kasa:aquarium"]}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username": "alice", "password": "wrong"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	member := signIn(t, router, "alice", "correct horse")
+	assert.True(t, member.HttpOnly)
+	assert.Equal(t, http.StatusOK, serveAs(router, member, http.MethodPost, "/on"))
+	assert.Equal(t, http.StatusForbidden, serveAs(router, member, http.MethodGet, "/api/v1/auth/keys"))
+
+	guest := signIn(t, router, "sitter", "battery staple")
+	assert.Equal(t, http.StatusOK, serveAs(router, guest, http.MethodPost, "/devices/kasa:aquarium/on"))
+	assert.Equal(t, http.StatusForbidden, serveAs(router, guest, http.MethodPost, "/devices/kasa:aquarium/rename"))
+	assert.Equal(t, http.StatusForbidden, serveAs(router, guest, http.MethodPost, "/devices/kasa:rack/on"))
+	assert.Equal(t, http.StatusForbidden, serveAs(router, guest, http.MethodGet, "/state"))
+	assert.Equal(t, http.StatusOK, serveAs(router, guest, http.MethodGet, "/api/v1/auth/me"))
+
+	// Unsharing takes effect immediately, a password change signs out.
+	code, _ = serve(t, router, http.MethodPatch, "/api/v1/auth/users/sitter", adminSecret, `{"devices": []}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusForbidden, serveAs(router, guest, http.MethodPost, "/devices/kasa:aquarium/on"))
+	code, _ = serve(t, router, http.MethodPatch, "/api/v1/auth/users/alice", adminSecret, `{"password": "new password"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusUnauthorized, serveAs(router, member, http.MethodPost, "/on"))
+
+	guest = signIn(t, router, "sitter", "battery staple")
+	router.ServeHTTP(httptest.NewRecorder(), func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+		req.AddCookie(guest)
+		return req
+	}())
+	assert.Equal(t, http.StatusUnauthorized, serveAs(router, guest, http.MethodGet, "/api/v1/auth/me"))
+}
@@ -0,0 +1,122 @@
+// Package auth authenticates API clients and checks what they may do.
+// This file keeps the login sessions of the web interface.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionCookie is the name of the cookie holding the session token.
+const SessionCookie = "alfred_session"
+
+// Sessions keeps the sessions of signed-in users in memory, so restarting
+// the server signs everybody out. A session expires after being idle for
+// its time to live. It is safe for concurrent use.
+type Sessions struct {
+	ttl    time.Duration
+	secure bool // Whether cookies are only sent over HTTPS
+
+	mu       sync.Mutex
+	sessions map[string]session // Keyed by the SHA-256 of the token
+}
+
+// session is a signed-in user.
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// NewSessions returns an empty session store. Sessions expire after being
+// idle for ttl; secure marks their cookies for HTTPS only.
+func NewSessions(ttl time.Duration, secure bool) *Sessions {
+	return &Sessions{ttl: ttl, secure: secure, sessions: map[string]session{}}
+}
+
+// Create starts a session for a user and returns its token.
+func (s *Sessions) Create(username string) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	s.sessions[hashToken(token)] = session{username: username, expires: time.Now().Add(s.ttl)}
+	return token, nil
+}
+
+// Lookup returns the user of a session and extends it.
+func (s *Sessions) Lookup(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hashToken(token)
+	sess, ok := s.sessions[key]
+	if !ok || time.Now().After(sess.expires) {
+		delete(s.sessions, key)
+		return "", false
+	}
+	sess.expires = time.Now().Add(s.ttl)
+	s.sessions[key] = sess
+	return sess.username, true
+}
+
+// Remove ends a session.
+func (s *Sessions) Remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, hashToken(token))
+}
+
+// RemoveUser ends every session of a user, e.g. after a password change.
+func (s *Sessions) RemoveUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, sess := range s.sessions {
+		if strings.EqualFold(sess.username, username) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// Cookie returns the cookie carrying a session token. An empty token
+// returns a cookie deleting it.
+func (s *Sessions) Cookie(token string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(s.ttl / time.Second),
+	}
+	if token == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// expireLocked drops expired sessions. s.mu must be held.
+func (s *Sessions) expireLocked() {
+	now := time.Now()
+	for key, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// hashToken returns the map key of a session token, so the tokens
+// themselves are not kept in memory.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,288 @@
+// Package auth authenticates API clients and checks what they may do.
+// This file stores the user accounts of the web interface.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role decides what a signed-in user may do.
+type Role string
+
+// Roles of user accounts.
+const (
+	RoleAdmin  Role = "admin"  // Everything, like an API key with the admin scope
+	RoleMember Role = "member" // Read, control and discover devices
+	RoleGuest  Role = "guest"  // Read and switch the devices shared with them
+)
+
+// minPasswordLength is the shortest password accepted for an account.
+const minPasswordLength = 8
+
+var (
+	// ErrUserNotFound is returned for usernames that are not stored.
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserExists is returned when creating an account whose username is taken.
+	ErrUserExists = errors.New("user already exists")
+
+	// ErrInvalidCredentials is returned for an unknown username or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrInvalidUser is wrapped by errors about invalid account settings.
+	ErrInvalidUser = errors.New("invalid user")
+
+	// ErrLastAdminUser is returned when a change would leave no admin account.
+	ErrLastAdminUser = errors.New("cannot remove the last admin user")
+)
+
+// usernamePattern restricts usernames to characters that are safe in URLs
+// and logs.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// User is an account of the web interface. Only a bcrypt hash of the
+// password is kept.
+type User struct {
+	Username     string    `json:"username"`
+	Role         Role      `json:"role"`
+	Devices      []string  `json:"devices,omitempty"`      // Device IDs shared with a guest, e.g. "kasa:50c7bf010203"
+	PasswordHash string    `json:"passwordHash,omitempty"` // bcrypt hash of the password
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Shares reports whether a device is shared with the user. Device IDs are
+// compared without regard to case.
+func (u User) Shares(device string) bool {
+	for _, d := range u.Devices {
+		if strings.EqualFold(d, device) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserUpdate holds the fields of an account to change; nil fields are kept.
+type UserUpdate struct {
+	Role     *Role     `json:"role"`
+	Password *string   `json:"password"`
+	Devices  *[]string `json:"devices"`
+}
+
+// ParseRole validates a role name.
+func ParseRole(name string) (Role, error) {
+	switch r := Role(strings.ToLower(strings.TrimSpace(name))); r {
+	case RoleAdmin, RoleMember, RoleGuest:
+		return r, nil
+	}
+	return "", fmt.Errorf("%w: role %q, expected admin, member or guest", ErrInvalidUser, name)
+}
+
+// UserStore keeps user accounts in a JSON file. It is safe for concurrent use.
+type UserStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]User
+}
+
+// OpenUserStore loads the accounts stored at path, starting empty if the
+// file does not exist. An empty path keeps the accounts in memory only.
+func OpenUserStore(path string) (*UserStore, error) {
+	s := &UserStore{path: path, users: map[string]User{}}
+
+	var users []User
+	if err := jsonfile.Load(path, &users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		s.users[u.Username] = u
+	}
+	return s, nil
+}
+
+// Create adds an account. Usernames are lower case.
+func (s *UserStore) Create(username, password string, role Role) (User, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return User{}, fmt.Errorf("%w: username %q, expected up to 32 letters, digits, dots, dashes or underscores", ErrInvalidUser, username)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return User{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; ok {
+		return User{}, ErrUserExists
+	}
+	u := User{Username: username, Role: role, PasswordHash: hash, CreatedAt: time.Now().UTC()}
+	s.users[username] = u
+	if err := s.save(); err != nil {
+		delete(s.users, username)
+		return User{}, err
+	}
+	return u.public(), nil
+}
+
+// Authenticate returns the account matching a username and password.
+func (s *UserStore) Authenticate(username, password string) (User, error) {
+	s.mu.RLock()
+	u, ok := s.users[strings.ToLower(strings.TrimSpace(username))]
+	s.mu.RUnlock()
+	if !ok {
+		// Compare anyway so unknown usernames take as long as wrong passwords.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return u.public(), nil
+}
+
+// Get returns an account without its password hash.
+func (s *UserStore) Get(username string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[strings.ToLower(username)]
+	return u.public(), ok
+}
+
+// List returns every account, without password hashes, sorted by username.
+func (s *UserStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u.public())
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// Update changes the role, password or shared devices of an account.
+func (s *UserStore) Update(username string, upd UserUpdate) (User, error) {
+	var hash string
+	if upd.Password != nil {
+		var err error
+		if hash, err = hashPassword(*upd.Password); err != nil {
+			return User{}, err
+		}
+	}
+	if upd.Role != nil {
+		if _, err := ParseRole(string(*upd.Role)); err != nil {
+			return User{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	previous := u
+	if upd.Role != nil {
+		if u.Role == RoleAdmin && *upd.Role != RoleAdmin && s.adminsLocked() == 1 {
+			return User{}, ErrLastAdminUser
+		}
+		u.Role = *upd.Role
+	}
+	if hash != "" {
+		u.PasswordHash = hash
+	}
+	if upd.Devices != nil {
+		u.Devices = uniqueDevices(*upd.Devices)
+	}
+	s.users[u.Username] = u
+	if err := s.save(); err != nil {
+		s.users[u.Username] = previous
+		return User{}, err
+	}
+	return u.public(), nil
+}
+
+// Remove deletes an account. The last admin account cannot be removed.
+func (s *UserStore) Remove(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Role == RoleAdmin && s.adminsLocked() == 1 {
+		return ErrLastAdminUser
+	}
+	delete(s.users, u.Username)
+	return s.save()
+}
+
+// adminsLocked counts the admin accounts. s.mu must be held.
+func (s *UserStore) adminsLocked() int {
+	n := 0
+	for _, u := range s.users {
+		if u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// public returns a copy of the account without its password hash.
+func (u User) public() User {
+	u.PasswordHash = ""
+	return u
+}
+
+// dummyHash is compared against when a username is unknown.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// hashPassword checks the length of a password and returns its bcrypt hash.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("%w: the password must have at least %d characters", ErrInvalidUser, minPasswordLength)
+	}
+	if len(password) > 72 {
+		return "", fmt.Errorf("%w: the password must have at most 72 bytes", ErrInvalidUser)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// uniqueDevices trims a list of device IDs and removes empty and duplicate
+// entries.
+func uniqueDevices(devices []string) []string {
+	var unique []string
+	for _, d := range devices {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if !(User{Devices: unique}).Shares(d) {
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
+
+// save writes the accounts to disk. s.mu must be held.
+func (s *UserStore) save() error {
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return jsonfile.Save(s.path, users)
+}
@@ -0,0 +1,60 @@
+// Package auth authenticates API clients and checks what they may do.
+// This test file contains unit tests for the user account store.
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUserStore verifies that passwords are stored as bcrypt hashes, that
+// accounts survive a reload and that the last admin cannot be removed.
+func TestUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	users, err := OpenUserStore(path)
+	assert.NoError(t, err)
+
+	admin, err := users.Create("Root", "correct horse", RoleAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", admin.Username)
+	assert.Empty(t, admin.PasswordHash)
+	_, err = users.Create("root", "another password", RoleMember)
+	assert.ErrorIs(t, err, ErrUserExists)
+	_, err = users.Create("../etc", "correct horse", RoleMember)
+	assert.ErrorIs(t, err, ErrInvalidUser)
+	_, err = users.Create("guest", "correct horse", Role("owner"))
+	assert.ErrorIs(t, err, ErrInvalidUser)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "correct horse")
+	assert.Contains(t, string(data), `"passwordHash": "$2a$`)
+
+	reloaded, err := OpenUserStore(path)
+	assert.NoError(t, err)
+	u, err := reloaded.Authenticate("ROOT", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleAdmin, u.Role)
+	_, err = reloaded.Authenticate("root", "wrong horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = reloaded.Authenticate("nobody", "correct horse")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	member := RoleMember
+	_, err = reloaded.Update("root", UserUpdate{Role: &member})
+	assert.ErrorIs(t, err, ErrLastAdminUser)
+	assert.ErrorIs(t, reloaded.Remove("root"), ErrLastAdminUser)
+
+	_, err = reloaded.Create("sitter", "battery staple", RoleGuest)
+	assert.NoError(t, err)
+	devices := []string{"kasa:aquarium", " KASA:Aquarium ", "", "hue:bridge:1"}
+	u, err = reloaded.Update("sitter", UserUpdate{Devices: &devices})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kasa:aquarium", "hue:bridge:1"}, u.Devices)
+	assert.True(t, u.Shares("kasa:AQUARIUM"))
+	assert.NoError(t, reloaded.Remove("sitter"))
+	assert.ErrorIs(t, reloaded.Remove("sitter"), ErrUserNotFound)
+}
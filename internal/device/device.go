@@ -0,0 +1,254 @@
+// Package device presents outlets, lights and other devices through a single
+// abstraction. Every device reports the capabilities it supports, and commands
+// are dispatched to the brand-specific implementation that owns it.
+package device
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotFound is returned for device IDs no provider knows.
+	ErrNotFound = errors.New("device not found")
+
+	// ErrUnsupportedCommand is returned for command names that do not exist.
+	ErrUnsupportedCommand = errors.New("unsupported command")
+
+	// ErrNotCapable is returned when a device lacks the capability a command needs.
+	ErrNotCapable = errors.New("device does not have the capability")
+
+	// ErrOffline is returned for commands to a device that does not answer.
+	ErrOffline = errors.New("device is offline")
+
+	// ErrInvalidRequest is wrapped by errors caused by malformed command arguments.
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// Capability is a feature a device supports.
+type Capability string
+
+// Capabilities reported by devices.
+const (
+	Switchable     Capability = "switchable"      // Can be switched on and off
+	Dimmable       Capability = "dimmable"        // Has an adjustable brightness
+	Color          Capability = "color"           // Accepts colors or color temperatures
+	EnergyMetering Capability = "energy-metering" // Measures its power consumption
+	MultiChannel   Capability = "multi-channel"   // Has independently switched channels, e.g. sockets
+)
+
+// Command names understood by every provider.
+const (
+	CommandOn         = "on"
+	CommandOff        = "off"
+	CommandToggle     = "toggle"
+	CommandBrightness = "brightness"
+	CommandColor      = "color"
+	CommandEnergy     = "energy"
+)
+
+// commandCapabilities maps each command to the capability it requires.
+var commandCapabilities = map[string]Capability{
+	CommandOn:         Switchable,
+	CommandOff:        Switchable,
+	CommandToggle:     Switchable,
+	CommandBrightness: Dimmable,
+	CommandColor:      Color,
+	CommandEnergy:     EnergyMetering,
+}
+
+// Channel is an independently switched part of a device, such as one socket
+// of a power strip.
+type Channel struct {
+	ID   string `json:"id"`   // Channel identifier within the device
+	Name string `json:"name"` // User-assigned name
+	On   bool   `json:"on"`   // Power state
+}
+
+// Info describes a device and its current state.
+type Info struct {
+	ID           string                 `json:"id"`                 // Unique ID, "<provider>:<native ID>"
+	Name         string                 `json:"name"`               // User-assigned name
+	Kind         string                 `json:"kind"`               // Device class, e.g. "outlet" or "light"
+	Brand        string                 `json:"brand"`              // Vendor, e.g. "kasa"
+	Model        string                 `json:"model,omitempty"`    // Vendor model name
+	Room         string                 `json:"room,omitempty"`     // Room the device is in
+	Online       bool                   `json:"online"`             // Whether the device answered
+	Capabilities []Capability           `json:"capabilities"`       // Supported capabilities, sorted
+	Channels     []Channel              `json:"channels,omitempty"` // Channels of multi-channel devices
+	State        map[string]interface{} `json:"state,omitempty"`    // Current state, e.g. "on" and "brightness"
+}
+
+// Has reports whether the device supports capability c.
+func (i Info) Has(c Capability) bool {
+	for _, have := range i.Capabilities {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// channel returns the channel named by ID, by name ignoring case, or by
+// zero-based index.
+func (i Info) channel(ref string) (Channel, bool) {
+	for _, ch := range i.Channels {
+		if ch.ID == ref || strings.EqualFold(ch.Name, ref) {
+			return ch, true
+		}
+	}
+	if idx, err := strconv.Atoi(ref); err == nil && idx >= 0 && idx < len(i.Channels) {
+		return i.Channels[idx], true
+	}
+	return Channel{}, false
+}
+
+// Command is a request to change or read a device.
+type Command struct {
+	Name    string // One of the Command* constants
+	Channel string // Channel ID or name, for multi-channel devices
+	Args    []byte // JSON arguments, e.g. {"brightness": 40}; may be empty
+
+	// Authorize checks an action that only turns out while executing, such
+	// as "off" for dimming to 0. It may be nil.
+	Authorize func(action string) error
+}
+
+// Provider exposes the devices of one brand or protocol. Device IDs start
+// with the provider's prefix followed by a colon.
+type Provider interface {
+	// Prefix returns the ID prefix of the provider's devices, e.g. "kasa".
+	Prefix() string
+
+	// Devices returns every device the provider knows.
+	Devices() ([]Info, error)
+
+	// Device returns the device with the given ID.
+	Device(id string) (Info, error)
+
+	// Execute runs a command on a device. The manager has already checked
+	// that the device has the capability the command requires and resolved
+	// cmd.Channel to a channel ID.
+	Execute(id string, cmd Command) (interface{}, error)
+}
+
+// Manager routes device requests to the provider owning each device.
+type Manager struct {
+	providers map[string]Provider
+	logger    *logrus.Logger
+}
+
+// NewManager returns a manager over the given providers.
+func NewManager(logger *logrus.Logger, providers ...Provider) *Manager {
+	m := &Manager{providers: map[string]Provider{}, logger: logger}
+	for _, p := range providers {
+		m.providers[p.Prefix()] = p
+	}
+	return m
+}
+
+// List returns the devices of every provider, sorted by room and name. A
+// provider that fails is logged and skipped so the others are still listed;
+// its error is returned alongside the devices.
+func (m *Manager) List() ([]Info, []error) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		devices []Info
+		errs    []error
+	)
+	for prefix, p := range m.providers {
+		wg.Add(1)
+		go func(prefix string, p Provider) {
+			defer wg.Done()
+			found, err := p.Devices()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				m.logger.Warnf("Error listing %s devices: %v", prefix, err)
+				errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+			}
+			devices = append(devices, found...)
+		}(prefix, p)
+	}
+	wg.Wait()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Room != devices[j].Room {
+			return devices[i].Room < devices[j].Room
+		}
+		if devices[i].Name != devices[j].Name {
+			return devices[i].Name < devices[j].Name
+		}
+		return devices[i].ID < devices[j].ID
+	})
+	return devices, errs
+}
+
+// Get returns the device with the given ID.
+func (m *Manager) Get(id string) (Info, error) {
+	p, err := m.provider(id)
+	if err != nil {
+		return Info{}, err
+	}
+	return p.Device(id)
+}
+
+// Execute runs a command on a device after checking that the device has the
+// capability the command requires.
+func (m *Manager) Execute(id string, cmd Command) (interface{}, error) {
+	required, ok := commandCapabilities[cmd.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCommand, cmd.Name)
+	}
+
+	p, err := m.provider(id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := p.Device(id)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Online {
+		return nil, fmt.Errorf("%w: %s", ErrOffline, id)
+	}
+	if !info.Has(required) {
+		return nil, fmt.Errorf("%w: %s needs %s", ErrNotCapable, cmd.Name, required)
+	}
+	if cmd.Channel != "" {
+		if !info.Has(MultiChannel) {
+			return nil, fmt.Errorf("%w: channels need %s", ErrNotCapable, MultiChannel)
+		}
+		ch, ok := info.channel(cmd.Channel)
+		if !ok {
+			return nil, fmt.Errorf("%w: channel %q of %s", ErrNotFound, cmd.Channel, id)
+		}
+		cmd.Channel = ch.ID
+	}
+
+	m.logger.Debugf("Executing %s on device %s", cmd.Name, id)
+	return p.Execute(id, cmd)
+}
+
+// provider returns the provider owning a device ID.
+func (m *Manager) provider(id string) (Provider, error) {
+	prefix, _, ok := strings.Cut(id, ":")
+	if p, known := m.providers[prefix]; ok && known {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// SortCapabilities sorts caps in place and returns it, so providers report
+// capabilities in a stable order.
+func SortCapabilities(caps []Capability) []Capability {
+	sort.Slice(caps, func(i, j int) bool { return caps[i] < caps[j] })
+	return caps
+}
@@ -0,0 +1,180 @@
+// Package device presents outlets, lights and other devices through a single
+// abstraction.
+// This test file contains unit tests for the device manager and its handlers.
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider serves a fixed set of devices and records executed commands.
+type fakeProvider struct {
+	prefix   string
+	devices  []Info
+	err      error
+	executed []Command
+}
+
+func (f *fakeProvider) Prefix() string { return f.prefix }
+
+func (f *fakeProvider) Devices() ([]Info, error) { return f.devices, f.err }
+
+func (f *fakeProvider) Device(id string) (Info, error) {
+	for _, d := range f.devices {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return Info{}, ErrNotFound
+}
+
+func (f *fakeProvider) Execute(id string, cmd Command) (interface{}, error) {
+	f.executed = append(f.executed, cmd)
+	return gin.H{"ok": true}, nil
+}
+
+// testManager returns a manager over an outlet provider with a plug and a
+// power strip, and a light provider with one bulb and a failing bridge.
+func testManager() (*Manager, *fakeProvider, *fakeProvider) {
+	outlets := &fakeProvider{prefix: "kasa", devices: []Info{
+		{ID: "kasa:plug", Name: "Kettle", Kind: "outlet", Room: "Kitchen", Online: true,
+			Capabilities: []Capability{EnergyMetering, Switchable}},
+		{ID: "kasa:strip", Name: "Desk", Kind: "outlet", Room: "Office", Online: true,
+			Capabilities: []Capability{MultiChannel, Switchable},
+			Channels:     []Channel{{ID: "strip00", Name: "Monitor"}, {ID: "strip01", Name: "Lamp"}}},
+		{ID: "kasa:away", Name: "Heater", Kind: "outlet", Capabilities: []Capability{Switchable}},
+	}}
+	lights := &fakeProvider{prefix: "hue", err: errors.New("bridge unreachable"), devices: []Info{
+		{ID: "hue:b:1", Name: "Ceiling", Kind: "light", Room: "Kitchen", Online: true,
+			Capabilities: []Capability{Dimmable, Switchable}},
+	}}
+	return NewManager(logrus.New(), outlets, lights), outlets, lights
+}
+
+// serveDevices routes a single request through the device handlers.
+func serveDevices(t *testing.T, mgr *Manager, method, target, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return serveDevicesWith(t, mgr, nil, method, target, body)
+}
+
+// serveDevicesWith is serveDevices with commands checked against rules.
+func serveDevicesWith(t *testing.T, mgr *Manager, rules *acl.List, method, target, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/devices", ListHandler(router, logrus.New(), mgr))
+	router.GET("/api/v1/devices/:id", GetHandler(router, logrus.New(), mgr))
+	router.POST("/api/v1/devices/:id/:command", CommandHandler(router, logrus.New(), mgr, rules))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w, response
+}
+
+// TestListDevices verifies that devices of every provider are listed
+// together, can be filtered and that failing providers are reported.
+func TestListDevices(t *testing.T) {
+	mgr, _, _ := testManager()
+
+	w, response := serveDevices(t, mgr, http.MethodGet, "/api/v1/devices", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	devices := response["devices"].([]interface{})
+	assert.Len(t, devices, 4)
+	assert.Equal(t, "kasa:away", devices[0].(map[string]interface{})["id"])
+	assert.Equal(t, []interface{}{"hue: bridge unreachable"}, response["errors"])
+
+	_, response = serveDevices(t, mgr, http.MethodGet, "/api/v1/devices?capability=switchable&room=Kitchen", "")
+	devices = response["devices"].([]interface{})
+	assert.Len(t, devices, 2)
+	assert.Equal(t, "Ceiling", devices[0].(map[string]interface{})["name"])
+	assert.Equal(t, "Kettle", devices[1].(map[string]interface{})["name"])
+
+	_, response = serveDevices(t, mgr, http.MethodGet, "/api/v1/devices?capability=dimmable", "")
+	assert.Len(t, response["devices"], 1)
+}
+
+// TestDeviceCommands verifies that commands are checked against the
+// device's capabilities before they are dispatched.
+func TestDeviceCommands(t *testing.T) {
+	mgr, outlets, lights := testManager()
+
+	w, response := serveDevices(t, mgr, http.MethodPost, "/api/v1/devices/hue:b:1/brightness", `{"brightness": 40}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "success", response["status"])
+	assert.Equal(t, `{"brightness": 40}`, string(lights.executed[0].Args))
+
+	w, _ = serveDevices(t, mgr, http.MethodPost, "/api/v1/devices/kasa:strip/off?channel=1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "strip01", outlets.executed[0].Channel)
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/devices/kasa:plug/brightness", http.StatusUnprocessableEntity},
+		{"/api/v1/devices/kasa:plug/off?channel=1", http.StatusUnprocessableEntity},
+		{"/api/v1/devices/kasa:strip/off?channel=Fan", http.StatusNotFound},
+		{"/api/v1/devices/kasa:away/on", http.StatusServiceUnavailable},
+		{"/api/v1/devices/zigbee:1/on", http.StatusNotFound},
+		{"/api/v1/devices/kasa:plug/explode", http.StatusBadRequest},
+	} {
+		w, _ := serveDevices(t, mgr, http.MethodPost, tc.target, "")
+		assert.Equal(t, tc.status, w.Code, tc.target)
+	}
+	assert.Len(t, outlets.executed, 1)
+}
+
+// TestCommandAccessRules verifies that commands are checked against the
+// access rules, including those of the channels a command switches off, and
+// that providers can check actions that only turn out
+// while executing, such as dimming a protected light to 0.
+func TestCommandAccessRules(t *testing.T) {
+	mgr, outlets, lights := testManager()
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: "hue:b:1", Protected: true})
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: "kasa:plug", Protected: true})
+	assert.NoError(t, err)
+
+	w, _ := serveDevicesWith(t, mgr, rules, http.MethodPost, "/api/v1/devices/kasa:plug/off", "")
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	assert.Empty(t, outlets.executed)
+
+	// Switching off a power strip switches off its protected socket too.
+	_, err = rules.Set(acl.Rule{Device: "kasa:strip:strip01", Protected: true})
+	assert.NoError(t, err)
+	for _, command := range []string{"off", "toggle"} {
+		w, _ = serveDevicesWith(t, mgr, rules, http.MethodPost, "/api/v1/devices/kasa:strip/"+command, "")
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code, command)
+	}
+	assert.Empty(t, outlets.executed)
+	w, _ = serveDevicesWith(t, mgr, rules, http.MethodPost, "/api/v1/devices/kasa:strip/off?channel=Monitor", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = serveDevicesWith(t, mgr, rules, http.MethodPost, "/api/v1/devices/kasa:strip/off?confirm=true", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, outlets.executed, 2)
+
+	w, _ = serveDevicesWith(t, mgr, rules, http.MethodPost, "/api/v1/devices/hue:b:1/brightness", `{"brightness": 0}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	authorize := lights.executed[0].Authorize
+	if assert.NotNil(t, authorize) {
+		assert.ErrorIs(t, authorize("off"), acl.ErrConfirmationRequired)
+		assert.NoError(t, authorize("on"))
+	}
+}
@@ -0,0 +1,196 @@
+// Package device presents outlets, lights and other devices through a single
+// abstraction.
+// This file provides the HTTP handlers for the unified device API.
+package device
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxCommandBody bounds the size of a command's JSON arguments.
+const maxCommandBody = 64 << 10
+
+// ListHandler returns every device of every provider. The "kind", "room" and
+// "capability" query parameters narrow the list down. Providers that could
+// not be reached are reported under "errors".
+//
+// Example URLs:
+//
+//	GET /api/v1/devices
+//	GET /api/v1/devices?capability=dimmable&room=Kitchen
+func ListHandler(svr *gin.Engine, logger *logrus.Logger, mgr *Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		all, errs := mgr.List()
+		kind, room, capability := c.Query("kind"), c.Query("room"), Capability(c.Query("capability"))
+
+		devices := make([]Info, 0, len(all))
+		for _, d := range all {
+			if (kind != "" && d.Kind != kind) || (room != "" && d.Room != room) || (capability != "" && !d.Has(capability)) {
+				continue
+			}
+			devices = append(devices, d)
+		}
+		logger.Debugf("Listing %d devices", len(devices))
+
+		response := gin.H{"devices": devices}
+		if len(errs) > 0 {
+			messages := make([]string, len(errs))
+			for i, err := range errs {
+				messages[i] = err.Error()
+			}
+			response["errors"] = messages
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetHandler returns the device named by the ":id" URL parameter.
+//
+// Example URL: GET /api/v1/devices/kasa:50c7bf010203
+func GetHandler(svr *gin.Engine, logger *logrus.Logger, mgr *Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		d, err := mgr.Get(id)
+		if err != nil {
+			logger.Errorf("Error reading device %s: %v", id, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"device": d})
+	}
+}
+
+// CommandHandler runs the ":command" URL parameter on the device named by
+// ":id". The request body holds the command's arguments, and the "channel"
+// query parameter addresses one channel of a multi-channel device.
+//
+// Commands and the capability they need:
+//   - on, off, toggle: switchable
+//   - brightness: dimmable, e.g. {"brightness": 40} or {"brightness": "-10"}
+//   - color: color, e.g. {"hex": "#ff8800"} or {"kelvin": 2700}
+//   - energy: energy-metering, returns a realtime reading
+//
+// A device without the needed capability is answered with 422, one that does
+// not answer with 503. Commands are checked against the access rules in
+// rules, which may be nil.
+//
+// Example URLs:
+//
+//	POST /api/v1/devices/kasa:50c7bf010203/toggle
+//	POST /api/v1/devices/kasa:50c7bf010204/off?channel=2
+//	POST /api/v1/devices/hue:001788fffe23bfc2:3f1c.../brightness {"brightness": 40}
+func CommandHandler(svr *gin.Engine, logger *logrus.Logger, mgr *Manager, rules *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		cmd := Command{Name: c.Param("command"), Channel: c.Query("channel")}
+
+		args, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCommandBody+1))
+		if err != nil || len(args) > maxCommandBody {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "could not read command arguments"})
+			return
+		}
+		cmd.Args = args
+
+		logger.Debugf("Received command: 'id=%s', 'command=%s', 'channel=%s'", id, cmd.Name, cmd.Channel)
+		if rules != nil {
+			req, channels := commandRequest(c, mgr, rules, id, cmd)
+			if err := checkCommand(rules, req, channels); err != nil {
+				logger.Warnf("Refused command %s on %s: %v", cmd.Name, id, err)
+				c.JSON(statusForError(err), gin.H{"error": err.Error()})
+				return
+			}
+			cmd.Authorize = func(action string) error {
+				req.Action = action
+				return checkCommand(rules, req, channels)
+			}
+		}
+		result, err := mgr.Execute(id, cmd)
+		if err != nil {
+			logger.Errorf("Error executing command %s on %s: %v", cmd.Name, id, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		response := gin.H{
+			"id":      id,
+			"command": cmd.Name,
+			"result":  result,
+			"status":  "success",
+		}
+		if cmd.Channel != "" {
+			response["channel"] = cmd.Channel
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// commandRequest describes a command for the access rules. When rules
+// restrict devices of the provider, the device is looked up so they apply to
+// its canonical ID and channel ID however the request names them, and its
+// channels are returned.
+func commandRequest(c *gin.Context, mgr *Manager, rules *acl.List, id string, cmd Command) (acl.Request, []Channel) {
+	req := acl.FromRequest(c, id, cmd.Name)
+	prefix, _, _ := strings.Cut(id, ":")
+	if !rules.Restricts(prefix + ":") {
+		return req, nil
+	}
+	info, err := mgr.Get(id)
+	if err != nil {
+		return req, nil
+	}
+	req.Device = info.ID
+	if cmd.Channel != "" {
+		if ch, ok := info.channel(cmd.Channel); ok {
+			req.Channel = ch.ID
+		}
+	}
+	return req, info.Channels
+}
+
+// checkCommand evaluates the access rules for a command. Switching off a
+// multi-channel device without naming a channel switches off every channel,
+// so the rules of each channel apply as well.
+func checkCommand(rules *acl.List, req acl.Request, channels []Channel) error {
+	if err := rules.Check(req); err != nil {
+		return err
+	}
+	if req.Channel != "" || !acl.SwitchesOff(req.Action) || !rules.Restricts(req.Device+":") {
+		return nil
+	}
+	for _, ch := range channels {
+		req.Channel = ch.ID
+		if err := rules.Check(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusForError maps a device error to the HTTP status returned to the client.
+func statusForError(err error) int {
+	var netErr net.Error
+	if status, ok := acl.StatusForError(err); ok {
+		return status
+	}
+	switch {
+	case errors.Is(err, ErrUnsupportedCommand), errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotCapable):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrOffline):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,83 @@
+// Package light provides functionality for controlling smart lights.
+// This file parses brightness requests into absolute levels.
+package light
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxTransition is the longest transition the bridge accepts, in milliseconds.
+const maxTransition = 6553500
+
+// BrightnessRequest is the body of a brightness action.
+//
+// Brightness is either an absolute percentage (40, "40" or "40%") or a step
+// relative to the current level ("+10", "-25"). Transition is an optional
+// fade duration in milliseconds.
+type BrightnessRequest struct {
+	Brightness json.RawMessage `json:"brightness"`
+	Transition *int            `json:"transition"`
+}
+
+// brightnessLevel is a parsed brightness value.
+type brightnessLevel struct {
+	value    float64
+	relative bool
+}
+
+// parse validates the request and returns the requested level.
+func (r BrightnessRequest) parse() (brightnessLevel, error) {
+	if r.Transition != nil && (*r.Transition < 0 || *r.Transition > maxTransition) {
+		return brightnessLevel{}, fmt.Errorf("transition must be between 0 and %d ms", maxTransition)
+	}
+	if len(r.Brightness) == 0 || string(r.Brightness) == "null" {
+		return brightnessLevel{}, errors.New(`expected {"brightness": 0-100, "+10" or "-25"}`)
+	}
+
+	var number float64
+	if err := json.Unmarshal(r.Brightness, &number); err == nil {
+		return absoluteLevel(number)
+	}
+
+	var text string
+	if err := json.Unmarshal(r.Brightness, &text); err != nil {
+		return brightnessLevel{}, errors.New("brightness must be a number or a string")
+	}
+	text = strings.TrimSuffix(strings.TrimSpace(text), "%")
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return brightnessLevel{}, fmt.Errorf("invalid brightness %q", text)
+	}
+	if strings.HasPrefix(text, "+") || strings.HasPrefix(text, "-") {
+		if value < -100 || value > 100 {
+			return brightnessLevel{}, errors.New("brightness steps must be between -100 and +100")
+		}
+		return brightnessLevel{value: value, relative: true}, nil
+	}
+	return absoluteLevel(value)
+}
+
+// absoluteLevel validates an absolute brightness percentage.
+func absoluteLevel(value float64) (brightnessLevel, error) {
+	if value < 0 || value > 100 {
+		return brightnessLevel{}, errors.New("brightness must be between 0 and 100")
+	}
+	return brightnessLevel{value: value}, nil
+}
+
+// apply returns the absolute level after applying l to the current level.
+// A light that is off counts as fully dimmed, so "+10" turns it on at 10%.
+func (l brightnessLevel) apply(current float64, on bool) float64 {
+	if !l.relative {
+		return l.value
+	}
+	if !on {
+		current = 0
+	}
+	return math.Max(0, math.Min(100, current+l.value))
+}
@@ -0,0 +1,52 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for brightness request parsing.
+package light
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBrightnessRequestParse verifies the accepted absolute and relative forms.
+func TestBrightnessRequestParse(t *testing.T) {
+	cases := []struct {
+		raw   string
+		level brightnessLevel
+	}{
+		{`40`, brightnessLevel{value: 40}},
+		{`"40"`, brightnessLevel{value: 40}},
+		{`"40%"`, brightnessLevel{value: 40}},
+		{`0`, brightnessLevel{value: 0}},
+		{`"+10"`, brightnessLevel{value: 10, relative: true}},
+		{`"-25"`, brightnessLevel{value: -25, relative: true}},
+		{`"-25%"`, brightnessLevel{value: -25, relative: true}},
+	}
+	for _, tc := range cases {
+		level, err := BrightnessRequest{Brightness: json.RawMessage(tc.raw)}.parse()
+		assert.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.level, level, tc.raw)
+	}
+
+	for _, raw := range []string{``, `null`, `101`, `-5`, `"+150"`, `"bright"`, `true`, `"NaN"`} {
+		_, err := BrightnessRequest{Brightness: json.RawMessage(raw)}.parse()
+		assert.Error(t, err, raw)
+	}
+
+	tooLong := maxTransition + 1
+	_, err := BrightnessRequest{Brightness: json.RawMessage(`50`), Transition: &tooLong}.parse()
+	assert.Error(t, err)
+}
+
+// TestBrightnessLevelApply verifies relative steps against the current level.
+func TestBrightnessLevelApply(t *testing.T) {
+	up := brightnessLevel{value: 10, relative: true}
+	down := brightnessLevel{value: -25, relative: true}
+
+	assert.Equal(t, 60.0, up.apply(50, true))
+	assert.Equal(t, 100.0, up.apply(95, true))
+	assert.Equal(t, 10.0, up.apply(50, false), "an off light starts from 0")
+	assert.Equal(t, 0.0, down.apply(20, true))
+	assert.Equal(t, 30.0, brightnessLevel{value: 30}.apply(80, true))
+}
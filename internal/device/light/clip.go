@@ -0,0 +1,374 @@
+// Package light provides functionality for controlling smart lights.
+// This file defines the Philips Hue CLIP v2 resources and their API views.
+package light
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clipResponse is the envelope of every CLIP v2 response.
+type clipResponse struct {
+	Errors []clipError     `json:"errors"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// clipError is a single error reported by the bridge.
+type clipError struct {
+	Description string `json:"description"`
+}
+
+// XY is a color in the CIE 1931 color space.
+type XY struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// clipLight is a light resource as returned by /clip/v2/resource/light.
+type clipLight struct {
+	ID       string `json:"id"`
+	IDv1     string `json:"id_v1"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Archetype string `json:"archetype"`
+	} `json:"metadata"`
+	On struct {
+		On bool `json:"on"`
+	} `json:"on"`
+	Dimming *struct {
+		Brightness  float64 `json:"brightness"`
+		MinDimLevel float64 `json:"min_dim_level"`
+	} `json:"dimming"`
+	ColorTemperature *struct {
+		Mirek       *int `json:"mirek"`
+		MirekValid  bool `json:"mirek_valid"`
+		MirekSchema struct {
+			Minimum int `json:"mirek_minimum"`
+			Maximum int `json:"mirek_maximum"`
+		} `json:"mirek_schema"`
+	} `json:"color_temperature"`
+	Color *struct {
+		XY        XY     `json:"xy"`
+		GamutType string `json:"gamut_type"`
+		Gamut     *Gamut `json:"gamut"`
+	} `json:"color"`
+	Mode string `json:"mode"`
+}
+
+// LightInfo is the state of a light as reported by the API.
+type LightInfo struct {
+	ID         string   `json:"id"`                   // Bridge resource ID
+	Name       string   `json:"name"`                 // Name set in the Hue app
+	Archetype  string   `json:"archetype,omitempty"`  // Bulb shape, e.g. "sultan_bulb"
+	On         bool     `json:"on"`                   // Power state
+	Brightness *float64 `json:"brightness,omitempty"` // Percentage, for dimmable lights
+	Mirek      *int     `json:"mirek,omitempty"`      // Color temperature, when in that mode
+	Color      *XY      `json:"color,omitempty"`      // CIE xy color, for color lights
+	GamutType  string   `json:"gamutType,omitempty"`  // Hue gamut "A", "B" or "C"
+	Mode       string   `json:"mode,omitempty"`       // "normal" or "streaming"
+}
+
+// info converts the bridge resource into the API view.
+func (l clipLight) info() LightInfo {
+	info := LightInfo{
+		ID:        l.ID,
+		Name:      l.Metadata.Name,
+		Archetype: l.Metadata.Archetype,
+		On:        l.On.On,
+		Mode:      l.Mode,
+	}
+	if l.Dimming != nil {
+		brightness := l.Dimming.Brightness
+		info.Brightness = &brightness
+	}
+	if l.ColorTemperature != nil && l.ColorTemperature.MirekValid {
+		info.Mirek = l.ColorTemperature.Mirek
+	}
+	if l.Color != nil {
+		xy := l.Color.XY
+		info.Color = &xy
+		info.GamutType = l.Color.GamutType
+	}
+	return info
+}
+
+// gamut returns the gamut reported by the light, falling back to the gamut
+// of its gamut type.
+func (l clipLight) gamut() Gamut {
+	if l.Color == nil {
+		return GamutC
+	}
+	if l.Color.Gamut != nil {
+		return *l.Color.Gamut
+	}
+	return gamutByType(l.Color.GamutType)
+}
+
+// mirekRange returns the color temperatures supported by the light.
+func (l clipLight) mirekRange() (int, int) {
+	if l.ColorTemperature == nil {
+		return 0, 0
+	}
+	return l.ColorTemperature.MirekSchema.Minimum, l.ColorTemperature.MirekSchema.Maximum
+}
+
+// clipRef references another CLIP v2 resource.
+type clipRef struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+// clipGroup is a room or zone as returned by /clip/v2/resource/room and
+// /clip/v2/resource/zone. Rooms list devices as children, zones list lights.
+type clipGroup struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Archetype string `json:"archetype"`
+	} `json:"metadata"`
+	Children []clipRef `json:"children"`
+	Services []clipRef `json:"services"`
+}
+
+// groupedLight returns the ID of the group's grouped_light service.
+func (g clipGroup) groupedLight() string {
+	for _, s := range g.Services {
+		if s.RType == "grouped_light" {
+			return s.RID
+		}
+	}
+	return ""
+}
+
+// clipGroupedLight is a grouped_light resource, the service controlling all
+// lights of a room or zone at once.
+type clipGroupedLight struct {
+	ID    string  `json:"id"`
+	Owner clipRef `json:"owner"`
+	On    *struct {
+		On bool `json:"on"`
+	} `json:"on"`
+	Dimming *struct {
+		Brightness float64 `json:"brightness"`
+	} `json:"dimming"`
+}
+
+// clipDevice is a device resource; its services include its lights.
+type clipDevice struct {
+	ID       string `json:"id"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	ProductData struct {
+		ModelID     string `json:"model_id"`
+		ProductName string `json:"product_name"`
+	} `json:"product_data"`
+	Services []clipRef `json:"services"`
+}
+
+// GroupInfo is the state of a room or zone as reported by the API.
+type GroupInfo struct {
+	ID           string   `json:"id"`                     // Bridge resource ID of the room or zone
+	Type         string   `json:"type"`                   // "room" or "zone"
+	Name         string   `json:"name"`                   // Name set in the Hue app
+	Archetype    string   `json:"archetype,omitempty"`    // Room kind, e.g. "living_room"
+	GroupedLight string   `json:"groupedLight,omitempty"` // ID of the grouped_light service
+	Lights       []string `json:"lights"`                 // Resource IDs of the member lights
+	On           *bool    `json:"on,omitempty"`           // Whether any light is on
+	Brightness   *float64 `json:"brightness,omitempty"`   // Average brightness of the lights that are on
+}
+
+// info converts the bridge resources into the API view. devices maps device
+// IDs to their light services; state is the group's grouped_light, if known.
+func (g clipGroup) info(devices map[string][]string, state *clipGroupedLight) GroupInfo {
+	info := GroupInfo{
+		ID:           g.ID,
+		Type:         g.Type,
+		Name:         g.Metadata.Name,
+		Archetype:    g.Metadata.Archetype,
+		GroupedLight: g.groupedLight(),
+		Lights:       []string{},
+	}
+	for _, c := range g.Children {
+		switch c.RType {
+		case "light":
+			info.Lights = append(info.Lights, c.RID)
+		case "device":
+			info.Lights = append(info.Lights, devices[c.RID]...)
+		}
+	}
+	if state != nil {
+		if state.On != nil {
+			on := state.On.On
+			info.On = &on
+		}
+		if state.Dimming != nil {
+			brightness := state.Dimming.Brightness
+			info.Brightness = &brightness
+		}
+	}
+	return info
+}
+
+// clipScene is a scene resource as returned by /clip/v2/resource/scene.
+type clipScene struct {
+	ID       string `json:"id"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Group   clipRef `json:"group"`
+	Actions []struct {
+		Target clipRef `json:"target"`
+		Action struct {
+			On *struct {
+				On bool `json:"on"`
+			} `json:"on"`
+		} `json:"action"`
+	} `json:"actions"`
+	Palette *struct {
+		Color            []json.RawMessage `json:"color"`
+		Dimming          []json.RawMessage `json:"dimming"`
+		ColorTemperature []json.RawMessage `json:"color_temperature"`
+	} `json:"palette"`
+	Status *struct {
+		Active string `json:"active"`
+	} `json:"status"`
+}
+
+// dynamic reports whether the scene has a palette to play dynamically.
+func (s clipScene) dynamic() bool {
+	return s.Palette != nil && len(s.Palette.Color)+len(s.Palette.Dimming)+len(s.Palette.ColorTemperature) > 0
+}
+
+// SceneInfo is a scene as reported by the API.
+type SceneInfo struct {
+	ID        string `json:"id"`                  // Bridge resource ID
+	Name      string `json:"name"`                // Name set in the Hue app
+	Group     string `json:"group"`               // ID of the room or zone the scene belongs to
+	GroupType string `json:"groupType"`           // "room" or "zone"
+	GroupName string `json:"groupName,omitempty"` // Name of the room or zone
+	Lights    int    `json:"lights"`              // Number of lights the scene sets
+	Dynamic   bool   `json:"dynamic"`             // Whether the scene can play its palette dynamically
+	Status    string `json:"status,omitempty"`    // "inactive", "static" or "dynamic_palette"
+}
+
+// info converts the bridge resource into the API view. groupName is the name
+// of the scene's room or zone, if known.
+func (s clipScene) info(groupName string) SceneInfo {
+	info := SceneInfo{
+		ID:        s.ID,
+		Name:      s.Metadata.Name,
+		Group:     s.Group.RID,
+		GroupType: s.Group.RType,
+		GroupName: groupName,
+		Lights:    len(s.Actions),
+		Dynamic:   s.dynamic(),
+	}
+	if s.Status != nil {
+		info.Status = s.Status.Active
+	}
+	return info
+}
+
+// clipMotion is a motion sensor service.
+type clipMotion struct {
+	ID      string  `json:"id"`
+	Owner   clipRef `json:"owner"`
+	Enabled bool    `json:"enabled"`
+	Motion  struct {
+		Motion       bool `json:"motion"`
+		MotionValid  bool `json:"motion_valid"`
+		MotionReport *struct {
+			Changed time.Time `json:"changed"`
+			Motion  bool      `json:"motion"`
+		} `json:"motion_report"`
+	} `json:"motion"`
+}
+
+// clipTemperature is a temperature sensor service.
+type clipTemperature struct {
+	ID          string  `json:"id"`
+	Owner       clipRef `json:"owner"`
+	Enabled     bool    `json:"enabled"`
+	Temperature struct {
+		Temperature       float64 `json:"temperature"`
+		TemperatureValid  bool    `json:"temperature_valid"`
+		TemperatureReport *struct {
+			Changed     time.Time `json:"changed"`
+			Temperature float64   `json:"temperature"`
+		} `json:"temperature_report"`
+	} `json:"temperature"`
+}
+
+// clipLightLevel is an ambient light sensor service. Levels are
+// 10000*log10(lux)+1.
+type clipLightLevel struct {
+	ID      string  `json:"id"`
+	Owner   clipRef `json:"owner"`
+	Enabled bool    `json:"enabled"`
+	Light   struct {
+		LightLevel       int  `json:"light_level"`
+		LightLevelValid  bool `json:"light_level_valid"`
+		LightLevelReport *struct {
+			Changed    time.Time `json:"changed"`
+			LightLevel int       `json:"light_level"`
+		} `json:"light_level_report"`
+	} `json:"light"`
+}
+
+// clipButton is one button of a switch such as the Hue dimmer.
+type clipButton struct {
+	ID       string  `json:"id"`
+	Owner    clipRef `json:"owner"`
+	Metadata struct {
+		ControlID int `json:"control_id"`
+	} `json:"metadata"`
+	Button *struct {
+		LastEvent    string `json:"last_event"`
+		ButtonReport *struct {
+			Updated time.Time `json:"updated"`
+			Event   string    `json:"event"`
+		} `json:"button_report"`
+	} `json:"button"`
+}
+
+// clipDevicePower is the battery state of a device.
+type clipDevicePower struct {
+	ID         string  `json:"id"`
+	Owner      clipRef `json:"owner"`
+	PowerState struct {
+		BatteryState string `json:"battery_state"`
+		BatteryLevel *int   `json:"battery_level"`
+	} `json:"power_state"`
+}
+
+// decodeClip parses a CLIP v2 response body into out. Errors listed in the
+// envelope are returned as a *BridgeError carrying status.
+func decodeClip(status int, body []byte, out interface{}) error {
+	var resp clipResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		if status >= 300 {
+			return &BridgeError{StatusCode: status, Messages: []string{strings.TrimSpace(string(body))}}
+		}
+		return fmt.Errorf("invalid response from bridge: %w", err)
+	}
+
+	if status >= 300 || len(resp.Errors) > 0 {
+		bridgeErr := &BridgeError{StatusCode: status}
+		for _, e := range resp.Errors {
+			bridgeErr.Messages = append(bridgeErr.Messages, e.Description)
+		}
+		return bridgeErr
+	}
+
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("invalid response from bridge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package light provides functionality for controlling smart lights.
+// This file converts the color formats accepted by the API into the CIE xy
+// and mirek values used by Hue bulbs, and clamps colors to a bulb's gamut.
+package light
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Color temperature range used when a light does not report its own.
+const (
+	defaultMirekMin = 153 // 6500 K
+	defaultMirekMax = 500 // 2000 K
+)
+
+// RGB is an sRGB color with 8-bit channels.
+type RGB struct {
+	R uint8 `json:"r"`
+	G uint8 `json:"g"`
+	B uint8 `json:"b"`
+}
+
+// HSV is a color given as hue (0-360 degrees), saturation (0-100) and
+// value (0-100). Value maps to the light's brightness when set.
+type HSV struct {
+	H float64  `json:"h"`
+	S float64  `json:"s"`
+	V *float64 `json:"v"`
+}
+
+// Gamut is the triangle of colors a bulb can reproduce, in CIE xy.
+type Gamut struct {
+	Red   XY `json:"red"`
+	Green XY `json:"green"`
+	Blue  XY `json:"blue"`
+}
+
+// Gamuts of the Hue bulb generations, as published by Philips.
+var (
+	GamutA = Gamut{Red: XY{0.704, 0.296}, Green: XY{0.2151, 0.7106}, Blue: XY{0.138, 0.08}}
+	GamutB = Gamut{Red: XY{0.675, 0.322}, Green: XY{0.409, 0.518}, Blue: XY{0.167, 0.04}}
+	GamutC = Gamut{Red: XY{0.6915, 0.3083}, Green: XY{0.17, 0.7}, Blue: XY{0.1532, 0.0475}}
+)
+
+// gamutByType returns the gamut of a CLIP v2 gamut type, defaulting to C,
+// the gamut of all current color bulbs.
+func gamutByType(gamutType string) Gamut {
+	switch strings.ToUpper(gamutType) {
+	case "A":
+		return GamutA
+	case "B":
+		return GamutB
+	}
+	return GamutC
+}
+
+// ColorRequest is the body of a color action. Exactly one field must be set.
+type ColorRequest struct {
+	XY     *XY    `json:"xy"`     // CIE xy chromaticity
+	RGB    *RGB   `json:"rgb"`    // sRGB color
+	Hex    string `json:"hex"`    // sRGB color as "#rrggbb" or "#rgb"
+	HSV    *HSV   `json:"hsv"`    // Hue, saturation and value
+	Kelvin *int   `json:"kelvin"` // Color temperature in kelvin
+	Mirek  *int   `json:"mirek"`  // Color temperature in mirek
+}
+
+// colorTarget is a color request resolved into CLIP v2 values. Exactly one of
+// xy and mirek is set.
+type colorTarget struct {
+	xy         *XY
+	mirek      *int
+	brightness *float64
+}
+
+// resolve converts the request into xy or mirek values.
+func (r ColorRequest) resolve() (colorTarget, error) {
+	set := 0
+	for _, present := range []bool{r.XY != nil, r.RGB != nil, r.Hex != "", r.HSV != nil, r.Kelvin != nil, r.Mirek != nil} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return colorTarget{}, errors.New("expected exactly one of xy, rgb, hex, hsv, kelvin or mirek")
+	}
+
+	switch {
+	case r.XY != nil:
+		if r.XY.X < 0 || r.XY.X > 1 || r.XY.Y < 0 || r.XY.Y > 1 {
+			return colorTarget{}, errors.New("xy coordinates must be between 0 and 1")
+		}
+		xy := *r.XY
+		return colorTarget{xy: &xy}, nil
+	case r.RGB != nil:
+		xy, err := rgbToXY(*r.RGB)
+		return colorTarget{xy: &xy}, err
+	case r.Hex != "":
+		rgb, err := parseHex(r.Hex)
+		if err != nil {
+			return colorTarget{}, err
+		}
+		xy, err := rgbToXY(rgb)
+		return colorTarget{xy: &xy}, err
+	case r.HSV != nil:
+		if r.HSV.V != nil && (*r.HSV.V < 0 || *r.HSV.V > 100) {
+			return colorTarget{}, errors.New("hsv must have h in 0-360 and s, v in 0-100")
+		}
+		// Chromaticity does not depend on value, which sets the brightness instead.
+		rgb, err := hsvToRGB(HSV{H: r.HSV.H, S: r.HSV.S})
+		if err != nil {
+			return colorTarget{}, err
+		}
+		xy, err := rgbToXY(rgb)
+		return colorTarget{xy: &xy, brightness: r.HSV.V}, err
+	case r.Kelvin != nil:
+		if *r.Kelvin <= 0 {
+			return colorTarget{}, errors.New("kelvin must be positive")
+		}
+		mirek := kelvinToMirek(*r.Kelvin)
+		return colorTarget{mirek: &mirek}, nil
+	}
+	mirek := *r.Mirek
+	return colorTarget{mirek: &mirek}, nil
+}
+
+// parseHex parses "#rrggbb", "#rgb" or the same without the leading '#'.
+func parseHex(s string) (RGB, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return RGB{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return RGB{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	return RGB{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+// hsvToRGB converts an HSV color to sRGB. A missing value means full value.
+func hsvToRGB(c HSV) (RGB, error) {
+	v := 100.0
+	if c.V != nil {
+		v = *c.V
+	}
+	if c.H < 0 || c.H > 360 || c.S < 0 || c.S > 100 || v < 0 || v > 100 {
+		return RGB{}, errors.New("hsv must have h in 0-360 and s, v in 0-100")
+	}
+
+	h := math.Mod(c.H, 360) / 60
+	s, val := c.S/100, v/100
+	chroma := val * s
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+	m := val - chroma
+
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = chroma, x, 0
+	case 1:
+		r, g, b = x, chroma, 0
+	case 2:
+		r, g, b = 0, chroma, x
+	case 3:
+		r, g, b = 0, x, chroma
+	case 4:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	channel := func(f float64) uint8 { return uint8(math.Round((f + m) * 255)) }
+	return RGB{R: channel(r), G: channel(g), B: channel(b)}, nil
+}
+
+// rgbToXY converts an sRGB color to CIE 1931 xy using the sRGB (D65)
+// primaries. Black has no chromaticity and is rejected.
+func rgbToXY(c RGB) (XY, error) {
+	linear := func(v uint8) float64 {
+		f := float64(v) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+
+	x := r*0.4124 + g*0.3576 + b*0.1805
+	y := r*0.2126 + g*0.7152 + b*0.0722
+	z := r*0.0193 + g*0.1192 + b*0.9505
+	sum := x + y + z
+	if sum == 0 {
+		return XY{}, errors.New("black has no color; turn the light off instead")
+	}
+	return XY{X: round4(x / sum), Y: round4(y / sum)}, nil
+}
+
+// kelvinToMirek converts a color temperature in kelvin to mirek.
+func kelvinToMirek(kelvin int) int {
+	return int(math.Round(1e6 / float64(kelvin)))
+}
+
+// clampMirek limits mirek to a light's supported range. Zero bounds select
+// the default Hue range.
+func clampMirek(mirek, min, max int) int {
+	if min <= 0 || max <= 0 {
+		min, max = defaultMirekMin, defaultMirekMax
+	}
+	if mirek < min {
+		return min
+	}
+	if mirek > max {
+		return max
+	}
+	return mirek
+}
+
+// Contains reports whether p lies inside the gamut triangle.
+func (g Gamut) Contains(p XY) bool {
+	cross := func(a, b, c XY) float64 { return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X) }
+	d1 := cross(g.Red, g.Green, p)
+	d2 := cross(g.Green, g.Blue, p)
+	d3 := cross(g.Blue, g.Red, p)
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNeg && hasPos)
+}
+
+// Clamp returns p when it lies inside the gamut, or otherwise the closest
+// color on the edge of the gamut triangle.
+func (g Gamut) Clamp(p XY) XY {
+	if g.Contains(p) {
+		return p
+	}
+
+	best, bestDist := p, math.Inf(1)
+	for _, edge := range [][2]XY{{g.Red, g.Green}, {g.Green, g.Blue}, {g.Blue, g.Red}} {
+		q := closestOnSegment(edge[0], edge[1], p)
+		if d := math.Hypot(q.X-p.X, q.Y-p.Y); d < bestDist {
+			best, bestDist = q, d
+		}
+	}
+	return XY{X: round4(best.X), Y: round4(best.Y)}
+}
+
+// closestOnSegment returns the point of segment ab closest to p.
+func closestOnSegment(a, b, p XY) XY {
+	abX, abY := b.X-a.X, b.Y-a.Y
+	t := ((p.X-a.X)*abX + (p.Y-a.Y)*abY) / (abX*abX + abY*abY)
+	t = math.Max(0, math.Min(1, t))
+	return XY{X: a.X + t*abX, Y: a.Y + t*abY}
+}
+
+// round4 rounds to the precision the bridge stores xy values with.
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
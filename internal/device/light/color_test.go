@@ -0,0 +1,143 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for the color conversions.
+package light
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRGBToXY verifies the conversion against the sRGB primaries and the D65
+// white point.
+func TestRGBToXY(t *testing.T) {
+	cases := []struct {
+		rgb RGB
+		xy  XY
+	}{
+		{RGB{255, 0, 0}, XY{0.6401, 0.3300}},
+		{RGB{0, 255, 0}, XY{0.3000, 0.6000}},
+		{RGB{0, 0, 255}, XY{0.1500, 0.0600}},
+		{RGB{255, 255, 255}, XY{0.3127, 0.3290}},
+		{RGB{128, 128, 128}, XY{0.3127, 0.3290}},
+	}
+	for _, tc := range cases {
+		xy, err := rgbToXY(tc.rgb)
+		assert.NoError(t, err)
+		assert.InDelta(t, tc.xy.X, xy.X, 0.0002, "%v", tc.rgb)
+		assert.InDelta(t, tc.xy.Y, xy.Y, 0.0002, "%v", tc.rgb)
+	}
+
+	_, err := rgbToXY(RGB{})
+	assert.Error(t, err)
+}
+
+// TestParseHex verifies the accepted hex notations.
+func TestParseHex(t *testing.T) {
+	for in, want := range map[string]RGB{
+		"#ff8800": {255, 136, 0},
+		"FF8800":  {255, 136, 0},
+		"#f80":    {255, 136, 0},
+		"#000000": {0, 0, 0},
+	} {
+		rgb, err := parseHex(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, rgb, in)
+	}
+
+	for _, in := range []string{"", "#ff88", "#gg8800", "#ff880011"} {
+		_, err := parseHex(in)
+		assert.Error(t, err, in)
+	}
+}
+
+// TestHSVToRGB verifies the conversion for each sector of the hue circle.
+func TestHSVToRGB(t *testing.T) {
+	half := 50.0
+	cases := []struct {
+		hsv HSV
+		rgb RGB
+	}{
+		{HSV{H: 0, S: 100}, RGB{255, 0, 0}},
+		{HSV{H: 60, S: 100}, RGB{255, 255, 0}},
+		{HSV{H: 120, S: 100}, RGB{0, 255, 0}},
+		{HSV{H: 180, S: 100}, RGB{0, 255, 255}},
+		{HSV{H: 240, S: 50, V: &half}, RGB{64, 64, 128}},
+		{HSV{H: 300, S: 100}, RGB{255, 0, 255}},
+		{HSV{H: 360, S: 100}, RGB{255, 0, 0}},
+		{HSV{H: 200, S: 0}, RGB{255, 255, 255}},
+	}
+	for _, tc := range cases {
+		rgb, err := hsvToRGB(tc.hsv)
+		assert.NoError(t, err, "%v", tc.hsv)
+		assert.Equal(t, tc.rgb, rgb, "%v", tc.hsv)
+	}
+
+	_, err := hsvToRGB(HSV{H: 400, S: 100})
+	assert.Error(t, err)
+}
+
+// TestColorTemperature verifies kelvin conversion and clamping to a light's range.
+func TestColorTemperature(t *testing.T) {
+	assert.Equal(t, 370, kelvinToMirek(2700))
+	assert.Equal(t, 154, kelvinToMirek(6500))
+	assert.Equal(t, 500, kelvinToMirek(2000))
+
+	assert.Equal(t, 454, clampMirek(500, 153, 454))
+	assert.Equal(t, 153, clampMirek(100, 0, 0))
+	assert.Equal(t, 300, clampMirek(300, 153, 454))
+}
+
+// TestGamutClamp verifies that colors outside a gamut are moved to the
+// closest reproducible color and colors inside it are kept.
+func TestGamutClamp(t *testing.T) {
+	// D65 white lies just outside gamut B, whose green-blue edge passes x=0.313.
+	white := XY{0.3127, 0.3290}
+	assert.False(t, GamutB.Contains(white))
+	for _, g := range []Gamut{GamutA, GamutC} {
+		assert.True(t, g.Contains(white))
+		assert.Equal(t, white, g.Clamp(white))
+	}
+
+	// Below the blue corner the closest color is the corner itself.
+	assert.Equal(t, GamutC.Blue, GamutC.Clamp(XY{0.1532, 0}))
+
+	// sRGB green lies beyond the red-green edge of gamut B.
+	green := XY{0.3, 0.6}
+	assert.False(t, GamutB.Contains(green))
+	clamped := GamutB.Clamp(green)
+	cross := (GamutB.Green.X-GamutB.Red.X)*(clamped.Y-GamutB.Red.Y) - (GamutB.Green.Y-GamutB.Red.Y)*(clamped.X-GamutB.Red.X)
+	assert.InDelta(t, 0, cross, 1e-4, "clamped color must lie on the gamut edge")
+	assert.NotEqual(t, green, clamped)
+
+	// The same green is reproducible by gamut C bulbs.
+	assert.True(t, GamutC.Contains(green))
+}
+
+// TestColorRequestResolve verifies the selection of exactly one color format.
+func TestColorRequestResolve(t *testing.T) {
+	kelvin := 2700
+	target, err := ColorRequest{Kelvin: &kelvin}.resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, 370, *target.mirek)
+	assert.Nil(t, target.xy)
+
+	v := 40.0
+	target, err = ColorRequest{HSV: &HSV{H: 0, S: 100, V: &v}}.resolve()
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.6401, target.xy.X, 0.0002)
+	assert.Equal(t, 40.0, *target.brightness)
+
+	target, err = ColorRequest{Hex: "#00f"}.resolve()
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.15, target.xy.X, 0.0002)
+
+	_, err = ColorRequest{}.resolve()
+	assert.Error(t, err)
+	_, err = ColorRequest{Hex: "#fff", Kelvin: &kelvin}.resolve()
+	assert.Error(t, err)
+	_, err = ColorRequest{RGB: &RGB{}}.resolve()
+	assert.Error(t, err)
+	_, err = ColorRequest{XY: &XY{1.2, 0.3}}.resolve()
+	assert.Error(t, err)
+}
@@ -0,0 +1,235 @@
+// Package light provides functionality for controlling smart lights.
+// This file exposes the lights of paired Hue bridges through the unified
+// device API.
+package light
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// devicePrefix starts the unified device IDs of Hue lights, which have the
+// form "hue:<bridge>:<light ID>".
+const devicePrefix = "hue"
+
+// DeviceProvider implements device.Provider for the lights of every paired
+// Hue bridge.
+type DeviceProvider struct {
+	cfg    Config
+	logger *logrus.Logger
+}
+
+// NewDeviceProvider returns a provider for the bridges paired in cfg.Keys.
+func NewDeviceProvider(cfg Config, logger *logrus.Logger) *DeviceProvider {
+	return &DeviceProvider{cfg: cfg, logger: logger}
+}
+
+// Prefix returns the ID prefix of Hue lights.
+func (d *DeviceProvider) Prefix() string {
+	return devicePrefix
+}
+
+// Devices lists the lights of every paired bridge. Bridges that cannot be
+// reached are skipped; the first error is returned with the other lights.
+func (d *DeviceProvider) Devices() ([]device.Info, error) {
+	if d.cfg.Keys == nil {
+		return nil, nil
+	}
+
+	var (
+		devices  []device.Info
+		firstErr error
+	)
+	for _, k := range d.cfg.Keys.List() {
+		infos, err := d.bridgeDevices(k.Bridge)
+		if err != nil {
+			d.logger.Warnf("Error listing lights of bridge %s: %v", k.Bridge, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("bridge %s: %w", k.Bridge, err)
+			}
+			continue
+		}
+		devices = append(devices, infos...)
+	}
+	return devices, firstErr
+}
+
+// Device returns the light with the given unified ID.
+func (d *DeviceProvider) Device(id string) (device.Info, error) {
+	bridge, lightID, err := parseDeviceID(id)
+	if err != nil {
+		return device.Info{}, err
+	}
+	infos, err := d.bridgeDevices(bridge)
+	if err != nil {
+		return device.Info{}, deviceError(err)
+	}
+	for _, info := range infos {
+		if info.ID == deviceID(bridge, lightID) {
+			return info, nil
+		}
+	}
+	return device.Info{}, fmt.Errorf("%w: %s", device.ErrNotFound, id)
+}
+
+// Execute runs a command on a light. Brightness and color arguments are the
+// bodies accepted by LightActionHandler.
+func (d *DeviceProvider) Execute(id string, cmd device.Command) (interface{}, error) {
+	bridge, lightID, err := parseDeviceID(id)
+	if err != nil {
+		return nil, err
+	}
+	p := d.light(bridge, lightID)
+	p.actionName = cmd.Name
+	p.authorize = cmd.Authorize
+
+	var result interface{}
+	switch cmd.Name {
+	case device.CommandOn:
+		err = p.on()
+		result = gin.H{"on": true}
+	case device.CommandOff:
+		err = p.off()
+		result = gin.H{"on": false}
+	case device.CommandToggle:
+		var info *LightInfo
+		if info, err = p.get(); err != nil {
+			break
+		}
+		if info.On {
+			err = p.off()
+		} else {
+			err = p.on()
+		}
+		result = gin.H{"on": !info.On}
+	case device.CommandBrightness:
+		var req BrightnessRequest
+		if err := json.Unmarshal(cmd.Args, &req); err != nil {
+			return nil, fmt.Errorf("%w: %v", device.ErrInvalidRequest, err)
+		}
+		result, err = p.applyBrightness(req)
+	case device.CommandColor:
+		var req ColorRequest
+		if err := json.Unmarshal(cmd.Args, &req); err != nil {
+			return nil, fmt.Errorf("%w: %v", device.ErrInvalidRequest, err)
+		}
+		result, err = p.applyColor(req)
+	default:
+		return nil, fmt.Errorf("%w: %s", device.ErrUnsupportedCommand, cmd.Name)
+	}
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return result, nil
+}
+
+// bridgeDevices reads the lights of a bridge together with the rooms they
+// are in and the product names of their devices.
+func (d *DeviceProvider) bridgeDevices(bridge string) ([]device.Info, error) {
+	p := d.light(bridge, "")
+	var lights []clipLight
+	if err := runGetRequest(p, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, err
+	}
+	rooms, err := (&philipsGroup{philipsLight: p, kind: GroupRoom}).list()
+	if err != nil {
+		return nil, err
+	}
+	var devices []clipDevice
+	if err := runGetRequest(p, "/clip/v2/resource/device", &devices); err != nil {
+		return nil, err
+	}
+
+	roomOf := map[string]string{}
+	for _, room := range rooms {
+		for _, id := range room.Lights {
+			roomOf[id] = room.Name
+		}
+	}
+	modelOf := map[string]string{}
+	for _, dev := range devices {
+		for _, s := range dev.Services {
+			if s.RType == "light" {
+				modelOf[s.RID] = dev.ProductData.ProductName
+			}
+		}
+	}
+
+	infos := make([]device.Info, 0, len(lights))
+	for _, l := range lights {
+		state := l.info()
+		info := device.Info{
+			ID:           deviceID(bridge, l.ID),
+			Name:         state.Name,
+			Kind:         "light",
+			Brand:        p.getBrand(),
+			Model:        modelOf[l.ID],
+			Room:         roomOf[l.ID],
+			Online:       true,
+			Capabilities: []device.Capability{device.Switchable},
+			State:        map[string]interface{}{"on": state.On},
+		}
+		if state.Brightness != nil {
+			info.Capabilities = append(info.Capabilities, device.Dimmable)
+			info.State["brightness"] = *state.Brightness
+		}
+		if l.Color != nil || l.ColorTemperature != nil {
+			info.Capabilities = append(info.Capabilities, device.Color)
+		}
+		if state.Color != nil {
+			info.State["color"] = *state.Color
+		}
+		if state.Mirek != nil {
+			info.State["mirek"] = *state.Mirek
+		}
+		info.Capabilities = device.SortCapabilities(info.Capabilities)
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// light returns a controller for a light of bridge that is not tied to an
+// HTTP request.
+func (d *DeviceProvider) light(bridge, id string) *philipsLight {
+	_, host := d.cfg.resolveBridge(bridge)
+	return &philipsLight{brand: "philips", bridge: bridge, ip: host, id: id, logger: d.logger, cfg: d.cfg}
+}
+
+// deviceID returns the unified device ID of a light.
+func deviceID(bridge, lightID string) string {
+	return devicePrefix + ":" + bridge + ":" + lightID
+}
+
+// parseDeviceID splits a unified device ID into its bridge and light ID.
+// Bridges addressed by host and port contain a colon themselves, so the
+// light ID is taken from the last colon on.
+func parseDeviceID(id string) (bridge, lightID string, err error) {
+	rest, ok := strings.CutPrefix(id, devicePrefix+":")
+	i := strings.LastIndex(rest, ":")
+	if !ok || i <= 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("%w: %s", device.ErrNotFound, id)
+	}
+	return rest[:i], rest[i+1:], nil
+}
+
+// deviceError translates a light error into the matching device error so the
+// device API answers with the same status as the light API.
+func deviceError(err error) error {
+	var bridgeErr *BridgeError
+	switch {
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrUnsupportedAction):
+		return fmt.Errorf("%w: %w", device.ErrInvalidRequest, err)
+	case errors.Is(err, ErrNotSupported):
+		return fmt.Errorf("%w: %w", device.ErrNotCapable, err)
+	case errors.As(err, &bridgeErr) && bridgeErr.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", device.ErrNotFound, err)
+	}
+	return err
+}
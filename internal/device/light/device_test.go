@@ -0,0 +1,83 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for the unified device provider.
+package light
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLightProvider returns a provider for a fake bridge paired with
+// "test-key", the bridge address and the requests it received.
+func testLightProvider(t *testing.T) (*DeviceProvider, string, *[]bridgeRequest) {
+	t.Helper()
+	bridge, requests := fakeHueBridge(t)
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge, AppKey: "test-key"}))
+	return NewDeviceProvider(Config{Keys: keys}, logrus.New()), bridge, requests
+}
+
+// TestDeviceProviderLights verifies that lights report their capabilities
+// from the dimming and color services and carry the name of their room.
+func TestDeviceProviderLights(t *testing.T) {
+	p, bridge, _ := testLightProvider(t)
+
+	devices, err := p.Devices()
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	desk := devices[0]
+	assert.Equal(t, "hue:"+bridge+":"+testLightID, desk.ID)
+	assert.Equal(t, "Desk", desk.Name)
+	assert.Equal(t, "Kitchen", desk.Room)
+	assert.Equal(t, []device.Capability{device.Color, device.Dimmable, device.Switchable}, desk.Capabilities)
+	assert.Equal(t, 62.5, desk.State["brightness"])
+
+	_, err = p.Device("hue:" + bridge + ":missing")
+	assert.ErrorIs(t, err, device.ErrNotFound)
+}
+
+// TestDeviceProviderCommands verifies that commands reach the light and that
+// light errors keep their meaning in the device API.
+func TestDeviceProviderCommands(t *testing.T) {
+	p, bridge, requests := testLightProvider(t)
+	mgr := device.NewManager(logrus.New(), p)
+	id := "hue:" + bridge + ":" + testLightID
+
+	result, err := mgr.Execute(id, device.Command{Name: device.CommandToggle})
+	assert.NoError(t, err)
+	assert.Equal(t, gin.H{"on": false}, result)
+	last := (*requests)[len(*requests)-1]
+	assert.Equal(t, http.MethodPut, last.method)
+	assert.Equal(t, map[string]interface{}{"on": map[string]interface{}{"on": false}}, last.body)
+
+	result, err = mgr.Execute(id, device.Command{Name: device.CommandBrightness, Args: []byte(`{"brightness": 40}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, 40.0, result.(gin.H)["brightness"])
+
+	var authorized []string
+	refuse := func(action string) error {
+		authorized = append(authorized, action)
+		return acl.ErrConfirmationRequired
+	}
+	before := len(*requests)
+	_, err = mgr.Execute(id, device.Command{Name: device.CommandBrightness, Args: []byte(`{"brightness": 0}`), Authorize: refuse})
+	assert.ErrorIs(t, err, acl.ErrConfirmationRequired)
+	_, err = mgr.Execute(id, device.Command{Name: device.CommandColor, Args: []byte(`{"hsv": {"h": 0, "s": 0, "v": 0}}`), Authorize: refuse})
+	assert.ErrorIs(t, err, acl.ErrConfirmationRequired)
+	assert.Equal(t, []string{"off", "off"}, authorized)
+	for _, r := range (*requests)[before:] {
+		assert.NotEqual(t, http.MethodPut, r.method, "switching off must not reach the light when refused")
+	}
+
+	_, err = mgr.Execute(id, device.Command{Name: device.CommandColor, Args: []byte(`{"hex": "nope"}`)})
+	assert.ErrorIs(t, err, device.ErrInvalidRequest)
+
+	_, err = mgr.Execute(id, device.Command{Name: device.CommandEnergy})
+	assert.ErrorIs(t, err, device.ErrNotCapable)
+}
@@ -0,0 +1,386 @@
+// Package light provides functionality for controlling smart lights.
+// This file discovers Hue bridges on the local network with mDNS and SSDP.
+package light
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Discovery settings.
+const (
+	defaultDiscoveryWait = 3 * time.Second // How long to listen for replies
+	hueService           = "_hue._tcp.local."
+	mdnsAddr             = "224.0.0.251:5353"
+	ssdpAddr             = "239.255.255.250:1900"
+)
+
+// Bridge is a Hue bridge found on the network.
+type Bridge struct {
+	ID         string `json:"id"`                   // Bridge ID, e.g. "001788fffe23bfc2"
+	IP         string `json:"ip"`                   // Address of the bridge
+	Name       string `json:"name,omitempty"`       // Name set in the Hue app
+	Model      string `json:"model,omitempty"`      // Model ID, e.g. "BSB002"
+	APIVersion string `json:"apiVersion,omitempty"` // API version, e.g. "1.65.0"
+	SWVersion  string `json:"swVersion,omitempty"`  // Firmware version
+	MAC        string `json:"mac,omitempty"`        // MAC address
+	Source     string `json:"source"`               // "mdns", "ssdp" or both
+	Paired     bool   `json:"paired"`               // Whether an application key is stored
+}
+
+// bridgeProbes are the discovery methods run in parallel. It is a variable so
+// tests can replace the network.
+var bridgeProbes = map[string]func(wait time.Duration) ([]Bridge, error){
+	"mdns": mdnsDiscover,
+	"ssdp": ssdpDiscover,
+}
+
+// discoverBridges runs every probe for wait, merges the replies by bridge ID
+// and address, and completes each bridge from its unauthenticated config.
+func discoverBridges(wait time.Duration, cfg Config, logger *logrus.Logger) ([]Bridge, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var found []Bridge
+	var errs []string
+	for name, probe := range bridgeProbes {
+		wg.Add(1)
+		go func(name string, probe func(time.Duration) ([]Bridge, error)) {
+			defer wg.Done()
+			bridges, err := probe(wait)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				logger.Warnf("Hue %s discovery failed: %v", name, err)
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				return
+			}
+			found = append(found, bridges...)
+		}(name, probe)
+	}
+	wg.Wait()
+
+	if len(errs) == len(bridgeProbes) {
+		return nil, fmt.Errorf("bridge discovery failed: %s", strings.Join(errs, "; "))
+	}
+
+	bridges := mergeBridges(found)
+	for i := range bridges {
+		wg.Add(1)
+		go func(b *Bridge) {
+			defer wg.Done()
+			if err := b.readConfig(cfg.bridgeClient(b.ID, b.IP)); err != nil {
+				logger.Debugf("Could not read config of bridge %s: %v", b.IP, err)
+			}
+		}(&bridges[i])
+	}
+	wg.Wait()
+
+	// Replies without an ID can only be merged once the config supplied it.
+	return mergeBridges(bridges), nil
+}
+
+// mergeBridges combines replies describing the same bridge, keyed by ID and
+// falling back to the address, and sorts the result by address.
+func mergeBridges(found []Bridge) []Bridge {
+	var out []Bridge
+	for _, b := range found {
+		b.ID = strings.ToLower(b.ID)
+		merged := false
+		for i := range out {
+			if (b.ID != "" && out[i].ID == b.ID) || out[i].IP == b.IP {
+				out[i].merge(b)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IP < out[j].IP })
+	return out
+}
+
+// merge fills the empty fields of b from other.
+func (b *Bridge) merge(other Bridge) {
+	for dst, src := range map[*string]string{
+		&b.ID: other.ID, &b.IP: other.IP, &b.Name: other.Name, &b.Model: other.Model,
+		&b.APIVersion: other.APIVersion, &b.SWVersion: other.SWVersion, &b.MAC: other.MAC,
+	} {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	if other.Source != "" && !strings.Contains(b.Source, other.Source) {
+		sources := append(strings.Split(b.Source, ","), other.Source)
+		sort.Strings(sources)
+		b.Source = strings.TrimPrefix(strings.Join(sources, ","), ",")
+	}
+}
+
+// readConfig completes b from the bridge's unauthenticated /api/0/config,
+// read with client.
+func (b *Bridge) readConfig(client *http.Client) error {
+	resp, err := client.Get(fmt.Sprintf("https://%s/api/0/config", b.IP))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bridge returned status %d", resp.StatusCode)
+	}
+
+	var cfg struct {
+		Name       string `json:"name"`
+		BridgeID   string `json:"bridgeid"`
+		ModelID    string `json:"modelid"`
+		APIVersion string `json:"apiversion"`
+		SWVersion  string `json:"swversion"`
+		MAC        string `json:"mac"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&cfg); err != nil {
+		return err
+	}
+	b.merge(Bridge{
+		ID:         strings.ToLower(cfg.BridgeID),
+		Name:       cfg.Name,
+		Model:      cfg.ModelID,
+		APIVersion: cfg.APIVersion,
+		SWVersion:  cfg.SWVersion,
+		MAC:        cfg.MAC,
+	})
+	// The config is authoritative for the fields the probes may guess.
+	if cfg.ModelID != "" {
+		b.Model = cfg.ModelID
+	}
+	if cfg.Name != "" {
+		b.Name = cfg.Name
+	}
+	return nil
+}
+
+// mdnsDiscover sends a one-shot mDNS query for the Hue service and collects
+// the answers for wait.
+func mdnsDiscover(wait time.Duration) ([]Bridge, error) {
+	name, err := dnsmessage.NewName(hueService)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name:  name,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET | 1<<15, // Ask for unicast replies
+	}}}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var bridges []Bridge
+	err = multicastExchange(mdnsAddr, packet, wait, func(reply []byte, from net.IP) {
+		found, err := parseMDNS(reply, from)
+		if err == nil {
+			bridges = append(bridges, found...)
+		}
+	})
+	return bridges, err
+}
+
+// parseMDNS extracts the Hue bridges announced in an mDNS reply. Addresses
+// missing from the reply are taken from the sender.
+func parseMDNS(reply []byte, from net.IP) ([]Bridge, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(reply); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var records []dnsmessage.Resource
+	for _, section := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rs, err := section()
+		if err != nil {
+			break
+		}
+		records = append(records, rs...)
+	}
+
+	hosts := map[string]string{}   // host name -> IPv4 address
+	targets := map[string]string{} // instance -> host name
+	txts := map[string][]string{}  // instance -> TXT entries
+	var instances []string
+	for _, r := range records {
+		owner := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if owner == hueService {
+				instances = append(instances, strings.ToLower(body.PTR.String()))
+			}
+		case *dnsmessage.SRVResource:
+			targets[owner] = strings.ToLower(body.Target.String())
+		case *dnsmessage.TXTResource:
+			txts[owner] = body.TXT
+		case *dnsmessage.AResource:
+			hosts[owner] = net.IP(body.A[:]).String()
+		}
+	}
+	for instance := range targets {
+		if strings.HasSuffix(instance, "."+hueService) && !contains(instances, instance) {
+			instances = append(instances, instance)
+		}
+	}
+
+	var bridges []Bridge
+	for _, instance := range instances {
+		b := Bridge{Source: "mdns", Name: strings.TrimSuffix(instance, "."+hueService)}
+		if ip, ok := hosts[targets[instance]]; ok {
+			b.IP = ip
+		} else if from != nil {
+			b.IP = from.String()
+		}
+		for _, kv := range txts[instance] {
+			key, value, _ := strings.Cut(kv, "=")
+			switch strings.ToLower(key) {
+			case "bridgeid":
+				b.ID = strings.ToLower(value)
+			case "modelid":
+				b.Model = value
+			}
+		}
+		if b.IP != "" {
+			bridges = append(bridges, b)
+		}
+	}
+	return bridges, nil
+}
+
+// ssdpDiscover sends an SSDP M-SEARCH and collects the Hue bridge replies for wait.
+func ssdpDiscover(wait time.Duration) ([]Bridge, error) {
+	search := strings.Join([]string{
+		"M-SEARCH * HTTP/1.1",
+		"HOST: " + ssdpAddr,
+		`MAN: "ssdp:discover"`,
+		fmt.Sprintf("MX: %d", int(wait.Seconds())),
+		"ST: ssdp:all",
+		"", "",
+	}, "\r\n")
+
+	var bridges []Bridge
+	err := multicastExchange(ssdpAddr, []byte(search), wait, func(reply []byte, from net.IP) {
+		if b, ok := parseSSDP(reply, from); ok {
+			bridges = append(bridges, b)
+		}
+	})
+	return bridges, err
+}
+
+// parseSSDP reports whether an SSDP reply comes from a Hue bridge and
+// extracts what it announces.
+func parseSSDP(reply []byte, from net.IP) (Bridge, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(reply)), nil)
+	if err != nil {
+		return Bridge{}, false
+	}
+	resp.Body.Close()
+
+	id := resp.Header.Get("hue-bridgeid")
+	if id == "" && !strings.Contains(resp.Header.Get("Server"), "IpBridge") {
+		return Bridge{}, false
+	}
+
+	b := Bridge{ID: strings.ToLower(id), Source: "ssdp"}
+	if loc, err := url.Parse(resp.Header.Get("Location")); err == nil && loc.Hostname() != "" {
+		b.IP = loc.Hostname()
+	} else if from != nil {
+		b.IP = from.String()
+	}
+	return b, b.IP != ""
+}
+
+// multicastExchange sends packet to a multicast group and passes every reply
+// received within wait to handle.
+func multicastExchange(group string, packet []byte, wait time.Duration, handle func(reply []byte, from net.IP)) error {
+	dst, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(packet, dst); err != nil {
+		return err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil
+			}
+			return err
+		}
+		handle(buf[:n], from.IP)
+	}
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// registerBridges records discovered bridges in the registry so they can be
+// addressed by ID, and logs bridges that changed address.
+func registerBridges(bridges []Bridge, reg *registry.Registry, logger *logrus.Logger) {
+	if reg == nil {
+		return
+	}
+	for _, b := range bridges {
+		if b.ID == "" {
+			continue
+		}
+		entry, movedFrom, err := reg.Record(registry.Device{
+			ID:       b.ID,
+			Kind:     bridgeKind,
+			Brand:    "philips",
+			Model:    b.Model,
+			Alias:    b.Name,
+			MAC:      b.MAC,
+			DeviceID: b.ID,
+			IP:       b.IP,
+		})
+		if err != nil {
+			logger.Warnf("Could not record bridge %s in registry: %v", b.ID, err)
+			continue
+		}
+		if movedFrom != "" {
+			logger.Infof("Bridge %s (%s) moved from %s to %s", entry.ID, entry.Alias, movedFrom, entry.IP)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for Hue bridge discovery.
+package light
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TestParseMDNS verifies that a bridge announcement is decoded from the PTR,
+// SRV, TXT and A records of an mDNS reply.
+func TestParseMDNS(t *testing.T) {
+	service := dnsmessage.MustNewName(hueService)
+	instance := dnsmessage.MustNewName("Hue Bridge - 23BFC2." + hueService)
+	host := dnsmessage.MustNewName("001788fffe23bfc2.local.")
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	assert.NoError(t, b.StartAnswers())
+	assert.NoError(t, b.PTRResource(dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: 120},
+		dnsmessage.PTRResource{PTR: instance}))
+	assert.NoError(t, b.StartAdditionals())
+	assert.NoError(t, b.SRVResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: 120},
+		dnsmessage.SRVResource{Target: host, Port: 443}))
+	assert.NoError(t, b.TXTResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: 120},
+		dnsmessage.TXTResource{TXT: []string{"bridgeid=001788FFFE23BFC2", "modelid=BSB002"}}))
+	assert.NoError(t, b.AResource(dnsmessage.ResourceHeader{Name: host, Class: dnsmessage.ClassINET, TTL: 120},
+		dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}}))
+	reply, err := b.Finish()
+	assert.NoError(t, err)
+
+	bridges, err := parseMDNS(reply, net.ParseIP("192.168.1.99"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Bridge{{
+		ID:     "001788fffe23bfc2",
+		IP:     "192.168.1.20",
+		Name:   "hue bridge - 23bfc2",
+		Model:  "BSB002",
+		Source: "mdns",
+	}}, bridges)
+}
+
+// TestParseSSDP verifies that only Hue bridges are accepted from SSDP replies.
+func TestParseSSDP(t *testing.T) {
+	hue := "HTTP/1.1 200 OK\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"EXT:\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"LOCATION: http://192.168.1.20:80/description.xml\r\n" +
+		"SERVER: Hue/1.0 UPnP/1.0 IpBridge/1.65.0\r\n" +
+		"hue-bridgeid: 001788FFFE23BFC2\r\n" +
+		"ST: upnp:rootdevice\r\n" +
+		"USN: uuid:2f402f80-da50-11e1-9b23-00178823bfc2::upnp:rootdevice\r\n\r\n"
+	b, ok := parseSSDP([]byte(hue), net.ParseIP("192.168.1.99"))
+	assert.True(t, ok)
+	assert.Equal(t, Bridge{ID: "001788fffe23bfc2", IP: "192.168.1.20", Source: "ssdp"}, b)
+
+	tv := "HTTP/1.1 200 OK\r\n" +
+		"LOCATION: http://192.168.1.30:8008/ssdp/device-desc.xml\r\n" +
+		"SERVER: Linux/3.8 UPnP/1.0 Chromecast\r\n\r\n"
+	_, ok = parseSSDP([]byte(tv), net.ParseIP("192.168.1.30"))
+	assert.False(t, ok)
+}
+
+// TestDiscoverRegistersBridges verifies that probe replies are merged,
+// completed from the bridge config and registered, so that light routes can
+// use the bridge ID.
+func TestDiscoverRegistersBridges(t *testing.T) {
+	host, _ := fakeHueBridge(t)
+
+	orig := bridgeProbes
+	defer func() { bridgeProbes = orig }()
+	bridgeProbes = map[string]func(time.Duration) ([]Bridge, error){
+		"mdns": func(time.Duration) ([]Bridge, error) { return []Bridge{{IP: host, Source: "mdns"}}, nil },
+		"ssdp": func(time.Duration) ([]Bridge, error) {
+			return []Bridge{{ID: "001788FFFE23BFC2", IP: host, Source: "ssdp"}}, nil
+		},
+	}
+
+	reg, err := registry.Open("")
+	assert.NoError(t, err)
+	keys, err := OpenKeyStore("")
+	assert.NoError(t, err)
+	cfg := Config{Keys: keys, Registry: reg}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/device/light/:brand/discover", DiscoverHandler(router, logrus.New(), cfg))
+	router.GET("/api/v1/device/light/:brand/bridges", BridgesHandler(router, logrus.New(), cfg))
+	router.GET("/api/v1/device/light/:brand/:bridge/lights", LightActionHandler(router, logrus.New(), cfg))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/device/light/philips/discover?wait=10ms", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Bridges []Bridge `json:"bridges"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []Bridge{{
+		ID:         "001788fffe23bfc2",
+		IP:         host,
+		Name:       "Hue Bridge",
+		Model:      "BSB002",
+		APIVersion: "1.65.0",
+		SWVersion:  "1965111030",
+		MAC:        "00:17:88:23:bf:c2",
+		Source:     "mdns,ssdp",
+	}}, response.Bridges)
+
+	d, ok := reg.Get("001788fffe23bfc2")
+	assert.True(t, ok)
+	assert.Equal(t, bridgeKind, d.Kind)
+	assert.Equal(t, host, d.IP)
+
+	// Once paired, the bridge is addressed by ID.
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: "001788fffe23bfc2", AppKey: "test-key"}))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/device/light/philips/001788fffe23bfc2/lights", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/device/light/philips/bridges", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Bridges, 1)
+	assert.True(t, response.Bridges[0].Paired)
+}
@@ -0,0 +1,506 @@
+// Package light provides functionality for controlling smart lights.
+// This file keeps a live cache of bridge resources from the CLIP v2 event
+// stream and re-emits the changes to API clients.
+package light
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/sirupsen/logrus"
+)
+
+// Reconnection delays of the event stream. They double after every failed
+// attempt and are variables so tests can shorten them.
+var (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+const (
+	subscriberBuffer = 64 // Events a slow client may lag behind before they are dropped
+)
+
+// Event is a change reported by a bridge's event stream.
+type Event struct {
+	Bridge   string          `json:"bridge"`   // Bridge the change happened on
+	Type     string          `json:"type"`     // "add", "update" or "delete"
+	Resource string          `json:"resource"` // Resource type, e.g. "light" or "button"
+	ID       string          `json:"id"`       // Resource ID
+	Data     json.RawMessage `json:"data"`     // Changed fields as sent by the bridge
+	Time     time.Time       `json:"time"`     // When the bridge reported the change
+}
+
+// clipEvent is one entry of an event stream message.
+type clipEvent struct {
+	CreationTime time.Time         `json:"creationtime"`
+	ID           string            `json:"id"`
+	Type         string            `json:"type"`
+	Data         []json.RawMessage `json:"data"`
+}
+
+// EventStreams keeps one event stream subscription per paired bridge. While a
+// stream is connected its cache answers resource reads instead of the bridge.
+type EventStreams struct {
+	ctx    context.Context
+	cfg    Config
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	streams map[string]*bridgeStream
+	closed  bool
+	wg      sync.WaitGroup // Running subscriptions
+}
+
+// NewEventStreams returns the subscriptions manager. Streams are started with
+// WatchPaired or on demand and stop when ctx is cancelled.
+func NewEventStreams(ctx context.Context, cfg Config, logger *logrus.Logger) *EventStreams {
+	cfg.Streams = nil
+	return &EventStreams{ctx: ctx, cfg: cfg, logger: logger, streams: map[string]*bridgeStream{}}
+}
+
+// WatchPaired subscribes to the event stream of every paired bridge.
+func (s *EventStreams) WatchPaired() {
+	if s.cfg.Keys == nil {
+		return
+	}
+	for _, k := range s.cfg.Keys.List() {
+		s.watch(k.Bridge)
+	}
+}
+
+// watch returns the stream of a bridge, subscribing to it if needed.
+func (s *EventStreams) watch(ref string) *bridgeStream {
+	id, _ := s.cfg.resolveBridge(ref)
+	id = strings.ToLower(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[id]; ok {
+		return st
+	}
+	st := &bridgeStream{
+		ref:         id,
+		streams:     s,
+		resources:   map[string]map[string]interface{}{},
+		subscribers: map[chan Event]struct{}{},
+	}
+	if s.closed {
+		st.stopped = true
+		return st
+	}
+	s.streams[id] = st
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		st.run(s.ctx)
+	}()
+	return st
+}
+
+// Close stops every subscription, disconnects their clients and waits for
+// the streams to end. Bridges paired afterwards are no longer watched.
+func (s *EventStreams) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for id, st := range s.streams {
+		st.stop()
+		delete(s.streams, id)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// live returns the stream of a bridge if it is connected, nil otherwise.
+func (s *EventStreams) live(ref string) *bridgeStream {
+	if s == nil {
+		return nil
+	}
+	id, _ := s.cfg.resolveBridge(ref)
+
+	s.mu.Lock()
+	st := s.streams[strings.ToLower(id)]
+	s.mu.Unlock()
+	if st == nil || !st.isLive() {
+		return nil
+	}
+	return st
+}
+
+// forget stops using the stream of a bridge, e.g. after it was unpaired.
+func (s *EventStreams) forget(ref string) {
+	if s == nil {
+		return
+	}
+	id, _ := s.cfg.resolveBridge(ref)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[strings.ToLower(id)]; ok {
+		st.stop()
+		delete(s.streams, strings.ToLower(id))
+	}
+}
+
+// bridgeStream is the event stream subscription of one bridge and the cache
+// of resources it maintains.
+type bridgeStream struct {
+	ref     string // Bridge ID, or address when the ID is unknown
+	streams *EventStreams
+
+	mu          sync.RWMutex
+	live        bool
+	stopped     bool
+	cancel      context.CancelFunc
+	resources   map[string]map[string]interface{}
+	subscribers map[chan Event]struct{}
+}
+
+// run keeps the stream connected until ctx is cancelled or the bridge is no
+// longer paired, backing off exponentially between attempts.
+func (b *bridgeStream) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	b.mu.Lock()
+	b.cancel = cancel
+	stopped := b.stopped
+	b.mu.Unlock()
+	defer cancel()
+	if stopped {
+		return
+	}
+
+	logger := b.streams.logger
+	backoff := streamMinBackoff
+	for {
+		connected, err := b.connect(ctx)
+		b.setLive(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrNotPaired) {
+			logger.Warnf("Stopping event stream of bridge %s: %v", b.ref, err)
+			b.streams.forget(b.ref)
+			return
+		}
+		if connected {
+			backoff = streamMinBackoff
+		}
+		logger.Warnf("Event stream of bridge %s closed: %v; reconnecting in %s", b.ref, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// stop ends the subscription and disconnects the clients.
+func (b *bridgeStream) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	b.live = false
+	if b.cancel != nil {
+		b.cancel()
+	}
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
+
+// connect opens the event stream, loads a snapshot of every resource into
+// the cache and applies events until the stream ends. It reports whether the
+// stream was established.
+func (b *bridgeStream) connect(ctx context.Context) (bool, error) {
+	cfg := b.streams.cfg
+	key, err := cfg.appKey(b.ref)
+	if err != nil {
+		return false, err
+	}
+	_, host := cfg.resolveBridge(b.ref)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/eventstream/clip/v2", host), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("hue-application-key", key)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := cfg.streamClient(b.ref).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return false, fmt.Errorf("%w: the bridge rejected the stored application key", ErrNotPaired)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, &BridgeError{StatusCode: resp.StatusCode, Messages: []string{"event stream unavailable"}}
+	}
+
+	// The snapshot is taken once the stream is open so no change made in
+	// between is missed.
+	p := &philipsLight{bridge: b.ref, ip: host, actionName: "eventstream", logger: b.streams.logger, cfg: cfg}
+	var all []map[string]interface{}
+	if err := doRequest(p, http.MethodGet, "/clip/v2/resource", nil, &all); err != nil {
+		return false, fmt.Errorf("loading resources: %w", err)
+	}
+	b.reset(all)
+	b.streams.logger.Infof("Subscribed to event stream of bridge %s (%d resources)", b.ref, len(all))
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				b.handle(data.Bytes())
+				data.Reset()
+			}
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Comments (": hi") and "id:" lines carry nothing to apply.
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, errors.New("stream closed by the bridge")
+}
+
+// handle applies one event stream message to the cache and emits its
+// changes to the subscribers.
+func (b *bridgeStream) handle(message []byte) {
+	var events []clipEvent
+	if err := json.Unmarshal(message, &events); err != nil {
+		b.streams.logger.Debugf("Ignoring malformed event from bridge %s: %v", b.ref, err)
+		return
+	}
+
+	for _, e := range events {
+		for _, raw := range e.Data {
+			var change map[string]interface{}
+			if err := json.Unmarshal(raw, &change); err != nil {
+				continue
+			}
+			id, _ := change["id"].(string)
+			rtype, _ := change["type"].(string)
+			if id == "" {
+				continue
+			}
+			b.apply(e.Type, id, change)
+			b.emit(Event{Bridge: b.ref, Type: e.Type, Resource: rtype, ID: id, Data: raw, Time: e.CreationTime})
+			if e.Type == "update" {
+				b.publish(rtype, id, change, e.CreationTime)
+			}
+		}
+	}
+}
+
+// publish raises button presses and motion changes on the server's event bus.
+func (b *bridgeStream) publish(rtype, id string, change map[string]interface{}, at time.Time) {
+	bus := b.streams.cfg.Bus
+	if bus == nil {
+		return
+	}
+
+	var e event.Event
+	switch rtype {
+	case "button":
+		action, _ := lookup(change, "button", "button_report", "event").(string)
+		if action == "" {
+			action, _ = lookup(change, "button", "last_event").(string)
+		}
+		if action == "" {
+			return
+		}
+		e = event.Event{Type: event.TypeButton, Action: action}
+	case "motion":
+		motion, ok := lookup(change, "motion", "motion_report", "motion").(bool)
+		if !ok {
+			motion, ok = lookup(change, "motion", "motion").(bool)
+		}
+		if !ok {
+			return
+		}
+		e = event.Event{Type: event.TypeMotion, Action: "cleared"}
+		if motion {
+			e.Action = "detected"
+		}
+	default:
+		return
+	}
+
+	b.mu.RLock()
+	resource := b.resources[id]
+	device, _ := lookup(resource, "owner", "rid").(string)
+	name, _ := lookup(b.resources[device], "metadata", "name").(string)
+	controlID := lookup(resource, "metadata", "control_id")
+	b.mu.RUnlock()
+
+	e.Source = "hue"
+	e.Device = device
+	e.Name = name
+	e.Time = at
+	e.Data = map[string]interface{}{"bridge": b.ref, "resource": id}
+	if rtype == "button" && controlID != nil {
+		e.Data["button"] = controlID
+	}
+	bus.Publish(e)
+}
+
+// lookup returns the value at a path of nested JSON objects, or nil.
+func lookup(m map[string]interface{}, path ...string) interface{} {
+	var v interface{} = m
+	for _, key := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	return v
+}
+
+// apply updates the cached resource id with an event of the given type.
+func (b *bridgeStream) apply(eventType, id string, change map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch eventType {
+	case "delete":
+		delete(b.resources, id)
+	case "update":
+		if current, ok := b.resources[id]; ok {
+			mergeResource(current, change)
+			return
+		}
+		b.resources[id] = change
+	default:
+		b.resources[id] = change
+	}
+}
+
+// mergeResource applies the fields of an update to a cached resource. Nested
+// objects are merged; everything else is replaced.
+func mergeResource(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if cur, ok := dst[k].(map[string]interface{}); ok {
+				mergeResource(cur, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// reset replaces the cache with a snapshot and marks it live.
+func (b *bridgeStream) reset(all []map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resources = make(map[string]map[string]interface{}, len(all))
+	for _, r := range all {
+		if id, ok := r["id"].(string); ok {
+			b.resources[id] = r
+		}
+	}
+	b.live = !b.stopped
+}
+
+// setLive marks whether the cache reflects the bridge.
+func (b *bridgeStream) setLive(live bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.live = live
+}
+
+// isLive reports whether the cache reflects the bridge.
+func (b *bridgeStream) isLive() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.live
+}
+
+// read answers a CLIP v2 resource read from the cache. It reports false for
+// paths the cache does not serve.
+func (b *bridgeStream) read(path string, out interface{}) (bool, error) {
+	parts := strings.Split(strings.TrimPrefix(path, "/clip/v2/resource/"), "/")
+	if !strings.HasPrefix(path, "/clip/v2/resource/") || len(parts) > 2 || parts[0] == "" {
+		return false, nil
+	}
+
+	b.mu.RLock()
+	var found []map[string]interface{}
+	for id, r := range b.resources {
+		if r["type"] == parts[0] && (len(parts) == 1 || id == parts[1]) {
+			found = append(found, r)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return fmt.Sprint(found[i]["id"]) < fmt.Sprint(found[j]["id"])
+	})
+	data, err := json.Marshal(found)
+	b.mu.RUnlock()
+	if err != nil {
+		return true, err
+	}
+
+	if len(parts) == 2 && len(found) == 0 {
+		return true, &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{"Not Found"}}
+	}
+	return true, json.Unmarshal(data, out)
+}
+
+// subscribe returns a channel receiving the bridge's events and a function
+// ending the subscription.
+func (b *bridgeStream) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	if b.stopped {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// emit sends an event to every subscriber, dropping it for those that fall
+// too far behind.
+func (b *bridgeStream) emit(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			b.streams.logger.Debugf("Dropping event for slow client of bridge %s", b.ref)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for the bridge event stream.
+package light
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamingBridge is a fake bridge serving a resource snapshot and an event
+// stream fed by the test.
+type streamingBridge struct {
+	host     string
+	messages chan string   // Event stream messages to send
+	drop     chan struct{} // Closes the current event stream connection
+
+	mu        sync.Mutex
+	streams   int // Event stream connections accepted
+	snapshots int // Snapshot reads
+	reads     int // Other resource reads
+}
+
+// newStreamingBridge starts a fake bridge accepting "test-key".
+func newStreamingBridge(t *testing.T) *streamingBridge {
+	t.Helper()
+	b := &streamingBridge{messages: make(chan string), drop: make(chan struct{})}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("hue-application-key") != "test-key" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors":[{"description":"unauthorized user"}],"data":[]}`)
+			return
+		}
+
+		b.mu.Lock()
+		switch r.URL.Path {
+		case "/eventstream/clip/v2":
+			b.streams++
+		case "/clip/v2/resource":
+			b.snapshots++
+		default:
+			b.reads++
+		}
+		b.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/eventstream/clip/v2":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, ": hi\n\n")
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case m := <-b.messages:
+					fmt.Fprintf(w, "id: 1:0\ndata: %s\n\n", m)
+					w.(http.Flusher).Flush()
+				case <-b.drop:
+					return
+				case <-r.Context().Done():
+					return
+				}
+			}
+		case "/clip/v2/resource":
+			io.WriteString(w, `{"errors":[],"data":[`+testLightJSON+`,
+				{"id":"`+testRoomID+`","type":"room","metadata":{"name":"Kitchen"},"children":[],"services":[]}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors":[{"description":"Not Found"}],"data":[]}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	b.host = strings.TrimPrefix(srv.URL, "https://")
+	return b
+}
+
+// counts returns the stream connections, snapshots and other reads so far.
+func (b *streamingBridge) counts() (int, int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.streams, b.snapshots, b.reads
+}
+
+// watchBridge returns a configuration with event streams watching the
+// bridge at host with key.
+func watchBridge(t *testing.T, host, key string) Config {
+	t.Helper()
+	streamMinBackoff, streamMaxBackoff = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { streamMinBackoff, streamMaxBackoff = time.Second, time.Minute })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: host, AppKey: key}))
+	cfg := Config{Keys: keys}
+	cfg.Streams = NewEventStreams(ctx, cfg, logrus.New())
+	cfg.Streams.WatchPaired()
+	return cfg
+}
+
+// lightUpdate is an event stream message switching the test light.
+func lightUpdate(on bool) string {
+	return fmt.Sprintf(`[{"creationtime":"2024-05-01T18:30:00Z","id":"e0b4c0d2-0000-4000-8000-000000000001","type":"update",`+
+		`"data":[{"id":"%s","id_v1":"/lights/1","on":{"on":%t},"owner":{"rid":"%s","rtype":"device"},"type":"light"}]}]`,
+		testLightID, on, testDeviceID)
+}
+
+// TestEventStreamCache verifies that reads are answered from the snapshot,
+// that updates are merged into the cache and emitted to subscribers, and
+// that a dropped stream is reconnected and reloaded.
+func TestEventStreamCache(t *testing.T) {
+	bridge := newStreamingBridge(t)
+	cfg := watchBridge(t, bridge.host, "test-key")
+	assert.Eventually(t, func() bool { return cfg.Streams.live(bridge.host) != nil }, 2*time.Second, 5*time.Millisecond)
+
+	p := &philipsLight{bridge: bridge.host, ip: bridge.host, logger: logrus.New(), cfg: cfg}
+	lights, err := p.list()
+	assert.NoError(t, err)
+	if assert.Len(t, lights, 1) {
+		assert.True(t, lights[0].On)
+	}
+	_, _, reads := bridge.counts()
+	assert.Equal(t, 0, reads, "list should be answered from the cache")
+
+	events, unsubscribe := cfg.Streams.watch(bridge.host).subscribe()
+	defer unsubscribe()
+	bridge.messages <- lightUpdate(false)
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "update", e.Type)
+		assert.Equal(t, "light", e.Resource)
+		assert.Equal(t, testLightID, e.ID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	p.id = testLightID
+	info, err := p.get()
+	assert.NoError(t, err)
+	assert.False(t, info.On)
+	if assert.NotNil(t, info.Brightness) {
+		assert.Equal(t, 62.5, *info.Brightness, "fields absent from the update are kept")
+	}
+
+	bridge.drop <- struct{}{}
+	assert.Eventually(t, func() bool {
+		streams, snapshots, _ := bridge.counts()
+		return streams == 2 && snapshots == 2 && cfg.Streams.live(bridge.host) != nil
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+// TestEventStreamStopsWhenUnpaired verifies that a bridge rejecting the key
+// is not retried and reads fall back to the bridge.
+func TestEventStreamStopsWhenUnpaired(t *testing.T) {
+	bridge := newStreamingBridge(t)
+	cfg := watchBridge(t, bridge.host, "stale-key")
+
+	assert.Eventually(t, func() bool {
+		cfg.Streams.mu.Lock()
+		defer cfg.Streams.mu.Unlock()
+		return len(cfg.Streams.streams) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Nil(t, cfg.Streams.live(bridge.host))
+}
+
+// TestEventStreamsClose verifies that closing the streams disconnects their
+// clients and that no bridge is watched afterwards.
+func TestEventStreamsClose(t *testing.T) {
+	bridge := newStreamingBridge(t)
+	cfg := watchBridge(t, bridge.host, "test-key")
+	assert.Eventually(t, func() bool { return cfg.Streams.live(bridge.host) != nil }, 2*time.Second, 5*time.Millisecond)
+	events, unsubscribe := cfg.Streams.watch(bridge.host).subscribe()
+	defer unsubscribe()
+
+	cfg.Streams.Close()
+	_, open := <-events
+	assert.False(t, open, "clients are disconnected")
+	assert.Nil(t, cfg.Streams.live(bridge.host))
+
+	events, _ = cfg.Streams.watch(bridge.host).subscribe()
+	_, open = <-events
+	assert.False(t, open, "closed streams accept no new clients")
+}
+
+// TestEventsHandler verifies that bridge events are re-emitted to API
+// clients as server-sent events.
+func TestEventsHandler(t *testing.T) {
+	bridge := newStreamingBridge(t)
+	cfg := watchBridge(t, bridge.host, "test-key")
+	assert.Eventually(t, func() bool { return cfg.Streams.live(bridge.host) != nil }, 2*time.Second, 5*time.Millisecond)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/device/light/:brand/:bridge/events", EventsHandler(router, logrus.New(), cfg))
+	api := httptest.NewServer(router)
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/api/v1/device/light/philips/" + bridge.host + "/events")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// The subscription is registered once the handler runs; keep sending
+	// until the client has seen an event.
+	received := make(chan Event, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				var e Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					received <- e
+					return
+				}
+			}
+		}
+	}()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case bridge.messages <- lightUpdate(false):
+			continue
+		case e := <-received:
+			assert.Equal(t, "update", e.Type)
+			assert.Equal(t, testLightID, e.ID)
+			assert.JSONEq(t, `{"on":false}`, mustExtract(t, e.Data, "on"))
+			return
+		case <-deadline:
+			t.Fatal("no event received by the client")
+		}
+	}
+}
+
+// mustExtract returns the JSON of one field of a JSON object.
+func mustExtract(t *testing.T, data json.RawMessage, field string) string {
+	var m map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(data, &m))
+	return string(m[field])
+}
+
+// TestEventsHandlerRequiresPairing verifies that events of an unpaired
+// bridge are refused.
+func TestEventsHandlerRequiresPairing(t *testing.T) {
+	keys, _ := OpenKeyStore("")
+	cfg := Config{Keys: keys}
+	cfg.Streams = NewEventStreams(context.Background(), cfg, logrus.New())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/device/light/:brand/:bridge/events", EventsHandler(router, logrus.New(), cfg))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/device/light/philips/192.168.1.2/events", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,252 @@
+// Package light provides functionality for controlling smart lights.
+// This file implements Philips Hue rooms and zones, controlled through their
+// grouped_light service.
+package light
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Group kinds, matching the CLIP v2 resource types.
+const (
+	GroupRoom = "room"
+	GroupZone = "zone"
+)
+
+// philipsGroup represents a Hue room or zone. It shares the bridge
+// connection and request helpers of philipsLight; its id is the ID or name
+// of the room or zone. It implements the group interface.
+type philipsGroup struct {
+	*philipsLight
+	kind string // GroupRoom or GroupZone
+}
+
+// execAction executes a command on the room or zone and returns its result.
+func (g *philipsGroup) execAction(action string) (interface{}, error) {
+	g.actionName = action
+	if action != "list" && g.id == "" {
+		return nil, fmt.Errorf("%w: action %s requires a %s id", ErrInvalidRequest, action, g.kind)
+	}
+
+	switch action {
+	case "list":
+		return g.list()
+	case "get":
+		return g.get()
+	case "on":
+		if err := g.on(); err != nil {
+			return nil, err
+		}
+		return gin.H{"on": true}, nil
+	case "off":
+		if err := g.off(); err != nil {
+			return nil, err
+		}
+		return gin.H{"on": false}, nil
+	case "brightness":
+		return g.setBrightness()
+	case "color":
+		return g.color()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAction, action)
+	}
+}
+
+// list returns every room or zone of the bridge with its lights and state.
+func (g *philipsGroup) list() ([]GroupInfo, error) {
+	groups, err := g.groups()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []clipDevice
+	if g.kind == GroupRoom {
+		if err := runGetRequest(g.philipsLight, "/clip/v2/resource/device", &devices); err != nil {
+			return nil, err
+		}
+	}
+	lightsOf := map[string][]string{}
+	for _, d := range devices {
+		for _, s := range d.Services {
+			if s.RType == "light" {
+				lightsOf[d.ID] = append(lightsOf[d.ID], s.RID)
+			}
+		}
+	}
+
+	var states []clipGroupedLight
+	if err := runGetRequest(g.philipsLight, "/clip/v2/resource/grouped_light", &states); err != nil {
+		return nil, err
+	}
+	stateOf := map[string]*clipGroupedLight{}
+	for i := range states {
+		stateOf[states[i].ID] = &states[i]
+	}
+
+	infos := make([]GroupInfo, 0, len(groups))
+	for _, grp := range groups {
+		infos = append(infos, grp.info(lightsOf, stateOf[grp.groupedLight()]))
+	}
+	return infos, nil
+}
+
+// get returns the room or zone with its lights and state.
+func (g *philipsGroup) get() (*GroupInfo, error) {
+	infos, err := g.list()
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		if matchesGroup(infos[i].ID, infos[i].Name, g.id) {
+			return &infos[i], nil
+		}
+	}
+	return nil, g.notFound()
+}
+
+// on switches every light of the room or zone on.
+func (g *philipsGroup) on() error {
+	return g.update(gin.H{"on": gin.H{"on": true}})
+}
+
+// off switches every light of the room or zone off.
+func (g *philipsGroup) off() error {
+	return g.update(gin.H{"on": gin.H{"on": false}})
+}
+
+// setBrightness dims the room or zone from a BrightnessRequest body. Relative
+// steps are sent as a dimming delta so the bridge applies them to each light.
+// Dimming to 0 switches the lights off.
+func (g *philipsGroup) setBrightness() (interface{}, error) {
+	var req BrightnessRequest
+	if err := g.ctx.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	level, err := req.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	body := gin.H{}
+	result := gin.H{}
+	if level.relative {
+		action := "up"
+		if level.value < 0 {
+			action = "down"
+		}
+		delta := level.value
+		if delta < 0 {
+			delta = -delta
+		}
+		body["dimming_delta"] = gin.H{"action": action, "brightness_delta": delta}
+		result["delta"] = level.value
+	} else {
+		if level.value <= 0 {
+			if err := g.authorizeOff(); err != nil {
+				return nil, err
+			}
+		}
+		setLevel(body, level.value)
+		result["brightness"] = level.value
+		result["on"] = level.value > 0
+	}
+	if req.Transition != nil {
+		body["dynamics"] = gin.H{"duration": *req.Transition}
+		result["transition"] = *req.Transition
+	}
+	if err := g.update(body); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// color colors the room or zone from a ColorRequest body. The bridge clamps
+// the color to each light's gamut; lights without color support ignore it.
+func (g *philipsGroup) color() (interface{}, error) {
+	var req ColorRequest
+	if err := g.ctx.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	target, err := req.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	body := gin.H{}
+	result := gin.H{}
+	if target.xy != nil {
+		body["color"] = gin.H{"xy": *target.xy}
+		result["xy"] = *target.xy
+	} else {
+		mirek := clampMirek(*target.mirek, 0, 0)
+		body["color_temperature"] = gin.H{"mirek": mirek}
+		result["mirek"] = mirek
+		result["clamped"] = mirek != *target.mirek
+	}
+	if target.brightness != nil {
+		if *target.brightness <= 0 {
+			if err := g.authorizeOff(); err != nil {
+				return nil, err
+			}
+		}
+		setLevel(body, *target.brightness)
+		result["brightness"] = *target.brightness
+	}
+
+	if err := g.update(body); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// update sends a state update to the group's grouped_light service, which
+// applies it to every member light in one bridge call.
+func (g *philipsGroup) update(body gin.H) error {
+	grp, err := g.find()
+	if err != nil {
+		return err
+	}
+	id := grp.groupedLight()
+	if id == "" {
+		return fmt.Errorf("%s %s has no grouped_light: %w", g.kind, grp.Metadata.Name, ErrNotSupported)
+	}
+	return doRequest(g.philipsLight, http.MethodPut, "/clip/v2/resource/grouped_light/"+id, body, nil)
+}
+
+// groups returns every room or zone of the bridge.
+func (g *philipsGroup) groups() ([]clipGroup, error) {
+	var groups []clipGroup
+	if err := runGetRequest(g.philipsLight, "/clip/v2/resource/"+g.kind, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// find returns the addressed room or zone, named by ID or by name.
+func (g *philipsGroup) find() (*clipGroup, error) {
+	groups, err := g.groups()
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if matchesGroup(groups[i].ID, groups[i].Metadata.Name, g.id) {
+			return &groups[i], nil
+		}
+	}
+	return nil, g.notFound()
+}
+
+// notFound reports that the addressed room or zone does not exist.
+func (g *philipsGroup) notFound() error {
+	return &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{fmt.Sprintf("%s %q not found", g.kind, g.id)}}
+}
+
+// matchesGroup reports whether ref names the group by ID or, ignoring case,
+// by name.
+func matchesGroup(id, name, ref string) bool {
+	return id == ref || strings.EqualFold(name, ref)
+}
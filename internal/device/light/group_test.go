@@ -0,0 +1,153 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for Hue rooms and zones.
+package light
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveGroup routes a single request through GroupActionHandler for kind,
+// with "test-key" stored as the application key of bridge.
+func serveGroup(t *testing.T, kind, method, bridge, suffix, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return serveGroupWith(t, Config{}, kind, method, bridge, suffix, body)
+}
+
+// serveGroupWith is serveGroup with further light settings in cfg.
+func serveGroupWith(t *testing.T, cfg Config, kind, method, bridge, suffix, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge, AppKey: "test-key"}))
+	cfg.Keys = keys
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := GroupActionHandler(router, logrus.New(), cfg, kind)
+	router.GET("/api/v1/device/light/:brand/:bridge/"+kind+"s", handler)
+	router.GET("/api/v1/device/light/:brand/:bridge/"+kind+"s/:id", handler)
+	router.PUT("/api/v1/device/light/:brand/:bridge/"+kind+"s/:id/:action", handler)
+
+	target := "/api/v1/device/light/philips/" + bridge + "/" + kind + "s" + suffix
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// TestListRoomsAndZones verifies that rooms resolve their devices to lights,
+// zones list their lights directly and both carry the grouped_light state.
+func TestListRoomsAndZones(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveGroup(t, GroupRoom, http.MethodGet, bridge, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	rooms := response["result"].([]interface{})
+	assert.Len(t, rooms, 1)
+	room := rooms[0].(map[string]interface{})
+	assert.Equal(t, "Kitchen", room["name"])
+	assert.Equal(t, "room", room["type"])
+	assert.Equal(t, testRoomGroupID, room["groupedLight"])
+	assert.Equal(t, []interface{}{testLightID}, room["lights"])
+	assert.Equal(t, true, room["on"])
+	assert.Equal(t, 62.5, room["brightness"])
+
+	w, response = serveGroup(t, GroupZone, http.MethodGet, bridge, "/downstairs", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	zone := response["result"].(map[string]interface{})
+	assert.Equal(t, testZoneID, zone["id"])
+	assert.Equal(t, []interface{}{testLightID}, zone["lights"])
+	assert.Equal(t, false, zone["on"])
+}
+
+// TestGroupActionsUseGroupedLight verifies that room and zone commands are
+// sent to the grouped_light service in a single update each.
+func TestGroupActionsUseGroupedLight(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		path string
+		body string
+		want map[string]interface{}
+		into string
+	}{
+		{"room on by name", GroupRoom, "/Kitchen/on", "", map[string]interface{}{"on": map[string]interface{}{"on": true}}, testRoomGroupID},
+		{"room off by id", GroupRoom, "/" + testRoomID + "/off", "", map[string]interface{}{"on": map[string]interface{}{"on": false}}, testRoomGroupID},
+		{"zone dim", GroupZone, "/Downstairs/brightness", `{"brightness": 30, "transition": 400}`, map[string]interface{}{
+			"on": map[string]interface{}{"on": true}, "dimming": map[string]interface{}{"brightness": 30.0},
+			"dynamics": map[string]interface{}{"duration": 400.0},
+		}, testZoneGroupID},
+		{"room relative dim", GroupRoom, "/kitchen/brightness", `{"brightness": "-25"}`, map[string]interface{}{
+			"dimming_delta": map[string]interface{}{"action": "down", "brightness_delta": 25.0},
+		}, testRoomGroupID},
+		{"room color", GroupRoom, "/Kitchen/color", `{"kelvin": 2700}`, map[string]interface{}{
+			"color_temperature": map[string]interface{}{"mirek": 370.0},
+		}, testRoomGroupID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge, requests := fakeHueBridge(t)
+			w, response := serveGroup(t, tt.kind, http.MethodPut, bridge, tt.path, tt.body)
+			assert.Equal(t, http.StatusOK, w.Code, response)
+
+			var puts []bridgeRequest
+			for _, r := range *requests {
+				if r.method == http.MethodPut {
+					puts = append(puts, r)
+				}
+			}
+			if assert.Len(t, puts, 1) {
+				assert.Equal(t, "/clip/v2/resource/grouped_light/"+tt.into, puts[0].path)
+				assert.Equal(t, tt.want, puts[0].body)
+			}
+		})
+	}
+}
+
+// TestGroupNotFound verifies that an unknown room is reported as 404 without
+// sending any update.
+func TestGroupNotFound(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	w, _ := serveGroup(t, GroupRoom, http.MethodPut, bridge, "/Attic/on", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	for _, r := range *requests {
+		assert.NotEqual(t, http.MethodPut, r.method)
+	}
+}
+
+// TestGroupOffProtected verifies that switching off a room, or dimming it to
+// 0 by brightness or color, is refused without confirmation when one of its lights is protected, and
+// that switching it on is not.
+func TestGroupOffProtected(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: deviceID(bridge, testLightID), Protected: true})
+	assert.NoError(t, err)
+
+	w, response := serveGroupWith(t, Config{ACL: rules}, GroupRoom, http.MethodPut, bridge, "/Kitchen/off", "")
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code, response)
+	w, response = serveGroupWith(t, Config{ACL: rules}, GroupRoom, http.MethodPut, bridge, "/Kitchen/brightness", `{"brightness": 0}`)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code, response)
+	w, response = serveGroupWith(t, Config{ACL: rules}, GroupRoom, http.MethodPut, bridge, "/Kitchen/color", `{"hsv": {"h": 0, "s": 0, "v": 0}}`)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code, response)
+	for _, r := range *requests {
+		assert.NotEqual(t, http.MethodPut, r.method)
+	}
+
+	w, response = serveGroupWith(t, Config{ACL: rules}, GroupRoom, http.MethodPut, bridge, "/Kitchen/off?confirm=true", "")
+	assert.Equal(t, http.StatusOK, w.Code, response)
+	w, response = serveGroupWith(t, Config{ACL: rules}, GroupRoom, http.MethodPut, bridge, "/Kitchen/on", "")
+	assert.Equal(t, http.StatusOK, w.Code, response)
+}
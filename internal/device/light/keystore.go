@@ -0,0 +1,92 @@
+// Package light provides functionality for controlling smart lights.
+// This file stores the application keys created by pairing with a bridge.
+package light
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+)
+
+// BridgeKey is the application key a bridge issued to this server.
+type BridgeKey struct {
+	Bridge    string    `json:"bridge"`              // Bridge the key belongs to
+	AppKey    string    `json:"appKey"`              // Sent as the hue-application-key header
+	ClientKey string    `json:"clientKey,omitempty"` // Entertainment streaming key
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// KeyStore keeps bridge application keys in a JSON file. It is safe for
+// concurrent use.
+type KeyStore struct {
+	mu   sync.RWMutex
+	path string
+	keys map[string]BridgeKey
+}
+
+// OpenKeyStore loads the keys stored at path, starting empty if the file does
+// not exist. An empty path keeps the keys in memory only.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path, keys: map[string]BridgeKey{}}
+
+	var keys []BridgeKey
+	if err := jsonfile.Load(path, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		s.keys[k.Bridge] = k
+	}
+	return s, nil
+}
+
+// Get returns the key stored for a bridge.
+func (s *KeyStore) Get(bridge string) (BridgeKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[bridge]
+	return k, ok
+}
+
+// List returns the stored keys sorted by bridge.
+func (s *KeyStore) List() []BridgeKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]BridgeKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Bridge < keys[j].Bridge })
+	return keys
+}
+
+// Set stores the key of a bridge, replacing any previous one.
+func (s *KeyStore) Set(k BridgeKey) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.Bridge] = k
+	return s.save()
+}
+
+// Remove forgets the key of a bridge.
+func (s *KeyStore) Remove(bridge string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, bridge)
+	return s.save()
+}
+
+// save writes the keys to disk. s.mu must be held.
+func (s *KeyStore) save() error {
+	keys := make([]BridgeKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Bridge < keys[j].Bridge })
+	return jsonfile.Save(s.path, keys)
+}
@@ -1,30 +1,546 @@
+// Package light provides functionality for controlling smart lights.
+// It supports different brands of smart lights through a common interface
+// and provides HTTP handlers for device control.
 package light
 
 import (
-	"github.com/gin-gonic/gin"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
-func LightActionHandler(svr *gin.Engine) gin.HandlerFunc {
+// LightActionHandler creates a gin.HandlerFunc that processes light control requests.
+//
+// Parameters:
+//   - svr: The gin engine instance for HTTP routing
+//   - logger: A configured logrus logger for operation tracking
+//   - cfg: Shared light settings such as the bridge keys
+//
+// The bridge must have been paired with PairHandler; its application key is
+// taken from cfg.Keys.
+//
+// The handler expects URL parameters:
+//   - brand: The light brand (e.g., "philips")
+//   - bridge: Registry ID of the bridge (see DiscoverHandler) or its address
+//   - id: Optional resource ID of the light; without it the lights are listed
+//   - action: Optional command ("on", "off", "brightness", "color"); without
+//     it the state of the light is returned
+//
+// Example URLs:
+//
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/lights
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/lights/3f1c...
+//	PUT /api/v1/device/light/philips/001788fffe23bfc2/lights/3f1c.../brightness {"brightness": 40}
+//	PUT /api/v1/device/light/philips/001788fffe23bfc2/lights/3f1c.../color {"hex": "#ff8800"}
+func LightActionHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		brand := c.Param("brand")
+		bridge := c.Param("bridge")
 		id := c.Param("id")
+		action := c.Param("action")
+		if action == "" {
+			action = "get"
+			if id == "" {
+				action = "list"
+			}
+		}
+
+		logger.Debugf("Received request: brand=%s, 'bridge=%s', 'id=%s', 'action=%s'", brand, bridge, id, action)
+		light, err := newLight(brand, bridge, id, c, logger, cfg)
+		if err != nil {
+			logger.Errorf("Error creating light: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		if err := cfg.checkLight(c, bridge, id, action); err != nil {
+			logger.Warnf("Refused %s on light %s: %v", action, id, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := light.execAction(action)
+		if err != nil {
+			logger.Errorf("Error executing action %s: %v", action, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"brand":  light.getBrand(),
+			"bridge": bridge,
+			"id":     light.getID(),
+			"action": action,
+			"result": result,
+			"status": "success",
+		})
+	}
+}
+
+// GroupActionHandler creates a gin.HandlerFunc that controls a Hue room or
+// zone, depending on kind (GroupRoom or GroupZone). Commands are sent to the
+// group's grouped_light service so every light changes in one bridge call.
+//
+// The handler expects URL parameters:
+//   - brand: The light brand (e.g., "philips")
+//   - bridge: Registry ID of the bridge or its address
+//   - id: Optional ID or name of the room or zone; without it they are listed
+//   - action: Optional command ("on", "off", "brightness", "color"); without
+//     it the state of the room or zone is returned
+//
+// Bodies are those of LightActionHandler. Relative brightness steps are
+// applied by the bridge to each light.
+//
+// Example URLs:
+//
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/rooms
+//	PUT /api/v1/device/light/philips/001788fffe23bfc2/rooms/Kitchen/on
+//	PUT /api/v1/device/light/philips/001788fffe23bfc2/zones/Downstairs/brightness {"brightness": "-20"}
+func GroupActionHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config, kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		brand := c.Param("brand")
-		ip := c.Param("ip")
+		bridge := c.Param("bridge")
+		id := c.Param("id")
 		action := c.Param("action")
+		if action == "" {
+			action = "get"
+			if id == "" {
+				action = "list"
+			}
+		}
 
-		light, err := newLight(brand, ip, id, c)
+		logger.Debugf("Received request: brand=%s, 'bridge=%s', '%s=%s', 'action=%s'", brand, bridge, kind, id, action)
+		group, err := newGroup(brand, kind, bridge, id, c, logger, cfg)
 		if err != nil {
+			logger.Errorf("Error creating %s: %v", kind, err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
 			return
 		}
-		err = light.execAction(action)
+
+		if err := cfg.checkGroup(c, bridge, group, action); err != nil {
+			logger.Warnf("Refused %s on %s %s: %v", action, kind, id, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := group.execAction(action)
+		if err != nil {
+			logger.Errorf("Error executing %s action %s: %v", kind, action, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"brand":  brand,
-			"ip":     ip,
-			"id":     id,
+			"brand":  group.getBrand(),
+			"bridge": bridge,
+			"type":   kind,
+			"id":     group.getID(),
+			"action": action,
+			"result": result,
+			"status": "success",
+		})
+	}
+}
+
+// SceneHandler creates a gin.HandlerFunc that lists, recalls and creates the
+// scenes stored on a Hue bridge.
+//
+// The handler expects URL parameters:
+//   - brand: The light brand (e.g., "philips")
+//   - bridge: Registry ID of the bridge or its address
+//   - id: Optional ID or name of a scene; without it the scenes are listed,
+//     or a new scene is created on POST
+//   - action: Optional command ("recall"); without it the scene is returned
+//
+// Listing and recalling by name accept "room" or "zone" query parameters to
+// pick among scenes of the same name. Recall takes an optional SceneRecall
+// body and creation a SceneCreate body, which snapshots the current state of
+// a room or zone. Recall is refused when the access rules of cfg.ACL deny
+// any light the scene sets; lights it switches off count as "off".
+//
+// Example URLs:
+//
+//	GET  /api/v1/device/light/philips/001788fffe23bfc2/scenes?room=Kitchen
+//	PUT  /api/v1/device/light/philips/001788fffe23bfc2/scenes/Relax/recall?room=Kitchen {"mode": "dynamic_palette"}
+//	POST /api/v1/device/light/philips/001788fffe23bfc2/scenes {"name": "Evening", "room": "Kitchen"}
+func SceneHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		brand := c.Param("brand")
+		bridge := c.Param("bridge")
+		id := c.Param("id")
+		action := c.Param("action")
+		if action == "" {
+			switch {
+			case c.Request.Method == http.MethodPost:
+				action = "create"
+			case id == "":
+				action = "list"
+			default:
+				action = "get"
+			}
+		}
+
+		logger.Debugf("Received request: brand=%s, 'bridge=%s', 'scene=%s', 'action=%s'", brand, bridge, id, action)
+		scenes, err := newScenes(brand, bridge, id, c, logger, cfg)
+		if err != nil {
+			logger.Errorf("Error creating scenes: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		result, err := scenes.execAction(action)
+		if err != nil {
+			logger.Errorf("Error executing scene action %s: %v", action, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		status := http.StatusOK
+		if action == "create" {
+			status = http.StatusCreated
+		}
+		c.JSON(status, gin.H{
+			"brand":  scenes.getBrand(),
+			"bridge": bridge,
+			"id":     scenes.getID(),
 			"action": action,
-			"body":   c.Request.Body,
-			"result": err,
+			"result": result,
+			"status": "success",
 		})
 	}
 }
+
+// SensorHandler creates a gin.HandlerFunc that reports the motion,
+// temperature, light level and button sensors behind a Hue bridge.
+//
+// The handler expects URL parameters:
+//   - brand: The light brand (e.g., "philips")
+//   - bridge: Registry ID of the bridge or its address
+//   - id: Optional device ID or name of a sensor; without it every sensor is
+//     listed
+//
+// Example URLs:
+//
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/sensors
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/sensors/Hallway%20sensor
+func SensorHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		brand := c.Param("brand")
+		bridge := c.Param("bridge")
+		id := c.Param("id")
+		action := "get"
+		if id == "" {
+			action = "list"
+		}
+
+		logger.Debugf("Received request: brand=%s, 'bridge=%s', 'sensor=%s', 'action=%s'", brand, bridge, id, action)
+		sensors, err := newSensors(brand, bridge, id, c, logger, cfg)
+		if err != nil {
+			logger.Errorf("Error creating sensors: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		result, err := sensors.execAction(action)
+		if err != nil {
+			logger.Errorf("Error executing sensor action %s: %v", action, err)
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"brand":  sensors.getBrand(),
+			"bridge": bridge,
+			"id":     sensors.getID(),
+			"action": action,
+			"result": result,
+			"status": "success",
+		})
+	}
+}
+
+// PairHandler creates a gin.HandlerFunc that pairs the server with a bridge.
+//
+// POST starts pairing: the user then has a minute to press the bridge's link
+// button while the server polls the bridge for an application key. GET
+// reports the progress and DELETE forgets the stored key. The key itself is
+// kept in cfg.Keys and never returned. A bridge that is already paired is
+// only paired again with "?force=true".
+//
+// Example URLs:
+//
+//	POST   /api/v1/device/light/philips/001788fffe23bfc2/pair
+//	GET    /api/v1/device/light/philips/001788fffe23bfc2/pair
+//	DELETE /api/v1/device/light/philips/001788fffe23bfc2/pair
+func PairHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		brand := c.Param("brand")
+		ref := c.Param("bridge")
+		if brand != "philips" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		bridge, host := cfg.resolveBridge(ref)
+		_, err := cfg.appKey(ref)
+		paired := err == nil
+		switch c.Request.Method {
+		case http.MethodPost:
+			if paired && c.Query("force") != "true" {
+				c.JSON(http.StatusOK, PairingStatus{Bridge: bridge, State: PairingPaired, Message: "Bridge already paired"})
+				return
+			}
+			logger.Infof("Pairing with Hue bridge %s, waiting for the link button", bridge)
+			st := startPairing(bridge, host, cfg, logger)
+			c.JSON(pairHandlerStatus(st), st)
+		case http.MethodDelete:
+			err := cfg.Keys.Remove(bridge)
+			if err == nil && host != bridge {
+				err = cfg.Keys.Remove(host)
+			}
+			if err != nil {
+				logger.Errorf("Error removing key of bridge %s: %v", bridge, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			cfg.Streams.forget(bridge)
+			logger.Infof("Forgot application key of bridge %s", bridge)
+			c.JSON(http.StatusOK, gin.H{"bridge": bridge, "status": "success"})
+		default:
+			if st, ok := pairingStatus(bridge); ok && (st.State == PairingWaiting || !paired) {
+				c.JSON(pairHandlerStatus(st), st)
+				return
+			}
+			if paired {
+				c.JSON(http.StatusOK, PairingStatus{Bridge: bridge, State: PairingPaired, Message: "Bridge paired"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "bridge is not paired and no pairing is in progress"})
+		}
+	}
+}
+
+// CertificateHandler creates a gin.HandlerFunc that shows or forgets the
+// certificate pinned for a bridge. GET returns the pin; DELETE removes it so
+// the next certificate the bridge presents is trusted, e.g. after the bridge
+// was replaced or reset.
+//
+// Example URLs:
+//
+//	GET    /api/v1/device/light/philips/001788fffe23bfc2/certificate
+//	DELETE /api/v1/device/light/philips/001788fffe23bfc2/certificate
+func CertificateHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("brand") != "philips" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		pins := cfg.bridgeTLS().pins
+		bridge, host := cfg.resolveBridge(c.Param("bridge"))
+		names := []string{strings.ToLower(bridge), host}
+
+		if c.Request.Method == http.MethodDelete {
+			for _, name := range names {
+				if err := pins.Remove(name); err != nil {
+					logger.Errorf("Error removing certificate pin of bridge %s: %v", name, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			logger.Warnf("Forgot pinned certificate of bridge %s", bridge)
+			c.JSON(http.StatusOK, gin.H{"bridge": bridge, "status": "success"})
+			return
+		}
+
+		for _, name := range names {
+			if pin, ok := pins.Get(name); ok {
+				c.JSON(http.StatusOK, pin)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "no certificate pinned for bridge " + bridge})
+	}
+}
+
+// EventsHandler creates a gin.HandlerFunc that streams the changes reported
+// by a bridge to the client as server-sent events, such as lights switched
+// with a physical dimmer. Each event is named after its type ("add",
+// "update" or "delete") and carries an Event. The bridge's event stream is
+// subscribed to if it is not already.
+//
+// Example URL:
+//
+//	GET /api/v1/device/light/philips/001788fffe23bfc2/events
+func EventsHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("brand") != "philips" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+		if cfg.Streams == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "event streams are disabled"})
+			return
+		}
+		bridge := c.Param("bridge")
+		if _, err := cfg.appKey(bridge); err != nil {
+			c.JSON(statusForError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		events, unsubscribe := cfg.Streams.watch(bridge).subscribe()
+		defer unsubscribe()
+		logger.Debugf("Client subscribed to events of bridge %s", bridge)
+
+		event.Stream(c, events, func(e Event) string { return e.Type })
+	}
+}
+
+// DiscoverHandler creates a gin.HandlerFunc that finds Hue bridges on the
+// local network with mDNS and SSDP, without any cloud lookup. Each bridge is
+// completed from its unauthenticated config and recorded in cfg.Registry so
+// light routes can name it by ID.
+//
+// Query parameters:
+//   - wait: how long to listen for replies (default 3s, at most 30s)
+//
+// Example URL:
+//
+//	POST /api/v1/device/light/philips/discover?wait=5s
+func DiscoverHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("brand") != "philips" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported light brand"})
+			return
+		}
+
+		wait := defaultDiscoveryWait
+		if v := c.Query("wait"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > 30*time.Second {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a duration between 0s and 30s"})
+				return
+			}
+			wait = d
+		}
+
+		logger.Debugf("Discovering Hue bridges for %v", wait)
+		bridges, err := discoverBridges(wait, cfg, logger)
+		if err != nil {
+			logger.Error("Error discovering bridges:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		registerBridges(bridges, cfg.Registry, logger)
+		for i := range bridges {
+			ref := bridges[i].ID
+			if ref == "" {
+				ref = bridges[i].IP
+			}
+			_, err := cfg.appKey(ref)
+			bridges[i].Paired = err == nil
+		}
+
+		logger.Debugf("Found %d Hue bridges", len(bridges))
+		c.JSON(http.StatusOK, gin.H{"bridges": bridges})
+	}
+}
+
+// BridgesHandler creates a gin.HandlerFunc that lists the registered bridges.
+//
+// Example URL:
+//
+//	GET /api/v1/device/light/philips/bridges
+func BridgesHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bridges := []Bridge{}
+		if cfg.Registry != nil {
+			for _, d := range cfg.Registry.List() {
+				if d.Kind != bridgeKind || d.Brand != c.Param("brand") {
+					continue
+				}
+				_, err := cfg.appKey(d.ID)
+				bridges = append(bridges, Bridge{
+					ID:     d.ID,
+					IP:     d.IP,
+					Name:   d.Alias,
+					Model:  d.Model,
+					MAC:    d.MAC,
+					Paired: err == nil,
+				})
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"bridges": bridges})
+	}
+}
+
+// statusForError maps an action error to the HTTP status returned to the client.
+func statusForError(err error) int {
+	var bridgeErr *BridgeError
+	var mismatch *CertificateMismatchError
+	var netErr net.Error
+	if status, ok := acl.StatusForError(err); ok {
+		return status
+	}
+	switch {
+	case errors.Is(err, ErrUnsupportedAction), errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotSupported):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrNotPaired):
+		return http.StatusConflict
+	case errors.As(err, &mismatch):
+		return http.StatusBadGateway
+	case errors.As(err, &bridgeErr):
+		switch {
+		case bridgeErr.StatusCode == http.StatusNotFound:
+			return http.StatusNotFound
+		case bridgeErr.StatusCode == http.StatusTooManyRequests, bridgeErr.StatusCode == http.StatusServiceUnavailable:
+			return http.StatusServiceUnavailable
+		case bridgeErr.StatusCode < 500:
+			return http.StatusUnprocessableEntity
+		}
+		return http.StatusBadGateway
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// checkLight evaluates the access rules of a single light for an action.
+// Listing the lights of a bridge addresses no light and is not restricted.
+func (cfg Config) checkLight(c *gin.Context, bridge, id, action string) error {
+	if cfg.ACL == nil || id == "" {
+		return nil
+	}
+	bridgeID, _ := cfg.resolveBridge(bridge)
+	return cfg.ACL.Check(acl.FromRequest(c, deviceID(bridgeID, id), action))
+}
+
+// checkGroup evaluates the access rules of every light of a room or zone for
+// an action changing them, so a group command cannot switch off a light its
+// sender may not. The group is only looked up when rules restrict lights of
+// the bridge.
+func (cfg Config) checkGroup(c *gin.Context, bridge string, g group, action string) error {
+	bridgeID, _ := cfg.resolveBridge(bridge)
+	if action == "list" || action == "get" || !cfg.ACL.Restricts(deviceID(bridgeID, "")) {
+		return nil
+	}
+	info, err := g.get()
+	if err != nil {
+		return err
+	}
+	for _, lightID := range info.Lights {
+		if err := cfg.ACL.Check(acl.FromRequest(c, deviceID(bridgeID, lightID), action)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for the light HTTP handler.
+package light
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/stretchr/testify/assert"
+)
+
+// timeoutError is a net.Error reporting a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// TestStatusForError verifies the mapping of action errors to HTTP statuses.
+func TestStatusForError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: blink", ErrUnsupportedAction), http.StatusBadRequest},
+		{fmt.Errorf("%w: bad body", ErrInvalidRequest), http.StatusBadRequest},
+		{fmt.Errorf("%w: pair first", ErrNotPaired), http.StatusConflict},
+		{&BridgeError{StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{&BridgeError{StatusCode: http.StatusTooManyRequests}, http.StatusServiceUnavailable},
+		{&BridgeError{StatusCode: http.StatusBadRequest}, http.StatusUnprocessableEntity},
+		{&BridgeError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway},
+		{fmt.Errorf("failed to perform action on: %w", timeoutError{}), http.StatusGatewayTimeout},
+		{errors.New("connection refused"), http.StatusBadGateway},
+		{fmt.Errorf("%w: user bob may not use hue:b:1", acl.ErrDenied), http.StatusForbidden},
+		{acl.ErrConfirmationRequired, http.StatusPreconditionRequired},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.status, statusForError(tc.err), tc.err.Error())
+	}
+}
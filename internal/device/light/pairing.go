@@ -0,0 +1,249 @@
+// Package light provides functionality for controlling smart lights.
+// This file implements pairing with a Hue bridge through its link button.
+package light
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hueLinkButtonNotPressed is the bridge error type returned until the link
+// button has been pressed.
+const hueLinkButtonNotPressed = 101
+
+// Pairing timing. They are variables so tests can shorten them.
+var (
+	pairPollInterval = 2 * time.Second // Delay between key requests
+	pairTimeout      = time.Minute     // Time the user has to press the button
+)
+
+// Pairing states reported by PairingStatus.
+const (
+	PairingWaiting = "waiting"
+	PairingPaired  = "paired"
+	PairingExpired = "expired"
+	PairingFailed  = "failed"
+)
+
+// PairingStatus describes a pairing attempt. It never contains the key.
+type PairingStatus struct {
+	Bridge    string    `json:"bridge"`
+	State     string    `json:"state"`
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// pairings holds the pairing attempts in progress or recently finished,
+// keyed by bridge.
+var pairings = struct {
+	sync.Mutex
+	status  map[string]*PairingStatus
+	stop    chan struct{}  // Closed by StopPairing
+	running sync.WaitGroup // Attempts still polling
+}{status: map[string]*PairingStatus{}, stop: make(chan struct{})}
+
+// startPairing begins polling the bridge at host for an application key,
+// to be stored under bridge, unless an attempt is already waiting. It returns
+// the current status.
+func startPairing(bridge, host string, cfg Config, logger *logrus.Logger) PairingStatus {
+	pairings.Lock()
+	defer pairings.Unlock()
+
+	if st, ok := pairings.status[bridge]; ok && st.State == PairingWaiting {
+		return *st
+	}
+
+	now := time.Now().UTC()
+	select {
+	case <-pairings.stop:
+		st := &PairingStatus{Bridge: bridge, State: PairingFailed, Message: "The server is shutting down", StartedAt: now}
+		pairings.status[bridge] = st
+		return *st
+	default:
+	}
+	st := &PairingStatus{
+		Bridge:    bridge,
+		State:     PairingWaiting,
+		Message:   "Press the link button on the bridge",
+		StartedAt: now,
+		ExpiresAt: now.Add(pairTimeout),
+	}
+	pairings.status[bridge] = st
+	pairings.running.Add(1)
+	go func(stop <-chan struct{}) {
+		defer pairings.running.Done()
+		pollPairing(bridge, host, st.ExpiresAt, stop, cfg, logger)
+	}(pairings.stop)
+	return *st
+}
+
+// StopPairing abandons the pairing attempts in progress and waits for them
+// to end. Attempts started afterwards fail immediately; it is called when the
+// server shuts down.
+func StopPairing() {
+	pairings.Lock()
+	select {
+	case <-pairings.stop:
+	default:
+		close(pairings.stop)
+	}
+	pairings.Unlock()
+	pairings.running.Wait()
+}
+
+// pairingStatus returns the latest pairing attempt for a bridge.
+func pairingStatus(bridge string) (PairingStatus, bool) {
+	pairings.Lock()
+	defer pairings.Unlock()
+	st, ok := pairings.status[bridge]
+	if !ok {
+		return PairingStatus{}, false
+	}
+	return *st, true
+}
+
+// finishPairing records the outcome of a pairing attempt.
+func finishPairing(bridge, state, message string) {
+	pairings.Lock()
+	defer pairings.Unlock()
+	if st, ok := pairings.status[bridge]; ok {
+		st.State = state
+		st.Message = message
+	}
+}
+
+// pollPairing requests an application key until the link button is pressed
+// or the deadline passes, then stores the key. It gives up when stop is
+// closed.
+func pollPairing(bridge, host string, deadline time.Time, stop <-chan struct{}, cfg Config, logger *logrus.Logger) {
+	ticker := time.NewTicker(pairPollInterval)
+	defer ticker.Stop()
+
+	for {
+		key, err := createAppKey(cfg.bridgeClient(bridge, host), host)
+		if err == nil {
+			key.Bridge = bridge
+			if err := cfg.Keys.Set(key); err != nil {
+				logger.Errorf("Could not store application key for bridge %s: %v", bridge, err)
+				finishPairing(bridge, PairingFailed, "Paired, but the key could not be stored: "+err.Error())
+				return
+			}
+			logger.Infof("Paired with Hue bridge %s", bridge)
+			finishPairing(bridge, PairingPaired, "Bridge paired")
+			if cfg.Streams != nil {
+				cfg.Streams.watch(bridge)
+			}
+			return
+		}
+		if !isLinkButtonError(err) {
+			logger.Debugf("Pairing with bridge %s: %v", bridge, err)
+		}
+
+		if time.Now().After(deadline) {
+			logger.Warnf("Pairing with bridge %s timed out", bridge)
+			finishPairing(bridge, PairingExpired, "The link button was not pressed in time")
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			logger.Warnf("Pairing with bridge %s abandoned by shutdown", bridge)
+			finishPairing(bridge, PairingFailed, "The server shut down before the link button was pressed")
+			return
+		}
+	}
+}
+
+// isLinkButtonError reports whether err means the link button is not pressed yet.
+func isLinkButtonError(err error) bool {
+	e, ok := err.(*hueV1Error)
+	return ok && e.Type == hueLinkButtonNotPressed
+}
+
+// hueV1Error is an error entry of the bridge's v1 API, used for pairing.
+type hueV1Error struct {
+	Type        int    `json:"type"`
+	Description string `json:"description"`
+}
+
+// Error implements the error interface.
+func (e *hueV1Error) Error() string {
+	return fmt.Sprintf("bridge error %d: %s", e.Type, e.Description)
+}
+
+// createAppKey asks the bridge at host for a new application key using
+// client. It fails with a *hueV1Error of type 101 until the link button has
+// been pressed.
+func createAppKey(client *http.Client, host string) (BridgeKey, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"devicetype":        deviceType(),
+		"generateclientkey": true,
+	})
+	if err != nil {
+		return BridgeKey{}, err
+	}
+
+	resp, err := client.Post(fmt.Sprintf("https://%s/api", host), "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return BridgeKey{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return BridgeKey{}, err
+	}
+
+	var results []struct {
+		Success *struct {
+			Username  string `json:"username"`
+			ClientKey string `json:"clientkey"`
+		} `json:"success"`
+		Error *hueV1Error `json:"error"`
+	}
+	if err := json.Unmarshal(body, &results); err != nil || len(results) == 0 {
+		return BridgeKey{}, fmt.Errorf("unexpected pairing response from bridge: %s", bytes.TrimSpace(body))
+	}
+	if results[0].Error != nil {
+		return BridgeKey{}, results[0].Error
+	}
+	if results[0].Success == nil || results[0].Success.Username == "" {
+		return BridgeKey{}, fmt.Errorf("unexpected pairing response from bridge: %s", bytes.TrimSpace(body))
+	}
+	return BridgeKey{
+		Bridge:    host,
+		AppKey:    results[0].Success.Username,
+		ClientKey: results[0].Success.ClientKey,
+	}, nil
+}
+
+// deviceType names this server in the bridge's list of paired apps. The
+// bridge allows at most 20 characters for the application and 19 for the
+// device name.
+func deviceType() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "server"
+	}
+	if len(host) > 19 {
+		host = host[:19]
+	}
+	return "alfred#" + host
+}
+
+// pairHandlerStatus returns the HTTP status for a pairing status.
+func pairHandlerStatus(st PairingStatus) int {
+	if st.State == PairingWaiting {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,126 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for bridge pairing and the key store.
+package light
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPairingStoresKey verifies that pairing polls the bridge until the link
+// button is pressed, stores the key and never returns it to the client.
+func TestPairingStoresKey(t *testing.T) {
+	pairPollInterval, pairTimeout = 10*time.Millisecond, 5*time.Second
+	defer func() { pairPollInterval, pairTimeout = 2*time.Second, time.Minute }()
+
+	bridge, requests := fakeHueBridge(t)
+	path := filepath.Join(t.TempDir(), "hue-keys.json")
+	keys, err := OpenKeyStore(path)
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := PairHandler(router, logrus.New(), Config{Keys: keys})
+	router.POST("/api/v1/device/light/:brand/:bridge/pair", handler)
+	router.GET("/api/v1/device/light/:brand/:bridge/pair", handler)
+	router.DELETE("/api/v1/device/light/:brand/:bridge/pair", handler)
+	target := "/api/v1/device/light/philips/" + bridge + "/pair"
+
+	serve := func(method string) (int, PairingStatus, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		var st PairingStatus
+		json.Unmarshal(w.Body.Bytes(), &st)
+		return w.Code, st, w.Body.String()
+	}
+
+	code, st, _ := serve(http.MethodPost)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, PairingWaiting, st.State)
+
+	assert.Eventually(t, func() bool {
+		_, st, _ := serve(http.MethodGet)
+		return st.State == PairingPaired
+	}, 2*time.Second, 10*time.Millisecond)
+
+	code, st, body := serve(http.MethodGet)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "test-key")
+	assert.NotContains(t, body, "0123456789ABCDEF")
+
+	for _, r := range *requests {
+		assert.Equal(t, "/api", r.path)
+		assert.Equal(t, true, r.body["generateclientkey"])
+	}
+
+	// The key is persisted privately and survives a reload.
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	reloaded, err := OpenKeyStore(path)
+	assert.NoError(t, err)
+	key, ok := reloaded.Get(bridge)
+	assert.True(t, ok)
+	assert.Equal(t, "test-key", key.AppKey)
+
+	// Pairing again is a no-op unless forced.
+	code, st, _ = serve(http.MethodPost)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, PairingPaired, st.State)
+
+	code, _, _ = serve(http.MethodDelete)
+	assert.Equal(t, http.StatusOK, code)
+	_, ok = keys.Get(bridge)
+	assert.False(t, ok)
+}
+
+// TestPairingExpires verifies that an attempt ends when the link button is
+// not pressed in time.
+func TestPairingExpires(t *testing.T) {
+	pairPollInterval, pairTimeout = 10*time.Millisecond, 0
+	defer func() { pairPollInterval, pairTimeout = 2*time.Second, time.Minute }()
+
+	keys, err := OpenKeyStore("")
+	assert.NoError(t, err)
+	startPairing("127.0.0.1:1", "127.0.0.1:1", Config{Keys: keys}, logrus.New())
+
+	assert.Eventually(t, func() bool {
+		st, ok := pairingStatus("127.0.0.1:1")
+		return ok && st.State == PairingExpired
+	}, 2*time.Second, 10*time.Millisecond)
+	_, ok := keys.Get("127.0.0.1:1")
+	assert.False(t, ok)
+}
+
+// TestPairingStopped verifies that stopping pairing abandons the attempts in
+// progress and refuses new ones.
+func TestPairingStopped(t *testing.T) {
+	pairPollInterval = 10 * time.Millisecond
+	defer func() {
+		pairPollInterval = 2 * time.Second
+		pairings.Lock()
+		pairings.stop = make(chan struct{})
+		pairings.Unlock()
+	}()
+
+	keys, err := OpenKeyStore("")
+	assert.NoError(t, err)
+	st := startPairing("127.0.0.1:2", "127.0.0.1:2", Config{Keys: keys}, logrus.New())
+	assert.Equal(t, PairingWaiting, st.State)
+
+	StopPairing()
+	st, _ = pairingStatus("127.0.0.1:2")
+	assert.Equal(t, PairingFailed, st.State)
+
+	st = startPairing("127.0.0.1:3", "127.0.0.1:3", Config{Keys: keys}, logrus.New())
+	assert.Equal(t, PairingFailed, st.State)
+}
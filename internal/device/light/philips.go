@@ -1,272 +1,311 @@
+// Package light provides functionality for controlling smart lights.
+// This file implements support for Philips Hue lights through the CLIP v2 API.
 package light
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
-type philipsLight struct {
-	brand      string
-	ip         string
-	id         string
-	actionName string
-	ctx        *gin.Context
-}
+// bridgeTimeout bounds every request to a Hue bridge.
+const bridgeTimeout = 10 * time.Second
 
-func (p *philipsLight) getIP() string {
-	return p.ip
+// philipsLight represents a Philips Hue light behind a bridge.
+// It implements the light interface using the bridge's CLIP v2 API.
+type philipsLight struct {
+	brand      string         // Brand from the request
+	bridge     string         // Bridge from the request (registry ID or address)
+	ip         string         // Address of the bridge
+	id         string         // Resource ID of the light
+	actionName string         // Action being executed, for error messages
+	ctx        *gin.Context   // HTTP context for request handling
+	logger     *logrus.Logger // Logger for operation tracking
+	cfg        Config         // Shared light settings
+
+	// authorize checks an action that only turns out while executing, such
+	// as "off" for dimming to 0, against the access rules. It may be nil.
+	authorize func(action string) error
 }
 
+// getID returns the resource ID of the light.
 func (p *philipsLight) getID() string {
 	return p.id
 }
 
+// getBrand returns the device brand name (always "philips").
 func (p *philipsLight) getBrand() string {
 	return "philips"
 }
 
-func (p *philipsLight) getGinContext() *gin.Context {
-	return p.ctx
-}
+// execAction executes a command on the light and returns its result.
+func (p *philipsLight) execAction(action string) (interface{}, error) {
+	p.actionName = action
+	if action != "list" && p.id == "" {
+		return nil, fmt.Errorf("%w: action %s requires a light id", ErrInvalidRequest, action)
+	}
 
-func (p *philipsLight) execAction(action string) error {
 	switch action {
-	case "getAll":
-		p.actionName = "getAll"
-		err := p.getAll()
-		if err != nil {
-			return err
-		}
+	case "list":
+		return p.list()
+	case "get":
+		return p.get()
 	case "on":
-		p.actionName = "on"
-		err := p.on()
-		if err != nil {
-			return err
+		if err := p.on(); err != nil {
+			return nil, err
 		}
+		return gin.H{"on": true}, nil
 	case "off":
-		p.actionName = "off"
-		err := p.off()
-		if err != nil {
-			return err
+		if err := p.off(); err != nil {
+			return nil, err
 		}
+		return gin.H{"on": false}, nil
 	case "brightness":
-		p.actionName = "brightness"
-		err := p.setBrightness()
-		if err != nil {
-			return err
-		}
+		return p.setBrightness()
 	case "color":
-		p.actionName = "color"
-		err := p.color()
-		if err != nil {
-			return err
-		}
+		return p.color()
 	default:
-		return fmt.Errorf("unsupported action: %s", action)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAction, action)
 	}
-	return nil
-}
-
-func (p *philipsLight) getAll() error {
-	return runGetRequest(p)
 }
 
-func (p *philipsLight) on() error {
-	jsonString := `{"on":{"on":true}}`
-
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonString), &jsonData); err != nil {
-		fmt.Printf("unmarshal error: %s\n", err)
-		return err
+// list returns every light known to the bridge.
+func (p *philipsLight) list() ([]LightInfo, error) {
+	var lights []clipLight
+	if err := runGetRequest(p, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, err
 	}
 
-	jsonBytes, err := json.Marshal(jsonData)
-	if err != nil {
-		fmt.Printf("marshal error: %s\n", err)
-		return err
+	infos := make([]LightInfo, 0, len(lights))
+	for _, l := range lights {
+		infos = append(infos, l.info())
 	}
-
-	p.ctx.Request.Body = io.NopCloser(bytes.NewBuffer(jsonBytes))
-	return runPutRequest(p)
+	return infos, nil
 }
 
-func (p *philipsLight) off() error {
-	jsonString := `{"on":{"on":false}}`
-
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonString), &jsonData); err != nil {
-		fmt.Printf("unmarshal error: %s\n", err)
-		return err
+// get returns the state of the light.
+func (p *philipsLight) get() (*LightInfo, error) {
+	var lights []clipLight
+	if err := runGetRequest(p, "/clip/v2/resource/light/"+p.id, &lights); err != nil {
+		return nil, err
 	}
-
-	jsonBytes, err := json.Marshal(jsonData)
-	if err != nil {
-		fmt.Printf("marshal error: %s\n", err)
-		return err
+	if len(lights) == 0 {
+		return nil, &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{"light not found"}}
 	}
-
-	p.ctx.Request.Body = io.NopCloser(bytes.NewBuffer(jsonBytes))
-	return runPutRequest(p)
+	info := lights[0].info()
+	return &info, nil
 }
 
-func (p *philipsLight) setBrightness() error {
-	// runRequest(p)
-	return nil
+// on switches the light on.
+func (p *philipsLight) on() error {
+	return runPutRequest(p, gin.H{"on": gin.H{"on": true}})
 }
 
-func (p *philipsLight) color() error {
-	return nil
+// off switches the light off.
+func (p *philipsLight) off() error {
+	return runPutRequest(p, gin.H{"on": gin.H{"on": false}})
 }
 
-// Helpers
-
-func runPutRequest(p *philipsLight) error {
-	url := fmt.Sprintf("https://%s/clip/v2/resource/light/%s", p.ip, p.id)
-
-	bodyBytes, err := io.ReadAll(p.ctx.Request.Body)
-	if err != nil {
-		return err
+// setBrightness sets the brightness from a BrightnessRequest body, e.g.
+// {"brightness": 40}, {"brightness": "-25"} or {"brightness": 80, "transition": 2000}.
+// Relative steps are applied to the light's current level. Dimming to 0
+// switches the light off; any other level switches it on.
+func (p *philipsLight) setBrightness() (interface{}, error) {
+	var req BrightnessRequest
+	if err := p.ctx.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
+	return p.applyBrightness(req)
+}
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(bodyBytes))
+// applyBrightness sets the brightness described by req.
+func (p *philipsLight) applyBrightness(req BrightnessRequest) (gin.H, error) {
+	level, err := req.parse()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("hue-application-key", p.ctx.GetHeader("hue-application-key"))
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	target := level.value
+	if level.relative {
+		var lights []clipLight
+		if err := runGetRequest(p, "/clip/v2/resource/light/"+p.id, &lights); err != nil {
+			return nil, err
+		}
+		if len(lights) == 0 {
+			return nil, &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{"light not found"}}
+		}
+		if lights[0].Dimming == nil {
+			return nil, fmt.Errorf("brightness: %w", ErrNotSupported)
+		}
+		target = level.apply(lights[0].Dimming.Brightness, lights[0].On.On)
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if target <= 0 {
+		if err := p.authorizeOff(); err != nil {
+			return nil, err
+		}
 	}
 
-	defer resp.Body.Close()
+	body := gin.H{}
+	setLevel(body, target)
+	if req.Transition != nil {
+		body["dynamics"] = gin.H{"duration": *req.Transition}
+	}
+	if err := runPutRequest(p, body); err != nil {
+		return nil, err
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to perform action: %s", p.actionName)
+	result := gin.H{"brightness": target, "on": target > 0}
+	if req.Transition != nil {
+		result["transition"] = *req.Transition
 	}
-	return nil
+	return result, nil
 }
 
-func runPostRequest(p *philipsLight) error {
-	url := fmt.Sprintf("https://%s/clip/v2/resource/light/%s", p.ip, p.id)
-
-	bodyBytes, err := io.ReadAll(p.ctx.Request.Body)
-	if err != nil {
-		return err
+// authorizeOff checks whether the request may switch the light off.
+func (p *philipsLight) authorizeOff() error {
+	if p.authorize == nil {
+		return nil
 	}
+	return p.authorize("off")
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return err
+// setLevel adds a brightness to a light update: zero switches the light off,
+// anything else switches it on at that level.
+func setLevel(body gin.H, brightness float64) {
+	if brightness <= 0 {
+		body["on"] = gin.H{"on": false}
+		return
 	}
+	body["on"] = gin.H{"on": true}
+	body["dimming"] = gin.H{"brightness": brightness}
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("hue-application-key", p.ctx.GetHeader("hue-application-key"))
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// color sets the color from a ColorRequest body, e.g. {"hex": "#ff8800"},
+// {"rgb": {"r": 255, "g": 136, "b": 0}}, {"hsv": {"h": 32, "s": 100}},
+// {"xy": {"x": 0.56, "y": 0.4}}, {"kelvin": 2700} or {"mirek": 370}.
+// Colors are clamped to the bulb's gamut and temperatures to its range.
+func (p *philipsLight) color() (interface{}, error) {
+	var req ColorRequest
+	if err := p.ctx.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
+	return p.applyColor(req)
+}
 
-	resp, err := client.Do(req)
+// applyColor sets the color described by req.
+func (p *philipsLight) applyColor(req ColorRequest) (gin.H, error) {
+	target, err := req.resolve()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to perform action: %s", p.actionName)
+	var lights []clipLight
+	if err := runGetRequest(p, "/clip/v2/resource/light/"+p.id, &lights); err != nil {
+		return nil, err
 	}
-	return nil
-}
+	if len(lights) == 0 {
+		return nil, &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{"light not found"}}
+	}
+	l := lights[0]
 
-func runGetRequest(p *philipsLight) error {
-	var url string
-	if p.actionName == "getAll" {
-		url = fmt.Sprintf("https://%s/clip/v2/resource/light", p.ip)
+	body := gin.H{}
+	result := gin.H{}
+	if target.xy != nil {
+		if l.Color == nil {
+			return nil, fmt.Errorf("color: %w", ErrNotSupported)
+		}
+		xy := l.gamut().Clamp(*target.xy)
+		body["color"] = gin.H{"xy": xy}
+		result["xy"] = xy
+		result["clamped"] = xy != *target.xy
 	} else {
-		url = fmt.Sprintf("https://%s/clip/v2/resource/light/%s", p.ip, p.id)
+		if l.ColorTemperature == nil {
+			return nil, fmt.Errorf("color temperature: %w", ErrNotSupported)
+		}
+		min, max := l.mirekRange()
+		mirek := clampMirek(*target.mirek, min, max)
+		body["color_temperature"] = gin.H{"mirek": mirek}
+		result["mirek"] = mirek
+		result["clamped"] = mirek != *target.mirek
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return err
+	if target.brightness != nil && l.Dimming != nil {
+		if *target.brightness <= 0 {
+			if err := p.authorizeOff(); err != nil {
+				return nil, err
+			}
+		}
+		setLevel(body, *target.brightness)
+		result["brightness"] = *target.brightness
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("hue-application-key", p.ctx.GetHeader("hue-application-key"))
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	if err := runPutRequest(p, body); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+// Helpers
 
-	defer resp.Body.Close()
+// runPutRequest sends a state update for the light.
+func runPutRequest(p *philipsLight, body interface{}) error {
+	return doRequest(p, http.MethodPut, "/clip/v2/resource/light/"+p.id, body, nil)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to perform action: %s", p.actionName)
+// runGetRequest reads a CLIP v2 resource into out. While the bridge's event
+// stream is connected the read is answered from its cache.
+func runGetRequest(p *philipsLight, path string, out interface{}) error {
+	if st := p.cfg.Streams.live(p.bridge); st != nil {
+		if ok, err := st.read(path, out); ok {
+			return err
+		}
 	}
-	return nil
+	return doRequest(p, http.MethodGet, path, nil, out)
 }
 
-func runDeleteRequest(p *philipsLight) error {
-	var jsonData map[string]interface{}
-	if err := p.ctx.ShouldBindJSON(&jsonData); err != nil {
-		p.ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+// doRequest performs a CLIP v2 request against the bridge and decodes the
+// "data" member of the response into out.
+func doRequest(p *philipsLight, method, path string, body interface{}, out interface{}) error {
+	key, err := p.cfg.appKey(p.bridge)
+	if err != nil {
 		return err
 	}
 
-	url := fmt.Sprintf("https://%s/clip/v2/resource/light/%s", p.ip, p.id)
-
-	jsonBytes, err := json.Marshal(p.ctx.Request.Body)
-	if err != nil {
-		return err
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, url, bytes.NewBuffer(jsonBytes))
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s%s", p.ip, path), reader)
 	if err != nil {
 		return err
 	}
-
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("hue-application-key", p.ctx.GetHeader("hue-application-key"))
+	req.Header.Set("hue-application-key", key)
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	p.logger.Debugf("Hue request: %s %s", method, path)
+	resp, err := p.cfg.client(p.bridge).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform action %s: %w", p.actionName, err)
 	}
+	defer resp.Body.Close()
 
-	resp, err := client.Do(req)
-
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to perform action: %s", p.actionName)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: the bridge rejected the stored application key, pair it again", ErrNotPaired)
 	}
-	return nil
+	return decodeClip(resp.StatusCode, respBody, out)
 }
@@ -0,0 +1,345 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for the Philips Hue light implementation.
+package light
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLightID is the resource ID of the light served by the fake bridge.
+const testLightID = "3f1c2b0e-5d4a-4c1e-9a7b-2e6f8d0c1a11"
+
+// testLightJSON is a color light resource as returned by a real bridge.
+const testLightJSON = `{
+	"id": "` + testLightID + `",
+	"id_v1": "/lights/1",
+	"metadata": {"name": "Desk", "archetype": "sultan_bulb"},
+	"on": {"on": true},
+	"dimming": {"brightness": 62.5, "min_dim_level": 0.2},
+	"color_temperature": {"mirek": null, "mirek_valid": false, "mirek_schema": {"mirek_minimum": 153, "mirek_maximum": 500}},
+	"color": {"xy": {"x": 0.4573, "y": 0.41}, "gamut_type": "C"},
+	"mode": "normal",
+	"type": "light"
+}`
+
+// Resource IDs of the rooms, zones and devices served by the fake bridge.
+const (
+	testDeviceID      = "8a1b0c2d-3e4f-4a5b-8c6d-7e8f9a0b1c22"
+	testRoomID        = "5b0e7c1d-2f3a-4b4c-9d5e-6f7a8b9c0d33"
+	testRoomGroupID   = "c4d5e6f7-0819-4a2b-8c3d-4e5f6a7b8c44"
+	testZoneID        = "e1f2a3b4-c5d6-4e7f-8091-a2b3c4d5e655"
+	testZoneGroupID   = "f6e5d4c3-b2a1-4098-8f7e-6d5c4b3a2966"
+	testSceneID       = "7d6c5b4a-3928-4170-a6b5-c4d3e2f1a088"
+	testZoneSceneID   = "a0b1c2d3-e4f5-4061-8273-9485a6b7c899"
+	testNewSceneID    = "b9a8c7d6-e5f4-4032-a1b0-c9d8e7f6a5aa"
+	testMotionDevice  = "d1e2f3a4-b5c6-4d7e-8f90-a1b2c3d4e5bb"
+	testDimmerDevice  = "e2f3a4b5-c6d7-4e8f-9a01-b2c3d4e5f6cc"
+	testButtonID      = "f3a4b5c6-d7e8-4f9a-0b12-c3d4e5f6a7dd"
+	testResourcesJSON = `{
+	"room": [{"id": "` + testRoomID + `", "type": "room", "metadata": {"name": "Kitchen", "archetype": "kitchen"},
+		"children": [{"rid": "` + testDeviceID + `", "rtype": "device"}],
+		"services": [{"rid": "` + testRoomGroupID + `", "rtype": "grouped_light"}]}],
+	"zone": [{"id": "` + testZoneID + `", "type": "zone", "metadata": {"name": "Downstairs", "archetype": "downstairs"},
+		"children": [{"rid": "` + testLightID + `", "rtype": "light"}],
+		"services": [{"rid": "` + testZoneGroupID + `", "rtype": "grouped_light"}]}],
+	"device": [{"id": "` + testDeviceID + `", "type": "device",
+		"services": [{"rid": "` + testLightID + `", "rtype": "light"}, {"rid": "0a9b8c7d-6e5f-4a3b-2c1d-0e9f8a7b6c77", "rtype": "zigbee_connectivity"}]},
+		{"id": "` + testMotionDevice + `", "type": "device", "metadata": {"name": "Hallway sensor"},
+			"product_data": {"model_id": "SML003", "product_name": "Hue motion sensor"}},
+		{"id": "` + testDimmerDevice + `", "type": "device", "metadata": {"name": "Bedroom dimmer"},
+			"product_data": {"model_id": "RWL022", "product_name": "Hue dimmer switch"}}],
+	"motion": [{"id": "11111111-aaaa-4bbb-8ccc-000000000001", "type": "motion", "owner": {"rid": "` + testMotionDevice + `", "rtype": "device"},
+		"enabled": true, "motion": {"motion": false, "motion_valid": true,
+			"motion_report": {"changed": "2024-05-01T18:00:00Z", "motion": true}}}],
+	"temperature": [{"id": "11111111-aaaa-4bbb-8ccc-000000000002", "type": "temperature", "owner": {"rid": "` + testMotionDevice + `", "rtype": "device"},
+		"enabled": true, "temperature": {"temperature": 21.5, "temperature_valid": true}}],
+	"light_level": [{"id": "11111111-aaaa-4bbb-8ccc-000000000003", "type": "light_level", "owner": {"rid": "` + testMotionDevice + `", "rtype": "device"},
+		"enabled": true, "light": {"light_level": 20001, "light_level_valid": true}}],
+	"button": [
+		{"id": "11111111-aaaa-4bbb-8ccc-000000000005", "type": "button", "owner": {"rid": "` + testDimmerDevice + `", "rtype": "device"},
+			"metadata": {"control_id": 2}, "button": {}},
+		{"id": "` + testButtonID + `", "type": "button", "owner": {"rid": "` + testDimmerDevice + `", "rtype": "device"},
+			"metadata": {"control_id": 1},
+			"button": {"last_event": "short_release", "button_report": {"updated": "2024-05-01T18:05:00Z", "event": "short_release"}}}],
+	"device_power": [
+		{"id": "11111111-aaaa-4bbb-8ccc-000000000006", "type": "device_power", "owner": {"rid": "` + testMotionDevice + `", "rtype": "device"},
+			"power_state": {"battery_state": "normal", "battery_level": 87}},
+		{"id": "11111111-aaaa-4bbb-8ccc-000000000007", "type": "device_power", "owner": {"rid": "` + testDeviceID + `", "rtype": "device"},
+			"power_state": {}}],
+	"scene": [
+		{"id": "` + testSceneID + `", "type": "scene", "metadata": {"name": "Relax"},
+			"group": {"rid": "` + testRoomID + `", "rtype": "room"},
+			"actions": [{"target": {"rid": "` + testLightID + `", "rtype": "light"}, "action": {"on": {"on": true}}}],
+			"palette": {"color": [{"color": {"xy": {"x": 0.5, "y": 0.4}}}], "dimming": [], "color_temperature": []},
+			"status": {"active": "inactive"}},
+		{"id": "` + testZoneSceneID + `", "type": "scene", "metadata": {"name": "Relax"},
+			"group": {"rid": "` + testZoneID + `", "rtype": "zone"},
+			"actions": [{"target": {"rid": "` + testLightID + `", "rtype": "light"}, "action": {"on": {"on": true}}}],
+			"status": {"active": "static"}}],
+	"grouped_light": [
+		{"id": "` + testRoomGroupID + `", "type": "grouped_light", "owner": {"rid": "` + testRoomID + `", "rtype": "room"},
+			"on": {"on": true}, "dimming": {"brightness": 62.5}},
+		{"id": "` + testZoneGroupID + `", "type": "grouped_light", "owner": {"rid": "` + testZoneID + `", "rtype": "zone"},
+			"on": {"on": false}, "dimming": {"brightness": 0}}]
+}`
+)
+
+// bridgeRequest records a request received by the fake bridge.
+type bridgeRequest struct {
+	method string
+	path   string
+	key    string
+	body   map[string]interface{}
+}
+
+// fakeHueBridge starts a TLS server answering CLIP v2 requests for a single
+// light and returns its address and the requests it received. Pairing
+// requests succeed from the third attempt on, as if the link button had been
+// pressed in between.
+func fakeHueBridge(t *testing.T) (string, *[]bridgeRequest) {
+	t.Helper()
+	var requests []bridgeRequest
+	pairAttempts := 0
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := bridgeRequest{method: r.Method, path: r.URL.Path, key: r.Header.Get("hue-application-key")}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			assert.NoError(t, json.Unmarshal(data, &rec.body))
+		}
+		requests = append(requests, rec)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api" && r.Method == http.MethodPost:
+			pairAttempts++
+			if pairAttempts < 3 {
+				io.WriteString(w, `[{"error":{"type":101,"address":"","description":"link button not pressed"}}]`)
+				return
+			}
+			io.WriteString(w, `[{"success":{"username":"test-key","clientkey":"0123456789ABCDEF"}}]`)
+		case r.URL.Path == "/api/0/config":
+			io.WriteString(w, `{"name":"Hue Bridge","datastoreversion":"163","swversion":"1965111030","apiversion":"1.65.0",`+
+				`"mac":"00:17:88:23:bf:c2","bridgeid":"001788FFFE23BFC2","factorynew":false,"modelid":"BSB002"}`)
+		case rec.key != "test-key":
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors":[{"description":"unauthorized user"}],"data":[]}`)
+		case r.URL.Path == "/clip/v2/resource/light":
+			io.WriteString(w, `{"errors":[],"data":[`+testLightJSON+`]}`)
+		case strings.HasPrefix(r.URL.Path, "/clip/v2/resource/") && r.Method == http.MethodGet &&
+			strings.Count(r.URL.Path, "/") == 4:
+			var resources map[string]json.RawMessage
+			assert.NoError(t, json.Unmarshal([]byte(testResourcesJSON), &resources))
+			data, ok := resources[strings.TrimPrefix(r.URL.Path, "/clip/v2/resource/")]
+			if !ok {
+				data = json.RawMessage("[]")
+			}
+			io.WriteString(w, `{"errors":[],"data":`+string(data)+`}`)
+		case r.Method == http.MethodPut && (r.URL.Path == "/clip/v2/resource/grouped_light/"+testRoomGroupID ||
+			r.URL.Path == "/clip/v2/resource/grouped_light/"+testZoneGroupID):
+			io.WriteString(w, `{"errors":[],"data":[{"rid":"`+path.Base(r.URL.Path)+`","rtype":"grouped_light"}]}`)
+		case r.Method == http.MethodPut && (r.URL.Path == "/clip/v2/resource/scene/"+testSceneID ||
+			r.URL.Path == "/clip/v2/resource/scene/"+testZoneSceneID):
+			io.WriteString(w, `{"errors":[],"data":[{"rid":"`+path.Base(r.URL.Path)+`","rtype":"scene"}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/clip/v2/resource/scene":
+			io.WriteString(w, `{"errors":[],"data":[{"rid":"`+testNewSceneID+`","rtype":"scene"}]}`)
+		case r.URL.Path != "/clip/v2/resource/light/"+testLightID:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors":[{"description":"Not Found"}],"data":[]}`)
+		case r.Method == http.MethodGet:
+			io.WriteString(w, `{"errors":[],"data":[`+testLightJSON+`]}`)
+		default:
+			io.WriteString(w, `{"errors":[],"data":[{"rid":"`+testLightID+`","rtype":"light"}]}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	return u.Host, &requests
+}
+
+// serveLight routes a single request through LightActionHandler. When key is
+// not empty it is stored as the application key of the bridge in target.
+func serveLight(t *testing.T, method, target, key, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return serveLightWith(t, Config{}, method, target, key, body)
+}
+
+// serveLightWith is serveLight with further light settings in cfg.
+func serveLightWith(t *testing.T, cfg Config, method, target, key, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	keys, err := OpenKeyStore("")
+	assert.NoError(t, err)
+	if key != "" {
+		bridge := strings.Split(target, "/")[6]
+		assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge, AppKey: key}))
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	cfg.Keys = keys
+	handler := LightActionHandler(router, logrus.New(), cfg)
+	router.GET("/api/v1/device/light/:brand/:bridge/lights", handler)
+	router.GET("/api/v1/device/light/:brand/:bridge/lights/:id", handler)
+	router.PUT("/api/v1/device/light/:brand/:bridge/lights/:id/:action", handler)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// TestPhilipsListLights verifies that the CLIP v2 light resources are parsed
+// into the API view.
+func TestPhilipsListLights(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveLight(t, http.MethodGet, "/api/v1/device/light/philips/"+bridge+"/lights", "test-key", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "list", response["action"])
+
+	lights := response["result"].([]interface{})
+	assert.Len(t, lights, 1)
+	desk := lights[0].(map[string]interface{})
+	assert.Equal(t, testLightID, desk["id"])
+	assert.Equal(t, "Desk", desk["name"])
+	assert.Equal(t, true, desk["on"])
+	assert.Equal(t, 62.5, desk["brightness"])
+	assert.Equal(t, "C", desk["gamutType"])
+	assert.NotContains(t, desk, "mirek")
+}
+
+// TestPhilipsGetLight verifies that a single light is returned.
+func TestPhilipsGetLight(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveLight(t, http.MethodGet, "/api/v1/device/light/philips/"+bridge+"/lights/"+testLightID, "test-key", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "get", response["action"])
+	assert.Equal(t, "Desk", response["result"].(map[string]interface{})["name"])
+}
+
+// TestPhilipsActionsSendClipBodies verifies the state updates sent for each action.
+func TestPhilipsActionsSendClipBodies(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	base := "/api/v1/device/light/philips/" + bridge + "/lights/" + testLightID + "/"
+
+	cases := []struct {
+		action string
+		body   string
+		sent   string
+	}{
+		{"on", "", `{"on":{"on":true}}`},
+		{"off", "", `{"on":{"on":false}}`},
+		{"brightness", `{"brightness": 40}`, `{"on":{"on":true},"dimming":{"brightness":40}}`},
+		{"brightness", `{"brightness": "+10", "transition": 1500}`, `{"on":{"on":true},"dimming":{"brightness":72.5},"dynamics":{"duration":1500}}`},
+		{"brightness", `{"brightness": "-80%"}`, `{"on":{"on":false}}`},
+		{"brightness", `{"brightness": 0}`, `{"on":{"on":false}}`},
+		{"color", `{"xy": {"x": 0.3, "y": 0.6}}`, `{"color":{"xy":{"x":0.3,"y":0.6}}}`},
+		{"color", `{"mirek": 366}`, `{"color_temperature":{"mirek":366}}`},
+		{"color", `{"hex": "#00ff00"}`, `{"color":{"xy":{"x":0.3,"y":0.6}}}`},
+		{"color", `{"kelvin": 1000}`, `{"color_temperature":{"mirek":500}}`},
+		{"color", `{"xy": {"x": 0.1532, "y": 0}}`, `{"color":{"xy":{"x":0.1532,"y":0.0475}}}`},
+		{"color", `{"hsv": {"h": 0, "s": 100, "v": 25}}`, `{"color":{"xy":{"x":0.6401,"y":0.33}},"on":{"on":true},"dimming":{"brightness":25}}`},
+	}
+	for _, tc := range cases {
+		w, response := serveLight(t, http.MethodPut, base+tc.action, "test-key", tc.body)
+		assert.Equal(t, http.StatusOK, w.Code, tc.action)
+		assert.Equal(t, "success", response["status"], tc.action)
+
+		if tc.action == "color" || strings.Contains(tc.body, `"+`) || strings.Contains(tc.body, `"-`) {
+			assert.Equal(t, http.MethodGet, (*requests)[len(*requests)-2].method, "%s reads the light first", tc.action)
+		}
+		last := (*requests)[len(*requests)-1]
+		assert.Equal(t, http.MethodPut, last.method)
+		assert.Equal(t, "/clip/v2/resource/light/"+testLightID, last.path)
+		sent, _ := json.Marshal(last.body)
+		assert.JSONEq(t, tc.sent, string(sent), tc.action)
+	}
+}
+
+// TestPhilipsDimToZeroProtected verifies that dimming a protected light to
+// 0, which switches it off, needs the same confirmation as "off", whether
+// through a brightness or a color request.
+func TestPhilipsDimToZeroProtected(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: deviceID(bridge, testLightID), Protected: true})
+	assert.NoError(t, err)
+	base := "/api/v1/device/light/philips/" + bridge + "/lights/" + testLightID + "/"
+
+	for _, tc := range []struct{ action, body string }{
+		{"brightness", `{"brightness": 0}`},
+		{"brightness", `{"brightness": "-80%"}`},
+		{"color", `{"hsv": {"h": 0, "s": 0, "v": 0}}`},
+	} {
+		before := len(*requests)
+		w, response := serveLightWith(t, Config{ACL: rules}, http.MethodPut, base+tc.action, "test-key", tc.body)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code, tc.body)
+		assert.NotEmpty(t, response["error"], tc.body)
+		for _, r := range (*requests)[before:] {
+			assert.NotEqual(t, http.MethodPut, r.method, tc.body)
+		}
+	}
+
+	w, response := serveLightWith(t, Config{ACL: rules}, http.MethodPut, base+"brightness", "test-key", `{"brightness": 40}`)
+	assert.Equal(t, http.StatusOK, w.Code, response)
+	w, response = serveLightWith(t, Config{ACL: rules}, http.MethodPut, base+"brightness?confirm=true", "test-key", `{"brightness": 0}`)
+	assert.Equal(t, http.StatusOK, w.Code, response)
+}
+
+// TestPhilipsErrorStatuses verifies that bridge and request errors are mapped
+// to HTTP status codes.
+func TestPhilipsErrorStatuses(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	base := "/api/v1/device/light/philips/" + bridge + "/lights/"
+
+	cases := []struct {
+		name   string
+		method string
+		target string
+		key    string
+		body   string
+		status int
+	}{
+		{"rejected key", http.MethodGet, base + testLightID, "wrong", "", http.StatusConflict},
+		{"not paired", http.MethodGet, base + testLightID, "", "", http.StatusConflict},
+		{"unknown light", http.MethodGet, base + "missing", "test-key", "", http.StatusNotFound},
+		{"unknown action", http.MethodPut, base + testLightID + "/blink", "test-key", "", http.StatusBadRequest},
+		{"brightness out of range", http.MethodPut, base + testLightID + "/brightness", "test-key", `{"brightness": 140}`, http.StatusBadRequest},
+		{"transition out of range", http.MethodPut, base + testLightID + "/brightness", "test-key", `{"brightness": 10, "transition": -1}`, http.StatusBadRequest},
+		{"color without value", http.MethodPut, base + testLightID + "/color", "test-key", `{}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		before := len(*requests)
+		w, response := serveLight(t, tc.method, tc.target, tc.key, tc.body)
+		assert.Equal(t, tc.status, w.Code, tc.name)
+		assert.NotEmpty(t, response["error"], tc.name)
+		if tc.status == http.StatusBadRequest || tc.key == "" {
+			assert.Len(t, *requests, before, "%s must not reach the bridge", tc.name)
+		}
+	}
+}
+
+// TestPhilipsUnreachableBridge verifies that connection failures are reported
+// as a bad gateway.
+func TestPhilipsUnreachableBridge(t *testing.T) {
+	w, _ := serveLight(t, http.MethodGet, "/api/v1/device/light/philips/127.0.0.1:1/lights", "test-key", "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,336 @@
+// Package light provides functionality for controlling smart lights.
+// This file implements listing, recalling and creating Philips Hue scenes.
+package light
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+)
+
+// Scene recall modes. "active" plays a scene the way it was saved, "static"
+// recalls it without its palette animation and "dynamic_palette" cycles
+// through its palette.
+const (
+	RecallActive  = "active"
+	RecallStatic  = "static"
+	RecallDynamic = "dynamic_palette"
+)
+
+// maxSceneName is the longest scene name the bridge accepts.
+const maxSceneName = 32
+
+// SceneRecall is the body of a recall request, e.g.
+// {"mode": "dynamic_palette", "transition": 1000, "brightness": 60}.
+// Every field is optional.
+type SceneRecall struct {
+	Mode       string   `json:"mode"`       // Recall mode, RecallActive by default
+	Transition *int     `json:"transition"` // Fade time in milliseconds
+	Brightness *float64 `json:"brightness"` // Overrides the scene's brightness, 1-100
+}
+
+// validate checks the request and fills in the default mode.
+func (r *SceneRecall) validate() error {
+	switch r.Mode {
+	case "":
+		r.Mode = RecallActive
+	case RecallActive, RecallStatic, RecallDynamic:
+	default:
+		return fmt.Errorf("mode must be %q, %q or %q", RecallActive, RecallStatic, RecallDynamic)
+	}
+	if r.Transition != nil && (*r.Transition < 0 || *r.Transition > maxTransition) {
+		return fmt.Errorf("transition must be between 0 and %d ms", maxTransition)
+	}
+	if r.Brightness != nil && (*r.Brightness <= 0 || *r.Brightness > 100) {
+		return fmt.Errorf("brightness must be between 1 and 100")
+	}
+	return nil
+}
+
+// SceneCreate is the body of a request saving the current state of a room or
+// zone as a new scene, e.g. {"name": "Evening", "room": "Kitchen"}.
+type SceneCreate struct {
+	Name string `json:"name"` // Name of the new scene
+	Room string `json:"room"` // ID or name of the room to snapshot
+	Zone string `json:"zone"` // ID or name of the zone to snapshot, instead of a room
+}
+
+// philipsScenes represents the scenes of a Hue bridge. It shares the bridge
+// connection and request helpers of philipsLight; its id is the ID or name of
+// the addressed scene. It implements the scenes interface.
+type philipsScenes struct {
+	*philipsLight
+	groupKind string // GroupRoom or GroupZone when scenes are filtered
+	groupRef  string // ID or name of the room or zone to filter by
+}
+
+// execAction executes a command on the scenes and returns its result.
+func (s *philipsScenes) execAction(action string) (interface{}, error) {
+	s.actionName = action
+	if (action == "get" || action == "recall") && s.id == "" {
+		return nil, fmt.Errorf("%w: action %s requires a scene id or name", ErrInvalidRequest, action)
+	}
+
+	switch action {
+	case "list":
+		return s.list()
+	case "get":
+		scene, groupName, err := s.find()
+		if err != nil {
+			return nil, err
+		}
+		info := scene.info(groupName)
+		return &info, nil
+	case "recall":
+		return s.recall()
+	case "create":
+		return s.create()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAction, action)
+	}
+}
+
+// list returns the scenes of the bridge, restricted to one room or zone when
+// a filter is set, sorted by room and name.
+func (s *philipsScenes) list() ([]SceneInfo, error) {
+	scenes, groupNames, err := s.scenes()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SceneInfo, 0, len(scenes))
+	for _, sc := range scenes {
+		infos = append(infos, sc.info(groupNames[sc.Group.RID]))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].GroupName != infos[j].GroupName {
+			return infos[i].GroupName < infos[j].GroupName
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// recall activates the scene from a SceneRecall body. An empty body recalls
+// the scene as saved.
+func (s *philipsScenes) recall() (interface{}, error) {
+	var req SceneRecall
+	if s.ctx.Request.ContentLength != 0 {
+		if err := s.ctx.ShouldBindJSON(&req); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	}
+	if err := req.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	scene, groupName, err := s.find()
+	if err != nil {
+		return nil, err
+	}
+	if req.Mode == RecallDynamic && !scene.dynamic() {
+		return nil, fmt.Errorf("scene %s has no palette to play dynamically: %w", scene.Metadata.Name, ErrNotSupported)
+	}
+	if err := s.checkAccess(scene); err != nil {
+		return nil, err
+	}
+
+	recall := gin.H{"action": req.Mode}
+	if req.Transition != nil {
+		recall["duration"] = *req.Transition
+	}
+	if req.Brightness != nil {
+		recall["dimming"] = gin.H{"brightness": *req.Brightness}
+	}
+	if err := doRequest(s.philipsLight, http.MethodPut, "/clip/v2/resource/scene/"+scene.ID, gin.H{"recall": recall}, nil); err != nil {
+		return nil, err
+	}
+
+	result := gin.H{"scene": scene.ID, "name": scene.Metadata.Name, "group": groupName, "mode": req.Mode}
+	if req.Transition != nil {
+		result["transition"] = *req.Transition
+	}
+	return result, nil
+}
+
+// checkAccess evaluates the access rules of every light a scene sets, so a
+// recall cannot switch off a light its sender may not. Lights the scene
+// switches off are checked as "off", the others as "recall".
+func (s *philipsScenes) checkAccess(scene *clipScene) error {
+	bridgeID, _ := s.cfg.resolveBridge(s.bridge)
+	if s.ctx == nil || !s.cfg.ACL.Restricts(deviceID(bridgeID, "")) {
+		return nil
+	}
+	for _, a := range scene.Actions {
+		if a.Target.RType != "light" {
+			continue
+		}
+		action := "recall"
+		if a.Action.On != nil && !a.Action.On.On {
+			action = "off"
+		}
+		if err := s.cfg.ACL.Check(acl.FromRequest(s.ctx, deviceID(bridgeID, a.Target.RID), action)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create saves the current state of every light in a room or zone as a new
+// scene, from a SceneCreate body.
+func (s *philipsScenes) create() (*SceneInfo, error) {
+	var req SceneCreate
+	if err := s.ctx.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxSceneName {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidRequest, maxSceneName)
+	}
+	kind, ref := GroupRoom, req.Room
+	if req.Zone != "" {
+		kind, ref = GroupZone, req.Zone
+	}
+	if ref == "" || (req.Room != "" && req.Zone != "") {
+		return nil, fmt.Errorf("%w: expected either a room or a zone", ErrInvalidRequest)
+	}
+
+	target := *s.philipsLight
+	target.id = ref
+	grp, err := (&philipsGroup{philipsLight: &target, kind: kind}).get()
+	if err != nil {
+		return nil, err
+	}
+	if len(grp.Lights) == 0 {
+		return nil, fmt.Errorf("%w: %s %s has no lights", ErrInvalidRequest, kind, grp.Name)
+	}
+
+	var lights []clipLight
+	if err := runGetRequest(s.philipsLight, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, err
+	}
+	members := map[string]bool{}
+	for _, id := range grp.Lights {
+		members[id] = true
+	}
+	var actions []gin.H
+	for _, l := range lights {
+		if members[l.ID] {
+			actions = append(actions, gin.H{
+				"target": clipRef{RID: l.ID, RType: "light"},
+				"action": l.sceneAction(),
+			})
+		}
+	}
+
+	body := gin.H{
+		"type":     "scene",
+		"metadata": gin.H{"name": req.Name},
+		"group":    clipRef{RID: grp.ID, RType: kind},
+		"actions":  actions,
+	}
+	var created []clipRef
+	if err := doRequest(s.philipsLight, http.MethodPost, "/clip/v2/resource/scene", body, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return nil, fmt.Errorf("invalid response from bridge: no scene created")
+	}
+
+	return &SceneInfo{
+		ID:        created[0].RID,
+		Name:      req.Name,
+		Group:     grp.ID,
+		GroupType: kind,
+		GroupName: grp.Name,
+		Lights:    len(actions),
+	}, nil
+}
+
+// sceneAction captures the current state of the light as a scene action.
+func (l clipLight) sceneAction() gin.H {
+	action := gin.H{"on": gin.H{"on": l.On.On}}
+	if !l.On.On {
+		return action
+	}
+	if l.Dimming != nil {
+		action["dimming"] = gin.H{"brightness": l.Dimming.Brightness}
+	}
+	switch {
+	case l.ColorTemperature != nil && l.ColorTemperature.MirekValid && l.ColorTemperature.Mirek != nil:
+		action["color_temperature"] = gin.H{"mirek": *l.ColorTemperature.Mirek}
+	case l.Color != nil:
+		action["color"] = gin.H{"xy": l.Color.XY}
+	}
+	return action
+}
+
+// scenes returns the scenes of the bridge matching the room or zone filter,
+// and the names of the rooms and zones by ID.
+func (s *philipsScenes) scenes() ([]clipScene, map[string]string, error) {
+	var all []clipScene
+	if err := runGetRequest(s.philipsLight, "/clip/v2/resource/scene", &all); err != nil {
+		return nil, nil, err
+	}
+
+	groupNames := map[string]string{}
+	for _, kind := range []string{GroupRoom, GroupZone} {
+		var groups []clipGroup
+		if err := runGetRequest(s.philipsLight, "/clip/v2/resource/"+kind, &groups); err != nil {
+			return nil, nil, err
+		}
+		for _, g := range groups {
+			groupNames[g.ID] = g.Metadata.Name
+		}
+	}
+
+	if s.groupRef == "" {
+		return all, groupNames, nil
+	}
+	var scenes []clipScene
+	for _, sc := range all {
+		if sc.Group.RType == s.groupKind && matchesGroup(sc.Group.RID, groupNames[sc.Group.RID], s.groupRef) {
+			scenes = append(scenes, sc)
+		}
+	}
+	return scenes, groupNames, nil
+}
+
+// find returns the addressed scene and the name of its room or zone. Scenes
+// are matched by ID, then by name; a name used in several rooms must be
+// narrowed down with a room or zone filter.
+func (s *philipsScenes) find() (*clipScene, string, error) {
+	scenes, groupNames, err := s.scenes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var matches []*clipScene
+	for i := range scenes {
+		if scenes[i].ID == s.id {
+			return &scenes[i], groupNames[scenes[i].Group.RID], nil
+		}
+		if strings.EqualFold(scenes[i].Metadata.Name, s.id) {
+			matches = append(matches, &scenes[i])
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, "", &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{fmt.Sprintf("scene %q not found", s.id)}}
+	case 1:
+		return matches[0], groupNames[matches[0].Group.RID], nil
+	}
+	var groups []string
+	for _, m := range matches {
+		groups = append(groups, groupNames[m.Group.RID])
+	}
+	sort.Strings(groups)
+	return nil, "", fmt.Errorf("%w: scene %q exists in %s; add ?room= or ?zone=, or use the scene id",
+		ErrInvalidRequest, s.id, strings.Join(groups, ", "))
+}
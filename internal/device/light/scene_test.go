@@ -0,0 +1,192 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for Hue scenes.
+package light
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveScene routes a single request through SceneHandler with "test-key"
+// stored as the application key of bridge. suffix follows ".../scenes".
+func serveScene(t *testing.T, method, bridge, suffix, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return serveSceneWith(t, Config{}, method, bridge, suffix, body)
+}
+
+// serveSceneWith is serveScene with further light settings in cfg.
+func serveSceneWith(t *testing.T, cfg Config, method, bridge, suffix, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge, AppKey: "test-key"}))
+	cfg.Keys = keys
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := SceneHandler(router, logrus.New(), cfg)
+	router.GET("/api/v1/device/light/:brand/:bridge/scenes", handler)
+	router.POST("/api/v1/device/light/:brand/:bridge/scenes", handler)
+	router.GET("/api/v1/device/light/:brand/:bridge/scenes/:id", handler)
+	router.PUT("/api/v1/device/light/:brand/:bridge/scenes/:id/:action", handler)
+
+	target := "/api/v1/device/light/philips/" + bridge + "/scenes" + suffix
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// sentTo returns the requests the fake bridge received with method.
+func sentTo(requests []bridgeRequest, method string) []bridgeRequest {
+	var out []bridgeRequest
+	for _, r := range requests {
+		if r.method == method {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// TestListScenes verifies that scenes are listed with their room or zone
+// names and can be filtered by room.
+func TestListScenes(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveScene(t, http.MethodGet, bridge, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	scenes := response["result"].([]interface{})
+	assert.Len(t, scenes, 2)
+	first := scenes[0].(map[string]interface{})
+	assert.Equal(t, "Downstairs", first["groupName"])
+	assert.Equal(t, false, first["dynamic"])
+
+	_, response = serveScene(t, http.MethodGet, bridge, "?room=kitchen", "")
+	scenes = response["result"].([]interface{})
+	if assert.Len(t, scenes, 1) {
+		scene := scenes[0].(map[string]interface{})
+		assert.Equal(t, testSceneID, scene["id"])
+		assert.Equal(t, "Kitchen", scene["groupName"])
+		assert.Equal(t, true, scene["dynamic"])
+		assert.Equal(t, 1.0, scene["lights"])
+	}
+}
+
+// TestRecallScene verifies recalling by name and ID, the recall modes, and
+// that an ambiguous name is refused.
+func TestRecallScene(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		body   string
+		status int
+		target string
+		want   map[string]interface{}
+	}{
+		{"by name in room", "/relax/recall?room=Kitchen", `{"mode": "dynamic_palette", "transition": 800}`, http.StatusOK, testSceneID,
+			map[string]interface{}{"recall": map[string]interface{}{"action": "dynamic_palette", "duration": 800.0}}},
+		{"by id without body", "/" + testZoneSceneID + "/recall", "", http.StatusOK, testZoneSceneID,
+			map[string]interface{}{"recall": map[string]interface{}{"action": "active"}}},
+		{"brightness override", "/Relax/recall?zone=Downstairs", `{"mode": "static", "brightness": 40}`, http.StatusOK, testZoneSceneID,
+			map[string]interface{}{"recall": map[string]interface{}{"action": "static", "dimming": map[string]interface{}{"brightness": 40.0}}}},
+		{"ambiguous name", "/Relax/recall", "", http.StatusBadRequest, "", nil},
+		{"no palette", "/Relax/recall?zone=Downstairs", `{"mode": "dynamic_palette"}`, http.StatusUnprocessableEntity, "", nil},
+		{"bad mode", "/Relax/recall?room=Kitchen", `{"mode": "party"}`, http.StatusBadRequest, "", nil},
+		{"unknown scene", "/Party/recall", "", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge, requests := fakeHueBridge(t)
+			w, response := serveScene(t, http.MethodPut, bridge, tt.suffix, tt.body)
+			assert.Equal(t, tt.status, w.Code, response)
+
+			puts := sentTo(*requests, http.MethodPut)
+			if tt.target == "" {
+				assert.Empty(t, puts)
+				return
+			}
+			if assert.Len(t, puts, 1) {
+				assert.Equal(t, "/clip/v2/resource/scene/"+tt.target, puts[0].path)
+				assert.Equal(t, tt.want, puts[0].body)
+			}
+		})
+	}
+}
+
+// TestCreateSceneFromRoom verifies that a new scene captures the current
+// state of every light in the room.
+func TestCreateSceneFromRoom(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+
+	w, response := serveScene(t, http.MethodPost, bridge, "", `{"name": "Evening", "room": "Kitchen"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, response)
+	result := response["result"].(map[string]interface{})
+	assert.Equal(t, testNewSceneID, result["id"])
+	assert.Equal(t, "Kitchen", result["groupName"])
+
+	posts := sentTo(*requests, http.MethodPost)
+	if assert.Len(t, posts, 1) {
+		assert.Equal(t, "/clip/v2/resource/scene", posts[0].path)
+		assert.Equal(t, map[string]interface{}{
+			"type":     "scene",
+			"metadata": map[string]interface{}{"name": "Evening"},
+			"group":    map[string]interface{}{"rid": testRoomID, "rtype": "room"},
+			"actions": []interface{}{map[string]interface{}{
+				"target": map[string]interface{}{"rid": testLightID, "rtype": "light"},
+				"action": map[string]interface{}{
+					"on":      map[string]interface{}{"on": true},
+					"dimming": map[string]interface{}{"brightness": 62.5},
+					"color":   map[string]interface{}{"xy": map[string]interface{}{"x": 0.4573, "y": 0.41}},
+				},
+			}},
+		}, posts[0].body)
+	}
+
+	for _, body := range []string{`{"room": "Kitchen"}`, `{"name": "Evening"}`, `{"name": "Evening", "room": "Kitchen", "zone": "Downstairs"}`} {
+		w, _ := serveScene(t, http.MethodPost, bridge, "", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+// TestRecallSceneChecksLights verifies that recalling a scene is refused
+// when the access rules deny one of its lights, and that a scene switching
+// a protected light on needs no confirmation.
+func TestRecallSceneChecksLights(t *testing.T) {
+	bridge, requests := fakeHueBridge(t)
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: deviceID(bridge, testLightID), Protected: true, Allow: []string{"user:alice"}})
+	assert.NoError(t, err)
+
+	w, response := serveSceneWith(t, Config{ACL: rules}, http.MethodPut, bridge, "/relax/recall?room=Kitchen", "")
+	assert.Equal(t, http.StatusForbidden, w.Code, response)
+	assert.Empty(t, sentTo(*requests, http.MethodPut))
+
+	_, err = rules.Set(acl.Rule{Device: deviceID(bridge, testLightID), Protected: true})
+	assert.NoError(t, err)
+	w, response = serveSceneWith(t, Config{ACL: rules}, http.MethodPut, bridge, "/relax/recall?room=Kitchen", "")
+	assert.Equal(t, http.StatusOK, w.Code, response)
+
+	// A scene switching the protected light off needs a confirmation.
+	var off clipScene
+	assert.NoError(t, json.Unmarshal([]byte(`{"id": "off", "actions": [{"target": {"rid": "`+testLightID+`", "rtype": "light"},
+		"action": {"on": {"on": false}}}]}`), &off))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+	scenes := &philipsScenes{philipsLight: &philipsLight{bridge: bridge, ctx: c, cfg: Config{ACL: rules}}}
+	assert.ErrorIs(t, scenes.checkAccess(&off), acl.ErrConfirmationRequired)
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPut, "/?confirm=true", nil)
+	scenes.ctx = c
+	assert.NoError(t, scenes.checkAccess(&off))
+}
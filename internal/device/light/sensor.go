@@ -0,0 +1,210 @@
+// Package light provides functionality for controlling smart lights.
+// This file implements reading Philips Hue motion, temperature, light level
+// and button sensors.
+package light
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SensorInfo is a sensor device with its current readings. A Hue motion
+// sensor carries motion, temperature and light level readings; a dimmer
+// switch carries buttons.
+type SensorInfo struct {
+	ID           string              `json:"id"`                     // Bridge resource ID of the device
+	Name         string              `json:"name"`                   // Name set in the Hue app
+	Model        string              `json:"model,omitempty"`        // Product name, e.g. "Hue motion sensor"
+	Battery      *int                `json:"battery,omitempty"`      // Battery level in percent
+	BatteryState string              `json:"batteryState,omitempty"` // "normal", "low" or "critical"
+	Motion       *MotionReading      `json:"motion,omitempty"`
+	Temperature  *TemperatureReading `json:"temperature,omitempty"`
+	LightLevel   *LightLevelReading  `json:"lightLevel,omitempty"`
+	Buttons      []ButtonReading     `json:"buttons,omitempty"`
+}
+
+// MotionReading is the state of a motion sensor.
+type MotionReading struct {
+	ID      string     `json:"id"`                // Resource ID of the motion service
+	Enabled bool       `json:"enabled"`           // Whether the sensor is enabled
+	Valid   bool       `json:"valid"`             // Whether the reading can be trusted
+	Motion  bool       `json:"motion"`            // Whether motion is currently detected
+	Changed *time.Time `json:"changed,omitempty"` // When the reading last changed
+}
+
+// TemperatureReading is the state of a temperature sensor.
+type TemperatureReading struct {
+	ID      string     `json:"id"`                // Resource ID of the temperature service
+	Enabled bool       `json:"enabled"`           // Whether the sensor is enabled
+	Valid   bool       `json:"valid"`             // Whether the reading can be trusted
+	Celsius float64    `json:"celsius"`           // Temperature in degrees Celsius
+	Changed *time.Time `json:"changed,omitempty"` // When the reading last changed
+}
+
+// LightLevelReading is the state of an ambient light sensor.
+type LightLevelReading struct {
+	ID      string     `json:"id"`                // Resource ID of the light_level service
+	Enabled bool       `json:"enabled"`           // Whether the sensor is enabled
+	Valid   bool       `json:"valid"`             // Whether the reading can be trusted
+	Level   int        `json:"level"`             // Raw level, 10000*log10(lux)+1
+	Lux     float64    `json:"lux"`               // Illuminance derived from the level
+	Changed *time.Time `json:"changed,omitempty"` // When the reading last changed
+}
+
+// ButtonReading is the last event of a button.
+type ButtonReading struct {
+	ID        string     `json:"id"`                  // Resource ID of the button service
+	Button    int        `json:"button"`              // Position on the switch, starting at 1
+	LastEvent string     `json:"lastEvent,omitempty"` // e.g. "short_release" or "long_press"
+	Updated   *time.Time `json:"updated,omitempty"`   // When the button was last used
+}
+
+// luxFromLevel converts a Hue light level to lux.
+func luxFromLevel(level int) float64 {
+	if level <= 0 {
+		return 0
+	}
+	return math.Round(math.Pow(10, float64(level-1)/10000)*10) / 10
+}
+
+// philipsSensors represents the sensor devices of a Hue bridge. It shares
+// the bridge connection and request helpers of philipsLight; its id is the
+// device ID or name of the addressed sensor. It implements the sensors
+// interface.
+type philipsSensors struct {
+	*philipsLight
+}
+
+// execAction executes a command on the sensors and returns its result.
+func (s *philipsSensors) execAction(action string) (interface{}, error) {
+	s.actionName = action
+	switch action {
+	case "list":
+		return s.list()
+	case "get":
+		if s.id == "" {
+			return nil, fmt.Errorf("%w: action get requires a sensor id", ErrInvalidRequest)
+		}
+		return s.get()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAction, action)
+	}
+}
+
+// list returns every device with a motion, temperature, light level or
+// button service, sorted by name.
+func (s *philipsSensors) list() ([]SensorInfo, error) {
+	var (
+		devices      []clipDevice
+		motions      []clipMotion
+		temperatures []clipTemperature
+		lightLevels  []clipLightLevel
+		buttons      []clipButton
+		power        []clipDevicePower
+	)
+	for path, out := range map[string]interface{}{
+		"/clip/v2/resource/device":       &devices,
+		"/clip/v2/resource/motion":       &motions,
+		"/clip/v2/resource/temperature":  &temperatures,
+		"/clip/v2/resource/light_level":  &lightLevels,
+		"/clip/v2/resource/button":       &buttons,
+		"/clip/v2/resource/device_power": &power,
+	} {
+		if err := runGetRequest(s.philipsLight, path, out); err != nil {
+			return nil, err
+		}
+	}
+
+	byID := map[string]*SensorInfo{}
+	sensor := func(owner clipRef) *SensorInfo {
+		if info, ok := byID[owner.RID]; ok {
+			return info
+		}
+		info := &SensorInfo{ID: owner.RID}
+		byID[owner.RID] = info
+		return info
+	}
+
+	for _, m := range motions {
+		r := &MotionReading{ID: m.ID, Enabled: m.Enabled, Valid: m.Motion.MotionValid, Motion: m.Motion.Motion}
+		if m.Motion.MotionReport != nil {
+			r.Motion = m.Motion.MotionReport.Motion
+			r.Changed = &m.Motion.MotionReport.Changed
+		}
+		sensor(m.Owner).Motion = r
+	}
+	for _, t := range temperatures {
+		r := &TemperatureReading{ID: t.ID, Enabled: t.Enabled, Valid: t.Temperature.TemperatureValid, Celsius: t.Temperature.Temperature}
+		if t.Temperature.TemperatureReport != nil {
+			r.Celsius = t.Temperature.TemperatureReport.Temperature
+			r.Changed = &t.Temperature.TemperatureReport.Changed
+		}
+		sensor(t.Owner).Temperature = r
+	}
+	for _, l := range lightLevels {
+		r := &LightLevelReading{ID: l.ID, Enabled: l.Enabled, Valid: l.Light.LightLevelValid, Level: l.Light.LightLevel}
+		if l.Light.LightLevelReport != nil {
+			r.Level = l.Light.LightLevelReport.LightLevel
+			r.Changed = &l.Light.LightLevelReport.Changed
+		}
+		r.Lux = luxFromLevel(r.Level)
+		sensor(l.Owner).LightLevel = r
+	}
+	for _, b := range buttons {
+		r := ButtonReading{ID: b.ID, Button: b.Metadata.ControlID}
+		if b.Button != nil {
+			r.LastEvent = b.Button.LastEvent
+			if b.Button.ButtonReport != nil {
+				r.LastEvent = b.Button.ButtonReport.Event
+				r.Updated = &b.Button.ButtonReport.Updated
+			}
+		}
+		info := sensor(b.Owner)
+		info.Buttons = append(info.Buttons, r)
+	}
+
+	// Batteries and names only complete devices found above.
+	for _, p := range power {
+		if info, ok := byID[p.Owner.RID]; ok {
+			info.Battery = p.PowerState.BatteryLevel
+			info.BatteryState = p.PowerState.BatteryState
+		}
+	}
+	for _, d := range devices {
+		if info, ok := byID[d.ID]; ok {
+			info.Name = d.Metadata.Name
+			info.Model = d.ProductData.ProductName
+		}
+	}
+
+	infos := make([]SensorInfo, 0, len(byID))
+	for _, info := range byID {
+		sort.Slice(info.Buttons, func(i, j int) bool { return info.Buttons[i].Button < info.Buttons[j].Button })
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+// get returns the sensor device named by ID or, ignoring case, by name.
+func (s *philipsSensors) get() (*SensorInfo, error) {
+	infos, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		if infos[i].ID == s.id || strings.EqualFold(infos[i].Name, s.id) {
+			return &infos[i], nil
+		}
+	}
+	return nil, &BridgeError{StatusCode: http.StatusNotFound, Messages: []string{fmt.Sprintf("sensor %q not found", s.id)}}
+}
@@ -0,0 +1,144 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for Hue sensors.
+package light
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSensors routes a single request through SensorHandler with
+// "test-key" stored as the application key of bridge.
+func serveSensors(t *testing.T, bridge, suffix string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge, AppKey: "test-key"}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := SensorHandler(router, logrus.New(), Config{Keys: keys})
+	router.GET("/api/v1/device/light/:brand/:bridge/sensors", handler)
+	router.GET("/api/v1/device/light/:brand/:bridge/sensors/:id", handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/device/light/philips/"+bridge+"/sensors"+suffix, nil))
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w, response
+}
+
+// TestListSensors verifies that sensor services are grouped by device with
+// their readings, names and battery, and that lights are left out.
+func TestListSensors(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveSensors(t, bridge, "")
+	assert.Equal(t, http.StatusOK, w.Code, response)
+
+	data, _ := json.Marshal(response["result"])
+	var sensors []SensorInfo
+	assert.NoError(t, json.Unmarshal(data, &sensors))
+	if !assert.Len(t, sensors, 2) {
+		return
+	}
+
+	dimmer, motion := sensors[0], sensors[1]
+	assert.Equal(t, "Bedroom dimmer", dimmer.Name)
+	if assert.Len(t, dimmer.Buttons, 2) {
+		assert.Equal(t, 1, dimmer.Buttons[0].Button)
+		assert.Equal(t, "short_release", dimmer.Buttons[0].LastEvent)
+		assert.Equal(t, 2, dimmer.Buttons[1].Button)
+	}
+
+	assert.Equal(t, "Hallway sensor", motion.Name)
+	assert.Equal(t, "Hue motion sensor", motion.Model)
+	if assert.NotNil(t, motion.Battery) {
+		assert.Equal(t, 87, *motion.Battery)
+	}
+	if assert.NotNil(t, motion.Motion) {
+		assert.True(t, motion.Motion.Motion, "the report is more recent than the plain value")
+	}
+	if assert.NotNil(t, motion.Temperature) {
+		assert.Equal(t, 21.5, motion.Temperature.Celsius)
+	}
+	if assert.NotNil(t, motion.LightLevel) {
+		assert.Equal(t, 20001, motion.LightLevel.Level)
+		assert.Equal(t, 100.0, motion.LightLevel.Lux)
+	}
+}
+
+// TestGetSensorByName verifies that a sensor can be addressed by name.
+func TestGetSensorByName(t *testing.T) {
+	bridge, _ := fakeHueBridge(t)
+
+	w, response := serveSensors(t, bridge, "/hallway%20sensor")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testMotionDevice, response["result"].(map[string]interface{})["id"])
+
+	w, _ = serveSensors(t, bridge, "/Attic")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestStreamPublishesSensorEvents verifies that button and motion updates
+// from the event stream are published on the event bus with the name of
+// their device.
+func TestStreamPublishesSensorEvents(t *testing.T) {
+	bridge := newStreamingBridge(t)
+	bus := event.NewBus(logrus.New())
+	events, stop := bus.Subscribe(4)
+	defer stop()
+
+	streamMinBackoff = 10 * time.Millisecond
+	defer func() { streamMinBackoff = time.Second }()
+	keys, _ := OpenKeyStore("")
+	assert.NoError(t, keys.Set(BridgeKey{Bridge: bridge.host, AppKey: "test-key"}))
+	cfg := Config{Keys: keys, Bus: bus}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Streams = NewEventStreams(ctx, cfg, logrus.New())
+	st := cfg.Streams.watch(bridge.host)
+	assert.Eventually(t, func() bool { return cfg.Streams.live(bridge.host) != nil }, 2*time.Second, 5*time.Millisecond)
+
+	// Seed the cache with the dimmer and its button as the snapshot would.
+	st.apply("add", testDimmerDevice, map[string]interface{}{
+		"id": testDimmerDevice, "type": "device", "metadata": map[string]interface{}{"name": "Bedroom dimmer"},
+	})
+	st.apply("add", testButtonID, map[string]interface{}{
+		"id": testButtonID, "type": "button", "metadata": map[string]interface{}{"control_id": 1.0},
+		"owner": map[string]interface{}{"rid": testDimmerDevice, "rtype": "device"},
+	})
+
+	bridge.messages <- `[{"creationtime":"2024-05-01T18:30:00Z","id":"e1","type":"update","data":[{"id":"` + testButtonID +
+		`","type":"button","button":{"last_event":"long_press","button_report":{"updated":"2024-05-01T18:30:00Z","event":"long_press"}},` +
+		`"owner":{"rid":"` + testDimmerDevice + `","rtype":"device"}}]}]`
+	bridge.messages <- `[{"creationtime":"2024-05-01T18:31:00Z","id":"e2","type":"update","data":[{"id":"m1","type":"motion",` +
+		`"motion":{"motion":true,"motion_valid":true},"owner":{"rid":"` + testMotionDevice + `","rtype":"device"}}]}]`
+
+	for _, want := range []event.Event{
+		{Type: event.TypeButton, Action: "long_press", Device: testDimmerDevice, Name: "Bedroom dimmer"},
+		{Type: event.TypeMotion, Action: "detected", Device: testMotionDevice},
+	} {
+		select {
+		case e := <-events:
+			assert.Equal(t, want.Type, e.Type)
+			assert.Equal(t, want.Action, e.Action)
+			assert.Equal(t, want.Device, e.Device)
+			assert.Equal(t, want.Name, e.Name)
+			assert.Equal(t, "hue", e.Source)
+			if e.Type == event.TypeButton {
+				assert.Equal(t, 1.0, e.Data["button"])
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s event published", want.Type)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Package light provides functionality for controlling smart lights.
+// This file verifies Hue bridge certificates and provides one pooled HTTP
+// client per bridge.
+package light
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+	"github.com/sirupsen/logrus"
+)
+
+// CertificateMismatchError is returned when a bridge presents a certificate
+// other than the one pinned for it.
+type CertificateMismatchError struct {
+	Bridge    string // Bridge the pin belongs to
+	Pinned    string // SHA-256 fingerprint of the pinned certificate
+	Presented string // SHA-256 fingerprint of the presented certificate
+}
+
+// Error implements the error interface.
+func (e *CertificateMismatchError) Error() string {
+	return fmt.Sprintf("TLS certificate of bridge %s does not match the pinned certificate "+
+		"(pinned sha256:%s, presented sha256:%s); the bridge may have been replaced or the "+
+		"connection intercepted. If the change is expected, remove the pin with "+
+		"DELETE /api/v1/device/light/philips/%s/certificate", e.Bridge, e.Pinned, e.Presented, e.Bridge)
+}
+
+// Pin is the certificate trusted for a bridge on first use.
+type Pin struct {
+	Bridge    string    `json:"bridge"`    // Bridge ID, or address when the ID is unknown
+	SHA256    string    `json:"sha256"`    // Fingerprint of the leaf certificate
+	Subject   string    `json:"subject"`   // Subject of the certificate
+	CN        string    `json:"cn"`        // Common name of the certificate, the bridge ID on Hue bridges
+	FirstSeen time.Time `json:"firstSeen"` // When the certificate was pinned
+}
+
+// PinStore keeps the pinned certificates in a JSON file. It is safe for
+// concurrent use.
+type PinStore struct {
+	mu   sync.Mutex
+	path string
+	pins map[string]Pin
+}
+
+// OpenPinStore loads the pins stored at path, starting empty if the file does
+// not exist. An empty path keeps the pins in memory only.
+func OpenPinStore(path string) (*PinStore, error) {
+	s := &PinStore{path: path, pins: map[string]Pin{}}
+
+	var pins []Pin
+	if err := jsonfile.Load(path, &pins); err != nil {
+		return nil, err
+	}
+	for _, p := range pins {
+		s.pins[p.Bridge] = p
+	}
+	return s, nil
+}
+
+// Get returns the pin of a bridge.
+func (s *PinStore) Get(bridge string) (Pin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pins[bridge]
+	return p, ok
+}
+
+// check pins cert for bridge on first use and afterwards requires the same
+// certificate. It reports whether the certificate was newly pinned.
+func (s *PinStore) check(bridge string, cert *x509.Certificate) (bool, error) {
+	fingerprint := fingerprint(cert)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.pins[bridge]; ok {
+		if p.SHA256 != fingerprint {
+			return false, &CertificateMismatchError{Bridge: bridge, Pinned: p.SHA256, Presented: fingerprint}
+		}
+		return false, nil
+	}
+
+	s.pins[bridge] = Pin{
+		Bridge:    bridge,
+		SHA256:    fingerprint,
+		Subject:   cert.Subject.String(),
+		CN:        strings.ToLower(cert.Subject.CommonName),
+		FirstSeen: time.Now().UTC(),
+	}
+	return true, s.save()
+}
+
+// move transfers the pin of a bridge first contacted at host to its ID once
+// discovery has named it, so the certificate pinned then keeps protecting
+// it. The certificate presented must be the pinned one. It reports whether a
+// pin was moved; like check, it keeps the move in memory when saving fails.
+func (s *PinStore) move(bridge, host string, cert *x509.Certificate) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pins[bridge]; ok || bridge == host {
+		return false, nil
+	}
+	p, ok := s.pins[host]
+	if !ok {
+		return false, nil
+	}
+	if presented := fingerprint(cert); p.SHA256 != presented {
+		return false, &CertificateMismatchError{Bridge: host, Pinned: p.SHA256, Presented: presented}
+	}
+	delete(s.pins, host)
+	p.Bridge = bridge
+	s.pins[bridge] = p
+	return true, s.save()
+}
+
+// fingerprint returns the hex encoded SHA-256 of a certificate.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Remove forgets the pin of a bridge so its next certificate is trusted again.
+func (s *PinStore) Remove(bridge string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pins, bridge)
+	return s.save()
+}
+
+// save writes the pins to disk. s.mu must be held.
+func (s *PinStore) save() error {
+	pins := make([]Pin, 0, len(s.pins))
+	for _, p := range s.pins {
+		pins = append(pins, p)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Bridge < pins[j].Bridge })
+	return jsonfile.Save(s.path, pins)
+}
+
+// BridgeTLS verifies bridge certificates and hands out one connection-pooled
+// HTTP client per bridge.
+//
+// A certificate is accepted when it chains to the Signify root CA and names
+// the expected bridge. Bridges with self-signed certificates are trusted on
+// first use: the first certificate seen is pinned to the bridge ID, or to
+// the address of a bridge not discovered yet, and any other certificate is
+// rejected afterwards. A pin taken by address moves to the ID once the bridge
+// is discovered.
+type BridgeTLS struct {
+	roots  *x509.CertPool // Signify root CA, nil when not configured
+	pins   *PinStore
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// NewBridgeTLS returns a verifier using the Signify root CA read from the PEM
+// file at caFile. Without a CA file every bridge is pinned on first use.
+func NewBridgeTLS(caFile string, pins *PinStore, logger *logrus.Logger) (*BridgeTLS, error) {
+	t := &BridgeTLS{pins: pins, logger: logger, clients: map[string]*http.Client{}}
+	if caFile == "" {
+		return t, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	t.roots = x509.NewCertPool()
+	if !t.roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", caFile)
+	}
+	return t, nil
+}
+
+// defaultBridgeTLS is used when Config.TLS is not set. It pins in memory only.
+var defaultBridgeTLS = &BridgeTLS{
+	pins:    &PinStore{pins: map[string]Pin{}},
+	logger:  logrus.StandardLogger(),
+	clients: map[string]*http.Client{},
+}
+
+// client returns the shared client for the bridge with the given ID (empty
+// when unknown) reached at host.
+func (t *BridgeTLS) client(id, host string) *http.Client {
+	return t.pooledClient(id, host, bridgeTimeout)
+}
+
+// streamClient returns the shared client for long-lived requests to the
+// bridge, such as its event stream, which must not time out.
+func (t *BridgeTLS) streamClient(id, host string) *http.Client {
+	return t.pooledClient(id, host, 0)
+}
+
+// pooledClient returns the client for the bridge with the given request
+// timeout, creating it on first use.
+func (t *BridgeTLS) pooledClient(id, host string, timeout time.Duration) *http.Client {
+	key := fmt.Sprintf("%s@%s/%s", id, host, timeout)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[key]; ok {
+		return c
+	}
+	c := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// Bridges are addressed by IP and their certificates name the
+				// bridge ID, so the standard hostname check cannot apply;
+				// VerifyConnection does the verification instead.
+				InsecureSkipVerify: true,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					return t.verify(cs, id, host)
+				},
+			},
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	t.clients[key] = c
+	return c
+}
+
+// verify checks the certificate a bridge presented.
+func (t *BridgeTLS) verify(cs tls.ConnectionState, id, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("bridge presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	cn := strings.ToLower(leaf.Subject.CommonName)
+
+	if t.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{Roots: t.roots, Intermediates: intermediates})
+		if err == nil {
+			if id != "" && cn != id {
+				t.logger.Errorf("SECURITY: bridge at %s presented a Signify certificate for bridge %s, expected %s", host, cn, id)
+				return fmt.Errorf("certificate of bridge at %s is issued to bridge %s, expected %s", host, cn, id)
+			}
+			return nil
+		}
+		t.logger.Debugf("Certificate of bridge %s does not chain to the Signify root CA, pinning instead: %v", host, err)
+	}
+
+	// The pin is keyed by what the caller knows about the bridge, never by
+	// what the peer claims, so an impostor cannot choose a fresh pin.
+	pinID := id
+	if pinID == "" {
+		pinID = host
+	}
+	if p, ok := t.pins.Get(host); ok && p.CN != "" && p.CN != cn {
+		err := &CertificateMismatchError{Bridge: host, Pinned: p.SHA256, Presented: fingerprint(leaf)}
+		t.logger.Errorf("SECURITY: certificate for %s presented at %s, pinned for %s: %v", cn, host, p.CN, err)
+		return err
+	}
+
+	var mismatch *CertificateMismatchError
+	if id != "" {
+		moved, err := t.pins.move(id, host, leaf)
+		if errors.As(err, &mismatch) {
+			t.logger.Errorf("SECURITY: certificate of bridge %s at %s differs from the one pinned for the address: %v", id, host, err)
+			return err
+		}
+		if err != nil {
+			t.logger.Warnf("Could not store certificate pin of bridge %s: %v", id, err)
+		}
+		if moved {
+			t.logger.Infof("Moved certificate pin of bridge at %s to bridge ID %s", host, id)
+		}
+	}
+
+	pinned, err := t.pins.check(pinID, leaf)
+	if errors.As(err, &mismatch) {
+		t.logger.Errorf("SECURITY: %v", err)
+		return err
+	}
+	if err != nil {
+		t.logger.Warnf("Could not store certificate pin of bridge %s: %v", pinID, err)
+	}
+	if pinned {
+		t.logger.Warnf("Pinned first-seen certificate of bridge %s (sha256:%s)", pinID, t.pinnedFingerprint(pinID))
+	}
+	return nil
+}
+
+// pinnedFingerprint returns the pinned fingerprint of a bridge.
+func (t *BridgeTLS) pinnedFingerprint(bridge string) string {
+	p, _ := t.pins.Get(bridge)
+	return p.SHA256
+}
@@ -0,0 +1,290 @@
+// Package light provides functionality for controlling smart lights.
+// This test file contains unit tests for bridge certificate verification.
+package light
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert creates a certificate for cn signed by parent, or self-signed
+// when parent is nil.
+func testCert(t *testing.T, cn string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Philips Hue"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// tlsBridge starts an HTTPS server presenting cert and returns its address.
+func tlsBridge(t *testing.T, cert *tls.Certificate) string {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"Hue Bridge"}`))
+	}))
+	if cert != nil {
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "https://")
+}
+
+// switchingTLSBridge starts an HTTPS server presenting cert and returns its
+// address and a function replacing the certificate presented. Connections
+// are not kept alive and session tickets are disabled, so every request
+// shakes hands with the current certificate. The certificate is served
+// whatever name the client asks for, even without SNI.
+func switchingTLSBridge(t *testing.T, cert *tls.Certificate) (string, func(*tls.Certificate)) {
+	var mu sync.Mutex
+	current := cert
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte(`{"name":"Hue Bridge"}`))
+	}))
+	srv.Listener = tls.NewListener(srv.Listener, &tls.Config{SessionTicketsDisabled: true, GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return current, nil
+	}})
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), func(c *tls.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		current = c
+	}
+}
+
+// newTestBridgeTLS returns a verifier with in-memory pins.
+func newTestBridgeTLS(t *testing.T, caFile string) *BridgeTLS {
+	pins, _ := OpenPinStore("")
+	v, err := NewBridgeTLS(caFile, pins, logrus.New())
+	if err != nil {
+		t.Fatalf("new bridge TLS: %v", err)
+	}
+	return v
+}
+
+// getBridge requests the root of the bridge at host with client.
+func getBridge(client *http.Client, host string) error {
+	resp, err := client.Get("https://" + host + "/")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// TestPinFirstSeenCertificate verifies that a self-signed bridge certificate
+// is pinned to the bridge ID on first use and that a different certificate
+// for the same bridge is rejected afterwards.
+func TestPinFirstSeenCertificate(t *testing.T) {
+	v := newTestBridgeTLS(t, "")
+	original := testCert(t, "001788fffe23bfc2", false, nil)
+	host := tlsBridge(t, &original)
+
+	assert.NoError(t, getBridge(v.client("001788fffe23bfc2", host), host))
+
+	pin, ok := v.pins.Get("001788fffe23bfc2")
+	assert.True(t, ok)
+	sum := sha256.Sum256(original.Leaf.Raw)
+	assert.Equal(t, hex.EncodeToString(sum[:]), pin.SHA256)
+
+	// The same bridge ID answering with another certificate, e.g. an
+	// impostor that took over the bridge's address.
+	impostor := testCert(t, "001788fffe23bfc2", false, nil)
+	other := tlsBridge(t, &impostor)
+	err := getBridge(v.client("001788fffe23bfc2", other), other)
+	var mismatch *CertificateMismatchError
+	if !assert.True(t, errors.As(err, &mismatch), "got %v", err) {
+		return
+	}
+	assert.Equal(t, pin.SHA256, mismatch.Pinned)
+	assert.Equal(t, http.StatusBadGateway, statusForError(err))
+
+	// Once the pin is removed the new certificate is trusted.
+	assert.NoError(t, v.pins.Remove("001788fffe23bfc2"))
+	assert.NoError(t, getBridge(v.client("001788fffe23bfc2", other), other))
+}
+
+// TestPinUsesAddressWhenIDUnknown verifies that a bridge addressed by IP is
+// pinned under its address rather than the name its certificate claims, so
+// a second self-signed certificate at the same address is rejected whatever
+// its common name.
+func TestPinUsesAddressWhenIDUnknown(t *testing.T) {
+	v := newTestBridgeTLS(t, "")
+	original := testCert(t, "001788FFFE23BFC2", false, nil)
+	host, present := switchingTLSBridge(t, &original)
+
+	client := v.client("", host)
+	assert.NoError(t, getBridge(client, host))
+	pin, ok := v.pins.Get(host)
+	assert.True(t, ok)
+	assert.Equal(t, "001788fffe23bfc2", pin.CN)
+	_, ok = v.pins.Get("001788fffe23bfc2")
+	assert.False(t, ok)
+
+	for _, cn := range []string{"001788fffe000001", "001788fffe23bfc2", "not-a-bridge"} {
+		impostor := testCert(t, cn, false, nil)
+		present(&impostor)
+		err := getBridge(client, host)
+		var mismatch *CertificateMismatchError
+		assert.True(t, errors.As(err, &mismatch), "%s: got %v", cn, err)
+		_, ok = v.pins.Get(cn)
+		assert.False(t, ok, cn)
+	}
+}
+
+// TestPinByIDRejectsOtherNameAtPinnedAddress verifies that once a bridge is
+// known by ID, a certificate naming another bridge is refused at an address
+// pinned before the bridge was discovered.
+func TestPinByIDRejectsOtherNameAtPinnedAddress(t *testing.T) {
+	v := newTestBridgeTLS(t, "")
+	original := testCert(t, "001788fffe23bfc2", false, nil)
+	host := tlsBridge(t, &original)
+	assert.NoError(t, getBridge(v.client("", host), host))
+
+	impostor := testCert(t, "001788fffe000001", false, nil)
+	other := tlsBridge(t, &impostor)
+	_, err := v.pins.check(other, original.Leaf)
+	assert.NoError(t, err)
+
+	err = getBridge(v.client("001788fffe000001", other), other)
+	var mismatch *CertificateMismatchError
+	assert.True(t, errors.As(err, &mismatch), "got %v", err)
+	_, ok := v.pins.Get("001788fffe000001")
+	assert.False(t, ok)
+}
+
+// TestPinMovesFromAddressToID verifies that the pin of a bridge first
+// contacted by address carries over to its ID once discovered, so a second
+// certificate with the same common name is not pinned as if seen first.
+func TestPinMovesFromAddressToID(t *testing.T) {
+	v := newTestBridgeTLS(t, "")
+	original := testCert(t, "001788fffe23bfc2", false, nil)
+	host, present := switchingTLSBridge(t, &original)
+
+	assert.NoError(t, getBridge(v.client("", host), host))
+
+	impostor := testCert(t, "001788fffe23bfc2", false, nil)
+	present(&impostor)
+	err := getBridge(v.client("001788fffe23bfc2", host), host)
+	var mismatch *CertificateMismatchError
+	assert.True(t, errors.As(err, &mismatch), "got %v", err)
+	_, ok := v.pins.Get("001788fffe23bfc2")
+	assert.False(t, ok, "the impostor must not be pinned")
+
+	present(&original)
+	client := v.client("001788fffe23bfc2", host)
+	assert.NoError(t, getBridge(client, host))
+	pin, ok := v.pins.Get("001788fffe23bfc2")
+	assert.True(t, ok)
+	assert.Equal(t, fingerprint(original.Leaf), pin.SHA256)
+	_, ok = v.pins.Get(host)
+	assert.False(t, ok, "the pin moved to the bridge ID")
+
+	present(&impostor)
+	assert.True(t, errors.As(getBridge(client, host), &mismatch))
+}
+
+// TestVerifyAgainstRootCA verifies that certificates issued by the configured
+// root CA are accepted without pinning when they name the expected bridge,
+// and rejected when they name another.
+func TestVerifyAgainstRootCA(t *testing.T) {
+	ca := testCert(t, "root-bridge", true, nil)
+	caFile := filepath.Join(t.TempDir(), "hue-ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw}), 0o600))
+
+	v := newTestBridgeTLS(t, caFile)
+	cert := testCert(t, "001788fffe23bfc2", false, &ca)
+	host := tlsBridge(t, &cert)
+
+	assert.NoError(t, getBridge(v.client("001788fffe23bfc2", host), host))
+	_, pinned := v.pins.Get("001788fffe23bfc2")
+	assert.False(t, pinned)
+
+	err := getBridge(v.client("001788fffe000001", host), host)
+	assert.ErrorContains(t, err, "expected 001788fffe000001")
+}
+
+// TestBridgeClientIsShared verifies that requests to the same bridge reuse
+// one pooled client.
+func TestBridgeClientIsShared(t *testing.T) {
+	v := newTestBridgeTLS(t, "")
+	cfg := Config{TLS: v}
+	assert.Same(t, cfg.client("192.168.1.2"), cfg.client("192.168.1.2"))
+	assert.NotSame(t, cfg.client("192.168.1.2"), cfg.client("192.168.1.3"))
+}
+
+// TestPinStorePersists verifies that pins survive reopening the store.
+func TestPinStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hue-pins.json")
+	pins, err := OpenPinStore(path)
+	assert.NoError(t, err)
+
+	cert := testCert(t, "001788fffe23bfc2", false, nil)
+	pinned, err := pins.check("001788fffe23bfc2", cert.Leaf)
+	assert.NoError(t, err)
+	assert.True(t, pinned)
+
+	reopened, err := OpenPinStore(path)
+	assert.NoError(t, err)
+	_, err = reopened.check("001788fffe23bfc2", cert.Leaf)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+// TestNewBridgeTLSRejectsBadCA verifies that a CA file without certificates
+// is reported at startup.
+func TestNewBridgeTLSRejectsBadCA(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "hue-ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o600))
+	_, err := NewBridgeTLS(caFile, nil, logrus.New())
+	assert.Error(t, err)
+}
@@ -1,23 +1,323 @@
+// Package light provides functionality for controlling smart lights.
+// It defines the core interface, the errors shared by every brand and the
+// factory for creating light controllers.
 package light
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/event"
+	"github.com/colbynh/alfred/internal/registry"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUnsupportedAction is returned for actions a light does not implement.
+	ErrUnsupportedAction = errors.New("unsupported action")
+
+	// ErrNotSupported is returned when a light lacks the capability an action needs.
+	ErrNotSupported = errors.New("not supported by this light")
+
+	// ErrNotPaired is returned when no application key is stored for a bridge.
+	ErrNotPaired = errors.New("bridge is not paired")
+
+	// ErrInvalidRequest is wrapped by errors caused by a malformed request body.
+	ErrInvalidRequest = errors.New("invalid request")
 )
 
+// BridgeError is an error response from a light bridge.
+type BridgeError struct {
+	StatusCode int      // HTTP status returned by the bridge
+	Messages   []string // Error descriptions from the response body
+}
+
+// Error implements the error interface.
+func (e *BridgeError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("bridge returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("bridge returned status %d: %s", e.StatusCode, strings.Join(e.Messages, "; "))
+}
+
+// bridgeKind is the registry kind of Hue bridges.
+const bridgeKind = "bridge"
+
+// Config holds the settings shared by every light request.
+type Config struct {
+	// Keys holds the application keys created by pairing with the bridges.
+	Keys *KeyStore
+
+	// Registry records discovered bridges so they can be addressed by ID.
+	// It is optional; without it every bridge is addressed by IP.
+	Registry *registry.Registry
+
+	// TLS verifies the bridges' certificates and pools their connections.
+	// Without it certificates are pinned in memory only.
+	TLS *BridgeTLS
+
+	// Streams keeps live caches of the bridges' resources from their event
+	// streams. It is optional; without it every read goes to the bridge.
+	Streams *EventStreams
+
+	// Bus receives button and motion events from the event streams. It is
+	// optional.
+	Bus *event.Bus
+
+	// ACL restricts who may act on individual lights. It is optional;
+	// without it every authenticated client may.
+	ACL *acl.List
+}
+
+// resolveBridge returns the ID under which a bridge's key is stored and the
+// address to contact it at. Registered bridges can be named by ID, MAC or IP;
+// anything else is assumed to be an address and used for both.
+func (cfg Config) resolveBridge(ref string) (id, host string) {
+	if cfg.Registry != nil {
+		if d, ok := cfg.Registry.Resolve(ref); ok && d.Kind == bridgeKind && d.IP != "" {
+			return d.ID, d.IP
+		}
+	}
+	return ref, ref
+}
+
+// client returns the shared HTTP client of a bridge.
+func (cfg Config) client(ref string) *http.Client {
+	id, host := cfg.resolveBridge(ref)
+	return cfg.bridgeClient(id, host)
+}
+
+// bridgeClient returns the shared HTTP client of the bridge with the given ID
+// at host. An ID equal to the address means the bridge ID is not known yet.
+func (cfg Config) bridgeClient(id, host string) *http.Client {
+	if id == host {
+		id = ""
+	}
+	return cfg.bridgeTLS().client(strings.ToLower(id), host)
+}
+
+// streamClient returns the shared HTTP client for long-lived requests to a
+// bridge.
+func (cfg Config) streamClient(ref string) *http.Client {
+	id, host := cfg.resolveBridge(ref)
+	if id == host {
+		id = ""
+	}
+	return cfg.bridgeTLS().streamClient(strings.ToLower(id), host)
+}
+
+// bridgeTLS returns the configured certificate verifier or the default one.
+func (cfg Config) bridgeTLS() *BridgeTLS {
+	if cfg.TLS == nil {
+		return defaultBridgeTLS
+	}
+	return cfg.TLS
+}
+
+// appKey returns the application key stored for a bridge. Keys created
+// before the bridge was discovered are stored under its address.
+func (cfg Config) appKey(ref string) (string, error) {
+	id, host := cfg.resolveBridge(ref)
+	if cfg.Keys != nil {
+		for _, name := range []string{id, host} {
+			if k, ok := cfg.Keys.Get(name); ok {
+				return k.AppKey, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: pair %s first with POST /api/v1/device/light/philips/%s/pair", ErrNotPaired, ref, ref)
+}
+
+// light defines the interface for controlling smart lights.
 type light interface {
+	// getID returns the identifier of the addressed light, if any
+	getID() string
+
+	// getBrand returns the brand name of the light
+	getBrand() string
+
+	// execAction executes a command and returns its result.
+	// Supported actions are "list", "get", "on", "off", "brightness" and "color".
+	execAction(action string) (interface{}, error)
+
+	// list returns every light known to the bridge
+	list() ([]LightInfo, error)
+
+	// get returns the state of the addressed light
+	get() (*LightInfo, error)
+
+	// on switches the light on
+	on() error
+
+	// off switches the light off
+	off() error
+
+	// setBrightness applies the brightness given in the request body
+	setBrightness() (interface{}, error)
+
+	// color applies the color given in the request body
+	color() (interface{}, error)
+}
+
+// group defines the interface for controlling a group of lights, such as a
+// room or zone, with single commands.
+type group interface {
+	// getID returns the identifier of the addressed group, if any
+	getID() string
+
+	// getBrand returns the brand name of the lights
+	getBrand() string
+
+	// execAction executes a command and returns its result.
+	// Supported actions are "list", "get", "on", "off", "brightness" and "color".
+	execAction(action string) (interface{}, error)
+
+	// list returns every group of this kind known to the bridge
+	list() ([]GroupInfo, error)
+
+	// get returns the state of the addressed group
+	get() (*GroupInfo, error)
+
+	// on switches the lights of the group on
 	on() error
+
+	// off switches the lights of the group off
 	off() error
-	setBrightness() error
-	color() error
-	execAction(action string) error
-	getGinContext() *gin.Context
+
+	// setBrightness applies the brightness given in the request body
+	setBrightness() (interface{}, error)
+
+	// color applies the color given in the request body
+	color() (interface{}, error)
+}
+
+// scenes defines the interface for the scenes stored on a bridge.
+type scenes interface {
+	// getID returns the identifier of the addressed scene, if any
+	getID() string
+
+	// getBrand returns the brand name of the lights
+	getBrand() string
+
+	// execAction executes a command and returns its result.
+	// Supported actions are "list", "get", "recall" and "create".
+	execAction(action string) (interface{}, error)
+
+	// list returns the scenes, optionally only those of one room or zone
+	list() ([]SceneInfo, error)
+
+	// recall activates the addressed scene
+	recall() (interface{}, error)
+
+	// create saves the current state of a room or zone as a new scene
+	create() (*SceneInfo, error)
+}
+
+// sensors defines the interface for reading the sensors behind a bridge.
+type sensors interface {
+	// getID returns the identifier of the addressed sensor, if any
+	getID() string
+
+	// getBrand returns the brand name of the sensors
+	getBrand() string
+
+	// execAction executes a command and returns its result.
+	// Supported actions are "list" and "get".
+	execAction(action string) (interface{}, error)
+
+	// list returns every sensor with its readings
+	list() ([]SensorInfo, error)
+
+	// get returns the readings of the addressed sensor
+	get() (*SensorInfo, error)
 }
 
-func newLight(brand string, ip string, id string, ctx *gin.Context) (light, error) {
+// newLight creates a new light controller based on the specified brand.
+// Currently supported brands:
+//   - "philips": Philips Hue lights behind a Hue bridge
+//
+// Parameters:
+//   - brand: The light brand name (case-sensitive)
+//   - bridge: Registry ID or address of the bridge
+//   - id: Resource ID of the light, empty for bridge-wide actions
+//   - ctx: Gin context of the request
+//   - logger: Logger for operation tracking
+//   - cfg: Shared light settings such as the bridge keys
+func newLight(brand string, bridge string, id string, ctx *gin.Context, logger *logrus.Logger, cfg Config) (light, error) {
 	switch brand {
 	case "philips":
-		return &philipsLight{brand: brand, ip: ip, id: id, ctx: ctx}, nil
+		_, host := cfg.resolveBridge(bridge)
+		p := &philipsLight{brand: brand, bridge: bridge, ip: host, id: id, ctx: ctx, logger: logger, cfg: cfg}
+		if ctx != nil {
+			p.authorize = func(action string) error { return cfg.checkLight(ctx, bridge, id, action) }
+		}
+		return p, nil
+	default:
+		return nil, errors.New("unsupported light brand")
+	}
+}
+
+// newGroup creates a controller for a room or zone based on the specified
+// brand. Parameters are those of newLight plus the kind, GroupRoom or
+// GroupZone; id may be the ID or the name of the group.
+func newGroup(brand string, kind string, bridge string, id string, ctx *gin.Context, logger *logrus.Logger, cfg Config) (group, error) {
+	if kind != GroupRoom && kind != GroupZone {
+		return nil, fmt.Errorf("unsupported group kind %q", kind)
+	}
+	l, err := newLight(brand, bridge, id, ctx, logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch l := l.(type) {
+	case *philipsLight:
+		g := &philipsGroup{philipsLight: l, kind: kind}
+		if ctx != nil {
+			g.authorize = func(action string) error { return cfg.checkGroup(ctx, bridge, g, action) }
+		}
+		return g, nil
+	default:
+		return nil, errors.New("unsupported light brand")
+	}
+}
+
+// newScenes creates a controller for the scenes of a bridge based on the
+// specified brand. Parameters are those of newLight; id may be the ID or the
+// name of a scene. Scenes can be narrowed down to a room or zone with the
+// "room" or "zone" query parameter.
+func newScenes(brand string, bridge string, id string, ctx *gin.Context, logger *logrus.Logger, cfg Config) (scenes, error) {
+	l, err := newLight(brand, bridge, id, ctx, logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch l := l.(type) {
+	case *philipsLight:
+		s := &philipsScenes{philipsLight: l}
+		if room := ctx.Query("room"); room != "" {
+			s.groupKind, s.groupRef = GroupRoom, room
+		} else if zone := ctx.Query("zone"); zone != "" {
+			s.groupKind, s.groupRef = GroupZone, zone
+		}
+		return s, nil
+	default:
+		return nil, errors.New("unsupported light brand")
+	}
+}
+
+// newSensors creates a reader for the sensors behind a bridge based on the
+// specified brand. Parameters are those of newLight; id may be the device ID
+// or the name of a sensor.
+func newSensors(brand string, bridge string, id string, ctx *gin.Context, logger *logrus.Logger, cfg Config) (sensors, error) {
+	l, err := newLight(brand, bridge, id, ctx, logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch l := l.(type) {
+	case *philipsLight:
+		return &philipsSensors{philipsLight: l}, nil
 	default:
 		return nil, errors.New("unsupported light brand")
 	}
@@ -0,0 +1,122 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file implements per-socket control of Kasa power strips (HS300, KP303, KP400).
+package outlet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errNoChildren is returned when a child socket is requested on a single-relay device.
+var errNoChildren = fmt.Errorf("%w: device has no child sockets", ErrNotSupported)
+
+// fullChildID returns the complete ID of a child socket. Some firmware reports
+// only the two-digit socket index, which is relative to the parent device ID.
+func fullChildID(parent *SysInfo, id string) string {
+	switch len(id) {
+	case 1:
+		return parent.DeviceID + "0" + id
+	case 2:
+		return parent.DeviceID + id
+	}
+	return id
+}
+
+// findChild locates a child socket by full ID, zero-based index or alias.
+func findChild(parent *SysInfo, ref string) (*ChildInfo, error) {
+	if len(parent.Children) == 0 {
+		return nil, errNoChildren
+	}
+
+	for i := range parent.Children {
+		child := parent.Children[i]
+		child.ID = fullChildID(parent, child.ID)
+		if strings.EqualFold(child.ID, ref) || strings.EqualFold(child.Alias, ref) {
+			return &child, nil
+		}
+	}
+
+	if idx, err := strconv.Atoi(ref); err == nil && idx >= 0 && idx < len(parent.Children) {
+		child := parent.Children[idx]
+		child.ID = fullChildID(parent, child.ID)
+		return &child, nil
+	}
+	return nil, fmt.Errorf("child socket %q not found", ref)
+}
+
+// selectChild resolves the requested child socket and points command traffic
+// at it. It is a no-op when no child was requested.
+func (k *kasaOutlet) selectChild() error {
+	if k.childRef == "" || k.child != nil {
+		return nil
+	}
+
+	info, err := k.client.getSysInfo()
+	if err != nil {
+		return err
+	}
+	child, err := findChild(info, k.childRef)
+	if err != nil {
+		return err
+	}
+
+	k.child = child
+	k.target = k.client.forChild(child.ID)
+	return nil
+}
+
+// commandClient returns the client that addresses the selected socket, or the
+// whole device when no child is selected.
+func (k *kasaOutlet) commandClient() *kasaClient {
+	if k.target != nil {
+		return k.target
+	}
+	return k.client
+}
+
+// children lists the sockets of a power strip with their aliases and states.
+func (k *kasaOutlet) children() (map[string]interface{}, error) {
+	info, err := k.client.getSysInfo()
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Children) == 0 {
+		return nil, errNoChildren
+	}
+
+	children := make([]ChildInfo, len(info.Children))
+	for i, child := range info.Children {
+		child.ID = fullChildID(info, child.ID)
+		children[i] = child
+	}
+	return toJSONMap(map[string]interface{}{"children": children})
+}
+
+// childState returns the selected socket's entry from the parent's sysinfo,
+// which carries its current relay state.
+func (k *kasaOutlet) childState() (*ChildInfo, error) {
+	info, err := k.client.getSysInfo()
+	if err != nil {
+		return nil, err
+	}
+	return findChild(info, k.child.ID)
+}
+
+// rename sets the alias given by the "alias" query parameter on the outlet or
+// the selected socket.
+func (k *kasaOutlet) rename() (map[string]interface{}, error) {
+	alias := ""
+	if k.c != nil && k.c.Request != nil {
+		alias = strings.TrimSpace(k.c.Query("alias"))
+	}
+	if alias == "" {
+		return nil, errors.New("alias query parameter is required")
+	}
+
+	if err := k.commandClient().setAlias(alias); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"alias": alias}, nil
+}
@@ -0,0 +1,46 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for power strip child sockets.
+package outlet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestChildSocketControl verifies that a socket addressed by alias is switched
+// with a child context and that its state is read from the parent's sysinfo.
+func TestChildSocketControl(t *testing.T) {
+	var switched []string
+	client := fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		if _, ok := req["system"]["set_relay_state"]; ok {
+			var ids []string
+			json.Unmarshal(req["context"]["child_ids"], &ids)
+			switched = append(switched, ids...)
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{"err_code": 0}}}
+		}
+		return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"model": "HS300(US)", "deviceId": "8006AB", "err_code": 0,
+			"children": []map[string]interface{}{
+				{"id": "00", "alias": "Router", "state": 1},
+				{"id": "8006AB01", "alias": "Aquarium", "state": 0},
+			},
+		}}}
+	})
+
+	k := &kasaOutlet{id: "strip", logger: logrus.New(), client: client, childRef: "aquarium"}
+	assert.NoError(t, k.action("on", nil))
+	assert.Equal(t, []string{"8006AB01"}, switched)
+
+	k = &kasaOutlet{id: "strip", logger: logrus.New(), client: client, childRef: "0"}
+	assert.NoError(t, k.selectChild())
+	assert.Equal(t, "8006AB00", k.child.ID)
+	state, err := k.state()
+	assert.NoError(t, err)
+	assert.Equal(t, "True", state["state"])
+
+	k = &kasaOutlet{id: "strip", logger: logrus.New(), client: client, childRef: "7"}
+	assert.Error(t, k.action("on", nil))
+}
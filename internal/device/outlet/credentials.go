@@ -0,0 +1,66 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file implements storage for the TP-Link account credentials used by KLAP devices.
+package outlet
+
+import (
+	"sync"
+)
+
+// Credentials holds the TP-Link cloud account used to authenticate with KLAP devices.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// kasaDefaultCredentials are tried when the configured account is rejected.
+// Devices that were never bound to a cloud account accept an empty account,
+// and some firmware ships with the Kasa setup account instead.
+var kasaDefaultCredentials = []Credentials{
+	{},
+	{Username: "kasa@tp-link.net", Password: "kasaSetup"},
+}
+
+// CredentialStore keeps the credentials used to authenticate with devices.
+// Per-host entries take precedence over the default account.
+// It is safe for concurrent use.
+type CredentialStore struct {
+	mu       sync.RWMutex
+	fallback *Credentials
+	hosts    map[string]Credentials
+}
+
+// NewCredentialStore returns an empty credential store.
+func NewCredentialStore() *CredentialStore {
+	return &CredentialStore{hosts: map[string]Credentials{}}
+}
+
+// SetDefault sets the account used for hosts without a specific entry.
+func (s *CredentialStore) SetDefault(creds Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = &creds
+}
+
+// Set stores credentials for a single host.
+func (s *CredentialStore) Set(host string, creds Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[host] = creds
+}
+
+// candidates returns the credentials to try for host, most specific first,
+// followed by the well-known defaults.
+func (s *CredentialStore) candidates(host string) []Credentials {
+	var out []Credentials
+	if s != nil {
+		s.mu.RLock()
+		if creds, ok := s.hosts[host]; ok {
+			out = append(out, creds)
+		}
+		if s.fallback != nil {
+			out = append(out, *s.fallback)
+		}
+		s.mu.RUnlock()
+	}
+	return append(out, kasaDefaultCredentials...)
+}
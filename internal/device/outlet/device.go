@@ -0,0 +1,173 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file exposes the registered Kasa outlets through the unified device API.
+package outlet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// devicePrefix starts the unified device IDs of Kasa outlets, which have the
+// form "kasa:<registry ID>".
+const devicePrefix = "kasa"
+
+// DeviceProvider implements device.Provider for the Kasa outlets in the
+// registry. Power strips report their sockets as channels.
+type DeviceProvider struct {
+	cfg    Config
+	logger *logrus.Logger
+	client func(host string) *kasaClient // Connects to an outlet, replaced in tests
+}
+
+// NewDeviceProvider returns a provider for the outlets in cfg.Registry.
+func NewDeviceProvider(cfg Config, logger *logrus.Logger) *DeviceProvider {
+	d := &DeviceProvider{cfg: cfg, logger: logger}
+	d.client = func(host string) *kasaClient { return newKasaClient(host, cfg.Credentials) }
+	return d
+}
+
+// Prefix returns the ID prefix of Kasa outlets.
+func (d *DeviceProvider) Prefix() string {
+	return devicePrefix
+}
+
+// Devices queries every registered Kasa outlet concurrently. Outlets that do
+// not answer are listed as offline with only their registry details.
+func (d *DeviceProvider) Devices() ([]device.Info, error) {
+	if d.cfg.Registry == nil {
+		return nil, nil
+	}
+
+	var entries []registry.Device
+	for _, entry := range d.cfg.Registry.List() {
+		if entry.Kind == "outlet" && entry.Brand == devicePrefix {
+			entries = append(entries, entry)
+		}
+	}
+
+	devices := make([]device.Info, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry registry.Device) {
+			defer wg.Done()
+			devices[i] = d.describe(entry)
+		}(i, entry)
+	}
+	wg.Wait()
+	return devices, nil
+}
+
+// Device returns the outlet with the given unified ID. An outlet that does
+// not answer is returned as offline.
+func (d *DeviceProvider) Device(id string) (device.Info, error) {
+	entry, err := d.entry(id)
+	if err != nil {
+		return device.Info{}, err
+	}
+	return d.describe(entry), nil
+}
+
+// Execute runs a command on an outlet or, when cmd.Channel is set, on one
+// socket of a power strip.
+func (d *DeviceProvider) Execute(id string, cmd device.Command) (interface{}, error) {
+	entry, err := d.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	client := d.client(entry.IP)
+	target := client
+	if cmd.Channel != "" {
+		target = client.forChild(cmd.Channel)
+	}
+
+	switch cmd.Name {
+	case device.CommandOn, device.CommandOff:
+		on := cmd.Name == device.CommandOn
+		if err := target.setRelayState(on); err != nil {
+			return nil, err
+		}
+		return gin.H{"on": on}, nil
+	case device.CommandToggle:
+		info, err := client.getSysInfo()
+		if err != nil {
+			return nil, err
+		}
+		on := info.RelayState != 1
+		if cmd.Channel != "" {
+			child, err := findChild(info, cmd.Channel)
+			if err != nil {
+				return nil, err
+			}
+			on = child.State != 1
+		}
+		if err := target.setRelayState(on); err != nil {
+			return nil, err
+		}
+		return gin.H{"on": on}, nil
+	case device.CommandEnergy:
+		reading, err := target.getRealtime()
+		if err != nil {
+			return nil, err
+		}
+		return reading, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", device.ErrUnsupportedCommand, cmd.Name)
+	}
+}
+
+// entry returns the registry entry of a unified device ID.
+func (d *DeviceProvider) entry(id string) (registry.Device, error) {
+	ref, ok := strings.CutPrefix(id, devicePrefix+":")
+	if ok && d.cfg.Registry != nil {
+		if entry, found := d.cfg.Registry.Get(ref); found && entry.Kind == "outlet" && entry.IP != "" {
+			return entry, nil
+		}
+	}
+	return registry.Device{}, fmt.Errorf("%w: %s", device.ErrNotFound, id)
+}
+
+// describe builds the device view of a registered outlet from its sysinfo.
+func (d *DeviceProvider) describe(entry registry.Device) device.Info {
+	info := device.Info{
+		ID:           devicePrefix + ":" + entry.ID,
+		Name:         entry.Alias,
+		Kind:         entry.Kind,
+		Brand:        entry.Brand,
+		Model:        entry.Model,
+		Room:         entry.Room,
+		Capabilities: []device.Capability{device.Switchable},
+	}
+
+	sys, err := d.client(entry.IP).getSysInfo()
+	if err != nil {
+		d.logger.Debugf("Outlet %s at %s did not answer: %v", entry.ID, entry.IP, err)
+		return info
+	}
+	info.Online = true
+	info.Name = sys.Alias
+	info.Model = sys.Model
+	info.State = map[string]interface{}{"on": sys.RelayState == 1}
+	if sys.hasEmeter() {
+		info.Capabilities = append(info.Capabilities, device.EnergyMetering)
+	}
+	if len(sys.Children) > 0 {
+		info.Capabilities = append(info.Capabilities, device.MultiChannel)
+		for _, child := range sys.Children {
+			info.Channels = append(info.Channels, device.Channel{
+				ID:   fullChildID(sys, child.ID),
+				Name: child.Alias,
+				On:   child.State == 1,
+			})
+		}
+		delete(info.State, "on")
+	}
+	info.Capabilities = device.SortCapabilities(info.Capabilities)
+	return info
+}
@@ -0,0 +1,85 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for the unified device provider.
+package outlet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStripProvider returns a provider whose only registered outlet is a
+// metering power strip with two sockets, and the child IDs it switched.
+func testStripProvider(t *testing.T) (*DeviceProvider, *[]string) {
+	t.Helper()
+	var switched []string
+	client := fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		if _, ok := req["system"]["set_relay_state"]; ok {
+			var ids []string
+			json.Unmarshal(req["context"]["child_ids"], &ids)
+			switched = append(switched, ids...)
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{"err_code": 0}}}
+		}
+		return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"alias": "Desk strip", "model": "HS300(US)", "deviceId": "8006AB", "feature": "TIM:ENE", "err_code": 0,
+			"children": []map[string]interface{}{
+				{"id": "00", "alias": "Monitor", "state": 1},
+				{"id": "01", "alias": "Lamp", "state": 0},
+			},
+		}}}
+	})
+
+	reg, err := registry.Open("")
+	assert.NoError(t, err)
+	_, _, err = reg.Record(registry.Device{Kind: "outlet", Brand: "kasa", MAC: "50:C7:BF:01:02:03", IP: "127.0.0.1"})
+	assert.NoError(t, err)
+	_, err = reg.SetRoom("50c7bf010203", "Office")
+	assert.NoError(t, err)
+
+	p := NewDeviceProvider(Config{Registry: reg}, logrus.New())
+	p.client = func(string) *kasaClient { return client }
+	return p, &switched
+}
+
+// TestDeviceProviderCapabilities verifies that a power strip reports its
+// meter and sockets as capabilities and channels.
+func TestDeviceProviderCapabilities(t *testing.T) {
+	p, _ := testStripProvider(t)
+
+	devices, err := p.Devices()
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	strip := devices[0]
+	assert.Equal(t, "kasa:50c7bf010203", strip.ID)
+	assert.Equal(t, "Desk strip", strip.Name)
+	assert.Equal(t, "Office", strip.Room)
+	assert.True(t, strip.Online)
+	assert.Equal(t, []device.Capability{device.EnergyMetering, device.MultiChannel, device.Switchable}, strip.Capabilities)
+	assert.Equal(t, []device.Channel{
+		{ID: "8006AB00", Name: "Monitor", On: true},
+		{ID: "8006AB01", Name: "Lamp", On: false},
+	}, strip.Channels)
+
+	_, err = p.Device("kasa:unknown")
+	assert.ErrorIs(t, err, device.ErrNotFound)
+}
+
+// TestDeviceProviderToggleChannel verifies that commands for a channel are
+// routed through the manager to the matching socket.
+func TestDeviceProviderToggleChannel(t *testing.T) {
+	p, switched := testStripProvider(t)
+	mgr := device.NewManager(logrus.New(), p)
+
+	result, err := mgr.Execute("kasa:50c7bf010203", device.Command{Name: device.CommandToggle, Channel: "lamp"})
+	assert.NoError(t, err)
+	assert.Equal(t, true, result.(gin.H)["on"])
+	assert.Equal(t, []string{"8006AB01"}, *switched)
+
+	_, err = mgr.Execute("kasa:50c7bf010203", device.Command{Name: device.CommandBrightness})
+	assert.ErrorIs(t, err, device.ErrNotCapable)
+}
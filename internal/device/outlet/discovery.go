@@ -0,0 +1,197 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file implements UDP broadcast discovery of Kasa devices.
+package outlet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	broadcastWait     = 2 * time.Second        // How long to collect replies
+	broadcastRepeat   = 3                      // Datagrams sent per target, UDP is lossy
+	broadcastInterval = 300 * time.Millisecond // Spacing between repeated datagrams
+)
+
+// kasaDiscoveryQuery is the plaintext probe answered by legacy devices on UDP 9999.
+var kasaDiscoveryQuery = []byte(`{"system":{"get_sysinfo":{}}}`)
+
+// klapDiscoveryQuery is the fixed probe answered by current firmware on UDP 20002.
+var klapDiscoveryQuery, _ = hex.DecodeString("020000010000000000000000463cb5d3")
+
+// klapDiscoveryHeaderSize is the length of the binary header preceding the JSON reply on 20002.
+const klapDiscoveryHeaderSize = 16
+
+// DiscoveredDevice describes a device that answered a broadcast discovery probe.
+type DiscoveredDevice struct {
+	ID       string `json:"id,omitempty"` // Registry ID, when a registry is configured
+	IP       string `json:"ip"`
+	Alias    string `json:"alias,omitempty"`
+	Model    string `json:"model"`
+	MAC      string `json:"mac"`
+	DeviceID string `json:"deviceId,omitempty"`
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"` // "legacy", "klap" or the reported encryption scheme
+}
+
+// DiscoveryResult is the response of the broadcast discovery action.
+// IPs mirrors ScanResult so existing clients keep working.
+type DiscoveryResult struct {
+	IPs     []string           `json:"ips"`
+	Devices []DiscoveredDevice `json:"devices"`
+}
+
+// klapDiscoveryReply is the JSON body returned by current firmware on UDP 20002.
+type klapDiscoveryReply struct {
+	Result struct {
+		DeviceID   string `json:"device_id"`
+		DeviceType string `json:"device_type"`
+		Model      string `json:"device_model"`
+		IP         string `json:"ip"`
+		MAC        string `json:"mac"`
+		Encrypt    struct {
+			Type string `json:"encrypt_type"`
+		} `json:"mgt_encrypt_schm"`
+	} `json:"result"`
+	ErrorCode int `json:"error_code"`
+}
+
+// discoveryTarget is an address to probe together with the datagram to send.
+type discoveryTarget struct {
+	addr  *net.UDPAddr
+	query []byte
+}
+
+// broadcastTargets returns the probes for the given broadcast address.
+func broadcastTargets(ip net.IP) []discoveryTarget {
+	legacyPort, _ := strconv.Atoi(port1)
+	klapDiscoveryPort, _ := strconv.Atoi(port2)
+	return []discoveryTarget{
+		{addr: &net.UDPAddr{IP: ip, Port: legacyPort}, query: kasaEncrypt(kasaDiscoveryQuery)},
+		{addr: &net.UDPAddr{IP: ip, Port: klapDiscoveryPort}, query: klapDiscoveryQuery},
+	}
+}
+
+// broadcastDiscovery sends the discovery probes to every target and collects
+// the replies until wait has elapsed. Devices answering on both ports are
+// reported once, preferring the more detailed legacy reply.
+func broadcastDiscovery(targets []discoveryTarget, wait time.Duration) ([]DiscoveredDevice, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(wait)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < broadcastRepeat && time.Now().Before(deadline); i++ {
+			for _, target := range targets {
+				conn.WriteToUDP(target.query, target.addr)
+			}
+			time.Sleep(broadcastInterval)
+		}
+	}()
+	defer wg.Wait()
+
+	found := map[string]DiscoveredDevice{}
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+
+		device, ok := parseDiscoveryReply(buf[:n], from)
+		if !ok {
+			continue
+		}
+		if existing, seen := found[device.IP]; seen && existing.Protocol == "legacy" {
+			continue
+		}
+		found[device.IP] = device
+	}
+
+	devices := make([]DiscoveredDevice, 0, len(found))
+	for _, d := range found {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].IP < devices[j].IP })
+	return devices, nil
+}
+
+// parseDiscoveryReply decodes a datagram received on either discovery port.
+func parseDiscoveryReply(data []byte, from *net.UDPAddr) (DiscoveredDevice, bool) {
+	if len(data) > 0 && data[0] == klapDiscoveryQuery[0] && len(data) > klapDiscoveryHeaderSize {
+		var reply klapDiscoveryReply
+		if err := json.Unmarshal(data[klapDiscoveryHeaderSize:], &reply); err == nil && reply.ErrorCode == 0 {
+			ip := reply.Result.IP
+			if ip == "" {
+				ip = from.IP.String()
+			}
+			protocol := "klap"
+			if t := reply.Result.Encrypt.Type; t != "" {
+				protocol = strings.ToLower(t)
+			}
+			return DiscoveredDevice{
+				IP:       ip,
+				Model:    reply.Result.Model,
+				MAC:      normalizeMAC(reply.Result.MAC),
+				DeviceID: reply.Result.DeviceID,
+				Type:     reply.Result.DeviceType,
+				Protocol: protocol,
+			}, true
+		}
+	}
+
+	var reply struct {
+		System struct {
+			SysInfo SysInfo `json:"get_sysinfo"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(kasaDecrypt(data), &reply); err != nil {
+		return DiscoveredDevice{}, false
+	}
+	info := reply.System.SysInfo
+	if info.DeviceID == "" && info.Model == "" {
+		return DiscoveredDevice{}, false
+	}
+	return DiscoveredDevice{
+		IP:       from.IP.String(),
+		Alias:    info.Alias,
+		Model:    info.Model,
+		MAC:      normalizeMAC(info.macAddress()),
+		DeviceID: info.DeviceID,
+		Type:     info.deviceType(),
+		Protocol: "legacy",
+	}, true
+}
+
+// normalizeMAC formats a MAC address as upper-case, colon separated octets.
+// Devices report "50:c7:bf:01:02:03", "50-C7-BF-01-02-03" or "50C7BF010203".
+func normalizeMAC(mac string) string {
+	clean := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if len(clean) != 12 {
+		return strings.ToUpper(mac)
+	}
+	parts := make([]string, 0, 6)
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, clean[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
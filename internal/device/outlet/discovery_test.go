@@ -0,0 +1,69 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for UDP broadcast discovery.
+package outlet
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBroadcastDiscovery verifies that legacy and KLAP replies are decoded
+// into device descriptions.
+func TestBroadcastDiscovery(t *testing.T) {
+	legacy, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer legacy.Close()
+
+	klap, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer klap.Close()
+
+	go func() {
+		buf := make([]byte, 1024)
+		n, from, err := legacy.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		assert.JSONEq(t, string(kasaDiscoveryQuery), string(kasaDecrypt(buf[:n])))
+		reply, _ := json.Marshal(map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"alias": "Kitchen", "model": "HS103(US)", "mac": "50:c7:bf:01:02:03",
+			"deviceId": "8006ABC", "mic_type": "IOT.SMARTPLUGSWITCH",
+		}}})
+		legacy.WriteToUDP(kasaEncrypt(reply), from)
+	}()
+
+	go func() {
+		buf := make([]byte, 1024)
+		_, from, err := klap.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		reply, _ := json.Marshal(map[string]interface{}{"result": map[string]interface{}{
+			"device_id": "8006DEF", "device_type": "IOT.SMARTPLUGSWITCH", "device_model": "KP115(US)",
+			"ip": "192.0.2.10", "mac": "AA-BB-CC-DD-EE-FF",
+			"mgt_encrypt_schm": map[string]interface{}{"encrypt_type": "KLAP"},
+		}, "error_code": 0})
+		klap.WriteToUDP(append(append([]byte{}, klapDiscoveryQuery...), reply...), from)
+	}()
+
+	legacyTarget := discoveryTarget{addr: legacy.LocalAddr().(*net.UDPAddr), query: kasaEncrypt(kasaDiscoveryQuery)}
+	klapTarget := discoveryTarget{addr: klap.LocalAddr().(*net.UDPAddr), query: klapDiscoveryQuery}
+
+	devices, err := broadcastDiscovery([]discoveryTarget{legacyTarget, klapTarget}, 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 2)
+
+	assert.Equal(t, "127.0.0.1", devices[0].IP)
+	assert.Equal(t, "Kitchen", devices[0].Alias)
+	assert.Equal(t, "50:C7:BF:01:02:03", devices[0].MAC)
+	assert.Equal(t, "legacy", devices[0].Protocol)
+
+	assert.Equal(t, "192.0.2.10", devices[1].IP)
+	assert.Equal(t, "KP115(US)", devices[1].Model)
+	assert.Equal(t, "AA:BB:CC:DD:EE:FF", devices[1].MAC)
+	assert.Equal(t, "klap", devices[1].Protocol)
+}
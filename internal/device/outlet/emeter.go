@@ -0,0 +1,217 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file implements energy monitoring (emeter) for metering Kasa plugs
+// such as the HS110, KP115 and HS300.
+package outlet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotSupported is returned when a device lacks the capability an action needs.
+var ErrNotSupported = errors.New("capability not supported by device")
+
+// EmeterRealtime is an instantaneous energy meter reading.
+type EmeterRealtime struct {
+	Voltage float64 `json:"voltage"` // Volts
+	Current float64 `json:"current"` // Amperes
+	Power   float64 `json:"power"`   // Watts
+	Total   float64 `json:"total"`   // Kilowatt-hours since the last reset
+}
+
+// UnmarshalJSON accepts both firmware formats: hardware v1 reports base units
+// (voltage, current, power, total) while v2 reports milli-units (voltage_mv,
+// current_ma, power_mw, total_wh).
+func (r *EmeterRealtime) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Voltage   *float64 `json:"voltage"`
+		Current   *float64 `json:"current"`
+		Power     *float64 `json:"power"`
+		Total     *float64 `json:"total"`
+		VoltageMV *float64 `json:"voltage_mv"`
+		CurrentMA *float64 `json:"current_ma"`
+		PowerMW   *float64 `json:"power_mw"`
+		TotalWH   *float64 `json:"total_wh"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.Voltage = pickUnit(raw.Voltage, raw.VoltageMV)
+	r.Current = pickUnit(raw.Current, raw.CurrentMA)
+	r.Power = pickUnit(raw.Power, raw.PowerMW)
+	r.Total = pickUnit(raw.Total, raw.TotalWH)
+	return nil
+}
+
+// EmeterStat is the energy consumed during one day or month.
+type EmeterStat struct {
+	Year   int     `json:"year"`
+	Month  int     `json:"month"`
+	Day    int     `json:"day,omitempty"`
+	Energy float64 `json:"energy"` // Kilowatt-hours
+}
+
+// UnmarshalJSON accepts both "energy" (kWh) and "energy_wh" (Wh) readings.
+func (s *EmeterStat) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Year     int      `json:"year"`
+		Month    int      `json:"month"`
+		Day      int      `json:"day"`
+		Energy   *float64 `json:"energy"`
+		EnergyWH *float64 `json:"energy_wh"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	s.Year, s.Month, s.Day = raw.Year, raw.Month, raw.Day
+	s.Energy = pickUnit(raw.Energy, raw.EnergyWH)
+	return nil
+}
+
+// pickUnit returns base when present, otherwise milli converted to base units.
+func pickUnit(base, milli *float64) float64 {
+	if base != nil {
+		return *base
+	}
+	if milli != nil {
+		return *milli / 1000
+	}
+	return 0
+}
+
+// hasEmeter reports whether the device advertises energy monitoring.
+func (s *SysInfo) hasEmeter() bool {
+	return strings.Contains(s.Feature, "ENE")
+}
+
+// getRealtime returns the current energy meter reading.
+func (c *kasaClient) getRealtime() (*EmeterRealtime, error) {
+	var reading EmeterRealtime
+	if err := c.request("emeter", "get_realtime", nil, &reading); err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// getDayStats returns the daily consumption for a month.
+func (c *kasaClient) getDayStats(year, month int) ([]EmeterStat, error) {
+	var reply struct {
+		Days []EmeterStat `json:"day_list"`
+	}
+	params := map[string]int{"year": year, "month": month}
+	if err := c.request("emeter", "get_daystat", params, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Days, nil
+}
+
+// getMonthStats returns the monthly consumption for a year.
+func (c *kasaClient) getMonthStats(year int) ([]EmeterStat, error) {
+	var reply struct {
+		Months []EmeterStat `json:"month_list"`
+	}
+	if err := c.request("emeter", "get_monthstat", map[string]int{"year": year}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Months, nil
+}
+
+// eraseStats clears the stored daily and monthly statistics.
+func (c *kasaClient) eraseStats() error {
+	return c.request("emeter", "erase_emeter_stat", nil, nil)
+}
+
+// requireEmeter returns ErrNotSupported unless the outlet has an energy meter.
+func (k *kasaOutlet) requireEmeter() error {
+	info, err := k.client.getSysInfo()
+	if err != nil {
+		return err
+	}
+	if !info.hasEmeter() {
+		return fmt.Errorf("%w: %s has no energy meter", ErrNotSupported, info.Model)
+	}
+	return nil
+}
+
+// emeterRealtime returns the current voltage, current, power and total energy.
+func (k *kasaOutlet) emeterRealtime() (map[string]interface{}, error) {
+	if err := k.requireEmeter(); err != nil {
+		return nil, err
+	}
+	reading, err := k.commandClient().getRealtime()
+	if err != nil {
+		return nil, err
+	}
+	return toJSONMap(reading)
+}
+
+// emeterDaily returns the daily consumption for the month given by the
+// "year" and "month" query parameters, defaulting to the current month.
+func (k *kasaOutlet) emeterDaily() (map[string]interface{}, error) {
+	now := time.Now()
+	year, err := k.queryInt("year", now.Year())
+	if err != nil {
+		return nil, err
+	}
+	month, err := k.queryInt("month", int(now.Month()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.requireEmeter(); err != nil {
+		return nil, err
+	}
+	days, err := k.commandClient().getDayStats(year, month)
+	if err != nil {
+		return nil, err
+	}
+	return toJSONMap(map[string]interface{}{"year": year, "month": month, "days": days})
+}
+
+// emeterMonthly returns the monthly consumption for the year given by the
+// "year" query parameter, defaulting to the current year.
+func (k *kasaOutlet) emeterMonthly() (map[string]interface{}, error) {
+	year, err := k.queryInt("year", time.Now().Year())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.requireEmeter(); err != nil {
+		return nil, err
+	}
+	months, err := k.commandClient().getMonthStats(year)
+	if err != nil {
+		return nil, err
+	}
+	return toJSONMap(map[string]interface{}{"year": year, "months": months})
+}
+
+// emeterReset erases the stored daily and monthly statistics.
+func (k *kasaOutlet) emeterReset() error {
+	if err := k.requireEmeter(); err != nil {
+		return err
+	}
+	return k.commandClient().eraseStats()
+}
+
+// queryInt reads an integer query parameter, returning def when it is absent.
+func (k *kasaOutlet) queryInt(name string, def int) (int, error) {
+	if k.c == nil || k.c.Request == nil {
+		return def, nil
+	}
+	v := k.c.Query(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return n, nil
+}
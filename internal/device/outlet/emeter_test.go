@@ -0,0 +1,46 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for energy monitoring.
+package outlet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEmeterPlug answers sysinfo with the given feature string and realtime
+// readings in the milli-unit format used by hardware v2.
+func fakeEmeterPlug(t *testing.T, feature string) *kasaClient {
+	return fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		if _, ok := req["emeter"]; ok {
+			return map[string]interface{}{"emeter": map[string]interface{}{"get_realtime": map[string]interface{}{
+				"voltage_mv": 121500, "current_ma": 250, "power_mw": 30125, "total_wh": 1500, "err_code": 0,
+			}}}
+		}
+		return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"model": "KP115(US)", "feature": feature, "err_code": 0,
+		}}}
+	})
+}
+
+// TestEmeterRealtime verifies that milli-unit readings are converted to base units.
+func TestEmeterRealtime(t *testing.T) {
+	k := &kasaOutlet{id: "test-id", logger: logrus.New(), client: fakeEmeterPlug(t, "TIM:ENE")}
+
+	jsonData, err := k.emeterRealtime()
+	assert.NoError(t, err)
+	assert.InDelta(t, 121.5, jsonData["voltage"], 1e-9)
+	assert.InDelta(t, 0.25, jsonData["current"], 1e-9)
+	assert.InDelta(t, 30.125, jsonData["power"], 1e-9)
+	assert.InDelta(t, 1.5, jsonData["total"], 1e-9)
+}
+
+// TestEmeterNotSupported verifies that plugs without a meter report a capability error.
+func TestEmeterNotSupported(t *testing.T) {
+	k := &kasaOutlet{id: "test-id", logger: logrus.New(), client: fakeEmeterPlug(t, "TIM")}
+
+	_, err := k.emeterRealtime()
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
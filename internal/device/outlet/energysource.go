@@ -0,0 +1,137 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file exposes metering Kasa outlets to the energy history collector.
+package outlet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/energy"
+)
+
+// meterCapsTTL is how long the energy meter capability of a device is cached.
+const meterCapsTTL = time.Hour
+
+// meterCaps caches what a registered outlet reported about its energy meter.
+type meterCaps struct {
+	checked  time.Time
+	metered  bool
+	deviceID string
+	alias    string
+	children []ChildInfo
+}
+
+// EnergySource implements energy.Source for the Kasa outlets in the registry.
+// Single plugs are exposed under their registry ID; each socket of a metering
+// power strip is exposed as "<registry ID>-<socket index>".
+type EnergySource struct {
+	cfg  Config
+	mu   sync.Mutex
+	caps map[string]meterCaps
+}
+
+// NewEnergySource returns an energy source backed by cfg.Registry.
+func NewEnergySource(cfg Config) *EnergySource {
+	return &EnergySource{cfg: cfg, caps: map[string]meterCaps{}}
+}
+
+// Meters lists the registered outlets that have an energy meter.
+func (s *EnergySource) Meters() ([]energy.Meter, error) {
+	if s.cfg.Registry == nil {
+		return nil, nil
+	}
+
+	var meters []energy.Meter
+	for _, d := range s.cfg.Registry.List() {
+		if d.Kind != "outlet" || d.Brand != "kasa" || d.IP == "" {
+			continue
+		}
+		caps, err := s.capabilities(d.ID, d.IP)
+		if err != nil || !caps.metered {
+			continue
+		}
+
+		if len(caps.children) == 0 {
+			meters = append(meters, energy.Meter{ID: d.ID, Name: caps.alias, Room: d.Room})
+			continue
+		}
+		for _, child := range caps.children {
+			// Read addresses a socket by the last two digits of its ID, so
+			// a malformed child ID cannot be a meter.
+			if len(child.ID) < 2 {
+				continue
+			}
+			index := child.ID[len(child.ID)-2:]
+			meters = append(meters, energy.Meter{ID: d.ID + "-" + index, Name: child.Alias, Room: d.Room})
+		}
+	}
+	return meters, nil
+}
+
+// Read takes a realtime reading from a meter returned by Meters.
+func (s *EnergySource) Read(id string) (energy.Sample, error) {
+	if s.cfg.Registry == nil {
+		return energy.Sample{}, fmt.Errorf("meter %s is not registered", id)
+	}
+
+	deviceID, socket, _ := strings.Cut(id, "-")
+	d, ok := s.cfg.Registry.Get(deviceID)
+	if !ok || d.IP == "" {
+		return energy.Sample{}, fmt.Errorf("meter %s is not registered", id)
+	}
+
+	client := newKasaClient(d.IP, s.cfg.Credentials)
+	if socket != "" {
+		caps, err := s.capabilities(d.ID, d.IP)
+		if err != nil {
+			return energy.Sample{}, err
+		}
+		client = client.forChild(caps.deviceID + socket)
+	}
+
+	reading, err := client.getRealtime()
+	if err != nil {
+		return energy.Sample{}, err
+	}
+	return energy.Sample{
+		Time:    time.Now(),
+		Voltage: reading.Voltage,
+		Current: reading.Current,
+		Power:   reading.Power,
+		Total:   reading.Total,
+	}, nil
+}
+
+// capabilities returns the cached meter capabilities of a device, refreshing
+// them from its sysinfo when they are stale.
+func (s *EnergySource) capabilities(id, ip string) (meterCaps, error) {
+	s.mu.Lock()
+	caps, ok := s.caps[id]
+	s.mu.Unlock()
+	if ok && time.Since(caps.checked) < meterCapsTTL {
+		return caps, nil
+	}
+
+	info, err := newKasaClient(ip, s.cfg.Credentials).getSysInfo()
+	if err != nil {
+		return meterCaps{}, err
+	}
+
+	caps = meterCaps{
+		checked:  time.Now(),
+		metered:  info.hasEmeter(),
+		deviceID: info.DeviceID,
+		alias:    info.Alias,
+	}
+	for _, child := range info.Children {
+		child.ID = fullChildID(info, child.ID)
+		caps.children = append(caps.children, child)
+	}
+
+	s.mu.Lock()
+	s.caps[id] = caps
+	s.mu.Unlock()
+	return caps, nil
+}
@@ -0,0 +1,33 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for the outlet energy source.
+package outlet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colbynh/alfred/internal/energy"
+	"github.com/colbynh/alfred/internal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetersSkipsMalformedChildren verifies that a power strip reporting a
+// socket without a usable ID lists its other sockets instead of panicking.
+func TestMetersSkipsMalformedChildren(t *testing.T) {
+	reg, err := registry.Open("")
+	assert.NoError(t, err)
+	d, _, err := reg.Record(registry.Device{Kind: "outlet", Brand: "kasa", MAC: "50:C7:BF:01:02:03", IP: "192.0.2.10"})
+	assert.NoError(t, err)
+
+	s := NewEnergySource(Config{Registry: reg})
+	s.caps[d.ID] = meterCaps{
+		checked:  time.Now(),
+		metered:  true,
+		deviceID: "8006AB",
+		children: []ChildInfo{{ID: "", Alias: "Broken"}, {ID: "8006AB01", Alias: "Aquarium"}},
+	}
+
+	meters, err := s.Meters()
+	assert.NoError(t, err)
+	assert.Equal(t, []energy.Meter{{ID: d.ID + "-01", Name: "Aquarium"}}, meters)
+}
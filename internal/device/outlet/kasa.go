@@ -3,31 +3,36 @@
 package outlet
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"os/exec"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/registry"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-// execCommand is a package variable that wraps exec.Command for testing purposes.
-var execCommand = exec.Command
-
 // kasaOutlet represents a TP-Link Kasa smart outlet device.
 // It implements the outlet interface for controlling the device state
 // and retrieving device information.
 type kasaOutlet struct {
-	id     string         // Unique identifier (typically IP address)
+	id     string         // Identifier from the request (registry ID, MAC or IP)
+	host   string         // Address used to reach the device
 	c      *gin.Context   // HTTP context for request handling
 	logger *logrus.Logger // Logger for operation tracking
+	client *kasaClient    // Protocol client used to talk to the device
+	cfg    Config         // Shared outlet settings
+
+	childRef string      // Requested child socket (index, ID or alias), if any
+	child    *ChildInfo  // Resolved child socket
+	target   *kasaClient // Client addressing the resolved child socket
 }
 
 // ScanResult represents the response format for device discovery.
@@ -38,13 +43,10 @@ type ScanResult struct {
 
 // Network scanning constants
 const (
-	timeout     = 1000 * time.Millisecond // Timeout for checking each port
-	port1       = "9999"                  // Legacy Kasa device port
-	port2       = "20002"                 // Newer Kasa device port
-	subnet      = "192.168.101."
-	IpBatchSize = 100 // Network subnet to scan
-	startIP     = 1   // Focus on known device IPs
-	endIP       = 254 // Focus on known device IPs
+	timeout          = 1000 * time.Millisecond // Timeout for checking each port
+	discoveryTimeout = 2 * time.Second         // Timeout for each get_sysinfo probe
+	port1            = "9999"                  // Legacy Kasa device port
+	port2            = "20002"                 // Newer Kasa device port
 )
 
 // joinHostPort combines an IP address and port into a network address string.
@@ -53,15 +55,11 @@ func joinHostPort(ip, port string) string {
 }
 
 // dialTimeout attempts to establish a network connection with timeout.
-func dialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout(network, addr, timeout)
-}
-
-// scanIP checks if a specific IP address has the Kasa device port open.
-// It is used as a goroutine in the port scanning process.
-func scanIP(ip string, wg *sync.WaitGroup, results chan<- string) {
-	defer wg.Done()
+// It is a package variable so tests can replace the network.
+var dialTimeout = net.DialTimeout
 
+// scanIP reports whether a specific IP address has a Kasa device port open.
+func scanIP(ip string) bool {
 	ports := []string{port1, port2}
 
 	for _, port := range ports {
@@ -69,62 +67,40 @@ func scanIP(ip string, wg *sync.WaitGroup, results chan<- string) {
 		conn, err := dialTimeout("tcp", address, timeout)
 		if err == nil && conn != nil {
 			conn.Close()
-			select {
-			case results <- ip:
-			default:
-			}
-			return
+			return true
 		}
 	}
+	return false
 }
 
-func ScanOpenPorts() ([]string, error) {
+// ScanOpenPorts probes every host of the given CIDR ranges for an open Kasa
+// port and returns the addresses that answered. When cidrs is empty the
+// networks of the local interfaces are scanned. At most concurrency hosts are
+// probed at once; zero selects a default.
+func ScanOpenPorts(cidrs []string, concurrency int, logger *logrus.Logger) ([]string, error) {
 	startTime := time.Now()
 
-	var wg sync.WaitGroup
-	results := make(chan string, endIP-startIP+1)
-	var openIPs []string
+	nets, err := resolveSubnets(cidrs, logger)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := subnetHosts(nets)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := startIP; i <= endIP; i++ {
-		ip := fmt.Sprintf("%s%d", subnet, i)
-		wg.Add(1)
-		go scanIP(ip, &wg, results)
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	// Create a channel to signal completion
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(results)
-		close(done)
-	}()
-
-	// Wait for completion with timeout
-	select {
-	case <-done:
-		// All goroutines completed
-	case <-time.After(10 * time.Second):
-		// Timeout - collect what we have so far
-	}
-
-	// Collect results
-	for {
-		select {
-		case ip, ok := <-results:
-			if !ok {
-				goto scanComplete
-			}
+	var mu sync.Mutex
+	var openIPs []string
+	forEachHost(hosts, concurrency, func(ip string) {
+		if scanIP(ip) {
+			mu.Lock()
 			openIPs = append(openIPs, ip)
-		case <-time.After(2 * time.Second):
-			// Additional timeout for collecting results
-			goto scanComplete
+			mu.Unlock()
 		}
-	}
+	})
+	sortIPs(openIPs)
 
-scanComplete:
-	elapsed := time.Since(startTime)
-	fmt.Printf("Port scanning completed in %v\n", elapsed)
+	logger.Debugf("Port scanning of %d hosts completed in %v", len(hosts), time.Since(startTime))
 
 	if len(openIPs) == 0 {
 		return nil, errors.New("no open ports found")
@@ -142,89 +118,140 @@ func (k *kasaOutlet) getBrand() string {
 	return "kasa"
 }
 
+// discoverDevicesKasa probes every address of the discovery subnets with a
+// get_sysinfo request and returns the IPs of the devices that answered.
+// The result is formatted as a JSON object with an "ips" array.
 func (k *kasaOutlet) discoverDevicesKasa() (map[string]interface{}, error) {
-	k.logger.Debug("Scanning for devices with Kasa tool on subnet:", subnet)
+	subnets, err := k.subnets()
+	if err != nil {
+		return nil, err
+	}
+	nets, err := resolveSubnets(subnets, k.logger)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := subnetHosts(nets)
+	if err != nil {
+		return nil, err
+	}
+
+	k.logger.Debugf("Scanning %d hosts with Kasa protocol on subnets: %v", len(hosts), nets)
 	startTime := time.Now()
 
-	var wg sync.WaitGroup
-	results := make(chan string, 100)
-	start := 1
-	end := 254
-
-	for batchStart := start; batchStart <= end; batchStart += IpBatchSize {
-		discoveryTimeout := "2"
-		batchEnd := batchStart + IpBatchSize - 1
-		if batchEnd > end {
-			batchEnd = end
+	var mu sync.Mutex
+	foundDevices := []string{}
+	forEachHost(hosts, k.cfg.Concurrency, func(ip string) {
+		client := &kasaClient{transport: &legacyTransport{
+			addr:    joinHostPort(ip, port1),
+			timeout: discoveryTimeout,
+		}}
+		info, err := client.getSysInfo()
+		if err != nil {
+			return
 		}
 
-		fmt.Printf("Scanning batch %d-%d\n", batchStart, batchEnd)
-
-		for i := batchStart; i <= batchEnd; i++ {
-			wg.Add(1)
-			go func(ipNum int) {
-				defer wg.Done()
-
-				ip := fmt.Sprintf("%s%d", subnet, ipNum)
-				cmd := execCommand("kasa", "--host", ip, "--discovery-timeout", discoveryTimeout, "state")
-				o, err := cmd.Output()
-				if err != nil {
-					fmt.Printf("Error scanning %s: %v\n\n", ip, err)
-					return
-				}
-
-				re := regexp.MustCompile(`Device state:\s+(False|True)`)
-				match := re.FindString(string(o))
-
-				if match != "" {
-					fmt.Println("Found device:", ip)
-					results <- ip
-				}
-			}(i)
-		}
-		wg.Wait()
-		fmt.Printf("Completed batch %d-%d\n", batchStart, batchEnd)
-	}
+		k.logger.Debug("Found device:", ip)
+		k.remember(ip, info)
+		mu.Lock()
+		foundDevices = append(foundDevices, ip)
+		mu.Unlock()
+	})
+	sortIPs(foundDevices)
 
-	close(results)
+	k.logger.Debugf("Kasa discovery completed in %v, found %d devices: %v",
+		time.Since(startTime), len(foundDevices), foundDevices)
 
-	var foundDevices []string
-	for ip := range results {
-		foundDevices = append(foundDevices, ip)
+	return toJSONMap(ScanResult{IPs: foundDevices})
+}
+
+// subnets returns the CIDR ranges to scan. Ranges passed in the "subnet" query
+// parameter (repeated or comma separated) override the configured ones, up to
+// maxRequestHosts addresses; when neither is set the local interface networks
+// are used.
+func (k *kasaOutlet) subnets() ([]string, error) {
+	if k.c != nil && k.c.Request != nil {
+		var requested []string
+		for _, v := range k.c.QueryArray("subnet") {
+			requested = append(requested, strings.Split(v, ",")...)
+		}
+		if len(requested) > 0 {
+			return requested, checkRequestedSubnets(requested)
+		}
 	}
+	return k.cfg.Subnets, nil
+}
 
-	elapsed := time.Since(startTime)
-	fmt.Printf("Kasa discovery completed in %v\n", elapsed)
-	fmt.Printf("Found %d devices: %v\n", len(foundDevices), foundDevices)
+// discoverDevices broadcasts the Kasa discovery probes on UDP 9999 and 20002
+// and returns every responder with its model, alias, MAC and device type.
+// Devices on current firmware do not include their alias in the discovery
+// reply, so it is fetched over KLAP afterwards.
+func (k *kasaOutlet) discoverDevices() (map[string]interface{}, error) {
+	k.logger.Debug("Broadcasting Kasa discovery probes")
+	startTime := time.Now()
 
-	sr := ScanResult{IPs: foundDevices}
-	jsonBytes, err := json.Marshal(sr)
+	subnets, err := k.subnets()
+	if err != nil {
+		return nil, err
+	}
+	nets, err := resolveSubnets(subnets, k.logger)
 	if err != nil {
-		fmt.Printf("Error marshaling ScanResult: %v\n", err)
 		return nil, err
 	}
+	var targets []discoveryTarget
+	for _, n := range nets {
+		targets = append(targets, broadcastTargets(subnetBroadcast(n))...)
+	}
 
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal(jsonBytes, &jsonData); err != nil {
-		fmt.Printf("Error unmarshaling JSON: %v\n", err)
+	devices, err := broadcastDiscovery(targets, broadcastWait)
+	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("Discovered with kasas devices JSON output: %s\n", string(jsonBytes))
+	var wg sync.WaitGroup
+	for i := range devices {
+		if devices[i].Protocol != "klap" {
+			continue
+		}
+		kasaProtocols.Store(devices[i].IP, true)
+		if devices[i].Alias != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(d *DiscoveredDevice) {
+			defer wg.Done()
+			info, err := newKasaClient(d.IP, k.cfg.Credentials).getSysInfo()
+			if err != nil {
+				k.logger.Debugf("Could not read alias of %s: %v", d.IP, err)
+				return
+			}
+			d.Alias = info.Alias
+		}(&devices[i])
+	}
+	wg.Wait()
+
+	result := DiscoveryResult{IPs: make([]string, 0, len(devices)), Devices: devices}
+	for i, d := range devices {
+		devices[i].ID = k.rememberDiscovered(d)
+		result.IPs = append(result.IPs, d.IP)
+	}
 
-	return jsonData, nil
+	k.logger.Debugf("Broadcast discovery completed in %v, found %d devices", time.Since(startTime), len(devices))
+	return toJSONMap(result)
 }
 
 // discoverDevicesIps scans the network for Kasa devices and returns their IP addresses.
 // The result is formatted as a JSON object with an "ips" array.
 func (k *kasaOutlet) discoverDevicesIps() (map[string]interface{}, error) {
-	k.logger.Debug("Scanning for open ports on subnet:", subnet)
+	subnets, err := k.subnets()
+	if err != nil {
+		return nil, err
+	}
+	k.logger.Debug("Scanning for open ports on subnets:", subnets)
 	var ips []string
-	var err error
 
 	for attempts := 0; attempts < 3; attempts++ {
 		k.logger.Debugf("Starting scan attempt %d", attempts+1)
-		ips, err = ScanOpenPorts()
+		ips, err = ScanOpenPorts(subnets, k.cfg.Concurrency, k.logger)
 		if err == nil && len(ips) > 0 {
 			k.logger.Debugf("Scan attempt %d successful, found %d devices", attempts+1, len(ips))
 			break
@@ -265,106 +292,226 @@ func (k *kasaOutlet) discoverDevicesIps() (map[string]interface{}, error) {
 // state retrieves the current state (on/off) of the outlet.
 // It returns the state as a JSON object with a "state" field.
 func (k *kasaOutlet) state() (map[string]interface{}, error) {
-	k.logger.Debug("Executing kasa state command")
-	cmd := execCommand("kasa", "--host", k.id, "state")
+	k.logger.Debug("Querying kasa relay state")
+	if k.child != nil {
+		child, err := k.childState()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"state": boolState(child.State)}, nil
+	}
 
-	o, err := cmd.Output()
+	info, err := k.client.getSysInfo()
 	if err != nil {
-		k.logger.Error("Error executing kasa state command:", err)
+		k.logger.Error("Error querying kasa sysinfo:", err)
 		return nil, err
 	}
 
-	var jsonData map[string]interface{}
-	re := regexp.MustCompile(`Device state:\s+(False|True)`)
-	match := re.FindString(string(o))
-
-	if match != "" {
-		match = strings.ReplaceAll(match, "Device state:", "")
-		jsonStr := fmt.Sprintf("{\"state\": \"%s\"}", strings.Trim(match, " "))
+	return map[string]interface{}{"state": boolState(info.RelayState)}, nil
+}
 
-		if err := json.Unmarshal([]byte(jsonStr), &jsonData); err != nil {
-			k.logger.Error("Error unmarshaling JSON:", err)
-			return nil, err
-		}
+// boolState formats a relay state the way the API has always reported it.
+func boolState(relay int) string {
+	if relay == 1 {
+		return "True"
 	}
-	return jsonData, nil
+	return "False"
 }
 
 // sysInfo retrieves system information from the outlet.
 // It returns device details including model and software version.
 func (k *kasaOutlet) sysInfo() (map[string]interface{}, error) {
-	k.logger.Debug("Executing kasa sysinfo command")
-	cmd := execCommand("kasa", "--host", k.id, "sysinfo")
+	k.logger.Debug("Querying kasa sysinfo")
+	if k.child != nil {
+		child, err := k.childState()
+		if err != nil {
+			return nil, err
+		}
+		return toJSONMap(child)
+	}
 
-	o, err := cmd.Output()
+	info, err := k.client.getSysInfo()
 	if err != nil {
-		k.logger.Error("Error executing kasa sysinfo command:", err)
+		k.logger.Error("Error querying kasa sysinfo:", err)
 		return nil, err
 	}
+	k.remember(k.host, info)
+	return toJSONMap(info)
+}
 
-	var jsonStr string
-	var jsonData map[string]interface{}
-	re := regexp.MustCompile(`(?s)\{.*\}`)
-	match := re.FindString(string(o))
+// remember records a device that answered at ip in the registry, if one is
+// configured, and logs when a known device has moved to a new address.
+func (k *kasaOutlet) remember(ip string, info *SysInfo) {
+	k.rememberDiscovered(DiscoveredDevice{
+		IP:       ip,
+		Alias:    info.Alias,
+		Model:    info.Model,
+		MAC:      normalizeMAC(info.macAddress()),
+		DeviceID: info.DeviceID,
+		Type:     info.deviceType(),
+	})
+}
 
-	if match != "" {
-		jsonStr = strings.ReplaceAll(match, "'", "\"")
-		jsonStr = strings.ReplaceAll(jsonStr, "\"{", "{")
-		jsonStr = strings.ReplaceAll(jsonStr, "}\"", "}")
+// rememberDiscovered records a discovered device and returns its registry ID,
+// or "" when no registry is configured or the device could not be recorded.
+func (k *kasaOutlet) rememberDiscovered(d DiscoveredDevice) string {
+	if k.cfg.Registry == nil || net.ParseIP(d.IP) == nil {
+		return ""
+	}
 
-		if err := json.Unmarshal([]byte(jsonStr), &jsonData); err != nil {
-			k.logger.Error("Error unmarshaling JSON:", err)
-			return nil, err
+	entry, movedFrom, err := k.cfg.Registry.Record(registry.Device{
+		Kind:     "outlet",
+		Brand:    k.getBrand(),
+		Model:    d.Model,
+		Alias:    d.Alias,
+		MAC:      d.MAC,
+		DeviceID: d.DeviceID,
+		IP:       d.IP,
+	})
+	if err != nil {
+		k.logger.Warnf("Could not record device %s in registry: %v", d.IP, err)
+		return ""
+	}
+	if movedFrom != "" {
+		k.logger.Infof("Device %s (%s) moved from %s to %s", entry.ID, entry.Alias, movedFrom, entry.IP)
+	}
+	return entry.ID
+}
+
+// relayRetryDelay is the delay before the second attempt to switch an
+// outlet; later attempts wait proportionally longer. It is a variable so
+// tests can shorten it.
+var relayRetryDelay = time.Second
+
+// context returns the context of the request being served, which ends when
+// the client goes away or the server gives up draining it on shutdown.
+func (k *kasaOutlet) context() context.Context {
+	if k.c == nil || k.c.Request == nil {
+		return context.Background()
+	}
+	return k.c.Request.Context()
+}
+
+// setRelay switches the outlet on or off, retrying transient failures. The
+// retries stop early when the request is cancelled.
+func (k *kasaOutlet) setRelay(on bool) error {
+	ctx := k.context()
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = k.commandClient().setRelayState(on)
+		if err == nil {
+			return nil
+		}
+		k.logger.Warnf("Attempt %d failed: %v", attempt, err)
+		if attempt < 3 {
+			select {
+			case <-ctx.Done():
+				k.logger.Errorf("Abandoned set_relay_state on %s after %d attempts: %v", k.id, attempt, ctx.Err())
+				return fmt.Errorf("set_relay_state abandoned after %d attempts: %w", attempt, ctx.Err())
+			case <-time.After(relayRetryDelay * time.Duration(attempt)):
+			}
 		}
 	}
+	k.logger.Errorf("All attempts failed for set_relay_state: %v", err)
+	return err
+}
+
+// toJSONMap converts a typed result into the generic map returned by outlet actions.
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
 
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &jsonData); err != nil {
+		return nil, err
+	}
 	return jsonData, nil
 }
 
+// childActions lists the actions that can address a single socket of a power strip.
+var childActions = map[string]bool{
+	"on": true, "off": true, "state": true, "sysinfo": true, "rename": true,
+	"emeter": true, "emeterDaily": true, "emeterMonthly": true, "emeterReset": true,
+}
+
+// discoveryActions lists the actions that scan the network instead of
+// addressing the outlet, which access rules do not apply to.
+var discoveryActions = map[string]bool{"discover": true, "discoverByKasa": true, "discoverByPorts": true}
+
+// checkAccess evaluates the access rules of the outlet, and of the selected
+// socket, for an action requested over HTTP. Switching off a power strip
+// without selecting a socket switches off every socket, so their rules apply
+// as well; the sockets are only looked up when rules restrict them.
+func (k *kasaOutlet) checkAccess(action string, c *gin.Context) error {
+	if k.cfg.ACL == nil || c == nil || discoveryActions[action] {
+		return nil
+	}
+	req := acl.FromRequest(c, k.cfg.deviceID(k.id), action)
+	if k.child != nil {
+		req.Channel = k.child.ID
+		return k.cfg.ACL.Check(req)
+	}
+	if err := k.cfg.ACL.Check(req); err != nil {
+		return err
+	}
+	if !acl.SwitchesOff(action) || !k.cfg.ACL.Restricts(req.Device+":") {
+		return nil
+	}
+	info, err := k.client.getSysInfo()
+	if err != nil {
+		return err
+	}
+	for _, child := range info.Children {
+		req.Channel = fullChildID(info, child.ID)
+		if err := k.cfg.ACL.Check(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // action executes a command on the outlet and returns the result.
-// Supported actions are: "on", "off", "discover", "state", and "sysinfo".
+// Supported actions are: "on", "off", "state", "sysinfo", "rename", "children",
+// the energy meter actions "emeter", "emeterDaily", "emeterMonthly" and
+// "emeterReset", and the discovery actions "discover", "discoverByKasa" and
+// "discoverByPorts". When a child socket is selected only childActions apply.
 // The result is returned as a JSON response through the gin.Context.
 func (k *kasaOutlet) action(action string, c *gin.Context) error {
 	k.logger.Debug("Executing action:", action)
 	jsonData := map[string]interface{}{}
 	var err error
 
+	if err := k.selectChild(); err != nil {
+		k.logger.Error("Error selecting child socket:", err)
+		return err
+	}
+	if k.child != nil && !childActions[action] {
+		return fmt.Errorf("action %s is not supported on a child socket", action)
+	}
+	if err := k.checkAccess(action, c); err != nil {
+		k.logger.Warnf("Refused %s on %s: %v", action, k.id, err)
+		return err
+	}
+
 	switch action {
 	case "on":
 		k.logger.Debug("Turning on the device")
-
-		for attempt := 1; attempt <= 3; attempt++ {
-			cmd := execCommand("kasa", "--host", k.id, "--timeout", "10", "on")
-			output, err := cmd.CombinedOutput()
-			if err == nil {
-				k.logger.Debugf("Kasa on command output: %s", string(output))
-				break
-			}
-			k.logger.Warnf("Attempt %d failed: %v\nOutput: %s", attempt, err, string(output))
-			if attempt < 3 {
-				time.Sleep(time.Second * time.Duration(attempt))
-			} else {
-				k.logger.Errorf("All attempts failed for kasa on command: %v\nOutput: %s", err, string(output))
-				return err
-			}
+		if err := k.setRelay(true); err != nil {
+			return err
 		}
 	case "off":
 		k.logger.Debug("Turning off the device")
-
-		for attempt := 1; attempt <= 3; attempt++ {
-			cmd := execCommand("kasa", "--host", k.id, "--timeout", "10", "off")
-			output, err := cmd.CombinedOutput()
-			if err == nil {
-				k.logger.Debugf("Kasa off command output: %s", string(output))
-				break
-			}
-			k.logger.Warnf("Attempt %d failed: %v\nOutput: %s", attempt, err, string(output))
-			if attempt < 3 {
-				time.Sleep(time.Second * time.Duration(attempt))
-			} else {
-				k.logger.Errorf("All attempts failed for kasa off command: %v\nOutput: %s", err, string(output))
-				return err
-			}
+		if err := k.setRelay(false); err != nil {
+			return err
+		}
+	case "discover":
+		k.logger.Debug("Discovering devices using UDP broadcast...")
+		jsonData, err = k.discoverDevices()
+		if err != nil {
+			k.logger.Error("Error discovering devices:", err)
+			return err
 		}
 	case "discoverByKasa":
 		k.logger.Debug("Discovering devices using kasa tool...")
@@ -394,6 +541,47 @@ func (k *kasaOutlet) action(action string, c *gin.Context) error {
 			k.logger.Error("Error getting sysinfo:", err)
 			return err
 		}
+	case "children":
+		k.logger.Debug("Listing child sockets")
+		jsonData, err = k.children()
+		if err != nil {
+			k.logger.Error("Error listing child sockets:", err)
+			return err
+		}
+	case "rename":
+		k.logger.Debug("Renaming device")
+		jsonData, err = k.rename()
+		if err != nil {
+			k.logger.Error("Error renaming device:", err)
+			return err
+		}
+	case "emeter":
+		k.logger.Debug("Getting realtime energy reading")
+		jsonData, err = k.emeterRealtime()
+		if err != nil {
+			k.logger.Error("Error getting energy reading:", err)
+			return err
+		}
+	case "emeterDaily":
+		k.logger.Debug("Getting daily energy statistics")
+		jsonData, err = k.emeterDaily()
+		if err != nil {
+			k.logger.Error("Error getting daily statistics:", err)
+			return err
+		}
+	case "emeterMonthly":
+		k.logger.Debug("Getting monthly energy statistics")
+		jsonData, err = k.emeterMonthly()
+		if err != nil {
+			k.logger.Error("Error getting monthly statistics:", err)
+			return err
+		}
+	case "emeterReset":
+		k.logger.Debug("Erasing energy statistics")
+		if err := k.emeterReset(); err != nil {
+			k.logger.Error("Error erasing energy statistics:", err)
+			return err
+		}
 	default:
 		err := fmt.Errorf("unsupported action: %s", action)
 		k.logger.Error(err)
@@ -414,13 +602,17 @@ func (k *kasaOutlet) action(action string, c *gin.Context) error {
 		}
 
 		k.logger.Debug("Action executed successfully:", action)
-		c.JSON(200, gin.H{
+		response := gin.H{
 			"brand":  k.getBrand(),
 			"id":     k.getID(),
 			"action": action,
 			"result": jsonData,
 			"status": "success",
-		})
+		}
+		if k.child != nil {
+			response["child"] = k.child.ID
+		}
+		c.JSON(200, response)
 	}
 	return nil
 }
@@ -3,16 +3,19 @@
 package outlet
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
-	"os/exec"
 	"testing"
 	"time"
 
 	"net"
 
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/registry"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -72,14 +75,18 @@ func TestDiscoverDevicesIps(t *testing.T) {
 		id:     "test-id",
 		logger: logger,
 		c:      &gin.Context{},
+		cfg:    Config{Subnets: []string{"192.168.101.0/24"}},
 	}
 
-	// Mock ScanOpenPorts to return consistent test data
-	originalScan := scanOpenPortsWrapper.f
-	defer func() { scanOpenPortsWrapper.f = originalScan }()
+	// Mock the network so only one host answers on the Kasa port
+	originalDial := dialTimeout
+	defer func() { dialTimeout = originalDial }()
 
-	scanOpenPortsWrapper.f = func() ([]string, []error) {
-		return []string{"192.168.101.170"}, nil
+	dialTimeout = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		if addr == "192.168.101.170:9999" {
+			return &mockConn{}, nil
+		}
+		return nil, errors.New("connection refused")
 	}
 
 	jsonData, err := k.discoverDevicesIps()
@@ -115,11 +122,11 @@ func TestState(t *testing.T) {
 	k := &kasaOutlet{
 		id:     "test-id",
 		logger: logger,
-	}
-
-	// Mock the exec.Command function
-	execCommand = func(name string, arg ...string) *exec.Cmd {
-		return exec.Command("echo", "Device state: True")
+		client: fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+			return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+				"relay_state": 1, "err_code": 0,
+			}}}
+		}),
 	}
 
 	jsonData, err := k.state()
@@ -138,11 +145,11 @@ func TestSysInfo(t *testing.T) {
 	k := &kasaOutlet{
 		id:     "test-id",
 		logger: logger,
-	}
-
-	// Mock the exec.Command function
-	execCommand = func(name string, arg ...string) *exec.Cmd {
-		return exec.Command("echo", `{"model": "HS103(US)", "sw_ver": "1.0.13"}`)
+		client: fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+			return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+				"model": "HS103(US)", "sw_ver": "1.0.13", "err_code": 0,
+			}}}
+		}),
 	}
 
 	jsonData, err := k.sysInfo()
@@ -162,11 +169,11 @@ func TestAction(t *testing.T) {
 	k := &kasaOutlet{
 		id:     "test-id",
 		logger: logger,
-	}
-
-	// Mock the exec.Command function
-	execCommand = func(name string, arg ...string) *exec.Cmd {
-		return exec.Command("echo", "OK")
+		client: fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{
+				"err_code": 0,
+			}}}
+		}),
 	}
 
 	router := gin.Default()
@@ -189,6 +196,121 @@ func TestAction(t *testing.T) {
 	assert.Equal(t, "success", response["status"])
 }
 
+// TestActionProtected verifies that the access rules are evaluated before an
+// action reaches the outlet, so a protected outlet is only switched off on
+// confirmation.
+func TestActionProtected(t *testing.T) {
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: "kasa:test-id", Protected: true})
+	assert.NoError(t, err)
+
+	switched := 0
+	k := &kasaOutlet{
+		id:     "test-id",
+		logger: logrus.New(),
+		cfg:    Config{ACL: rules},
+		client: fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+			switched++
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{
+				"err_code": 0,
+			}}}
+		}),
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/device/outlet/kasa/test-id/off", nil)
+	assert.ErrorIs(t, k.action("off", c), acl.ErrConfirmationRequired)
+	assert.Equal(t, 0, switched)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/device/outlet/kasa/test-id/off?confirm=true", nil)
+	assert.NoError(t, k.action("off", c))
+	assert.Equal(t, 1, switched)
+}
+
+// TestActionProtectedSocket verifies that switching off a whole power strip
+// is refused without confirmation when one of its sockets is protected.
+func TestActionProtectedSocket(t *testing.T) {
+	rules, err := acl.Open("", nil)
+	assert.NoError(t, err)
+	_, err = rules.Set(acl.Rule{Device: "kasa:strip:8006AB01", Protected: true})
+	assert.NoError(t, err)
+
+	switched := 0
+	client := fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		if _, ok := req["system"]["set_relay_state"]; ok {
+			switched++
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{"err_code": 0}}}
+		}
+		return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"model": "HS300(US)", "deviceId": "8006AB", "err_code": 0,
+			"children": []map[string]interface{}{
+				{"id": "00", "alias": "Router", "state": 1},
+				{"id": "01", "alias": "Aquarium", "state": 1},
+			},
+		}}}
+	})
+	strip := func(target string) (*kasaOutlet, *gin.Context) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, target, nil)
+		return &kasaOutlet{id: "strip", logger: logrus.New(), cfg: Config{ACL: rules}, client: client, childRef: requestedChild(c)}, c
+	}
+
+	k, c := strip("/api/v1/device/outlet/kasa/strip/off")
+	assert.ErrorIs(t, k.action("off", c), acl.ErrConfirmationRequired)
+	assert.Equal(t, 0, switched)
+
+	k, c = strip("/api/v1/device/outlet/kasa/strip/off?child=Router")
+	assert.NoError(t, k.action("off", c))
+	k, c = strip("/api/v1/device/outlet/kasa/strip/on")
+	assert.NoError(t, k.action("on", c))
+	k, c = strip("/api/v1/device/outlet/kasa/strip/off?confirm=true")
+	assert.NoError(t, k.action("off", c))
+	assert.Equal(t, 3, switched)
+}
+
+// TestActionWithoutAddress verifies that a registered outlet whose IP is not
+// known is reported offline instead of being dialed by its ID.
+func TestActionWithoutAddress(t *testing.T) {
+	reg, err := registry.Open("")
+	assert.NoError(t, err)
+	reg.Record(registry.Device{Kind: "outlet", Brand: "kasa", DeviceID: "AAA", IP: "10.0.0.5"})
+	reg.Record(registry.Device{Kind: "outlet", Brand: "kasa", DeviceID: "BBB", IP: "10.0.0.5"})
+
+	_, err = newOutlet("kasa", "aaa", nil, logrus.New(), Config{Registry: reg})
+	assert.ErrorIs(t, err, device.ErrOffline)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/device/outlet/:brand/:id/:action", OutletActionHandler(router, logrus.New(), Config{Registry: reg}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/device/outlet/kasa/AAA/on", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "no known IP address")
+}
+
+// TestSetRelayAbandoned verifies that the relay retries stop when the
+// request is cancelled, e.g. because the server is shutting down.
+func TestSetRelayAbandoned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	k := &kasaOutlet{
+		id:     "test-id",
+		logger: logrus.New(),
+		c:      &gin.Context{Request: httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)},
+		client: fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+			cancel()
+			return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{
+				"err_code": -1, "err_msg": "busy",
+			}}}
+		}),
+	}
+
+	err := k.setRelay(true)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "abandoned after 1 attempts")
+}
+
 // TestScanOpenPorts verifies that the port scanning functionality
 // correctly identifies open ports and handles errors.
 // It tests both successful port discovery and error conditions,
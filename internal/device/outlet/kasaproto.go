@@ -0,0 +1,261 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file implements the TP-Link Kasa legacy protocol spoken on TCP port 9999.
+package outlet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// kasaInitialKey is the seed of the autokey XOR cipher used by Kasa devices.
+const kasaInitialKey = 171
+
+// kasaRequestTimeout bounds a single request/response exchange with a device.
+const kasaRequestTimeout = 5 * time.Second
+
+// kasaMaxResponseSize guards against garbage length prefixes from non-Kasa peers.
+const kasaMaxResponseSize = 1 << 20
+
+// SysInfo holds the device details reported by the "system.get_sysinfo" command.
+type SysInfo struct {
+	Alias      string      `json:"alias"`
+	Model      string      `json:"model"`
+	DeviceID   string      `json:"deviceId"`
+	HwID       string      `json:"hwId,omitempty"`
+	OemID      string      `json:"oemId,omitempty"`
+	SwVer      string      `json:"sw_ver"`
+	HwVer      string      `json:"hw_ver"`
+	Type       string      `json:"type,omitempty"`
+	MicType    string      `json:"mic_type,omitempty"`
+	MAC        string      `json:"mac,omitempty"`
+	MicMAC     string      `json:"mic_mac,omitempty"`
+	Feature    string      `json:"feature,omitempty"`
+	RelayState int         `json:"relay_state"`
+	OnTime     int         `json:"on_time"`
+	LedOff     int         `json:"led_off"`
+	RSSI       int         `json:"rssi"`
+	Updating   int         `json:"updating"`
+	ChildNum   int         `json:"child_num,omitempty"`
+	Children   []ChildInfo `json:"children,omitempty"`
+}
+
+// ChildInfo describes a single socket of a multi-socket power strip.
+type ChildInfo struct {
+	ID     string `json:"id"`
+	Alias  string `json:"alias"`
+	State  int    `json:"state"`
+	OnTime int    `json:"on_time"`
+}
+
+// deviceType returns the device type regardless of which field the firmware used.
+func (s *SysInfo) deviceType() string {
+	if s.Type != "" {
+		return s.Type
+	}
+	return s.MicType
+}
+
+// macAddress returns the MAC address regardless of which field the firmware used.
+func (s *SysInfo) macAddress() string {
+	if s.MAC != "" {
+		return s.MAC
+	}
+	return s.MicMAC
+}
+
+// kasaError is returned when a device answers a command with a non-zero err_code.
+type kasaError struct {
+	Code int
+	Msg  string
+}
+
+func (e *kasaError) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("kasa device error %d: %s", e.Code, e.Msg)
+	}
+	return fmt.Sprintf("kasa device error %d", e.Code)
+}
+
+// kasaEncrypt applies the autokey XOR cipher to a payload.
+func kasaEncrypt(plain []byte) []byte {
+	key := byte(kasaInitialKey)
+	out := make([]byte, len(plain))
+	for i, b := range plain {
+		key ^= b
+		out[i] = key
+	}
+	return out
+}
+
+// kasaDecrypt reverses kasaEncrypt.
+func kasaDecrypt(cipher []byte) []byte {
+	key := byte(kasaInitialKey)
+	out := make([]byte, len(cipher))
+	for i, b := range cipher {
+		out[i] = key ^ b
+		key = b
+	}
+	return out
+}
+
+// kasaTransport sends a raw JSON request to a device and returns the raw JSON response.
+type kasaTransport interface {
+	send(request []byte) ([]byte, error)
+}
+
+// legacyTransport speaks the length-prefixed XOR protocol over a TCP connection.
+// A new connection is opened for every request, as the devices expect.
+type legacyTransport struct {
+	addr    string
+	timeout time.Duration
+}
+
+// send writes one framed request and reads one framed response.
+func (t *legacyTransport) send(request []byte) ([]byte, error) {
+	conn, err := dialTimeout("tcp", t.addr, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4+len(request))
+	binary.BigEndian.PutUint32(frame, uint32(len(request)))
+	copy(frame[4:], kasaEncrypt(request))
+	if _, err := conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > kasaMaxResponseSize {
+		return nil, fmt.Errorf("kasa response too large: %d bytes", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	return kasaDecrypt(payload), nil
+}
+
+// kasaClient issues typed commands to a single Kasa device.
+type kasaClient struct {
+	transport kasaTransport
+	childIDs  []string // When set, commands address these sockets of a power strip
+}
+
+// forChild returns a client whose commands address a single child socket.
+func (c *kasaClient) forChild(id string) *kasaClient {
+	return &kasaClient{transport: c.transport, childIDs: []string{id}}
+}
+
+// newKasaClient returns a client for the device at host. The legacy protocol
+// is tried first and KLAP, authenticated with creds, is used as a fallback.
+func newKasaClient(host string, creds *CredentialStore) *kasaClient {
+	return &kasaClient{
+		transport: &autoTransport{
+			host: host,
+			legacy: &legacyTransport{
+				addr:    joinHostPort(host, port1),
+				timeout: kasaRequestTimeout,
+			},
+			klap: newKlapTransport(host, creds),
+		},
+	}
+}
+
+// request sends {module: {method: params}} and decodes the method's reply into out.
+// Requests from a child client carry a {"context": {"child_ids": [...]}} member.
+// A non-zero err_code in the reply is returned as a *kasaError.
+func (c *kasaClient) request(module, method string, params interface{}, out interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	req := map[string]interface{}{
+		module: map[string]interface{}{method: params},
+	}
+	if len(c.childIDs) > 0 {
+		req["context"] = map[string][]string{"child_ids": c.childIDs}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.transport.send(body)
+	if err != nil {
+		return err
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("invalid kasa response: %w", err)
+	}
+	moduleReply, ok := envelope[module]
+	if !ok {
+		return fmt.Errorf("kasa response missing %s", module)
+	}
+
+	// Unsupported modules answer with a module-level err_code instead of a
+	// method reply, e.g. {"emeter":{"err_code":-1,"err_msg":"module not support"}}.
+	var methods map[string]json.RawMessage
+	if err := json.Unmarshal(moduleReply, &methods); err != nil {
+		return fmt.Errorf("invalid kasa response: %w", err)
+	}
+	reply, hasMethod := methods[method]
+	if !hasMethod {
+		reply = moduleReply
+	}
+
+	var status struct {
+		ErrCode int    `json:"err_code"`
+		ErrMsg  string `json:"err_msg"`
+	}
+	if err := json.Unmarshal(reply, &status); err != nil {
+		return fmt.Errorf("invalid kasa response: %w", err)
+	}
+	if status.ErrCode != 0 {
+		return &kasaError{Code: status.ErrCode, Msg: status.ErrMsg}
+	}
+	if !hasMethod {
+		return fmt.Errorf("kasa response missing %s.%s", module, method)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(reply, out)
+}
+
+// getSysInfo returns the device's system information.
+func (c *kasaClient) getSysInfo() (*SysInfo, error) {
+	var info SysInfo
+	if err := c.request("system", "get_sysinfo", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// setRelayState switches the device relay on or off.
+func (c *kasaClient) setRelayState(on bool) error {
+	state := 0
+	if on {
+		state = 1
+	}
+	return c.request("system", "set_relay_state", map[string]int{"state": state}, nil)
+}
+
+// setAlias renames the device, or the child socket for a child client.
+func (c *kasaClient) setAlias(alias string) error {
+	return c.request("system", "set_dev_alias", map[string]string{"alias": alias}, nil)
+}
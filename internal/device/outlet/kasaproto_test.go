@@ -0,0 +1,105 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for the Kasa legacy protocol client.
+package outlet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKasaDevice starts a TCP listener that speaks the legacy Kasa protocol.
+// Every decoded request is passed to handle, whose return value is sent back.
+// It returns a client connected to the listener.
+func fakeKasaDevice(t *testing.T, handle func(req map[string]map[string]json.RawMessage) interface{}) *kasaClient {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				header := make([]byte, 4)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				payload := make([]byte, binary.BigEndian.Uint32(header))
+				if _, err := io.ReadFull(conn, payload); err != nil {
+					return
+				}
+
+				var req map[string]map[string]json.RawMessage
+				if err := json.Unmarshal(kasaDecrypt(payload), &req); err != nil {
+					return
+				}
+
+				resp, _ := json.Marshal(handle(req))
+				frame := make([]byte, 4+len(resp))
+				binary.BigEndian.PutUint32(frame, uint32(len(resp)))
+				copy(frame[4:], kasaEncrypt(resp))
+				conn.Write(frame)
+			}(conn)
+		}
+	}()
+
+	return &kasaClient{transport: &legacyTransport{addr: ln.Addr().String(), timeout: time.Second}}
+}
+
+// TestKasaCipherRoundTrip verifies that decrypting an encrypted payload yields the original.
+func TestKasaCipherRoundTrip(t *testing.T) {
+	plain := []byte(`{"system":{"get_sysinfo":{}}}`)
+
+	cipher := kasaEncrypt(plain)
+	assert.NotEqual(t, plain, cipher)
+	assert.Equal(t, byte(0xd0), cipher[0]) // '{' ^ 171
+	assert.Equal(t, plain, kasaDecrypt(cipher))
+}
+
+// TestKasaClientSysInfo verifies that sysinfo replies are decoded into typed fields.
+func TestKasaClientSysInfo(t *testing.T) {
+	client := fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		_, ok := req["system"]["get_sysinfo"]
+		assert.True(t, ok)
+		return map[string]interface{}{"system": map[string]interface{}{"get_sysinfo": map[string]interface{}{
+			"alias": "Desk Lamp", "model": "HS103(US)", "sw_ver": "1.0.13",
+			"mic_mac": "AABBCCDDEEFF", "relay_state": 1, "err_code": 0,
+		}}}
+	})
+
+	info, err := client.getSysInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, "Desk Lamp", info.Alias)
+	assert.Equal(t, "HS103(US)", info.Model)
+	assert.Equal(t, "AABBCCDDEEFF", info.macAddress())
+	assert.Equal(t, 1, info.RelayState)
+}
+
+// TestKasaClientErrCode verifies that non-zero err_code replies surface as errors.
+func TestKasaClientErrCode(t *testing.T) {
+	client := fakeKasaDevice(t, func(req map[string]map[string]json.RawMessage) interface{} {
+		return map[string]interface{}{"system": map[string]interface{}{"set_relay_state": map[string]interface{}{
+			"err_code": -1, "err_msg": "module not support",
+		}}}
+	})
+
+	err := client.setRelayState(true)
+	assert.Error(t, err)
+	kerr, ok := err.(*kasaError)
+	assert.True(t, ok)
+	assert.Equal(t, -1, kerr.Code)
+}
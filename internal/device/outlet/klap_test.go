@@ -0,0 +1,134 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for the KLAP transport.
+package outlet
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKlapDevice emulates the device side of a KLAP v2 session for creds.
+// It counts handshakes and can be told to reject the next request as expired.
+type fakeKlapDevice struct {
+	mu         sync.Mutex
+	authHash   []byte
+	localSeed  []byte
+	remoteSeed []byte
+	session    *klapSession
+	handshakes int
+	expireNext bool
+	reply      []byte
+}
+
+func (d *fakeKlapDevice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/app/handshake1":
+		d.handshakes++
+		d.localSeed = body
+		d.remoteSeed = make([]byte, 16)
+		rand.Read(d.remoteSeed)
+		http.SetCookie(w, &http.Cookie{Name: "TP_SESSIONID", Value: "sess" + strconv.Itoa(d.handshakes)})
+		http.SetCookie(w, &http.Cookie{Name: "TIMEOUT", Value: "86400"})
+		w.Write(append(append([]byte{}, d.remoteSeed...), klapSeedHash(d.localSeed, d.remoteSeed, d.authHash)...))
+	case "/app/handshake2":
+		if string(body) != string(klapSeedHash(d.remoteSeed, d.localSeed, d.authHash)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		d.session = newKlapSession(d.localSeed, d.remoteSeed, d.authHash, "", time.Time{})
+	case "/app/request":
+		if d.expireNext {
+			d.expireNext = false
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		seq, _ := strconv.Atoi(r.URL.Query().Get("seq"))
+		if _, err := d.session.decrypt(body, int32(seq)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d.session.seq = int32(seq) - 1
+		payload, _ := d.session.encrypt(d.reply)
+		w.Write(payload)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestKlapTransport starts a fake device and returns a transport for it.
+func newTestKlapTransport(t *testing.T, deviceCreds, clientCreds Credentials) (*klapTransport, *fakeKlapDevice) {
+	t.Helper()
+
+	device := &fakeKlapDevice{
+		authHash: klapAuthHash(2, deviceCreds),
+		reply:    []byte(`{"system":{"get_sysinfo":{"alias":"Plug","err_code":0}}}`),
+	}
+	srv := httptest.NewServer(device)
+	t.Cleanup(srv.Close)
+
+	store := NewCredentialStore()
+	store.SetDefault(clientCreds)
+	transport := &klapTransport{
+		host:        srv.URL,
+		baseURL:     srv.URL,
+		credentials: store,
+		client:      srv.Client(),
+	}
+	t.Cleanup(func() {
+		klapSessions.Lock()
+		delete(klapSessions.m, srv.URL)
+		klapSessions.Unlock()
+	})
+	return transport, device
+}
+
+// TestKlapSessionReuse verifies that a handshake is performed once and its
+// session is reused for later requests.
+func TestKlapSessionReuse(t *testing.T) {
+	creds := Credentials{Username: "user@example.com", Password: "secret"}
+	transport, device := newTestKlapTransport(t, creds, creds)
+	client := &kasaClient{transport: transport}
+
+	for i := 0; i < 3; i++ {
+		info, err := client.getSysInfo()
+		assert.NoError(t, err)
+		assert.Equal(t, "Plug", info.Alias)
+	}
+	assert.Equal(t, 1, device.handshakes)
+}
+
+// TestKlapRehandshakeOnExpiry verifies that a rejected session triggers a new handshake.
+func TestKlapRehandshakeOnExpiry(t *testing.T) {
+	creds := Credentials{Username: "user@example.com", Password: "secret"}
+	transport, device := newTestKlapTransport(t, creds, creds)
+
+	_, err := transport.send([]byte(`{}`))
+	assert.NoError(t, err)
+
+	device.expireNext = true
+	_, err = transport.send([]byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, device.handshakes)
+}
+
+// TestKlapWrongCredentials verifies that a device bound to another account is reported.
+func TestKlapWrongCredentials(t *testing.T) {
+	transport, _ := newTestKlapTransport(t,
+		Credentials{Username: "owner@example.com", Password: "right"},
+		Credentials{Username: "owner@example.com", Password: "wrong"})
+
+	_, err := transport.send([]byte(`{}`))
+	assert.ErrorIs(t, err, errKlapAuth)
+}
@@ -4,8 +4,11 @@
 package outlet
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/device"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -16,28 +19,38 @@ import (
 // Parameters:
 //   - svr: The gin engine instance for HTTP routing
 //   - logger: A configured logrus logger for operation tracking
+//   - cfg: Shared outlet settings such as device credentials
 //
 // The handler expects URL parameters:
 //   - brand: The outlet brand (e.g., "kasa")
-//   - id: Device identifier (typically IP address)
-//   - action: Command to execute (e.g., "on", "off", "state")
+//   - id: Registry ID, MAC address or IP address of the device
+//   - action: Command to execute (e.g., "on", "off", "state", "discover")
+//   - childId: Optional socket of a power strip (index, child ID or alias),
+//     also accepted as the "child" query parameter
 //
 // Returns a gin.HandlerFunc that:
 //   - Creates an appropriate outlet controller based on brand
 //   - Executes the requested action
 //   - Returns JSON response with operation result
 //
-// Example URL: POST /api/v1/device/outlet/kasa/192.168.1.100/on
-func OutletActionHandler(svr *gin.Engine, logger *logrus.Logger) gin.HandlerFunc {
+// Example URLs:
+//
+//	POST /api/v1/device/outlet/kasa/192.168.1.100/on
+//	POST /api/v1/device/outlet/kasa/192.168.1.100/children/2/off
+func OutletActionHandler(svr *gin.Engine, logger *logrus.Logger, cfg Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		brand := c.Param("brand")
 		action := c.Param("action")
 
 		logger.Debugf("Received request: brand=%s, 'id=%s', 'action=%s'", brand, id, action)
-		outlet, err := newOutlet(brand, id, c, logger)
+		outlet, err := newOutlet(brand, id, c, logger, cfg)
 		if err != nil {
 			logger.Errorf("Error creating outlet: %v", err)
+			if errors.Is(err, device.ErrOffline) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported outlet brand"})
 			return
 		}
@@ -45,7 +58,14 @@ func OutletActionHandler(svr *gin.Engine, logger *logrus.Logger) gin.HandlerFunc
 		err = outlet.action(action, c)
 		if err != nil {
 			logger.Errorf("Error executing action: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrNotSupported) {
+				status = http.StatusUnprocessableEntity
+			}
+			if denied, ok := acl.StatusForError(err); ok {
+				status = denied
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 	}
@@ -0,0 +1,213 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This file resolves the networks scanned during device discovery.
+package outlet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultConcurrency = 64      // Concurrent probes when Config.Concurrency is unset
+	maxScanHosts       = 1 << 16 // Refuse to sweep more addresses than a /16
+	maxRequestHosts    = 1 << 10 // Addresses a single request may ask to sweep, a /22
+)
+
+// ParseSubnets parses CIDR ranges such as "192.168.0.0/23".
+// A bare IPv4 address is accepted as a single-host range.
+func ParseSubnets(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid subnet %q: only IPv4 is supported", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// checkRequestedSubnets limits the ranges a discovery request names to
+// maxRequestHosts addresses together, so one request cannot hold a sweep of a
+// large or foreign network. Larger ranges must be configured.
+func checkRequestedSubnets(cidrs []string) error {
+	nets, err := ParseSubnets(cidrs)
+	if err != nil {
+		return err
+	}
+	var total uint64
+	for _, n := range nets {
+		total += subnetSize(n)
+	}
+	if total > maxRequestHosts {
+		return fmt.Errorf("requested subnets cover %d addresses, a request may scan at most %d (a /22); configure larger ranges instead", total, maxRequestHosts)
+	}
+	return nil
+}
+
+// localSubnets returns the IPv4 networks of the host's non-loopback interfaces
+// that are up. Networks that would take the sweep past maxScanHosts are
+// skipped and logged, so one large network does not stop discovery on the
+// others.
+func localSubnets(logger *logrus.Logger) ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var nets []*net.IPNet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			nets = append(nets, &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask).To4(), Mask: ipNet.Mask})
+		}
+	}
+
+	if len(nets) == 0 {
+		return nil, errors.New("no non-loopback IPv4 interfaces found")
+	}
+	nets = scannableSubnets(nets, logger)
+	if len(nets) == 0 {
+		return nil, fmt.Errorf("every local IPv4 network is too large to scan (limit %d hosts), configure the discovery subnets", maxScanHosts)
+	}
+	return nets, nil
+}
+
+// scannableSubnets keeps the networks that fit into maxScanHosts together, in
+// order, and logs the ones skipped.
+func scannableSubnets(nets []*net.IPNet, logger *logrus.Logger) []*net.IPNet {
+	var kept []*net.IPNet
+	var total uint64
+	for _, n := range nets {
+		size := subnetSize(n)
+		if total+size > maxScanHosts {
+			logger.Warnf("Skipping network %s during discovery: too large to scan (limit %d hosts)", n, maxScanHosts)
+			continue
+		}
+		total += size
+		kept = append(kept, n)
+	}
+	return kept
+}
+
+// resolveSubnets parses cidrs, or detects the local networks when none are given.
+func resolveSubnets(cidrs []string, logger *logrus.Logger) ([]*net.IPNet, error) {
+	nets, err := ParseSubnets(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(nets) == 0 {
+		return localSubnets(logger)
+	}
+	return nets, nil
+}
+
+// subnetSize returns the number of addresses of a network.
+func subnetSize(n *net.IPNet) uint64 {
+	ones, bits := n.Mask.Size()
+	return uint64(1) << uint(bits-ones)
+}
+
+// subnetHosts lists the host addresses of the given networks in ascending order.
+// Network and broadcast addresses are skipped for prefixes shorter than /31.
+func subnetHosts(nets []*net.IPNet) ([]string, error) {
+	seen := map[uint32]bool{}
+	var hosts []uint32
+
+	for _, n := range nets {
+		size := subnetSize(n)
+		if uint64(len(hosts))+size > maxScanHosts {
+			return nil, fmt.Errorf("subnet %s is too large to scan (limit %d hosts)", n, maxScanHosts)
+		}
+
+		base := binary.BigEndian.Uint32(n.IP.To4())
+		first, last := base, base+uint32(size-1)
+		if size > 2 {
+			first, last = first+1, last-1
+		}
+		for ip := first; ip >= first && ip <= last; ip++ {
+			if !seen[ip] {
+				seen[ip] = true
+				hosts = append(hosts, ip)
+			}
+		}
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i] < hosts[j] })
+	out := make([]string, len(hosts))
+	for i, ip := range hosts {
+		b := make(net.IP, 4)
+		binary.BigEndian.PutUint32(b, ip)
+		out[i] = b.String()
+	}
+	return out, nil
+}
+
+// subnetBroadcast returns the directed broadcast address of a network.
+func subnetBroadcast(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	mask := net.IP(n.Mask).To4()
+	if mask == nil {
+		mask = net.IP(n.Mask)
+	}
+	out := make(net.IP, 4)
+	for i := range out {
+		out[i] = ip[i] | ^mask[i]
+	}
+	return out
+}
+
+// forEachHost calls fn for every host, running at most concurrency calls at once.
+func forEachHost(hosts []string, concurrency int, fn func(ip string)) {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, ip := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(ip)
+		}(ip)
+	}
+	wg.Wait()
+}
+
+// sortIPs orders dotted-quad addresses numerically.
+func sortIPs(ips []string) {
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(ips[i]).To16(), net.ParseIP(ips[j]).To16()) < 0
+	})
+}
@@ -0,0 +1,87 @@
+// Package outlet provides functionality for controlling smart outlets.
+// This test file contains unit tests for discovery subnet handling.
+package outlet
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSubnetHosts verifies host enumeration across multiple CIDR ranges.
+func TestSubnetHosts(t *testing.T) {
+	nets, err := ParseSubnets([]string{"10.0.0.0/30", "10.0.0.2", "192.168.0.0/23"})
+	assert.NoError(t, err)
+
+	hosts, err := subnetHosts(nets)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, hosts[:2])
+	assert.Len(t, hosts, 2+510)
+	assert.Equal(t, "192.168.1.254", hosts[len(hosts)-1])
+
+	_, err = ParseSubnets([]string{"192.168.1.0/33"})
+	assert.Error(t, err)
+
+	nets, _ = ParseSubnets([]string{"10.0.0.0/8"})
+	_, err = subnetHosts(nets)
+	assert.Error(t, err)
+}
+
+// TestScannableSubnets verifies that detected networks too large to sweep
+// are skipped and logged instead of failing discovery.
+func TestScannableSubnets(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	nets, _ := ParseSubnets([]string{"192.168.1.0/24", "10.0.0.0/8", "172.16.0.0/16", "192.168.2.0/24"})
+
+	kept := scannableSubnets(nets, logger)
+	assert.Equal(t, []*net.IPNet{nets[0], nets[3]}, kept)
+	assert.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, "172.16.0.0/16")
+
+	hosts, err := subnetHosts(kept)
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 2*254)
+}
+
+// TestRequestedSubnets verifies that subnets named in a discovery request
+// override the configured ones but may not sweep more than a /22.
+func TestRequestedSubnets(t *testing.T) {
+	cfg := Config{Subnets: []string{"10.0.0.0/16"}}
+	for _, tc := range []struct {
+		query string
+		want  []string
+		ok    bool
+	}{
+		{"", []string{"10.0.0.0/16"}, true},
+		{"?subnet=192.168.0.0/22", []string{"192.168.0.0/22"}, true},
+		{"?subnet=192.168.0.0/24,192.168.1.7", []string{"192.168.0.0/24", "192.168.1.7"}, true},
+		{"?subnet=192.168.0.0/21", nil, false},
+		{"?subnet=192.168.0.0/23&subnet=192.168.4.0/23&subnet=192.168.8.0/24", nil, false},
+		{"?subnet=nope", nil, false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/device/outlet/kasa/all/discover"+tc.query, nil)
+		k := &kasaOutlet{c: c, cfg: cfg, logger: logrus.New()}
+
+		subnets, err := k.subnets()
+		if !tc.ok {
+			assert.Error(t, err, tc.query)
+			continue
+		}
+		assert.NoError(t, err, tc.query)
+		assert.Equal(t, tc.want, subnets, tc.query)
+	}
+}
+
+// TestSubnetBroadcast verifies directed broadcast address calculation.
+func TestSubnetBroadcast(t *testing.T) {
+	nets, _ := ParseSubnets([]string{"192.168.0.0/23"})
+	assert.Equal(t, net.IPv4(192, 168, 1, 255).To4(), subnetBroadcast(nets[0]))
+}
@@ -4,7 +4,11 @@ package outlet
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/colbynh/alfred/internal/acl"
+	"github.com/colbynh/alfred/internal/device"
+	"github.com/colbynh/alfred/internal/registry"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -21,7 +25,7 @@ type Outlet interface {
 
 	// action executes a command on the outlet and returns any error
 	// Supported actions vary by implementation but typically include:
-	// "on", "off", "state", "sysinfo", and "discover"
+	// "on", "off", "state", "sysinfo", "emeter", and "discover"
 	action(action string, c *gin.Context) error
 
 	// state retrieves the current state of the outlet
@@ -37,24 +41,100 @@ type Outlet interface {
 	discoverDevicesIps() (map[string]interface{}, error)
 }
 
+// Config holds the settings shared by every outlet request.
+type Config struct {
+	// Credentials authenticates with devices that require the KLAP protocol.
+	Credentials *CredentialStore
+
+	// Subnets lists the CIDR ranges scanned during discovery.
+	// When empty, the networks of the host's interfaces are scanned.
+	Subnets []string
+
+	// Concurrency bounds the number of hosts probed at once during discovery.
+	Concurrency int
+
+	// Registry records discovered devices and resolves stable IDs to IPs.
+	// It is optional; without it every id is treated as an IP address.
+	Registry *registry.Registry
+
+	// ACL restricts who may act on individual outlets. It is optional;
+	// without it every authenticated client may.
+	ACL *acl.List
+}
+
+// resolveHost returns the address to contact for id. Registry IDs and MAC
+// addresses are translated to the device's last known IP; anything else is
+// assumed to be an IP address or hostname already. A registered device whose
+// IP is not known is reported offline rather than dialed by its ID.
+func (cfg Config) resolveHost(id string) (string, error) {
+	if cfg.Registry != nil {
+		if d, ok := cfg.Registry.Resolve(id); ok {
+			if d.IP == "" {
+				return "", fmt.Errorf("%w: %s has no known IP address, run discovery to find it", device.ErrOffline, d.ID)
+			}
+			return d.IP, nil
+		}
+	}
+	return id, nil
+}
+
+// deviceID returns the device API ID of the outlet named by id, so access
+// rules apply however a request names the outlet.
+func (cfg Config) deviceID(id string) string {
+	if cfg.Registry != nil {
+		if d, ok := cfg.Registry.Resolve(id); ok {
+			id = d.ID
+		}
+	}
+	return devicePrefix + ":" + id
+}
+
 // newOutlet creates a new Outlet instance based on the specified brand.
 // Currently supported brands:
 //   - "kasa": TP-Link Kasa smart outlets
 //
 // Parameters:
 //   - brand: The outlet brand name (case-sensitive)
-//   - id: Unique identifier for the device (typically IP address)
+//   - id: Registry ID, MAC address or IP address of the device
 //   - c: Gin context for HTTP request handling
 //   - logger: Logger for operation tracking
+//   - cfg: Shared outlet settings such as device credentials
 //
 // Returns:
 //   - Outlet: An implementation of the Outlet interface
 //   - error: Non-nil if brand is unsupported
-func newOutlet(brand string, id string, c *gin.Context, logger *logrus.Logger) (Outlet, error) {
+func newOutlet(brand string, id string, c *gin.Context, logger *logrus.Logger, cfg Config) (Outlet, error) {
 	switch brand {
 	case "kasa":
-		return &kasaOutlet{id: id, c: c, logger: logger}, nil
+		host, err := cfg.resolveHost(id)
+		if err != nil {
+			return nil, err
+		}
+		return &kasaOutlet{
+			id:       id,
+			host:     host,
+			c:        c,
+			logger:   logger,
+			client:   newKasaClient(host, cfg.Credentials),
+			cfg:      cfg,
+			childRef: requestedChild(c),
+		}, nil
 	default:
 		return nil, errors.New("unsupported outlet brand")
 	}
 }
+
+// requestedChild returns the child socket addressed by a request, taken from
+// the ":childId" URL parameter or the "child" query parameter.
+func requestedChild(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	if child := c.Param("childId"); child != "" {
+		return child
+	}
+	if c.Request != nil {
+		return c.Query("child")
+	}
+	return ""
+}
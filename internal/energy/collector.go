@@ -0,0 +1,115 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file implements the background collector that polls the meters.
+package energy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// collectConcurrency bounds how many meters are read at once.
+const collectConcurrency = 8
+
+// Meter identifies a device that can be sampled.
+type Meter struct {
+	ID   string `json:"id"`             // Store key, e.g. a registry ID
+	Name string `json:"name"`           // Human-readable name
+	Room string `json:"room,omitempty"` // Room the meter is assigned to
+}
+
+// Source lists the meters to poll and takes readings from them.
+type Source interface {
+	// Meters returns the meters that currently exist.
+	Meters() ([]Meter, error)
+
+	// Read takes an instantaneous reading from a meter.
+	Read(id string) (Sample, error)
+}
+
+// Collector samples every meter of a Source at a fixed interval and stores
+// the readings.
+type Collector struct {
+	source    Source
+	store     *Store
+	interval  time.Duration
+	retention time.Duration
+	logger    *logrus.Logger
+}
+
+// NewCollector returns a collector polling source every interval. Samples
+// older than retention are pruned once a day; zero keeps them forever.
+func NewCollector(source Source, store *Store, interval, retention time.Duration, logger *logrus.Logger) *Collector {
+	return &Collector{
+		source:    source,
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run polls until ctx is cancelled. The first poll happens immediately.
+func (c *Collector) Run(ctx context.Context) {
+	c.logger.Infof("Energy collector started, polling every %v", c.interval)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	lastPrune := time.Time{}
+	for {
+		c.collect(ctx)
+
+		if c.retention > 0 && time.Since(lastPrune) > 24*time.Hour {
+			if err := c.store.Prune(time.Now().Add(-c.retention)); err != nil {
+				c.logger.Warn("Error pruning energy samples:", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("Energy collector stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect reads every meter once and stores the results.
+func (c *Collector) collect(ctx context.Context) {
+	meters, err := c.source.Meters()
+	if err != nil {
+		c.logger.Warn("Error listing energy meters:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, collectConcurrency)
+	for _, m := range meters {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(m Meter) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			sample, err := c.source.Read(m.ID)
+			if err != nil {
+				c.logger.Debugf("Error reading energy meter %s: %v", m.ID, err)
+				return
+			}
+			if sample.Time.IsZero() {
+				sample.Time = time.Now()
+			}
+			if err := c.store.Append(m.ID, sample); err != nil {
+				c.logger.Warnf("Error storing energy sample for %s: %v", m.ID, err)
+			}
+		}(m)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,91 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file computes the cost of recorded consumption.
+package energy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cost is the energy used over a period and what it cost.
+type Cost struct {
+	Energy float64         `json:"energy"`          // Kilowatt-hours
+	Cost   float64         `json:"cost"`            // Amount in the tariff currency
+	Bands  map[string]Cost `json:"bands,omitempty"` // Breakdown by tariff band
+}
+
+// add accumulates o into c.
+func (c *Cost) add(o Cost) {
+	c.Energy += o.Energy
+	c.Cost += o.Cost
+	for name, band := range o.Bands {
+		if c.Bands == nil {
+			c.Bands = map[string]Cost{}
+		}
+		sum := c.Bands[name]
+		sum.Energy += band.Energy
+		sum.Cost += band.Cost
+		c.Bands[name] = sum
+	}
+}
+
+// scale returns the totals of c multiplied by factor, without the breakdown.
+func (c Cost) scale(factor float64) Cost {
+	return Cost{Energy: c.Energy * factor, Cost: c.Cost * factor}
+}
+
+// CostOf prices the energy used between consecutive samples, oldest first.
+// Only intervals ending at or after from are counted, so a caller can pass
+// one sample before the period to include the consumption leading into it.
+// Each interval is priced at the rate in effect at its midpoint.
+func (t *Tariff) CostOf(samples []Sample, from time.Time) Cost {
+	var total Cost
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		if cur.Time.Before(from) {
+			continue
+		}
+
+		kwh := Consumption(prev, cur)
+		rate, band := t.RateAt(prev.Time.Add(cur.Time.Sub(prev.Time) / 2))
+		total.add(Cost{
+			Energy: kwh,
+			Cost:   kwh * rate,
+			Bands:  map[string]Cost{band: {Energy: kwh, Cost: kwh * rate}},
+		})
+	}
+	return total
+}
+
+// PeriodRange returns the day, week (starting Monday) or month containing
+// date, in loc.
+func PeriodRange(period string, date time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	y, m, d := date.In(loc).Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	switch period {
+	case "day":
+		return day, day.AddDate(0, 0, 1), nil
+	case "week":
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7), nil
+	case "month":
+		start := time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q, expected day, week or month", period)
+}
+
+// projectionFactor returns how much the cost of [from, to) observed up to now
+// must be scaled to estimate the full period. It returns 0 when the period has
+// not started yet and 1 when it has ended.
+func projectionFactor(from, to, now time.Time) float64 {
+	switch {
+	case !now.After(from):
+		return 0
+	case !now.Before(to):
+		return 1
+	}
+	return float64(to.Sub(from)) / float64(now.Sub(from))
+}
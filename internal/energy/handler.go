@@ -0,0 +1,342 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file provides the HTTP handlers for the energy history API.
+package energy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxQueryPoints bounds the number of buckets a single query may produce.
+const maxQueryPoints = 10000
+
+// costLookback is how far before a period samples are read so that the
+// consumption leading into its first sample is counted.
+const costLookback = time.Hour
+
+// unassignedRoom groups meters without a room in cost breakdowns.
+const unassignedRoom = "unassigned"
+
+// CostLine is the cost of one meter or room over the requested period.
+type CostLine struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Room string `json:"room"`
+	Cost
+	Projected *Cost `json:"projected,omitempty"` // Estimated month-end total
+}
+
+// MetersHandler lists the meters that have recorded history. Names are taken
+// from source when the meter still exists.
+//
+// Example URL: GET /api/v1/energy/meters
+func MetersHandler(svr *gin.Engine, logger *logrus.Logger, store *Store, source Source) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ids, err := store.Meters()
+		if err != nil {
+			logger.Error("Error listing energy meters:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		names := map[string]string{}
+		if live, err := source.Meters(); err == nil {
+			for _, m := range live {
+				names[m.ID] = m.Name
+			}
+		}
+
+		meters := make([]Meter, 0, len(ids))
+		for _, id := range ids {
+			meters = append(meters, Meter{ID: id, Name: names[id]})
+		}
+		c.JSON(http.StatusOK, gin.H{"meters": meters})
+	}
+}
+
+// HistoryHandler returns the power and energy of one meter over a time range.
+//
+// Query parameters:
+//   - from, to: RFC 3339 timestamps (default: the last 24 hours)
+//   - resolution: "minute", "hour" or "day" (default: "hour")
+//   - tz: IANA time zone used to align day buckets (default: server local time)
+//
+// Example URL: GET /api/v1/energy/meters/50c7bf010203/history?resolution=day&from=2024-05-01T00:00:00Z
+func HistoryHandler(svr *gin.Engine, logger *logrus.Logger, store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		q, err := parseRangeQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if int(q.to.Sub(q.from)/q.resolution) > maxQueryPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "time range too large for resolution"})
+			return
+		}
+
+		samples, err := store.Range(id, q.from, q.to)
+		if err != nil {
+			logger.Errorf("Error reading energy history for %s: %v", id, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		points, err := Downsample(samples, q.resolution, q.loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		total := 0.0
+		for _, p := range points {
+			total += p.Energy
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":         id,
+			"from":       q.from,
+			"to":         q.to,
+			"resolution": c.DefaultQuery("resolution", "hour"),
+			"energy":     total,
+			"points":     points,
+		})
+	}
+}
+
+// rangeQuery holds the parsed time range parameters of a history request.
+type rangeQuery struct {
+	from, to   time.Time
+	resolution time.Duration
+	loc        *time.Location
+}
+
+// parseRangeQuery reads the from, to, resolution and tz query parameters.
+func parseRangeQuery(c *gin.Context) (rangeQuery, error) {
+	q := rangeQuery{to: time.Now(), loc: time.Local}
+
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+		q.to = t
+	}
+	q.from = q.to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+		q.from = t
+	}
+	if !q.from.Before(q.to) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	res, ok := Resolutions[c.DefaultQuery("resolution", "hour")]
+	if !ok {
+		return q, fmt.Errorf("invalid resolution %q, expected minute, hour or day", c.Query("resolution"))
+	}
+	q.resolution = res
+
+	if v := c.Query("tz"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid tz: %w", err)
+		}
+		q.loc = loc
+	}
+	return q, nil
+}
+
+// GetTariffHandler returns the configured tariff.
+//
+// Example URL: GET /api/v1/energy/tariff
+func GetTariffHandler(svr *gin.Engine, logger *logrus.Logger, tariffs *TariffFile) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, err := tariffs.Get()
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// SetTariffHandler replaces the tariff with the JSON request body.
+//
+// Example URL: PUT /api/v1/energy/tariff
+//
+//	{"currency": "EUR", "rate": 0.30, "weekendRate": 0.22, "timezone": "Europe/Berlin",
+//	 "windows": [{"name": "offpeak", "days": ["weekday"], "start": "22:00", "end": "06:00", "rate": 0.18}]}
+func SetTariffHandler(svr *gin.Engine, logger *logrus.Logger, tariffs *TariffFile) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var t Tariff
+		if err := c.ShouldBindJSON(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tariffs.Set(t); err != nil {
+			logger.Warn("Rejected tariff:", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Infof("Tariff updated: %s %.4f/kWh with %d windows", t.Currency, t.Rate, len(t.Windows))
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// CostHandler prices the recorded consumption for a day, week or month. It
+// serves the total over all meters, a single room (":room" URL parameter) or
+// a single meter (":id" URL parameter). Monthly reports of the current month
+// include a projected month-end bill, assuming consumption continues at the
+// average rate observed so far.
+//
+// Query parameters:
+//   - period: "day", "week" or "month" (default: "month")
+//   - date: a day within the period as YYYY-MM-DD (default: today)
+//
+// Example URL: GET /api/v1/energy/cost?period=week
+// Example URL: GET /api/v1/energy/rooms/kitchen/cost?period=month&date=2024-05-01
+// Example URL: GET /api/v1/energy/meters/50c7bf010203/cost?period=day
+func CostHandler(svr *gin.Engine, logger *logrus.Logger, store *Store, source Source, tariffs *TariffFile) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tariff, err := tariffs.Get()
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error() + ", set one with PUT /api/v1/energy/tariff"})
+			return
+		}
+		loc := tariff.Location()
+
+		period := c.DefaultQuery("period", "month")
+		date := time.Now()
+		if v := c.Query("date"); v != "" {
+			if date, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		from, to, err := PeriodRange(period, date, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		meters, err := costMeters(store, source)
+		if err != nil {
+			logger.Error("Error listing energy meters:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		meters = filterMeters(meters, c.Param("id"), c.Param("room"))
+		if len(meters) == 0 && (c.Param("id") != "" || c.Param("room") != "") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no energy meters match the request"})
+			return
+		}
+
+		factor := 0.0
+		if period == "month" {
+			factor = projectionFactor(from, to, time.Now())
+		}
+		project := func(cost Cost) *Cost {
+			if factor == 0 {
+				return nil
+			}
+			p := cost.scale(factor)
+			return &p
+		}
+
+		var total Cost
+		lines := make([]CostLine, 0, len(meters))
+		rooms := map[string]*CostLine{}
+		for _, m := range meters {
+			samples, err := store.Range(m.ID, from.Add(-costLookback), to)
+			if err != nil {
+				logger.Errorf("Error reading energy history for %s: %v", m.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			cost := tariff.CostOf(samples, from)
+			lines = append(lines, CostLine{ID: m.ID, Name: m.Name, Room: m.Room, Cost: cost, Projected: project(cost)})
+
+			room, ok := rooms[m.Room]
+			if !ok {
+				room = &CostLine{Room: m.Room}
+				rooms[m.Room] = room
+			}
+			room.add(cost)
+			total.add(cost)
+		}
+
+		roomLines := make([]CostLine, 0, len(rooms))
+		for _, room := range rooms {
+			room.Projected = project(room.Cost)
+			roomLines = append(roomLines, *room)
+		}
+		sort.Slice(roomLines, func(i, j int) bool { return roomLines[i].Room < roomLines[j].Room })
+
+		c.JSON(http.StatusOK, gin.H{
+			"period":    period,
+			"from":      from,
+			"to":        to,
+			"currency":  tariff.Currency,
+			"total":     total,
+			"projected": project(total),
+			"rooms":     roomLines,
+			"meters":    lines,
+		})
+	}
+}
+
+// costMeters returns every meter with recorded history or currently live,
+// with the name and room reported by source.
+func costMeters(store *Store, source Source) ([]Meter, error) {
+	ids, err := store.Meters()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]Meter{}
+	for _, id := range ids {
+		byID[id] = Meter{ID: id}
+	}
+	if live, err := source.Meters(); err == nil {
+		for _, m := range live {
+			byID[m.ID] = m
+		}
+	}
+
+	meters := make([]Meter, 0, len(byID))
+	for _, m := range byID {
+		if m.Room == "" {
+			m.Room = unassignedRoom
+		}
+		meters = append(meters, m)
+	}
+	sort.Slice(meters, func(i, j int) bool { return meters[i].ID < meters[j].ID })
+	return meters, nil
+}
+
+// filterMeters keeps the meter with the given ID or the meters in the given
+// room (compared case-insensitively). Empty filters keep everything.
+func filterMeters(meters []Meter, id, room string) []Meter {
+	if id == "" && room == "" {
+		return meters
+	}
+	var out []Meter
+	for _, m := range meters {
+		if (id != "" && m.ID == id) || (room != "" && strings.EqualFold(m.Room, room)) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file implements downsampling of stored samples.
+package energy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resolutions maps the accepted query resolutions to bucket widths.
+var Resolutions = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// Point aggregates the samples of one time bucket.
+type Point struct {
+	Time     time.Time `json:"time"`     // Start of the bucket
+	AvgPower float64   `json:"avgPower"` // Mean power in watts
+	MaxPower float64   `json:"maxPower"` // Peak power in watts
+	Energy   float64   `json:"energy"`   // Kilowatt-hours consumed within the bucket
+	Samples  int       `json:"samples"`  // Number of samples in the bucket
+}
+
+// Consumption returns the energy in kWh used between two consecutive samples.
+// The meter's running total is preferred; when it went backwards (the
+// statistics were reset) the average power over the interval is used instead.
+func Consumption(prev, cur Sample) float64 {
+	if delta := cur.Total - prev.Total; delta >= 0 {
+		return delta
+	}
+	hours := cur.Time.Sub(prev.Time).Hours()
+	return (prev.Power + cur.Power) / 2 * hours / 1000
+}
+
+// bucketStart returns the start of the bucket containing t. Day buckets begin
+// at midnight in loc; shorter buckets are aligned to the wall clock.
+func bucketStart(t time.Time, res time.Duration, loc *time.Location) time.Time {
+	if res >= 24*time.Hour {
+		y, m, d := t.In(loc).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+	return t.In(loc).Truncate(res)
+}
+
+// Downsample groups samples, oldest first, into buckets of width res. The
+// energy consumed between two samples is attributed to the later one's bucket.
+func Downsample(samples []Sample, res time.Duration, loc *time.Location) ([]Point, error) {
+	if res <= 0 {
+		return nil, fmt.Errorf("invalid resolution %v", res)
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var points []Point
+	for i, s := range samples {
+		start := bucketStart(s.Time, res, loc)
+		if len(points) == 0 || !points[len(points)-1].Time.Equal(start) {
+			points = append(points, Point{Time: start})
+		}
+
+		p := &points[len(points)-1]
+		p.AvgPower += s.Power
+		if s.Power > p.MaxPower {
+			p.MaxPower = s.Power
+		}
+		p.Samples++
+		if i > 0 {
+			p.Energy += Consumption(samples[i-1], s)
+		}
+	}
+
+	for i := range points {
+		points[i].AvgPower /= float64(points[i].Samples)
+	}
+	return points, nil
+}
@@ -0,0 +1,57 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This test file contains unit tests for downsampling.
+package energy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDownsampleHourly verifies bucket averages, peaks and energy totals.
+func TestDownsampleHourly(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Time: base, Power: 100, Total: 1.0},
+		{Time: base.Add(30 * time.Minute), Power: 200, Total: 1.075},
+		{Time: base.Add(60 * time.Minute), Power: 60, Total: 1.14},
+	}
+
+	points, err := Downsample(samples, time.Hour, time.UTC)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+
+	assert.True(t, points[0].Time.Equal(base))
+	assert.Equal(t, 150.0, points[0].AvgPower)
+	assert.Equal(t, 200.0, points[0].MaxPower)
+	assert.InDelta(t, 0.075, points[0].Energy, 1e-9)
+	assert.Equal(t, 2, points[0].Samples)
+
+	assert.InDelta(t, 0.065, points[1].Energy, 1e-9)
+}
+
+// TestConsumptionAfterReset verifies that a counter reset falls back to the
+// average power over the interval.
+func TestConsumptionAfterReset(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	prev := Sample{Time: base, Power: 100, Total: 5}
+	cur := Sample{Time: base.Add(time.Hour), Power: 300, Total: 0}
+
+	assert.InDelta(t, 0.2, Consumption(prev, cur), 1e-9)
+}
+
+// TestDownsampleDayUsesLocation verifies that day buckets start at local midnight.
+func TestDownsampleDayUsesLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*3600)
+	samples := []Sample{
+		{Time: time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC), Power: 10},
+		{Time: time.Date(2024, 5, 2, 6, 0, 0, 0, time.UTC), Power: 10},
+	}
+
+	points, err := Downsample(samples, 24*time.Hour, loc)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+	assert.True(t, points[0].Time.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, loc)))
+}
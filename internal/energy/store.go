@@ -0,0 +1,176 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file implements the embedded sample store.
+package energy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dayLayout names the per-day sample files.
+const dayLayout = "2006-01-02"
+
+// validMeterID restricts meter IDs to characters that are safe in file names.
+// The leading alphanumeric rules out "." and "..".
+var validMeterID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Sample is a single reading taken from an energy meter.
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Voltage float64   `json:"voltage"` // Volts
+	Current float64   `json:"current"` // Amperes
+	Power   float64   `json:"power"`   // Watts
+	Total   float64   `json:"total"`   // Kilowatt-hours counter reported by the meter
+}
+
+// Store keeps samples as JSON lines in one file per meter and UTC day:
+//
+//	<dir>/<meter>/<YYYY-MM-DD>.jsonl
+//
+// It is safe for concurrent use.
+type Store struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// OpenStore returns a store rooted at dir, creating the directory if needed.
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Append stores a sample for a meter.
+func (s *Store) Append(meter string, sample Sample) error {
+	if !validMeterID.MatchString(meter) {
+		return fmt.Errorf("invalid meter id %q", meter)
+	}
+	sample.Time = sample.Time.UTC()
+
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, meter)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, sample.Time.Format(dayLayout)+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Range returns the samples of a meter with from <= time < to, oldest first.
+func (s *Store) Range(meter string, from, to time.Time) ([]Sample, error) {
+	if !validMeterID.MatchString(meter) {
+		return nil, fmt.Errorf("invalid meter id %q", meter)
+	}
+	from, to = from.UTC(), to.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Sample
+	for day := truncateDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		samples, err := s.readDay(meter, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			if !sample.Time.Before(from) && sample.Time.Before(to) {
+				out = append(out, sample)
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// Meters lists the meters that have stored samples.
+func (s *Store) Meters() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var meters []string
+	for _, e := range entries {
+		if e.IsDir() && validMeterID.MatchString(e.Name()) {
+			meters = append(meters, e.Name())
+		}
+	}
+	return meters, nil
+}
+
+// Prune deletes the day files that end before the given time.
+func (s *Store) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	cutoff := truncateDay(before.UTC())
+	for _, f := range files {
+		day, err := time.Parse(dayLayout, filepath.Base(f[:len(f)-len(".jsonl")]))
+		if err != nil || !day.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDay loads the samples stored for one UTC day. s.mu must be held.
+func (s *Store) readDay(meter string, day time.Time) ([]Sample, error) {
+	f, err := os.Open(filepath.Join(s.dir, meter, day.Format(dayLayout)+".jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Sample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var sample Sample
+		// A torn final line from a crash is skipped rather than failing the query.
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err == nil {
+			out = append(out, sample)
+		}
+	}
+	return out, scanner.Err()
+}
+
+// truncateDay returns midnight UTC of t's day.
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,65 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This test file contains unit tests for the sample store.
+package energy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStoreRangeAcrossDays verifies that samples spanning several day files
+// are returned in order and filtered to the requested range.
+func TestStoreRangeAcrossDays(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	assert.NoError(t, err)
+
+	base := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, store.Append("plug1", Sample{Time: base.Add(time.Duration(i) * 30 * time.Minute), Power: float64(i)}))
+	}
+
+	samples, err := store.Range("plug1", base.Add(30*time.Minute), base.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+	assert.Equal(t, 1.0, samples[0].Power)
+	assert.Equal(t, 3.0, samples[2].Power)
+
+	meters, err := store.Meters()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"plug1"}, meters)
+}
+
+// TestStorePrune verifies that day files before the cutoff are removed.
+func TestStorePrune(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	assert.NoError(t, err)
+
+	old := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recent := old.AddDate(0, 0, 10)
+	assert.NoError(t, store.Append("plug1", Sample{Time: old}))
+	assert.NoError(t, store.Append("plug1", Sample{Time: recent}))
+
+	assert.NoError(t, store.Prune(recent))
+
+	samples, err := store.Range("plug1", old, recent.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.True(t, samples[0].Time.Equal(recent))
+}
+
+// TestStoreRejectsUnsafeMeterID verifies that meter IDs cannot escape the
+// store directory.
+func TestStoreRejectsUnsafeMeterID(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, meter := range []string{"../etc", "a/b", ".", "..", ".hidden", ""} {
+		assert.Error(t, store.Append(meter, Sample{Time: time.Now()}), meter)
+		_, err = store.Range(meter, time.Now().Add(-time.Hour), time.Now())
+		assert.Error(t, err, meter)
+	}
+	assert.NoError(t, store.Append("50c7bf010203_8006AB0101.2", Sample{Time: time.Now()}))
+}
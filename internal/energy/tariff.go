@@ -0,0 +1,220 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This file implements electricity tariffs with time-of-use pricing.
+package energy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+)
+
+// ErrNoTariff is returned when costs are requested before a tariff is configured.
+var ErrNoTariff = errors.New("no tariff configured")
+
+// Band names used for energy priced outside any time-of-use window.
+const (
+	bandStandard = "standard"
+	bandWeekend  = "weekend"
+)
+
+// dayNames maps the accepted day names of a window to weekday bitmasks.
+var dayNames = map[string]uint8{
+	"sun": 1 << time.Sunday, "mon": 1 << time.Monday, "tue": 1 << time.Tuesday,
+	"wed": 1 << time.Wednesday, "thu": 1 << time.Thursday, "fri": 1 << time.Friday,
+	"sat":     1 << time.Saturday,
+	"weekday": 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday,
+	"weekend": 1<<time.Saturday | 1<<time.Sunday,
+}
+
+// Tariff describes how electricity is priced.
+//
+// Energy is charged at the rate of the first window covering the time it was
+// used, falling back to WeekendRate on Saturdays and Sundays when set, and to
+// Rate otherwise. A tariff without windows is a flat rate.
+type Tariff struct {
+	Currency    string   `json:"currency"`              // ISO 4217 code, e.g. "EUR"
+	Rate        float64  `json:"rate"`                  // Price per kWh outside any window
+	WeekendRate *float64 `json:"weekendRate,omitempty"` // Price per kWh on weekends outside any window
+	Windows     []Window `json:"windows,omitempty"`     // Time-of-use windows, first match wins
+	Timezone    string   `json:"timezone,omitempty"`    // IANA zone the windows are expressed in (default: server local time)
+
+	loc *time.Location
+}
+
+// Window is a daily time range with its own price.
+type Window struct {
+	Name  string   `json:"name"`           // Band name reported in cost breakdowns, e.g. "peak"
+	Days  []string `json:"days,omitempty"` // "mon".."sun", "weekday" or "weekend"; empty means every day
+	Start string   `json:"start"`          // Start time "HH:MM"
+	End   string   `json:"end"`            // End time "HH:MM"; earlier than Start wraps past midnight
+	Rate  float64  `json:"rate"`           // Price per kWh
+
+	days       uint8
+	start, end int // Minutes since midnight
+}
+
+// Validate checks the tariff and prepares it for use. It must be called
+// before RateAt on a tariff that was not returned by TariffFile.
+func (t *Tariff) Validate() error {
+	if strings.TrimSpace(t.Currency) == "" {
+		return errors.New("currency is required")
+	}
+	if t.Rate < 0 || (t.WeekendRate != nil && *t.WeekendRate < 0) {
+		return errors.New("rates must not be negative")
+	}
+
+	t.loc = time.Local
+	if t.Timezone != "" {
+		loc, err := time.LoadLocation(t.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+		t.loc = loc
+	}
+
+	for i := range t.Windows {
+		w := &t.Windows[i]
+		if w.Name == "" {
+			return fmt.Errorf("window %d: name is required", i)
+		}
+		if w.Name == bandStandard || w.Name == bandWeekend {
+			return fmt.Errorf("window %d: name %q is reserved", i, w.Name)
+		}
+		if w.Rate < 0 {
+			return fmt.Errorf("window %s: rate must not be negative", w.Name)
+		}
+
+		var err error
+		if w.start, err = parseClock(w.Start); err != nil {
+			return fmt.Errorf("window %s: invalid start: %w", w.Name, err)
+		}
+		if w.end, err = parseClock(w.End); err != nil {
+			return fmt.Errorf("window %s: invalid end: %w", w.Name, err)
+		}
+
+		w.days = 0
+		for _, d := range w.Days {
+			mask, ok := dayNames[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("window %s: invalid day %q", w.Name, d)
+			}
+			w.days |= mask
+		}
+		if w.days == 0 {
+			w.days = dayNames["weekday"] | dayNames["weekend"]
+		}
+	}
+	return nil
+}
+
+// Location returns the time zone the tariff is evaluated in.
+func (t *Tariff) Location() *time.Location {
+	if t.loc == nil {
+		return time.Local
+	}
+	return t.loc
+}
+
+// RateAt returns the price per kWh and the band name in effect at time at.
+func (t *Tariff) RateAt(at time.Time) (float64, string) {
+	local := at.In(t.Location())
+	for _, w := range t.Windows {
+		if w.covers(local) {
+			return w.Rate, w.Name
+		}
+	}
+	if t.WeekendRate != nil && isWeekend(local.Weekday()) {
+		return *t.WeekendRate, bandWeekend
+	}
+	return t.Rate, bandStandard
+}
+
+// covers reports whether the window applies at local time t. The part of a
+// window that wraps past midnight belongs to the day the window started on.
+func (w Window) covers(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case w.start == w.end:
+		// A window starting and ending at the same time lasts all day.
+	case w.start < w.end:
+		if minute < w.start || minute >= w.end {
+			return false
+		}
+	case minute >= w.start:
+	case minute < w.end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return w.days&(1<<day) != 0
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// isWeekend reports whether d is a Saturday or Sunday.
+func isWeekend(d time.Weekday) bool {
+	return d == time.Saturday || d == time.Sunday
+}
+
+// TariffFile holds the configured tariff and persists it as JSON. It is safe
+// for concurrent use.
+type TariffFile struct {
+	mu     sync.RWMutex
+	path   string
+	tariff *Tariff
+}
+
+// OpenTariffFile loads the tariff stored at path. A missing file leaves the
+// tariff unconfigured; an empty path keeps it in memory only.
+func OpenTariffFile(path string) (*TariffFile, error) {
+	f := &TariffFile{path: path}
+	if err := jsonfile.Load(path, &f.tariff); err != nil {
+		return nil, err
+	}
+	if f.tariff != nil {
+		if err := f.tariff.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+// Get returns the configured tariff or ErrNoTariff.
+func (f *TariffFile) Get() (*Tariff, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.tariff == nil {
+		return nil, ErrNoTariff
+	}
+	return f.tariff, nil
+}
+
+// Set validates and stores a new tariff.
+func (f *TariffFile) Set(t Tariff) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := jsonfile.Save(f.path, t); err != nil {
+		return err
+	}
+	f.tariff = &t
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package energy records power readings from metering devices and answers
+// time-series queries over them.
+// This test file contains unit tests for tariffs and cost calculation.
+package energy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testTariff returns a time-of-use tariff evaluated in UTC: an overnight
+// off-peak window on weekdays, an evening peak every day and a cheaper
+// weekend rate.
+func testTariff(t *testing.T) *Tariff {
+	weekend := 0.20
+	tariff := &Tariff{
+		Currency:    "EUR",
+		Rate:        0.30,
+		WeekendRate: &weekend,
+		Timezone:    "UTC",
+		Windows: []Window{
+			{Name: "offpeak", Days: []string{"weekday"}, Start: "22:00", End: "06:00", Rate: 0.10},
+			{Name: "peak", Start: "17:00", End: "20:00", Rate: 0.50},
+		},
+	}
+	assert.NoError(t, tariff.Validate())
+	return tariff
+}
+
+// TestTariffRateAt verifies window matching, including windows that wrap past
+// midnight and the weekend fallback.
+func TestTariffRateAt(t *testing.T) {
+	tariff := testTariff(t)
+
+	cases := []struct {
+		at   time.Time
+		rate float64
+		band string
+	}{
+		{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), 0.30, "standard"}, // Wednesday midday
+		{time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC), 0.10, "offpeak"},  // Wednesday night
+		{time.Date(2024, 5, 2, 5, 59, 0, 0, time.UTC), 0.10, "offpeak"},  // Thursday early morning
+		{time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC), 0.50, "peak"},     // Wednesday evening
+		{time.Date(2024, 5, 4, 18, 0, 0, 0, time.UTC), 0.50, "peak"},     // Saturday evening
+		{time.Date(2024, 5, 4, 2, 0, 0, 0, time.UTC), 0.10, "offpeak"},   // Friday's window wrapping into Saturday
+		{time.Date(2024, 5, 4, 23, 0, 0, 0, time.UTC), 0.20, "weekend"},  // Saturday night
+		{time.Date(2024, 5, 6, 2, 0, 0, 0, time.UTC), 0.30, "standard"},  // Sunday night is not a weekday window
+	}
+	for _, tc := range cases {
+		rate, band := tariff.RateAt(tc.at)
+		assert.Equal(t, tc.rate, rate, tc.at.String())
+		assert.Equal(t, tc.band, band, tc.at.String())
+	}
+}
+
+// TestTariffValidate verifies that malformed tariffs are rejected.
+func TestTariffValidate(t *testing.T) {
+	assert.Error(t, (&Tariff{Rate: 0.3}).Validate())
+	assert.Error(t, (&Tariff{Currency: "EUR", Rate: -1}).Validate())
+	assert.Error(t, (&Tariff{Currency: "EUR", Timezone: "Mars/Olympus"}).Validate())
+	assert.Error(t, (&Tariff{Currency: "EUR", Windows: []Window{{Name: "x", Start: "25:00", End: "06:00"}}}).Validate())
+	assert.Error(t, (&Tariff{Currency: "EUR", Windows: []Window{{Name: "x", Days: []string{"funday"}, Start: "01:00", End: "06:00"}}}).Validate())
+	assert.Error(t, (&Tariff{Currency: "EUR", Windows: []Window{{Name: "standard", Start: "01:00", End: "06:00"}}}).Validate())
+}
+
+// TestTariffCostOf verifies that intervals are priced by band and that
+// intervals ending before the period are ignored.
+func TestTariffCostOf(t *testing.T) {
+	tariff := testTariff(t)
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Time: from.Add(-time.Hour), Total: 9},                           // before the period
+		{Time: from.Add(-30 * time.Minute), Total: 10},                   // interval ends before from
+		{Time: from.Add(30 * time.Minute), Total: 11},                    // 1 kWh off-peak
+		{Time: time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC), Total: 13},  // 2 kWh, midpoint 09:15 standard
+		{Time: time.Date(2024, 5, 1, 18, 30, 0, 0, time.UTC), Total: 14}, // 1 kWh peak
+	}
+
+	cost := tariff.CostOf(samples, from)
+	assert.InDelta(t, 4.0, cost.Energy, 1e-9)
+	assert.InDelta(t, 0.10+0.60+0.50, cost.Cost, 1e-9)
+	assert.InDelta(t, 1.0, cost.Bands["offpeak"].Energy, 1e-9)
+	assert.InDelta(t, 2.0, cost.Bands["standard"].Energy, 1e-9)
+	assert.InDelta(t, 0.50, cost.Bands["peak"].Cost, 1e-9)
+}
+
+// TestPeriodRange verifies day, week and month boundaries.
+func TestPeriodRange(t *testing.T) {
+	date := time.Date(2024, 5, 16, 15, 0, 0, 0, time.UTC) // Thursday
+
+	from, to, err := PeriodRange("week", date, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC), to)
+
+	from, to, err = PeriodRange("month", date, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), to)
+
+	_, _, err = PeriodRange("year", date, time.UTC)
+	assert.Error(t, err)
+}
+
+// TestProjectionFactor verifies the month-end projection scaling.
+func TestProjectionFactor(t *testing.T) {
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	assert.Equal(t, 0.0, projectionFactor(from, to, from.Add(-time.Hour)))
+	assert.InDelta(t, 3.0, projectionFactor(from, to, from.AddDate(0, 0, 10)), 1e-9)
+	assert.Equal(t, 1.0, projectionFactor(from, to, to.Add(time.Hour)))
+}
+
+// TestTariffFilePersists verifies that a stored tariff is reloaded.
+func TestTariffFilePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tariff.json")
+	file, err := OpenTariffFile(path)
+	assert.NoError(t, err)
+
+	_, err = file.Get()
+	assert.ErrorIs(t, err, ErrNoTariff)
+	assert.NoError(t, file.Set(*testTariff(t)))
+
+	reloaded, err := OpenTariffFile(path)
+	assert.NoError(t, err)
+	tariff, err := reloaded.Get()
+	assert.NoError(t, err)
+	rate, band := tariff.RateAt(time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC))
+	assert.Equal(t, 0.10, rate)
+	assert.Equal(t, "offpeak", band)
+}
@@ -0,0 +1,108 @@
+// Package event distributes events raised by devices, such as button presses
+// and motion, to the parts of the server that react to them.
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event types.
+const (
+	TypeButton = "button" // A button was pressed or released
+	TypeMotion = "motion" // A motion sensor detected or cleared motion
+)
+
+// Event is something that happened on a device.
+type Event struct {
+	Type   string                 `json:"type"`           // TypeButton or TypeMotion
+	Action string                 `json:"action"`         // e.g. "short_release" or "detected"
+	Source string                 `json:"source"`         // Integration that raised it, e.g. "hue"
+	Device string                 `json:"device"`         // ID of the device that raised it
+	Name   string                 `json:"name,omitempty"` // Name of the device
+	Time   time.Time              `json:"time"`           // When it happened
+	Data   map[string]interface{} `json:"data,omitempty"` // Type specific details
+}
+
+// Bus delivers published events to every matching subscriber. Subscribers
+// that fall behind miss events rather than blocking publishers. It is safe
+// for concurrent use.
+type Bus struct {
+	logger *logrus.Logger
+
+	mu     sync.RWMutex
+	subs   map[chan Event]map[string]bool
+	closed bool
+}
+
+// NewBus returns an empty bus.
+func NewBus(logger *logrus.Logger) *Bus {
+	return &Bus{logger: logger, subs: map[chan Event]map[string]bool{}}
+}
+
+// Subscribe returns a channel receiving the events of the given types, or of
+// every type when none are given, and a function ending the subscription.
+// buffer is how many events the subscriber may lag behind.
+func (b *Bus) Subscribe(buffer int, types ...string) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	var filter map[string]bool
+	if len(types) > 0 {
+		filter = map[string]bool{}
+		for _, t := range types {
+			filter[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = filter
+	}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends e to the matching subscribers. A nil bus discards events.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch, filter := range b.subs {
+		if filter != nil && !filter[e.Type] {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			b.logger.Debugf("Dropping %s event for a slow subscriber", e.Type)
+		}
+	}
+}
+
+// Close ends every subscription. Events published afterwards are discarded.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+	}
+}
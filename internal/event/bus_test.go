@@ -0,0 +1,75 @@
+// Package event distributes events raised by devices.
+// This test file contains unit tests for the event bus.
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBusFiltersByType verifies that subscribers receive only the types they
+// asked for and that subscribers without a filter receive everything.
+func TestBusFiltersByType(t *testing.T) {
+	bus := NewBus(logrus.New())
+	buttons, stopButtons := bus.Subscribe(4, TypeButton)
+	defer stopButtons()
+	all, stopAll := bus.Subscribe(4)
+	defer stopAll()
+
+	bus.Publish(Event{Type: TypeMotion, Action: "detected", Device: "hallway"})
+	bus.Publish(Event{Type: TypeButton, Action: "short_release", Device: "dimmer"})
+
+	e := <-buttons
+	assert.Equal(t, "dimmer", e.Device)
+	assert.False(t, e.Time.IsZero())
+	assert.Len(t, buttons, 0)
+
+	assert.Equal(t, TypeMotion, (<-all).Type)
+	assert.Equal(t, TypeButton, (<-all).Type)
+}
+
+// TestBusDropsForSlowSubscribers verifies that a full subscriber does not
+// block publishers.
+func TestBusDropsForSlowSubscribers(t *testing.T) {
+	bus := NewBus(logrus.New())
+	events, stop := bus.Subscribe(1)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bus.Publish(Event{Type: TypeButton})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+	assert.Len(t, events, 1)
+}
+
+// TestBusClose verifies that closing the bus ends subscriptions and that
+// unsubscribing afterwards is harmless.
+func TestBusClose(t *testing.T) {
+	bus := NewBus(logrus.New())
+	events, stop := bus.Subscribe(1)
+	bus.Close()
+
+	_, open := <-events
+	assert.False(t, open)
+	stop()
+	bus.Publish(Event{Type: TypeMotion})
+
+	late, _ := bus.Subscribe(1)
+	_, open = <-late
+	assert.False(t, open)
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: TypeMotion})
+}
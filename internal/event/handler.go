@@ -0,0 +1,67 @@
+// Package event distributes events raised by devices.
+// This file provides the HTTP handler streaming events to clients and the
+// server-sent event loop it shares with other streams.
+package event
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	clientBuffer    = 64               // Events a slow client may lag behind before they are dropped
+	clientKeepAlive = 30 * time.Second // Interval of keep-alive comments sent to idle clients
+)
+
+// StreamHandler streams the events published on bus to the client as
+// server-sent events named after their type. The "type" query parameter
+// restricts the stream to a comma separated list of types.
+//
+// Example URL: GET /api/v1/events?type=button,motion
+func StreamHandler(svr *gin.Engine, logger *logrus.Logger, bus *Bus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var types []string
+		if v := c.Query("type"); v != "" {
+			types = strings.Split(v, ",")
+		}
+		events, unsubscribe := bus.Subscribe(clientBuffer, types...)
+		defer unsubscribe()
+		logger.Debugf("Client subscribed to events %v", types)
+
+		Stream(c, events, func(e Event) string { return e.Type })
+	}
+}
+
+// Stream sends the values received on events to the client as server-sent
+// events named by name, with keep-alive comments while it is idle. It returns
+// when events is closed or the client goes away.
+func Stream[T any](c *gin.Context, events <-chan T, name func(T) string) {
+	keepAlive := time.NewTicker(clientKeepAlive)
+	defer keepAlive.Stop()
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	io.WriteString(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(name(e), e)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Package event distributes events raised by devices.
+// This test file contains unit tests for the event stream handler.
+package event
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStream verifies that values are sent as named server-sent events and
+// that the stream ends when the channel is closed.
+func TestStream(t *testing.T) {
+	events := make(chan Event, 2)
+	events <- Event{Type: TypeButton, Action: "short_release", Device: "dimmer"}
+	events <- Event{Type: TypeMotion, Action: "detected", Device: "hallway"}
+	close(events)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/events", func(c *gin.Context) {
+		Stream(c, events, func(e Event) string { return e.Type })
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), ": connected\n\n")
+	assert.Contains(t, string(body), "event:button\ndata:")
+	assert.Contains(t, string(body), `"device":"hallway"`)
+}
+
+// TestStreamHandlerFiltersByType verifies that clients receive only the
+// types named in the query.
+func TestStreamHandlerFiltersByType(t *testing.T) {
+	bus := NewBus(logrus.New())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/events", StreamHandler(router, logrus.New(), bus))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/events?type=motion")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Publish once the handler has subscribed, then close the bus to end the
+	// stream.
+	received := make(chan string, 1)
+	go func() {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		received <- string(body)
+	}()
+	assert.Eventually(t, func() bool {
+		bus.mu.RLock()
+		defer bus.mu.RUnlock()
+		return len(bus.subs) > 0
+	}, 2*time.Second, 5*time.Millisecond)
+	bus.Publish(Event{Type: TypeButton, Device: "dimmer"})
+	bus.Publish(Event{Type: TypeMotion, Device: "hallway"})
+	bus.Close()
+
+	body := <-received
+	assert.Contains(t, body, "event:motion")
+	assert.NotContains(t, body, "dimmer")
+}
@@ -0,0 +1,44 @@
+// Package jsonfile reads and writes the JSON files the server keeps its
+// state in, such as the device registry and the key stores.
+// This test file contains unit tests for loading and saving JSON files.
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSaveAndLoad verifies that a saved value loads again, that the file is
+// private and that no temporary file is left behind.
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "state.json")
+	assert.NoError(t, Save(path, []string{"a", "b"}))
+
+	var got []string
+	assert.NoError(t, Load(path, &got))
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestLoadMissing verifies that a missing file or an empty path leaves the
+// value unchanged, and that malformed JSON names the file.
+func TestLoadMissing(t *testing.T) {
+	dir := t.TempDir()
+	got := []string{"kept"}
+	assert.NoError(t, Load(filepath.Join(dir, "missing.json"), &got))
+	assert.NoError(t, Load("", &got))
+	assert.Equal(t, []string{"kept"}, got)
+	assert.NoError(t, Save("", got))
+
+	path := filepath.Join(dir, "broken.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	assert.ErrorContains(t, Load(path, &got), path)
+}
@@ -0,0 +1,73 @@
+// Package registry keeps a persistent record of known devices.
+// This file provides the HTTP handlers for inspecting the registry.
+package registry
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ListHandler returns every registered device.
+//
+// Example URL: GET /api/v1/registry/devices
+func ListHandler(svr *gin.Engine, logger *logrus.Logger, reg *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		devices := reg.List()
+		logger.Debugf("Listing %d registered devices", len(devices))
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	}
+}
+
+// UpdateHandler changes the user-assigned fields of the device named by the
+// ":id" URL parameter. Only "room" can currently be set.
+//
+// Example URL: PATCH /api/v1/registry/devices/50c7bf010203 {"room": "Kitchen"}
+func UpdateHandler(svr *gin.Engine, logger *logrus.Logger, reg *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var body struct {
+			Room *string `json:"room"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil || body.Room == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON body with a \"room\" field"})
+			return
+		}
+
+		d, err := reg.SetRoom(id, *body.Room)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			logger.Errorf("Error updating device %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Infof("Assigned device %s to room %q", id, d.Room)
+		c.JSON(http.StatusOK, gin.H{"device": d})
+	}
+}
+
+// RemoveHandler forgets the device named by the ":id" URL parameter.
+//
+// Example URL: DELETE /api/v1/registry/devices/50c7bf010203
+func RemoveHandler(svr *gin.Engine, logger *logrus.Logger, reg *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := reg.Remove(id); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			logger.Errorf("Error removing device %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Infof("Removed device %s from registry", id)
+		c.JSON(http.StatusOK, gin.H{"id": id, "status": "success"})
+	}
+}
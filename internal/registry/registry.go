@@ -0,0 +1,208 @@
+// Package registry keeps a persistent record of known devices.
+// Devices are keyed by a stable ID derived from their MAC address or vendor
+// device ID, so they can be addressed independently of their current IP.
+package registry
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colbynh/alfred/internal/jsonfile"
+)
+
+// ErrNotFound is returned when a device is not in the registry.
+var ErrNotFound = errors.New("device not found in registry")
+
+// lastSeenSaveInterval bounds how often updates that only move LastSeen are
+// written to disk, as every state read of a device records it.
+const lastSeenSaveInterval = 5 * time.Minute
+
+// Device is a registry entry describing a known device.
+type Device struct {
+	ID       string    `json:"id"`                 // Stable identifier (see StableID)
+	Kind     string    `json:"kind"`               // Device class, e.g. "outlet"
+	Brand    string    `json:"brand"`              // Vendor, e.g. "kasa"
+	Model    string    `json:"model,omitempty"`    // Vendor model name
+	Alias    string    `json:"alias,omitempty"`    // User-assigned name on the device
+	MAC      string    `json:"mac,omitempty"`      // Colon separated MAC address
+	DeviceID string    `json:"deviceId,omitempty"` // Vendor device identifier
+	IP       string    `json:"ip"`                 // Last known IP address
+	Room     string    `json:"room,omitempty"`     // User-assigned room, e.g. "Kitchen"
+	LastSeen time.Time `json:"lastSeen"`           // Last time the device answered
+}
+
+// StableID derives a registry ID from a MAC address, falling back to the
+// vendor device ID. It returns "" when neither is known.
+func StableID(mac, deviceID string) string {
+	clean := strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if clean != "" {
+		return clean
+	}
+	return strings.ToLower(deviceID)
+}
+
+// Registry is a set of devices persisted as JSON. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	devices map[string]Device
+	saved   time.Time // Last time the registry was written to disk
+}
+
+// Open loads the registry stored at path, creating an empty one if the file
+// does not exist. An empty path keeps the registry in memory only.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, devices: map[string]Device{}}
+
+	var devices []Device
+	if err := jsonfile.Load(path, &devices); err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		r.devices[d.ID] = d
+	}
+	return r, nil
+}
+
+// Record inserts or updates a device. Empty fields of d do not overwrite
+// known values. It returns the stored entry and the previous IP address when
+// the device has moved, or "" otherwise. An update that only moves LastSeen
+// is written to disk at most every lastSeenSaveInterval.
+func (r *Registry) Record(d Device) (Device, string, error) {
+	if d.ID == "" {
+		d.ID = StableID(d.MAC, d.DeviceID)
+	}
+	if d.ID == "" {
+		return Device{}, "", errors.New("device has neither MAC nor device ID")
+	}
+	if d.LastSeen.IsZero() {
+		d.LastSeen = time.Now().UTC()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, exists := r.devices[d.ID]
+	merged := prev
+	merged.ID = d.ID
+	merged.LastSeen = d.LastSeen
+	for dst, src := range map[*string]string{
+		&merged.Kind: d.Kind, &merged.Brand: d.Brand, &merged.Model: d.Model,
+		&merged.Alias: d.Alias, &merged.MAC: d.MAC, &merged.DeviceID: d.DeviceID, &merged.IP: d.IP,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+
+	// Only a new LastSeen is not worth a write on every request.
+	seenOnly := exists && sameExceptLastSeen(prev, merged)
+
+	// An IP belongs to one device at a time; drop it from any stale entry.
+	for id, other := range r.devices {
+		if id != d.ID && merged.IP != "" && other.IP == merged.IP {
+			other.IP = ""
+			r.devices[id] = other
+			seenOnly = false
+		}
+	}
+
+	r.devices[d.ID] = merged
+	if !seenOnly || time.Since(r.saved) >= lastSeenSaveInterval {
+		if err := r.save(); err != nil {
+			return Device{}, "", err
+		}
+	}
+
+	moved := ""
+	if exists && prev.IP != "" && prev.IP != merged.IP {
+		moved = prev.IP
+	}
+	return merged, moved, nil
+}
+
+// Get returns the device with the given ID.
+func (r *Registry) Get(id string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[strings.ToLower(id)]
+	return d, ok
+}
+
+// Resolve looks a device up by registry ID, MAC address or IP address.
+func (r *Registry) Resolve(ref string) (Device, bool) {
+	if d, ok := r.Get(StableID(ref, "")); ok {
+		return d, true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.devices {
+		if d.IP == ref {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// List returns all devices ordered by ID.
+func (r *Registry) List() []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sortedLocked()
+}
+
+// SetRoom assigns a device to a room. An empty room clears the assignment.
+func (r *Registry) SetRoom(id, room string) (Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[strings.ToLower(id)]
+	if !ok {
+		return Device{}, ErrNotFound
+	}
+	d.Room = strings.TrimSpace(room)
+	r.devices[d.ID] = d
+	return d, r.save()
+}
+
+// Remove deletes the device with the given ID.
+func (r *Registry) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(id)
+	if _, ok := r.devices[key]; !ok {
+		return ErrNotFound
+	}
+	delete(r.devices, key)
+	return r.save()
+}
+
+// sameExceptLastSeen reports whether two entries differ in LastSeen at most.
+func sameExceptLastSeen(a, b Device) bool {
+	a.LastSeen, b.LastSeen = time.Time{}, time.Time{}
+	return a == b
+}
+
+// sortedLocked returns the devices ordered by ID. r.mu must be held.
+func (r *Registry) sortedLocked() []Device {
+	out := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// save writes the registry to disk. r.mu must be held.
+func (r *Registry) save() error {
+	if err := jsonfile.Save(r.path, r.sortedLocked()); err != nil {
+		return err
+	}
+	r.saved = time.Now()
+	return nil
+}
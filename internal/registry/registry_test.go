@@ -0,0 +1,123 @@
+// Package registry keeps a persistent record of known devices.
+// This test file contains unit tests for the device registry.
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRecordTracksMovedDevice verifies that a device keeps its ID when its IP
+// changes and that the new mapping survives a reload.
+func TestRecordTracksMovedDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	reg, err := Open(path)
+	assert.NoError(t, err)
+
+	d, moved, err := reg.Record(Device{Kind: "outlet", Brand: "kasa", MAC: "50:C7:BF:01:02:03", IP: "192.168.1.10", Alias: "Lamp"})
+	assert.NoError(t, err)
+	assert.Equal(t, "50c7bf010203", d.ID)
+	assert.Empty(t, moved)
+
+	d, moved, err = reg.Record(Device{MAC: "50:C7:BF:01:02:03", IP: "192.168.1.22"})
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.10", moved)
+	assert.Equal(t, "Lamp", d.Alias)
+
+	reloaded, err := Open(path)
+	assert.NoError(t, err)
+
+	byIP, ok := reloaded.Resolve("192.168.1.22")
+	assert.True(t, ok)
+	assert.Equal(t, "50c7bf010203", byIP.ID)
+
+	byMAC, ok := reloaded.Resolve("50-C7-BF-01-02-03")
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.22", byMAC.IP)
+
+	_, ok = reloaded.Resolve("192.168.1.10")
+	assert.False(t, ok)
+}
+
+// TestRecordReassignsIP verifies that an IP taken over by another device is
+// removed from the stale entry.
+func TestRecordReassignsIP(t *testing.T) {
+	reg, _ := Open("")
+
+	reg.Record(Device{DeviceID: "AAA", IP: "10.0.0.5"})
+	reg.Record(Device{DeviceID: "BBB", IP: "10.0.0.5"})
+
+	d, ok := reg.Resolve("10.0.0.5")
+	assert.True(t, ok)
+	assert.Equal(t, "bbb", d.ID)
+
+	old, _ := reg.Get("aaa")
+	assert.Empty(t, old.IP)
+}
+
+// TestSetRoomSurvivesRecord verifies that a room assignment is kept when the
+// device is rediscovered.
+func TestSetRoomSurvivesRecord(t *testing.T) {
+	reg, err := Open("")
+	assert.NoError(t, err)
+
+	_, _, err = reg.Record(Device{Kind: "outlet", MAC: "50:C7:BF:01:02:03", IP: "192.168.1.10"})
+	assert.NoError(t, err)
+
+	d, err := reg.SetRoom("50c7bf010203", " Kitchen ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Kitchen", d.Room)
+
+	d, _, err = reg.Record(Device{MAC: "50:C7:BF:01:02:03", IP: "192.168.1.11"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Kitchen", d.Room)
+
+	_, err = reg.SetRoom("unknown", "Attic")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// TestRemoveIgnoresCase verifies that devices are removed by ID in any case,
+// as they are looked up.
+func TestRemoveIgnoresCase(t *testing.T) {
+	reg, err := Open("")
+	assert.NoError(t, err)
+
+	_, _, err = reg.Record(Device{Kind: "outlet", MAC: "50:C7:BF:01:02:03", IP: "192.168.1.10"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, reg.Remove("50C7BF010203"))
+	_, ok := reg.Get("50c7bf010203")
+	assert.False(t, ok)
+	assert.ErrorIs(t, reg.Remove("50c7bf010203"), ErrNotFound)
+}
+
+// TestRecordThrottlesLastSeenWrites verifies that an update changing only
+// LastSeen is not written to disk each time, while any other change is.
+func TestRecordThrottlesLastSeenWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	reg, err := Open(path)
+	assert.NoError(t, err)
+
+	first := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	_, _, err = reg.Record(Device{Kind: "outlet", MAC: "50:C7:BF:01:02:03", IP: "192.168.1.10", LastSeen: first})
+	assert.NoError(t, err)
+
+	d, _, err := reg.Record(Device{MAC: "50:C7:BF:01:02:03", IP: "192.168.1.10", LastSeen: first.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Add(time.Minute), d.LastSeen)
+	reloaded, err := Open(path)
+	assert.NoError(t, err)
+	stored, _ := reloaded.Get("50c7bf010203")
+	assert.Equal(t, first, stored.LastSeen, "a LastSeen update alone is not written")
+
+	_, _, err = reg.Record(Device{MAC: "50:C7:BF:01:02:03", Alias: "Kettle", LastSeen: first.Add(2 * time.Minute)})
+	assert.NoError(t, err)
+	reloaded, err = Open(path)
+	assert.NoError(t, err)
+	stored, _ = reloaded.Get("50c7bf010203")
+	assert.Equal(t, "Kettle", stored.Alias)
+	assert.Equal(t, first.Add(2*time.Minute), stored.LastSeen)
+}